@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"sort"
+	"sync"
+	"time"
+)
+
+// auditLog appends an entry for every change made to the running configuration, so changes made
+// during a show can be reviewed and made again later. Each line of the file is a JSON object.
+type auditLog struct {
+	mu   sync.Mutex
+	file *os.File
+	user string
+}
+
+// auditEntry is one change to the running configuration
+type auditEntry struct {
+	Time    string        `json:"time"`    // RFC 3339 with milliseconds
+	User    string        `json:"user"`    // user the router runs as
+	Source  string        `json:"source"`  // what made the change: editor, control or the config URL
+	Action  string        `json:"action"`  // the command, method or event that made it
+	Changes []auditChange `json:"changes"` // every setting that changed
+}
+
+// auditChange is a setting that changed, with its values before and after. Outputs are named by
+// their name, e.g. outputs[Bass].transpose_semitones. A null value means the setting was unset,
+// or the output didn't exist.
+type auditChange struct {
+	Path string          `json:"path"`
+	Old  json.RawMessage `json:"old"`
+	New  json.RawMessage `json:"new"`
+}
+
+// openAuditLog opens an audit log for appending, creating it if needed
+func openAuditLog(path string) (*auditLog, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	name := "unknown"
+	if current, err := user.Current(); err == nil {
+		name = current.Username
+	}
+	return &auditLog{file: file, user: name}, nil
+}
+
+// Record appends an entry for the settings that differ between two configurations. Nothing is
+// written when they are the same, or when there is no audit log.
+func (al *auditLog) Record(source, action string, old, updated *Config) {
+	if al == nil {
+		return
+	}
+	changes, err := configChanges(old, updated)
+	if err != nil {
+		log.Printf("Error writing audit log: %v", err)
+		return
+	}
+	if len(changes) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(auditEntry{
+		Time:    time.Now().Format("2006-01-02T15:04:05.000Z07:00"),
+		User:    al.user,
+		Source:  source,
+		Action:  action,
+		Changes: changes,
+	})
+	if err != nil {
+		log.Printf("Error writing audit log: %v", err)
+		return
+	}
+
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	if _, err := al.file.Write(append(data, '\n')); err != nil {
+		log.Printf("Error writing audit log: %v", err)
+	}
+}
+
+// Close closes the audit log
+func (al *auditLog) Close() error {
+	return al.file.Close()
+}
+
+// configChanges lists the settings that differ between two configurations. Outputs are matched by
+// name, so reordering them is one change of the output order instead of a change to every field.
+func configChanges(old, updated *Config) ([]auditChange, error) {
+	oldFields, err := jsonFields(old)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := jsonFields(updated)
+	if err != nil {
+		return nil, err
+	}
+	delete(oldFields, "outputs")
+	delete(newFields, "outputs")
+	changes := fieldChanges("", oldFields, newFields)
+
+	oldOutputs := make(map[string]map[string]json.RawMessage)
+	var oldOrder, newOrder []string
+	for i := range old.Outputs {
+		fields, err := jsonFields(&old.Outputs[i])
+		if err != nil {
+			return nil, err
+		}
+		oldOutputs[old.Outputs[i].Name] = fields
+		oldOrder = append(oldOrder, old.Outputs[i].Name)
+	}
+	for i := range updated.Outputs {
+		name := updated.Outputs[i].Name
+		fields, err := jsonFields(&updated.Outputs[i])
+		if err != nil {
+			return nil, err
+		}
+		newOrder = append(newOrder, name)
+		path := fmt.Sprintf("outputs[%s]", name)
+		if oldOutput, ok := oldOutputs[name]; ok {
+			changes = append(changes, fieldChanges(path+".", oldOutput, fields)...)
+			delete(oldOutputs, name)
+		} else {
+			data, _ := json.Marshal(&updated.Outputs[i])
+			changes = append(changes, auditChange{Path: path, Old: json.RawMessage("null"), New: data})
+		}
+	}
+	for i := range old.Outputs {
+		name := old.Outputs[i].Name
+		if _, removed := oldOutputs[name]; removed {
+			data, _ := json.Marshal(&old.Outputs[i])
+			changes = append(changes, auditChange{Path: fmt.Sprintf("outputs[%s]", name), Old: data, New: json.RawMessage("null")})
+		}
+	}
+
+	// Only report the order when the same outputs were moved around
+	if len(oldOrder) == len(newOrder) && len(oldOutputs) == 0 {
+		oldData, _ := json.Marshal(oldOrder)
+		newData, _ := json.Marshal(newOrder)
+		if !bytes.Equal(oldData, newData) {
+			changes = append(changes, auditChange{Path: "outputs order", Old: oldData, New: newData})
+		}
+	}
+	return changes, nil
+}
+
+// jsonFields returns the JSON fields of a value, as it would be saved
+func jsonFields(value any) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// fieldChanges lists the fields that differ, in name order
+func fieldChanges(prefix string, old, updated map[string]json.RawMessage) []auditChange {
+	names := make(map[string]bool)
+	for name := range old {
+		names[name] = true
+	}
+	for name := range updated {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	var changes []auditChange
+	for _, name := range sorted {
+		oldValue, newValue := old[name], updated[name]
+		if oldValue == nil {
+			oldValue = json.RawMessage("null")
+		}
+		if newValue == nil {
+			newValue = json.RawMessage("null")
+		}
+		if !bytes.Equal(oldValue, newValue) {
+			changes = append(changes, auditChange{Path: prefix + name, Old: oldValue, New: newValue})
+		}
+	}
+	return changes
+}
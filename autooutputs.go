@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// AutoOutputsConfig is a shorthand for a row of numbered outputs, such as one port per MIDI
+// channel. It expands into outputs when the configuration is loaded, after the ones listed in
+// outputs.
+type AutoOutputsConfig struct {
+	Count      int    `json:"count"`                 // number of outputs, 1-16 with per_channel
+	PerChannel bool   `json:"per_channel,omitempty"` // output N only passes channel N
+	Name       string `json:"name,omitempty"`        // name the output numbers are added to, default "Channel" with per_channel and "Out" without
+}
+
+// Validate checks the count
+func (ac *AutoOutputsConfig) Validate() error {
+	if ac.Count < 1 {
+		return fmt.Errorf("invalid count: %d", ac.Count)
+	}
+	if ac.PerChannel && ac.Count > 16 {
+		return fmt.Errorf("invalid count: %d (must be 1-16 with per_channel)", ac.Count)
+	}
+	return nil
+}
+
+// outputs returns the outputs auto_outputs adds
+func (ac *AutoOutputsConfig) outputs() []OutputConfig {
+	name := ac.Name
+	if name == "" {
+		name = "Out"
+		if ac.PerChannel {
+			name = "Channel"
+		}
+	}
+	outputs := make([]OutputConfig, ac.Count)
+	for n := 1; n <= ac.Count; n++ {
+		outputs[n-1].Name = fmt.Sprintf("%s %d", name, n)
+		if ac.PerChannel {
+			outputs[n-1].ChannelFilter = &ChannelFilter{Channel: uint8(n)}
+		}
+	}
+	return outputs
+}
+
+// expandAutoOutputs adds the outputs of auto_outputs to the configuration. auto_outputs is kept,
+// so a saved configuration can write it back instead of the outputs it added.
+func expandAutoOutputs(config *Config) error {
+	auto := config.AutoOutputs
+	if auto == nil {
+		return nil
+	}
+	if err := auto.Validate(); err != nil {
+		return fmt.Errorf("invalid auto_outputs: %w", err)
+	}
+	for _, output := range auto.outputs() {
+		if findOutputIndex(config.Outputs, output.Name) >= 0 {
+			return fmt.Errorf("auto_outputs adds output %s, which is already listed in outputs", output.Name)
+		}
+		config.Outputs = append(config.Outputs, output)
+	}
+	return nil
+}
+
+// collapseAutoOutputs removes the outputs auto_outputs added from a configuration about to be
+// saved. When any of them was changed or removed since, they are all kept as plain outputs and
+// auto_outputs is dropped instead.
+func collapseAutoOutputs(config *Config) error {
+	if config.AutoOutputs == nil {
+		return nil
+	}
+	added := make(map[int]bool)
+	for _, generated := range config.AutoOutputs.outputs() {
+		i := findOutputIndex(config.Outputs, generated.Name)
+		if i < 0 {
+			config.AutoOutputs = nil
+			return nil
+		}
+		want, err := json.Marshal(generated)
+		if err != nil {
+			return err
+		}
+		have, err := json.Marshal(config.Outputs[i])
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(want, have) {
+			config.AutoOutputs = nil
+			return nil
+		}
+		added[i] = true
+	}
+	var outputs []OutputConfig
+	for i, output := range config.Outputs {
+		if !added[i] {
+			outputs = append(outputs, output)
+		}
+	}
+	config.Outputs = outputs
+	return nil
+}
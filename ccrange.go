@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/drivers"
+)
+
+// CCRangeConfig rescales a controller that doesn't cover the full 0-127 range, such as an
+// expression pedal that only reaches 12-118, so its travel maps to the output range
+type CCRangeConfig struct {
+	Controller uint8  `json:"controller"`           // 0-127
+	InputMin   uint8  `json:"input_min"`            // lowest value the control sends
+	InputMax   uint8  `json:"input_max"`            // highest value the control sends
+	OutputMin  uint8  `json:"output_min,omitempty"` // value sent for input_min, default 0
+	OutputMax  *uint8 `json:"output_max,omitempty"` // value sent for input_max, default 127
+}
+
+// Validate checks the controller number and ranges
+func (cr *CCRangeConfig) Validate() error {
+	if cr.Controller > 127 {
+		return fmt.Errorf("invalid controller: %d (must be 0-127)", cr.Controller)
+	}
+	if cr.InputMax > 127 || cr.InputMin >= cr.InputMax {
+		return fmt.Errorf("invalid input range: %d-%d", cr.InputMin, cr.InputMax)
+	}
+	if cr.OutputMin > 127 || cr.outputMax() > 127 {
+		return fmt.Errorf("invalid output range: %d-%d (must be 0-127)", cr.OutputMin, cr.outputMax())
+	}
+	return nil
+}
+
+func (cr *CCRangeConfig) outputMax() uint8 {
+	if cr.OutputMax == nil {
+		return 127
+	}
+	return *cr.OutputMax
+}
+
+// Scale maps a value from the input range to the output range. Values outside the input range
+// are clamped. An output range with output_min above output_max inverts the control.
+func (cr *CCRangeConfig) Scale(value uint8) uint8 {
+	value = max(cr.InputMin, min(cr.InputMax, value))
+	position := float64(value-cr.InputMin) / float64(cr.InputMax-cr.InputMin)
+	scaled := float64(cr.OutputMin) + position*(float64(cr.outputMax())-float64(cr.OutputMin))
+	return uint8(math.Round(scaled))
+}
+
+// applyCCRanges rescales control changes for the controllers in ranges
+func applyCCRanges(msg midi.Message, ranges []CCRangeConfig, transform *MessageTransformation) midi.Message {
+	var channel, controller, value uint8
+	if len(ranges) == 0 || !msg.GetControlChange(&channel, &controller, &value) {
+		return msg
+	}
+
+	for i := range ranges {
+		if ranges[i].Controller != controller {
+			continue
+		}
+		scaled := ranges[i].Scale(value)
+		transform.OriginalValue = &value
+		transform.TransformedValue = &scaled
+		return midi.ControlChange(channel, controller, scaled)
+	}
+	return msg
+}
+
+// configureCCRange learns a controller's range while the user sweeps the physical control.
+// With a channel filter only control changes on that channel are captured.
+func configureCCRange(reader *bufio.Reader, inputPort drivers.In, channelFilter *ChannelFilter) (*CCRangeConfig, error) {
+	var onlyChannel uint8
+	if channelFilter != nil {
+		onlyChannel = channelFilter.Channel
+		fmt.Printf("  Listening on channel %d only\n", onlyChannel)
+	}
+	fmt.Println("  Move the control across its full range, then press Enter")
+
+	var mu sync.Mutex
+	var captured *CCRangeConfig
+
+	stop, err := midi.ListenTo(inputPort, func(msg midi.Message, timestampms int32) {
+		var channel, controller, value uint8
+		if !msg.GetControlChange(&channel, &controller, &value) {
+			return
+		}
+		if onlyChannel != 0 && channel+1 != onlyChannel {
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		// The first control moved is the one being learned
+		if captured == nil {
+			captured = &CCRangeConfig{Controller: controller, InputMin: value, InputMax: value}
+		} else if controller != captured.Controller {
+			return
+		}
+		captured.InputMin = min(captured.InputMin, value)
+		captured.InputMax = max(captured.InputMax, value)
+		fmt.Printf("\r  Controller %d (channel %d): %d-%d   ", controller, channel+1, captured.InputMin, captured.InputMax)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start listening: %w", err)
+	}
+
+	_, err = reader.ReadString('\n')
+	stop()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input: %w", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if captured == nil {
+		return nil, fmt.Errorf("no control change received")
+	}
+	if captured.InputMin == captured.InputMax {
+		return nil, fmt.Errorf("controller %d only sent the value %d", captured.Controller, captured.InputMin)
+	}
+
+	fmt.Printf("Confirm controller %d range %d-%d? (Y/n): ", captured.Controller, captured.InputMin, captured.InputMax)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input: %w", err)
+	}
+	if strings.ToLower(strings.TrimSpace(line)) == "n" {
+		return nil, nil
+	}
+	return captured, nil
+}
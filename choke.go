@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// validateChokeGroups checks that choke groups hold valid notes, and that no note is in two groups
+func validateChokeGroups(groups [][]uint8) error {
+	seen := make(map[uint8]bool)
+	for i, group := range groups {
+		if len(group) < 2 {
+			return fmt.Errorf("choke group %d needs at least 2 notes", i+1)
+		}
+		for _, note := range group {
+			if note > 127 {
+				return fmt.Errorf("invalid note in choke group %d: %d (must be 0-127)", i+1, note)
+			}
+			if seen[note] {
+				return fmt.Errorf("note %d is in more than one choke group", note)
+			}
+			seen[note] = true
+		}
+	}
+	return nil
+}
+
+// chokeState tracks the sounding notes of an output and ends the other notes of a choke group
+// when one of its notes starts, like an open hi-hat cut off by the closed hi-hat
+type chokeState struct {
+	groups   [][]uint8
+	sounding map[noteKey]bool
+}
+
+func newChokeState(groups [][]uint8) *chokeState {
+	return &chokeState{groups: groups, sounding: make(map[noteKey]bool)}
+}
+
+// group returns the choke group of a note, nil when it is in none
+func (cs *chokeState) group(note uint8) []uint8 {
+	for _, group := range cs.groups {
+		if slices.Contains(group, note) {
+			return group
+		}
+	}
+	return nil
+}
+
+// Apply adds note offs for the notes a note start chokes, on the same channel, and drops the
+// later note offs of choked notes
+func (cs *chokeState) Apply(msgs []midi.Message) []midi.Message {
+	var result []midi.Message
+	for _, msg := range msgs {
+		var channel, key, velocity uint8
+		switch {
+		case msg.GetNoteStart(&channel, &key, &velocity):
+			for _, note := range cs.group(key) {
+				k := noteKey{channel, note}
+				if note != key && cs.sounding[k] {
+					result = append(result, midi.NoteOff(channel, note))
+					delete(cs.sounding, k)
+				}
+			}
+			if cs.group(key) != nil {
+				cs.sounding[noteKey{channel, key}] = true
+			}
+		case msg.GetNoteEnd(&channel, &key):
+			k := noteKey{channel, key}
+			if cs.group(key) != nil {
+				if !cs.sounding[k] {
+					// Already ended by a choke
+					continue
+				}
+				delete(cs.sounding, k)
+			}
+		}
+		result = append(result, msg)
+	}
+	return result
+}
+
+// applyChokeGroups ends choked notes if choke groups are configured
+func applyChokeGroups(msgs []midi.Message, chokes *chokeState) []midi.Message {
+	if chokes == nil {
+		return msgs
+	}
+	return chokes.Apply(msgs)
+}
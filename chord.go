@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// ChordFilterConfig passes notes depending on how many keys are held, so one output can play
+// only chords and another only single notes
+type ChordFilterConfig struct {
+	MinNotes int  `json:"min_notes,omitempty"` // pass notes while at least this many keys are held
+	MaxNotes int  `json:"max_notes,omitempty"` // pass notes while at most this many keys are held, no limit when 0
+	Invert   bool `json:"invert,omitempty"`    // pass notes while the held keys are outside the counts instead
+}
+
+// Validate checks the note counts
+func (cfc *ChordFilterConfig) Validate() error {
+	if cfc.MinNotes < 0 || cfc.MaxNotes < 0 {
+		return fmt.Errorf("note counts must be positive")
+	}
+	if cfc.MinNotes == 0 && cfc.MaxNotes == 0 {
+		return fmt.Errorf("set min_notes or max_notes")
+	}
+	if cfc.MaxNotes > 0 && cfc.MinNotes > cfc.MaxNotes {
+		return fmt.Errorf("min_notes %d is more than max_notes %d", cfc.MinNotes, cfc.MaxNotes)
+	}
+	return nil
+}
+
+// chordGate tracks the held keys that reach an output and decides at each NoteOn whether the
+// note passes. Each NoteOff follows the decision made for its NoteOn.
+type chordGate struct {
+	config *ChordFilterConfig
+	held   []noteKey         // held keys in the order they were pressed
+	vel    map[noteKey]uint8 // velocity of each held key
+	passed map[noteKey]bool  // held keys that were let through
+}
+
+func newChordGate(config *ChordFilterConfig) *chordGate {
+	return &chordGate{
+		config: config,
+		vel:    make(map[noteKey]uint8),
+		passed: make(map[noteKey]bool),
+	}
+}
+
+// ShouldPass tests if a MIDI message should pass through this chord gate
+func (cg *chordGate) ShouldPass(msg midi.Message) bool {
+	var channel, key, velocity uint8
+	if msg.GetNoteStart(&channel, &key, &velocity) {
+		k := noteKey{channel, key}
+		if _, ok := cg.vel[k]; !ok {
+			cg.held = append(cg.held, k)
+		}
+		cg.vel[k] = velocity
+
+		count := len(cg.held)
+		pass := count >= cg.config.MinNotes && (cg.config.MaxNotes == 0 || count <= cg.config.MaxNotes)
+		pass = pass != cg.config.Invert
+		cg.passed[k] = pass
+		return pass
+	}
+	if msg.GetNoteEnd(&channel, &key) {
+		k := noteKey{channel, key}
+		pass := cg.passed[k]
+		cg.held = slices.DeleteFunc(cg.held, func(held noteKey) bool { return held == k })
+		delete(cg.vel, k)
+		delete(cg.passed, k)
+		return pass
+	}
+	// Non-note messages pass through
+	return true
+}
+
+// CatchUp returns NoteOns for held keys that were pressed before the chord was big enough to
+// pass, so the first notes of a chord are not lost. Call it after a NoteOn passes.
+func (cg *chordGate) CatchUp() []midi.Message {
+	if cg.config.MinNotes <= 1 || cg.config.Invert {
+		return nil
+	}
+	var msgs []midi.Message
+	for _, k := range cg.held {
+		if !cg.passed[k] {
+			cg.passed[k] = true
+			msgs = append(msgs, midi.NoteOn(k.Channel, k.Note, cg.vel[k]))
+		}
+	}
+	return msgs
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+const (
+	// clocksPerQuarterNote is the MIDI clock resolution
+	clocksPerQuarterNote = 24
+	// defaultTempoBPM is assumed when no MIDI clock is being received
+	defaultTempoBPM = 120.0
+	// clockTimeout is how long without a clock tick before the tempo is considered unknown
+	clockTimeout = 2 * time.Second
+)
+
+// clockTracker measures the tempo of incoming MIDI clock
+type clockTracker struct {
+	mu       sync.Mutex
+	lastTick time.Time
+	interval time.Duration // smoothed time between clock ticks
+}
+
+// Tick records an incoming MIDI timing clock message
+func (ct *clockTracker) Tick(now time.Time) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	if !ct.lastTick.IsZero() {
+		elapsed := now.Sub(ct.lastTick)
+		if elapsed < clockTimeout {
+			if ct.interval == 0 {
+				ct.interval = elapsed
+			} else {
+				// Exponential moving average to smooth out jitter
+				ct.interval += (elapsed - ct.interval) / 8
+			}
+		}
+	}
+	ct.lastTick = now
+}
+
+// TickInterval returns the current time between clock ticks, falling back to the default tempo
+// when no clock has been received recently
+func (ct *clockTracker) TickInterval() time.Duration {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	if ct.interval == 0 || time.Since(ct.lastTick) > clockTimeout {
+		return tickIntervalForTempo(defaultTempoBPM)
+	}
+	return ct.interval
+}
+
+// Running reports whether clock ticks have been received recently
+func (ct *clockTracker) Running() bool {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	return ct.interval != 0 && time.Since(ct.lastTick) <= clockTimeout
+}
+
+// tickIntervalForTempo returns the time between MIDI clock ticks at the given tempo
+func tickIntervalForTempo(bpm float64) time.Duration {
+	return time.Duration(float64(time.Minute) / (bpm * clocksPerQuarterNote))
+}
+
+// ClockConfig runs an internal MIDI clock generator
+type ClockConfig struct {
+	Source   string          `json:"source,omitempty"`    // "internal" (default) or "input" to regenerate the input clock
+	BPM      float64         `json:"bpm,omitempty"`       // 20-300, for the internal source
+	Outputs  []string        `json:"outputs,omitempty"`   // names of outputs or output groups that receive the clock, all outputs when empty
+	TapTempo *TapTempoConfig `json:"tap_tempo,omitempty"` // set the tempo by tapping
+
+	StartPosition    uint16         `json:"start_position,omitempty"`    // song position in MIDI beats (16th notes) to start from
+	TransportTrigger *TriggerConfig `json:"transport_trigger,omitempty"` // input control that stops and continues the clock
+}
+
+// TapTempoConfig sets the internal clock tempo from taps on a mapped control or the Enter key
+type TapTempoConfig struct {
+	Trigger *TriggerConfig `json:"trigger,omitempty"` // input note or controller used as the tap button
+	Hotkey  bool           `json:"hotkey,omitempty"`  // tap by pressing Enter in the terminal
+}
+
+const (
+	minTempoBPM = 20.0
+	maxTempoBPM = 300.0
+
+	// clocksPerSongPositionBeat is the number of clock ticks in a song position pointer beat (a 16th note)
+	clocksPerSongPositionBeat = 6
+	// maxSongPosition is the largest value a song position pointer can hold
+	maxSongPosition = 16383
+)
+
+// Validate checks the clock settings against the configured outputs
+func (cc *ClockConfig) Validate(outputs []OutputConfig) error {
+	switch cc.Source {
+	case "", "internal":
+		if cc.BPM < minTempoBPM || cc.BPM > maxTempoBPM {
+			return fmt.Errorf("invalid bpm: %g (must be %g-%g)", cc.BPM, minTempoBPM, maxTempoBPM)
+		}
+	case "input":
+		if cc.TapTempo != nil || cc.TransportTrigger != nil || cc.StartPosition > 0 {
+			return fmt.Errorf("tap tempo, transport trigger and start position need the internal clock source")
+		}
+	default:
+		return fmt.Errorf("invalid clock source: %q (must be internal or input)", cc.Source)
+	}
+	for _, name := range cc.Outputs {
+		if !hasOutputOrGroup(outputs, name) {
+			return fmt.Errorf("unknown clock output: %q", name)
+		}
+	}
+	if cc.StartPosition > maxSongPosition {
+		return fmt.Errorf("invalid start position: %d (must be 0-%d)", cc.StartPosition, maxSongPosition)
+	}
+	if cc.TransportTrigger != nil {
+		if err := cc.TransportTrigger.Validate(); err != nil {
+			return fmt.Errorf("invalid transport trigger: %w", err)
+		}
+	}
+	if cc.TapTempo != nil && cc.TapTempo.Trigger != nil {
+		if err := cc.TapTempo.Trigger.Validate(); err != nil {
+			return fmt.Errorf("invalid tap tempo trigger: %w", err)
+		}
+	}
+	return nil
+}
+
+// regenerates reports whether the clock follows the input clock instead of running on its own
+func (cc *ClockConfig) regenerates() bool {
+	return cc.Source == "input"
+}
+
+// clockGenerator sends MIDI timing clock, either at an adjustable tempo or locked to the input clock
+type clockGenerator struct {
+	mu      sync.Mutex
+	bpm     float64
+	send    func(midi.Message)
+	tracker *clockTracker // receives the generated ticks so clock synced features follow the tempo
+	source  *clockTracker // input clock to follow when regenerating
+
+	// Ticks received from the input and sent to the outputs while regenerating
+	received int64
+	emitted  int64
+
+	// Song position in clock ticks and whether it advances, for the free running clock
+	position int64
+	paused   bool
+
+	inputTick chan struct{}
+	done      chan struct{}
+	stopped   chan struct{}
+}
+
+// newClockGenerator creates a free running clock at the given tempo
+func newClockGenerator(bpm float64, send func(midi.Message), tracker *clockTracker) *clockGenerator {
+	return &clockGenerator{
+		bpm:       bpm,
+		send:      send,
+		tracker:   tracker,
+		inputTick: make(chan struct{}, 1),
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+}
+
+// newClockRegenerator creates a clock that follows the smoothed tempo of the input clock.
+// Ticks are sent on the generator's own schedule and nudged to keep the same count as the input.
+func newClockRegenerator(source *clockTracker, send func(midi.Message)) *clockGenerator {
+	return &clockGenerator{
+		send:      send,
+		source:    source,
+		inputTick: make(chan struct{}, 1),
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+}
+
+// InputTick records a clock tick received from the input while regenerating
+func (cg *clockGenerator) InputTick() {
+	cg.mu.Lock()
+	cg.received++
+	cg.mu.Unlock()
+
+	select {
+	case cg.inputTick <- struct{}{}:
+	default:
+	}
+}
+
+// Transport passes Start, Stop and Continue from the input through to the clock outputs
+func (cg *clockGenerator) Transport(msg midi.Message) {
+	cg.send(msg)
+}
+
+// nextInterval returns the time until the next tick, or false if the input clock was lost
+func (cg *clockGenerator) nextInterval() (time.Duration, bool) {
+	if cg.source == nil {
+		return tickIntervalForTempo(cg.BPM()), true
+	}
+	if !cg.source.Running() {
+		return 0, false
+	}
+
+	cg.mu.Lock()
+	lead := cg.emitted - cg.received
+	cg.mu.Unlock()
+
+	// Stretch the tick when ahead of the input and shorten it when behind, to stay in phase
+	lead = max(-4, min(4, lead))
+	return time.Duration(float64(cg.source.TickInterval()) * (1 + 0.1*float64(lead))), true
+}
+
+// resync drops any accumulated phase difference after the input clock comes back
+func (cg *clockGenerator) resync() {
+	cg.mu.Lock()
+	defer cg.mu.Unlock()
+	cg.emitted = cg.received
+}
+
+// SetBPM changes the tempo, clamped to the supported range
+func (cg *clockGenerator) SetBPM(bpm float64) {
+	cg.mu.Lock()
+	defer cg.mu.Unlock()
+	cg.bpm = math.Max(minTempoBPM, math.Min(maxTempoBPM, bpm))
+}
+
+// BPM returns the current tempo
+func (cg *clockGenerator) BPM() float64 {
+	cg.mu.Lock()
+	defer cg.mu.Unlock()
+	return cg.bpm
+}
+
+// Start begins sending clock ticks. A free running clock sends MIDI Start first, or a song position
+// pointer and Continue when starting mid-song. A regenerated clock leaves transport to the input.
+func (cg *clockGenerator) Start(startPosition uint16) {
+	if cg.source == nil {
+		if startPosition > 0 {
+			cg.SetSongPosition(startPosition)
+			cg.send(midi.SPP(startPosition))
+			cg.send(midi.Continue())
+		} else {
+			cg.send(midi.Start())
+		}
+	}
+	go cg.run()
+}
+
+// SetSongPosition moves the song position, in MIDI beats
+func (cg *clockGenerator) SetSongPosition(beats uint16) {
+	cg.mu.Lock()
+	defer cg.mu.Unlock()
+	cg.position = int64(beats) * clocksPerSongPositionBeat
+}
+
+// ToggleTransport stops a running clock, or continues a stopped clock from where it was stopped.
+// Clock ticks keep being sent while stopped, as most devices expect. Continuing sends the song
+// position first, rounded down to a MIDI beat, so devices resume at the right place in the song.
+func (cg *clockGenerator) ToggleTransport() (running bool) {
+	cg.mu.Lock()
+	cg.paused = !cg.paused
+	paused := cg.paused
+	beats := min(cg.position/clocksPerSongPositionBeat, maxSongPosition)
+	cg.position = beats * clocksPerSongPositionBeat
+	cg.mu.Unlock()
+
+	if paused {
+		cg.send(midi.Stop())
+		return false
+	}
+	cg.send(midi.SPP(uint16(beats)))
+	cg.send(midi.Continue())
+	return true
+}
+
+// Stop stops the clock ticks, sending MIDI Stop for a free running clock
+func (cg *clockGenerator) Stop() {
+	close(cg.done)
+	<-cg.stopped
+	if cg.source == nil {
+		cg.send(midi.Stop())
+	}
+}
+
+func (cg *clockGenerator) run() {
+	defer close(cg.stopped)
+	defer recoverFailsafe()
+
+	next := time.Now()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-cg.done:
+			return
+		case <-timer.C:
+		}
+
+		interval, ok := cg.nextInterval()
+		if !ok {
+			// Lost the input clock, wait for it to come back
+			select {
+			case <-cg.done:
+				return
+			case <-cg.inputTick:
+			}
+			cg.resync()
+			next = time.Now()
+			timer.Reset(0)
+			continue
+		}
+
+		now := time.Now()
+		cg.send(midi.TimingClock())
+		cg.mu.Lock()
+		cg.emitted++
+		if !cg.paused {
+			cg.position++
+		}
+		cg.mu.Unlock()
+		if cg.tracker != nil {
+			cg.tracker.Tick(now)
+		}
+
+		// Schedule from the ideal tick time so timer latency doesn't accumulate into drift
+		next = next.Add(interval)
+		if now.Sub(next) > interval {
+			next = now.Add(interval)
+		}
+		timer.Reset(time.Until(next))
+	}
+}
+
+// tapTempo computes a tempo from the average spacing of recent taps
+type tapTempo struct {
+	mu   sync.Mutex
+	taps []time.Time
+}
+
+// maxTapInterval is the longest gap between taps before a new tap sequence starts
+const maxTapInterval = 2 * time.Second
+
+// Tap records a tap and returns the tapped tempo once there are at least two taps
+func (tt *tapTempo) Tap(now time.Time) (float64, bool) {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+
+	if len(tt.taps) > 0 && now.Sub(tt.taps[len(tt.taps)-1]) > maxTapInterval {
+		tt.taps = nil
+	}
+	tt.taps = append(tt.taps, now)
+	// Average over the last four intervals
+	if len(tt.taps) > 5 {
+		tt.taps = tt.taps[len(tt.taps)-5:]
+	}
+	if len(tt.taps) < 2 {
+		return 0, false
+	}
+
+	average := tt.taps[len(tt.taps)-1].Sub(tt.taps[0]) / time.Duration(len(tt.taps)-1)
+	return float64(time.Minute) / float64(average), true
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// ansiColors maps the color names accepted in the config to ANSI foreground color codes
+var ansiColors = map[string]string{
+	"red":            "31",
+	"green":          "32",
+	"yellow":         "33",
+	"blue":           "34",
+	"magenta":        "35",
+	"cyan":           "36",
+	"white":          "37",
+	"bright_red":     "91",
+	"bright_green":   "92",
+	"bright_yellow":  "93",
+	"bright_blue":    "94",
+	"bright_magenta": "95",
+	"bright_cyan":    "96",
+}
+
+// defaultColorOrder is the order colors are assigned to outputs without a configured color
+var defaultColorOrder = []string{"cyan", "yellow", "green", "magenta", "blue", "red",
+	"bright_cyan", "bright_yellow", "bright_green", "bright_magenta", "bright_blue", "bright_red"}
+
+// colorNames returns the supported color names in alphabetical order
+func colorNames() []string {
+	names := make([]string, 0, len(ansiColors))
+	for name := range ansiColors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// outputColor returns the ANSI color code for an output, either its configured color or one
+// picked by its position so it stays the same between runs
+func outputColor(output *OutputConfig, index int) string {
+	if code, ok := ansiColors[output.Color]; ok {
+		return code
+	}
+	return ansiColors[defaultColorOrder[index%len(defaultColorOrder)]]
+}
+
+// colorize wraps text in an ANSI color code
+func colorize(text string, code string) string {
+	return fmt.Sprintf("\033[%sm%s\033[0m", code, text)
+}
+
+// useColor decides whether to color log output for a --color setting of auto, always or never.
+// auto colors only when stdout is a terminal and NO_COLOR is not set.
+func useColor(mode string) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto", "":
+		if os.Getenv("NO_COLOR") != "" {
+			return false, nil
+		}
+		info, err := os.Stdout.Stat()
+		if err != nil {
+			return false, nil
+		}
+		return info.Mode()&os.ModeCharDevice != 0, nil
+	default:
+		return false, fmt.Errorf("invalid color mode: %q (must be auto, always or never)", mode)
+	}
+}
@@ -0,0 +1,568 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/drivers"
+)
+
+// subcommand is a command line mode other than running the router
+type subcommand struct {
+	usage string
+	run   func(args []string) error
+}
+
+// subcommands are selected by the first command line argument. "run", or no subcommand at all,
+// runs the router with the regular flags.
+var subcommands map[string]subcommand
+
+func init() {
+	subcommands = map[string]subcommand{
+		"validate":      {"validate <file>", runValidateCommand},
+		"list-devices":  {"list-devices [--inputs|--outputs] [--names]", runListDevicesCommand},
+		"monitor":       {"monitor <input>", runMonitorCommand},
+		"send":          {"send <output> <hex bytes...>", runSendCommand},
+		"graph":         {"graph <file>", runGraphCommand},
+		"config":        {"config print <file>", runConfigCommand},
+		"import-output": {"import-output [--config file] [--name name] <snippet>", runImportOutputCommand},
+		"merge":         {"merge --output device [--base name] [--save file] name[=channel]...", runMergeCommand},
+		"multitimbral":  {"multitimbral [--input device] [--base name] [--save file] [name=channel...]", runMultitimbralCommand},
+		"replay":        {"replay <recording> [--config file] [--expect file] [--output file] [--tail duration]", runReplayCommand},
+		"completion":    {"completion bash|zsh|fish", runCompletionCommand},
+	}
+}
+
+// subcommandNames returns the names of all subcommands, including run
+func subcommandNames() []string {
+	names := []string{"run"}
+	for name := range subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+// printSubcommandUsage adds the subcommands to the flag usage message
+func printSubcommandUsage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: midirouter [run] [flags]\n")
+	for _, name := range subcommandNames()[1:] {
+		fmt.Fprintf(out, "       midirouter %s\n", subcommands[name].usage)
+	}
+	fmt.Fprintf(out, "\nFlags for run:\n")
+	flag.PrintDefaults()
+}
+
+// runValidateCommand checks a config file and that its input device is connected
+func runValidateCommand(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: midirouter validate <file>")
+	}
+
+	// A file with several routers is valid when each of them is
+	configs, err := loadRouters(args[0])
+	if err != nil {
+		return err
+	}
+	if configs == nil {
+		config, err := loadConfig(args[0])
+		if err != nil {
+			return err
+		}
+		if err := validateConfigStructure(config); err != nil {
+			return err
+		}
+		configs = []*Config{config}
+	}
+
+	for i, config := range configs {
+		if err := validateConnectedDevices(config); err != nil {
+			if len(configs) > 1 {
+				return fmt.Errorf("router %d: %w", i+1, err)
+			}
+			return err
+		}
+	}
+
+	fmt.Printf("%s is valid\n", args[0])
+	return nil
+}
+
+// validateConnectedDevices checks that the devices a config uses are connected
+func validateConnectedDevices(config *Config) error {
+	usesDevices := slices.ContainsFunc(config.Outputs, func(output OutputConfig) bool { return output.Device != "" })
+	if config.RawInput != nil && !usesDevices {
+		return nil
+	}
+	drv, err := openDriver(config.Driver)
+	if err != nil {
+		return err
+	}
+	defer drv.Close()
+	if config.RawInput == nil {
+		if err := validateInputDevices(config, drv); err != nil {
+			return err
+		}
+	}
+	return validateOutputDevices(config, drv)
+}
+
+// runListDevicesCommand prints the available MIDI ports. --names prints only the port names,
+// one per line, for shell completion.
+func runListDevicesCommand(args []string) error {
+	flags := flag.NewFlagSet("list-devices", flag.ExitOnError)
+	inputsOnly := flags.Bool("inputs", false, "Only list input ports")
+	outputsOnly := flags.Bool("outputs", false, "Only list output ports")
+	namesOnly := flags.Bool("names", false, "Print only port names, one per line")
+	driverName := flags.String("driver", "", "MIDI backend to list the ports of: "+strings.Join(driverNames(), ", "))
+	flags.Parse(args)
+
+	drv, err := openDriver(*driverName)
+	if err != nil {
+		return err
+	}
+	defer drv.Close()
+
+	var inNames, outNames []string
+	if !*outputsOnly {
+		ins, err := drv.Ins()
+		if err != nil {
+			return fmt.Errorf("failed to get MIDI inputs: %w", err)
+		}
+		inNames = getDeviceNames(ins)
+	}
+	if !*inputsOnly {
+		outs, err := drv.Outs()
+		if err != nil {
+			return fmt.Errorf("failed to get MIDI outputs: %w", err)
+		}
+		for _, out := range outs {
+			outNames = append(outNames, out.String())
+		}
+	}
+
+	if *namesOnly {
+		for _, name := range append(inNames, outNames...) {
+			fmt.Println(name)
+		}
+		return nil
+	}
+
+	if !*outputsOnly {
+		fmt.Println("Inputs:")
+		for i, name := range inNames {
+			fmt.Printf("  %d: %s\n", i+1, name)
+		}
+	}
+	if !*inputsOnly {
+		fmt.Println("Outputs:")
+		for i, name := range outNames {
+			fmt.Printf("  %d: %s\n", i+1, name)
+		}
+	}
+	return nil
+}
+
+// findPort finds a port by its exact name, a name from portNameMatches, or its 1-based number in the list
+func findPort[T drivers.Port](ports []T, name string) (T, error) {
+	for _, port := range ports {
+		if port.String() == name {
+			return port, nil
+		}
+	}
+	for _, port := range ports {
+		if portNameMatches(port.String(), name) {
+			return port, nil
+		}
+	}
+	if number, err := strconv.Atoi(name); err == nil && number >= 1 && number <= len(ports) {
+		return ports[number-1], nil
+	}
+	var none T
+	return none, fmt.Errorf("port not found: %s", name)
+}
+
+// runMonitorCommand prints every message received on an input until interrupted
+func runMonitorCommand(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: midirouter monitor <input>")
+	}
+
+	drv, err := openDriver("")
+	if err != nil {
+		return err
+	}
+	defer drv.Close()
+
+	ins, err := drv.Ins()
+	if err != nil {
+		return fmt.Errorf("failed to get MIDI inputs: %w", err)
+	}
+	in, err := findPort(ins, args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Monitoring %s, press Ctrl+C to stop...\n", in.String())
+	stop, err := midi.ListenTo(in, func(msg midi.Message, timestampms int32) {
+		fmt.Printf("%8d  % -12X  %s\n", timestampms, []byte(msg), msg.String())
+	}, midi.UseSysEx(), midi.UseTimeCode())
+	if err != nil {
+		return fmt.Errorf("failed to start listening: %w", err)
+	}
+	defer stop()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	<-sigChan
+	return nil
+}
+
+// runSendCommand sends raw bytes, given in hex, to an output
+func runSendCommand(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: midirouter send <output> <hex bytes...>")
+	}
+
+	var data []byte
+	for _, field := range strings.Fields(strings.Join(args[1:], " ")) {
+		b, err := strconv.ParseUint(field, 16, 8)
+		if err != nil {
+			return fmt.Errorf("invalid byte: %q", field)
+		}
+		data = append(data, byte(b))
+	}
+	if len(data) == 0 {
+		return fmt.Errorf("usage: midirouter send <output> <hex bytes...>")
+	}
+	if data[0] < 0x80 {
+		return fmt.Errorf("message must start with a status byte, got %02X", data[0])
+	}
+
+	drv, err := openDriver("")
+	if err != nil {
+		return err
+	}
+	defer drv.Close()
+
+	outs, err := drv.Outs()
+	if err != nil {
+		return fmt.Errorf("failed to get MIDI outputs: %w", err)
+	}
+	out, err := findPort(outs, args[0])
+	if err != nil {
+		return err
+	}
+	if err := out.Open(); err != nil {
+		return fmt.Errorf("failed to open %s: %w", out.String(), err)
+	}
+	defer out.Close()
+
+	if err := out.Send(data); err != nil {
+		return fmt.Errorf("failed to send: %w", err)
+	}
+	fmt.Printf("Sent % X to %s\n", data, out.String())
+	return nil
+}
+
+// runGraphCommand prints the routing of a config file as a Graphviz graph
+func runGraphCommand(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: midirouter graph <file>")
+	}
+
+	config, err := loadConfig(args[0])
+	if err != nil {
+		return err
+	}
+	if err := validateConfigStructure(config); err != nil {
+		return err
+	}
+
+	inputs := config.sourceNames()
+
+	fmt.Println("digraph midirouter {")
+	fmt.Println("  rankdir=LR;")
+	fmt.Println("  node [shape=box];")
+	for j, input := range inputs {
+		fmt.Printf("  in%d [label=%s];\n", j+1, strconv.Quote(input))
+	}
+	for i, output := range config.Outputs {
+		name := config.outputPortName(i)
+		if output.Description != "" {
+			name += "\n" + output.Description
+		}
+		fmt.Printf("  out%d [label=%s];\n", i+1, strconv.Quote(name))
+		label := strconv.Quote(strings.Join(describeOutput(&output), "\n"))
+		for j, input := range inputs {
+			if output.acceptsInput(config, input) {
+				fmt.Printf("  in%d -> out%d [label=%s];\n", j+1, i+1, label)
+			}
+		}
+	}
+	fmt.Println("}")
+	return nil
+}
+
+// describeOutput lists an output's filters and processing in short form
+func describeOutput(output *OutputConfig) []string {
+	var parts []string
+	if !output.isEnabled() {
+		parts = append(parts, "disabled")
+	}
+	if output.Thru {
+		return append(parts, "thru")
+	}
+	if output.RouteGroup != "" {
+		parts = append(parts, fmt.Sprintf("group %s", output.RouteGroup))
+	}
+	if output.StopOnMatch {
+		parts = append(parts, "stop on match")
+	}
+	if output.CatchDropped {
+		parts = append(parts, "catch dropped")
+	}
+	if output.Dry != nil {
+		if output.Dry.Output != "" {
+			parts = append(parts, fmt.Sprintf("dry to %s", output.Dry.Output))
+		} else {
+			parts = append(parts, "dry")
+		}
+	}
+	if output.ChannelFilter != nil {
+		parts = append(parts, output.ChannelFilter.String())
+	}
+	if output.MessageTypes != nil {
+		parts = append(parts, output.MessageTypes.String())
+	}
+	if output.Mode == "drums" {
+		parts = append(parts, fmt.Sprintf("drums on channel %d", output.drums().channel()))
+	}
+	if output.NoteRangeFilter != nil {
+		parts = append(parts, output.NoteRangeFilter.String())
+	}
+	if output.Filter != nil {
+		parts = append(parts, "filter "+output.Filter.String())
+	}
+	if output.MinVelocity != nil {
+		parts = append(parts, fmt.Sprintf("velocity %d+", *output.MinVelocity))
+	}
+	if output.ChordFilter != nil {
+		held := fmt.Sprintf("%d+ held notes", output.ChordFilter.MinNotes)
+		if output.ChordFilter.MaxNotes > 0 {
+			held = fmt.Sprintf("%d-%d held notes", output.ChordFilter.MinNotes, output.ChordFilter.MaxNotes)
+		}
+		if output.ChordFilter.Invert {
+			held = "not " + held
+		}
+		parts = append(parts, held)
+	}
+	if output.Probability != nil {
+		parts = append(parts, fmt.Sprintf("probability %g", *output.Probability))
+	}
+	if output.OverrideChannel != nil {
+		parts = append(parts, fmt.Sprintf("to channel %d", *output.OverrideChannel))
+	}
+	if output.TransposeSemitones != nil {
+		parts = append(parts, fmt.Sprintf("transpose %+d", *output.TransposeSemitones))
+	}
+	if output.OctaveShift != nil {
+		parts = append(parts, fmt.Sprintf("octave shift %+d", output.OctaveShift.Octaves))
+	}
+	if output.ScaleQuantize {
+		parts = append(parts, "quantize to key")
+	}
+	if output.ScaleTranspose != 0 {
+		parts = append(parts, fmt.Sprintf("scale transpose %+d", output.ScaleTranspose))
+	}
+	if output.MinNote != nil || output.MaxNote != nil {
+		minNote, maxNote := uint8(0), uint8(127)
+		if output.MinNote != nil {
+			minNote = *output.MinNote
+		}
+		if output.MaxNote != nil {
+			maxNote = *output.MaxNote
+		}
+		parts = append(parts, fmt.Sprintf("note limits %d-%d", minNote, maxNote))
+	}
+	for _, ccRange := range output.CCRanges {
+		parts = append(parts, fmt.Sprintf("cc %d %d-%d to %d-%d", ccRange.Controller, ccRange.InputMin, ccRange.InputMax, ccRange.OutputMin, ccRange.outputMax()))
+	}
+	if output.SoftTakeover {
+		parts = append(parts, "soft takeover")
+	}
+	if output.VelocityCompressor != nil {
+		parts = append(parts, fmt.Sprintf("compress velocity above %d %g:1", output.VelocityCompressor.Threshold, output.VelocityCompressor.Ratio))
+	}
+	if output.Harmonizer != nil {
+		if output.Harmonizer.usesGlobalKey() {
+			parts = append(parts, "harmonize in key")
+		} else {
+			parts = append(parts, fmt.Sprintf("harmonize %s %s", output.Harmonizer.Key, output.Harmonizer.Scale))
+		}
+	}
+	if output.ChannelRotation != nil {
+		parts = append(parts, fmt.Sprintf("rotate channels %v", output.ChannelRotation.Channels))
+	}
+	if len(output.DuplicateChannels) > 0 {
+		parts = append(parts, fmt.Sprintf("duplicate to %v", output.DuplicateChannels))
+	}
+	if output.Tuning != nil {
+		parts = append(parts, fmt.Sprintf("tuning %s", output.Tuning.File))
+	}
+	if output.MTS != nil {
+		parts = append(parts, fmt.Sprintf("mts %s", output.MTS.File))
+	}
+	if len(output.ChokeGroups) > 0 {
+		parts = append(parts, fmt.Sprintf("%d choke groups", len(output.ChokeGroups)))
+	}
+	if output.Strum != nil {
+		parts = append(parts, fmt.Sprintf("strum %dms", output.Strum.TimeMS))
+	}
+	if output.Echo != nil {
+		parts = append(parts, fmt.Sprintf("echo x%d", output.Echo.Repeats))
+	}
+	if output.Envelope != nil {
+		parts = append(parts, fmt.Sprintf("envelope cc %d", output.Envelope.Controller))
+	}
+	if output.NoteRepeat != nil {
+		parts = append(parts, fmt.Sprintf("repeat %s", output.NoteRepeat.Rate))
+	}
+	if output.SystemMessages != nil {
+		if output.SystemMessages.ActiveSensing != "" {
+			parts = append(parts, fmt.Sprintf("active sensing %s", output.SystemMessages.ActiveSensing))
+		}
+		if output.SystemMessages.DropUndefined {
+			parts = append(parts, "drop undefined")
+		}
+		if output.SystemMessages.DropSystemCommon {
+			parts = append(parts, "drop system common")
+		}
+	}
+	if output.Gain != nil {
+		if output.Gain.Volume != nil {
+			parts = append(parts, fmt.Sprintf("volume %d", *output.Gain.Volume))
+		}
+		if output.Gain.Expression != nil {
+			parts = append(parts, fmt.Sprintf("expression %d", *output.Gain.Expression))
+		}
+	}
+	if output.SysExPacing != nil {
+		parts = append(parts, "paced sysex")
+	}
+	if output.Device != "" {
+		parts = append(parts, fmt.Sprintf("device %s", output.Device))
+	}
+	if output.Network != nil {
+		parts = append(parts, fmt.Sprintf("rtp-midi %s:%d", output.Network.Host, output.Network.port()))
+	}
+	if output.RawFile != nil {
+		parts = append(parts, fmt.Sprintf("file %s", output.RawFile.Path))
+	}
+	if output.Socket != nil {
+		parts = append(parts, fmt.Sprintf("%s %s", output.Socket.protocol(), output.Socket.Address))
+	}
+	if output.OSC != nil {
+		parts = append(parts, fmt.Sprintf("osc %s%s", output.OSC.Address, output.OSC.Prefix))
+	}
+	if output.MQTT != nil {
+		parts = append(parts, fmt.Sprintf("mqtt %s", output.MQTT.Topic))
+	}
+	if len(parts) == 0 {
+		parts = append(parts, "all")
+	}
+	return parts
+}
+
+// runCompletionCommand prints a shell completion script
+func runCompletionCommand(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: midirouter completion bash|zsh|fish")
+	}
+
+	commands := strings.Join(subcommandNames(), " ")
+	switch args[0] {
+	case "bash":
+		fmt.Printf(bashCompletion, commands)
+	case "zsh":
+		fmt.Printf(zshCompletion, commands)
+	case "fish":
+		fmt.Printf(fishCompletion, commands)
+	default:
+		return fmt.Errorf("unsupported shell: %s (must be bash, zsh or fish)", args[0])
+	}
+	return nil
+}
+
+// Completion scripts complete subcommands, device names for monitor and send, and files elsewhere.
+// Device names come from list-devices when completing, so they match the connected devices.
+const bashCompletion = `_midirouter() {
+  local cur="${COMP_WORDS[COMP_CWORD]}"
+  local IFS=$'\n'
+  if [ "$COMP_CWORD" -eq 1 ]; then
+    COMPREPLY=($(compgen -W "$(printf '%%s\n' %s)" -- "$cur"))
+    return
+  fi
+  case "${COMP_WORDS[1]}" in
+    monitor)
+      [ "$COMP_CWORD" -eq 2 ] && COMPREPLY=($(compgen -W "$(midirouter list-devices --inputs --names 2>/dev/null)" -- "$cur" | while read -r name; do printf '%%q\n' "$name"; done))
+      ;;
+    send)
+      [ "$COMP_CWORD" -eq 2 ] && COMPREPLY=($(compgen -W "$(midirouter list-devices --outputs --names 2>/dev/null)" -- "$cur" | while read -r name; do printf '%%q\n' "$name"; done))
+      ;;
+    completion)
+      COMPREPLY=($(compgen -W "$(printf '%%s\n' bash zsh fish)" -- "$cur"))
+      ;;
+    *)
+      COMPREPLY=($(compgen -f -- "$cur"))
+      ;;
+  esac
+}
+complete -o filenames -F _midirouter midirouter
+`
+
+const zshCompletion = `#compdef midirouter
+_midirouter() {
+  local -a names
+  if (( CURRENT == 2 )); then
+    names=(%s)
+    compadd -a names
+    return
+  fi
+  case $words[2] in
+    monitor)
+      names=("${(@f)$(midirouter list-devices --inputs --names 2>/dev/null)}")
+      compadd -a names
+      ;;
+    send)
+      (( CURRENT == 3 )) || return
+      names=("${(@f)$(midirouter list-devices --outputs --names 2>/dev/null)}")
+      compadd -a names
+      ;;
+    completion)
+      compadd bash zsh fish
+      ;;
+    *)
+      _files
+      ;;
+  esac
+}
+compdef _midirouter midirouter
+`
+
+const fishCompletion = `complete -c midirouter -f
+complete -c midirouter -n __fish_use_subcommand -a "%s"
+complete -c midirouter -n "__fish_seen_subcommand_from monitor" -a "(midirouter list-devices --inputs --names 2>/dev/null)"
+complete -c midirouter -n "__fish_seen_subcommand_from send" -a "(midirouter list-devices --outputs --names 2>/dev/null)"
+complete -c midirouter -n "__fish_seen_subcommand_from completion" -a "bash zsh fish"
+complete -c midirouter -n "__fish_seen_subcommand_from run validate graph config import-output" -F
+`
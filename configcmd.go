@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// runConfigCommand handles the "config" subcommand:
+//
+//	midirouter config print <file>
+func runConfigCommand(args []string) error {
+	if len(args) != 2 || args[0] != "print" {
+		return fmt.Errorf("usage: midirouter config print <file>")
+	}
+
+	config, err := loadConfig(args[1])
+	if err != nil {
+		return err
+	}
+	if err := validateConfigStructure(config); err != nil {
+		return err
+	}
+
+	// Printed as it runs, with groups, pipelines and auto_outputs applied to the outputs
+	fillConfigDefaults(config)
+	config.AutoOutputs = nil
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+// fillConfigDefaults sets unset options to the values the router uses for them,
+// so a printed config shows exactly what will run
+func fillConfigDefaults(config *Config) {
+	for i := range config.Outputs {
+		output := &config.Outputs[i]
+		if output.Color == "" {
+			output.Color = defaultColorOrder[i%len(defaultColorOrder)]
+		}
+		if output.SongPosition != nil && output.SongPosition.Mode == "" {
+			output.SongPosition.Mode = "forward"
+		}
+		if output.Tuning != nil {
+			if output.Tuning.ReferenceNote == nil {
+				referenceNote := uint8(60)
+				output.Tuning.ReferenceNote = &referenceNote
+			}
+			output.Tuning.PitchBendRange = output.Tuning.bendRange()
+		}
+		if output.MTS != nil {
+			if output.MTS.Mode == "" {
+				output.MTS.Mode = "bulk"
+			}
+			if output.MTS.ReferenceNote == nil {
+				referenceNote := uint8(60)
+				output.MTS.ReferenceNote = &referenceNote
+			}
+			if output.MTS.DeviceID == nil {
+				deviceID := uint8(0x7F)
+				output.MTS.DeviceID = &deviceID
+			}
+		}
+		for j := range output.CCRanges {
+			outputMax := output.CCRanges[j].outputMax()
+			output.CCRanges[j].OutputMax = &outputMax
+		}
+		if output.RawFile != nil && output.RawFile.Format == "" {
+			output.RawFile.Format = "raw"
+		}
+	}
+
+	if config.Clock != nil && config.Clock.Source == "" {
+		config.Clock.Source = "internal"
+	}
+	if config.SysEx != nil {
+		config.SysEx.MaxSize = config.SysEx.maxSize()
+	}
+	if config.RawInput != nil && config.RawInput.Format == "" {
+		config.RawInput.Format = "raw"
+	}
+}
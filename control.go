@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// controlModes lists the --control values
+var controlModes = []string{"stdio"}
+
+// validateControlMode checks a --control value, empty for none
+func validateControlMode(mode string) error {
+	if mode == "" {
+		return nil
+	}
+	for _, known := range controlModes {
+		if mode == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid control: %q (must be %s)", mode, strings.Join(controlModes, " or "))
+}
+
+// JSON-RPC error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcFailed         = -32000
+)
+
+// rpcRequest is a JSON-RPC 2.0 request, or a notification when it has no id
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// rpcResponse is the reply to a request, with either a result or an error
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// rpcNotification is an event sent to the parent process without a request
+type rpcNotification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params,omitempty"`
+}
+
+// controlStatus is the result of the status method
+type controlStatus struct {
+	Config  string                `json:"config"`
+	Saved   bool                  `json:"saved"` // whether the configuration has no unsaved changes
+	Inputs  []string              `json:"inputs"`
+	Outputs []controlOutputStatus `json:"outputs"`
+	Dropped uint64                `json:"dropped"`
+	Uptime  float64               `json:"uptime_seconds"` // since the router last (re)started
+}
+
+type controlOutputStatus struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	Routed  uint64 `json:"routed"`
+	Errors  uint64 `json:"errors"`
+}
+
+// controlServer writes JSON-RPC responses and notifications to the parent process. It lasts
+// across router restarts.
+type controlServer struct {
+	mu       sync.Mutex
+	out      io.Writer
+	messages atomic.Bool // send a message notification for every message sent to an output
+	stats    atomic.Pointer[routeStats]
+	running  *runningRouter // the router currently running, for changes that don't restart it
+	started  atomic.Pointer[time.Time]
+	ready    chan struct{} // signalled when a router has started
+}
+
+func newControlServer(out io.Writer, running *runningRouter) *controlServer {
+	return &controlServer{out: out, running: running, ready: make(chan struct{}, 1)}
+}
+
+// write sends one JSON-RPC object on its own line
+func (cs *controlServer) write(value any) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.out.Write(append(data, '\n'))
+}
+
+// Notify sends an event to the parent process
+func (cs *controlServer) Notify(method string, params any) {
+	cs.write(rpcNotification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+// attach starts reporting the counters of a newly started router
+func (cs *controlServer) attach(stats *routeStats) {
+	now := time.Now()
+	cs.stats.Store(stats)
+	cs.started.Store(&now)
+	select {
+	case cs.ready <- struct{}{}:
+	default:
+	}
+}
+
+// sender wraps an output's sender to notify the parent of the messages it sends, when subscribed
+func (cs *controlServer) sender(output string, send func(midi.Message) error) func(midi.Message) error {
+	return func(msg midi.Message) error {
+		if err := send(msg); err != nil {
+			return err
+		}
+		if cs.messages.Load() && !msg.Is(midi.TimingClockMsg) {
+			data := make([]int, len(msg))
+			for i, value := range msg {
+				data[i] = int(value)
+			}
+			cs.Notify("message", webSocketMessage{Output: output, Data: data, TimeMS: time.Since(processStarted).Milliseconds()})
+		}
+		return nil
+	}
+}
+
+// status reports the inputs, outputs and message counts of the running configuration
+func (cs *controlServer) status(config *Config, filename string, saved bool) controlStatus {
+	status := controlStatus{Config: filename, Saved: saved, Inputs: config.sourceNames(), Outputs: []controlOutputStatus{}}
+	stats := cs.stats.Load()
+	for i, output := range config.Outputs {
+		outputStatus := controlOutputStatus{Name: output.Name, Enabled: cs.running.OutputEnabled(i, output.isEnabled())}
+		if stats != nil && i < len(stats.routed) {
+			outputStatus.Routed = stats.routed[i].Load()
+			outputStatus.Errors = stats.errors[i].Load()
+		}
+		status.Outputs = append(status.Outputs, outputStatus)
+	}
+	if stats != nil {
+		status.Dropped = stats.dropped.Load()
+	}
+	if started := cs.started.Load(); started != nil {
+		status.Uptime = time.Since(*started).Seconds()
+	}
+	return status
+}
+
+// controlParams are the parameters of every method, each using the ones it needs
+type controlParams struct {
+	Name     string          `json:"name"`     // add_output, fire_macro and the group methods
+	Output   int             `json:"output"`   // 1-based output number, for the output methods
+	Position int             `json:"position"` // move_output
+	Field    string          `json:"field"`    // set_output and unset_output
+	Value    json.RawMessage `json:"value"`    // set_output
+	Config   json.RawMessage `json:"config"`   // set_config
+	Path     string          `json:"path"`     // save_config
+}
+
+// runControl runs the router while a parent process manages it with JSON-RPC requests on stdin,
+// one per line. Responses and notifications are written to the control server's output. Like the
+// editor, every change is validated and applied by restarting the router, and only written to
+// disk by save_config.
+func runControl(drv midiDriver, config *Config, filename string, control *controlServer, prepare func(*Config), logging logOptions) error {
+	if (config.RawInput != nil && config.RawInput.Path == "-") || config.readsHotkeys() {
+		return fmt.Errorf("--control stdio cannot be used with raw input from stdin or the tap tempo and macro hotkeys")
+	}
+	if config.writesStdout() {
+		return fmt.Errorf("--control stdio cannot be used with outputs that write to stdout")
+	}
+	logging.Control = control
+
+	// Keep the virtual ports open across the restarts that apply each change
+	logging.Ports = newPortPool()
+	defer logging.Ports.Close()
+
+	lines := make(chan []byte)
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxRemoteConfigSize)
+		for scanner.Scan() {
+			lines <- append([]byte(nil), scanner.Bytes()...)
+		}
+		close(lines)
+	}()
+
+	saved := true
+	// After a change the reply waits until the router has started with it, and the previous
+	// configuration runs again if it fails to start
+	var previous *Config
+	var pending func(err *rpcError)
+	for {
+		select {
+		case <-control.ready:
+		default:
+		}
+		done := make(chan struct{})
+		result := make(chan error, 1)
+		go func(config *Config) {
+			result <- runMIDIRouter(drv, config, logging, done)
+		}(config)
+
+		if pending != nil {
+			select {
+			case <-control.ready:
+				pending(nil)
+			case err := <-result:
+				if err == nil {
+					// Interrupted while starting
+					pending(nil)
+					return nil
+				}
+				log.Printf("Error: %v, restoring the previous configuration", err)
+				pending(&rpcError{rpcFailed, err.Error()})
+				pending = nil
+				config = previous
+				continue
+			}
+			pending = nil
+		}
+
+		restart := false
+		for !restart {
+			select {
+			case err := <-result:
+				// Interrupted, or the router failed to start
+				return err
+			case line, ok := <-lines:
+				if !ok {
+					// The parent process went away
+					close(done)
+					return <-result
+				}
+				if len(strings.TrimSpace(string(line))) == 0 {
+					continue
+				}
+
+				var req rpcRequest
+				if err := json.Unmarshal(line, &req); err != nil {
+					control.write(rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{rpcParseError, err.Error()}})
+					continue
+				}
+				reply := func(result any, err *rpcError) {
+					if req.ID == nil {
+						return
+					}
+					if err == nil && result == nil {
+						result = true
+					}
+					control.write(rpcResponse{JSONRPC: "2.0", ID: req.ID, Result: result, Error: err})
+				}
+				if req.JSONRPC != "2.0" || req.Method == "" {
+					reply(nil, &rpcError{rpcInvalidRequest, "expected a JSON-RPC 2.0 request"})
+					continue
+				}
+				var params controlParams
+				if len(req.Params) > 0 {
+					if err := json.Unmarshal(req.Params, &params); err != nil {
+						reply(nil, &rpcError{rpcInvalidParams, err.Error()})
+						continue
+					}
+				}
+
+				var updated *Config
+				var err error
+				switch req.Method {
+				case "status":
+					reply(control.status(config, filename, saved), nil)
+					continue
+				case "get_config":
+					// The outputs as they run, which set_config takes back without adding
+					// the auto outputs again
+					shown := *config
+					shown.AutoOutputs = nil
+					reply(&shown, nil)
+					continue
+				case "subscribe", "unsubscribe":
+					control.messages.Store(req.Method == "subscribe")
+					reply(nil, nil)
+					continue
+				case "save_config":
+					target := filename
+					if params.Path != "" {
+						target = params.Path
+					}
+					if err := saveConfig(config, target); err != nil {
+						reply(nil, &rpcError{rpcFailed, err.Error()})
+						continue
+					}
+					filename = target
+					saved = true
+					reply(map[string]string{"path": filename}, nil)
+					continue
+				case "fire_macro":
+					if err := control.running.FireMacro(params.Name); err != nil {
+						reply(nil, &rpcError{rpcFailed, err.Error()})
+						continue
+					}
+					reply(nil, nil)
+					continue
+				case "enable_output", "disable_output", "enable_group", "disable_group":
+					// Outputs are switched on and off without restarting the router
+					command, target, _ := strings.Cut(req.Method, "_")
+					args := []string{fmt.Sprint(params.Output)}
+					if target == "group" {
+						args = strings.Fields(params.Name)
+					}
+					updated, err := editConfig(config, command, args, "")
+					if err == nil {
+						err = switchOutputs(control.running, config, command, args)
+					}
+					if err != nil {
+						reply(nil, &rpcError{rpcFailed, err.Error()})
+						continue
+					}
+					logging.Audit.Record("control", req.Method, config, updated)
+					config = updated
+					saved = false
+					reply(nil, nil)
+					continue
+				case "shutdown":
+					reply(nil, nil)
+					close(done)
+					return <-result
+				case "set_config":
+					updated, err = parseConfig(params.Config)
+					if err == nil {
+						err = validateConfigStructure(updated)
+					}
+					if err == nil && updated.RawInput == nil {
+						err = validateInputDevices(updated, drv)
+					}
+				case "add_output":
+					updated, err = editConfig(config, "add", strings.Fields(params.Name), "")
+				case "remove_output":
+					updated, err = editConfig(config, "remove", []string{fmt.Sprint(params.Output)}, "")
+				case "move_output":
+					updated, err = editConfig(config, "move", []string{fmt.Sprint(params.Output), fmt.Sprint(params.Position)}, "")
+				case "set_output":
+					line := fmt.Sprintf("set %d %s %s", params.Output, params.Field, params.Value)
+					updated, err = editConfig(config, "set", []string{fmt.Sprint(params.Output), params.Field, string(params.Value)}, line)
+				case "unset_output":
+					updated, err = editConfig(config, "unset", []string{fmt.Sprint(params.Output), params.Field}, "")
+				default:
+					reply(nil, &rpcError{rpcMethodNotFound, fmt.Sprintf("unknown method %q", req.Method)})
+					continue
+				}
+				if err != nil {
+					reply(nil, &rpcError{rpcFailed, err.Error()})
+					continue
+				}
+
+				// Apply the change by restarting the router
+				prepare(updated)
+				close(done)
+				if err := <-result; err != nil {
+					reply(nil, &rpcError{rpcFailed, err.Error()})
+					return err
+				}
+				previous = config
+				config = updated
+				wasSaved := saved
+				method := req.Method
+				pending = func(err *rpcError) {
+					if err != nil {
+						saved = wasSaved
+						reply(nil, err)
+						return
+					}
+					logging.Audit.Record("control", method, previous, config)
+					saved = false
+					reply(nil, nil)
+				}
+				restart = true
+			}
+		}
+	}
+}
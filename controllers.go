@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// ccKey identifies a controller on a 0-based channel
+type ccKey struct {
+	Channel    uint8
+	Controller uint8
+}
+
+// outputControllers is the last controller, program and pitch bend state sent to an output
+type outputControllers struct {
+	cc         map[ccKey]uint8
+	programs   map[uint8]uint8 // by 0-based channel
+	pitchBends map[uint8]int16 // by 0-based channel
+}
+
+// controllerState remembers the controller values, programs and pitch bends last sent to each
+// output, by output name. It outlives the router, so a router restarted with a new configuration
+// knows what its outputs were set to, and the state can be sent again to a synth that was
+// switched on mid-session.
+type controllerState struct {
+	mu      sync.Mutex
+	outputs map[string]*outputControllers
+}
+
+// sentControllers is the controller state of every output this process has routed to
+var sentControllers = &controllerState{outputs: make(map[string]*outputControllers)}
+
+// controllerSnapshot is the file format of a saved controller state, with messages in hex
+type controllerSnapshot struct {
+	Outputs map[string][]string `json:"outputs"`
+}
+
+func (cs *controllerState) output(name string) *outputControllers {
+	state := cs.outputs[name]
+	if state == nil {
+		state = &outputControllers{
+			cc:         make(map[ccKey]uint8),
+			programs:   make(map[uint8]uint8),
+			pitchBends: make(map[uint8]int16),
+		}
+		cs.outputs[name] = state
+	}
+	return state
+}
+
+// Update records a message sent to an output if it is a control change, program change or
+// pitch bend
+func (cs *controllerState) Update(output string, msg midi.Message) {
+	var channel, controller, value uint8
+	var relative int16
+	var absolute uint16
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	switch {
+	case msg.GetControlChange(&channel, &controller, &value):
+		cs.output(output).cc[ccKey{channel, controller}] = value
+	case msg.GetProgramChange(&channel, &value):
+		cs.output(output).programs[channel] = value
+	case msg.GetPitchBend(&channel, &relative, &absolute):
+		cs.output(output).pitchBends[channel] = relative
+	}
+}
+
+// Values returns a copy of the controller values last sent to an output
+func (cs *controllerState) Values(output string) map[ccKey]uint8 {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if state := cs.outputs[output]; state != nil {
+		return maps.Clone(state.cc)
+	}
+	return nil
+}
+
+// Messages returns the messages that recreate an output's state: programs first, since they
+// can reset controllers, then controllers and pitch bends, by channel
+func (cs *controllerState) Messages(output string) []midi.Message {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	state := cs.outputs[output]
+	if state == nil {
+		return nil
+	}
+
+	var msgs []midi.Message
+	for _, channel := range slices.Sorted(maps.Keys(state.programs)) {
+		msgs = append(msgs, midi.ProgramChange(channel, state.programs[channel]))
+	}
+	keys := slices.SortedFunc(maps.Keys(state.cc), func(a, b ccKey) int {
+		if a.Channel != b.Channel {
+			return int(a.Channel) - int(b.Channel)
+		}
+		return int(a.Controller) - int(b.Controller)
+	})
+	for _, k := range keys {
+		msgs = append(msgs, midi.ControlChange(k.Channel, k.Controller, state.cc[k]))
+	}
+	for _, channel := range slices.Sorted(maps.Keys(state.pitchBends)) {
+		msgs = append(msgs, midi.Pitchbend(channel, state.pitchBends[channel]))
+	}
+	return msgs
+}
+
+// Save writes the state of every output to a file
+func (cs *controllerState) Save(filename string) error {
+	cs.mu.Lock()
+	names := slices.Sorted(maps.Keys(cs.outputs))
+	cs.mu.Unlock()
+
+	snapshot := controllerSnapshot{Outputs: make(map[string][]string)}
+	for _, name := range names {
+		for _, msg := range cs.Messages(name) {
+			snapshot.Outputs[name] = append(snapshot.Outputs[name], fmt.Sprintf("% X", []byte(msg)))
+		}
+	}
+
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to save controller state: %w", err)
+	}
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		return fmt.Errorf("failed to save controller state: %w", err)
+	}
+	return nil
+}
+
+// Load replaces the state of the outputs in a saved file. A missing file is not an error, there
+// is just no state to restore yet.
+func (cs *controllerState) Load(filename string) error {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load controller state: %w", err)
+	}
+
+	var snapshot controllerSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("failed to load controller state %s: %w", filename, err)
+	}
+	for name, messages := range snapshot.Outputs {
+		cs.mu.Lock()
+		delete(cs.outputs, name)
+		cs.mu.Unlock()
+		for _, hex := range messages {
+			message := recordedMessage{Data: hex}
+			msg, err := message.bytes()
+			if err != nil {
+				return fmt.Errorf("failed to load controller state %s: %w", filename, err)
+			}
+			cs.Update(name, msg)
+		}
+	}
+	return nil
+}
+
+// softTakeover holds back a physical control after a configuration change until its value
+// reaches the value the output was last set to, so a fader that was moved meanwhile doesn't
+// make the destination jump
+type softTakeover struct {
+	targets map[ccKey]uint8 // values still to be picked up
+	last    map[ccKey]uint8 // previous value from each control
+}
+
+func newSoftTakeover(values map[ccKey]uint8) *softTakeover {
+	if values == nil {
+		values = make(map[ccKey]uint8)
+	}
+	return &softTakeover{targets: values, last: make(map[ccKey]uint8)}
+}
+
+// Pass tests if a control change, as it would be sent to the output, has picked up the
+// output's value. The control is picked up when it comes within one step of the value or moves
+// across it.
+func (st *softTakeover) Pass(msg midi.Message) bool {
+	var channel, controller, value uint8
+	if !msg.GetControlChange(&channel, &controller, &value) {
+		return true
+	}
+
+	k := ccKey{channel, controller}
+	target, waiting := st.targets[k]
+	if !waiting {
+		return true
+	}
+
+	previous, hasPrevious := st.last[k]
+	st.last[k] = value
+	crossed := hasPrevious && ((previous < target && value > target) || (previous > target && value < target))
+	if crossed || max(value, target)-min(value, target) <= 1 {
+		delete(st.targets, k)
+		delete(st.last, k)
+		return true
+	}
+	return false
+}
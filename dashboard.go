@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// dashboardRefresh is how often the dashboard is redrawn
+const dashboardRefresh = 500 * time.Millisecond
+
+// runDashboard redraws a live table of per-output message counts, rates and the last routed
+// message until stop is closed. colors holds the ANSI color of each output, and descriptions the
+// description shown under each output.
+func runDashboard(stats *routeStats, colors []string, descriptions []string, logging logOptions, stop <-chan struct{}) {
+	ticker := time.NewTicker(dashboardRefresh)
+	defer ticker.Stop()
+
+	previous := make([]uint64, len(stats.names)+1)
+	lastDraw := time.Now()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			elapsed := now.Sub(lastDraw).Seconds()
+			lastDraw = now
+
+			var sb strings.Builder
+			// Move to the top left and clear the screen
+			sb.WriteString("\033[H\033[2J")
+			sb.WriteString("MIDI Router - press Ctrl+C to stop\n\n")
+			fmt.Fprintf(&sb, "%-24s %10s %8s %8s  %s\n", "OUTPUT", "TOTAL", "MSG/S", "ERRORS", "LAST MESSAGE")
+
+			for i, name := range stats.names {
+				total := stats.routed[i].Load()
+				rate := float64(total-previous[i]) / elapsed
+				previous[i] = total
+				line := fmt.Sprintf("%-24.24s %10d %8.1f %8d  %s",
+					name, total, rate, stats.errors[i].Load(), previewMessage(stats.last[i].Load(), logging))
+				if logging.Color {
+					line = colorize(line, colors[i])
+				}
+				sb.WriteString(line + "\n")
+				if descriptions[i] != "" {
+					line = "  " + descriptions[i]
+					if logging.Color {
+						line = colorize(line, "2")
+					}
+					sb.WriteString(line + "\n")
+				}
+			}
+
+			dropped := stats.dropped.Load()
+			index := len(stats.names)
+			rate := float64(dropped-previous[index]) / elapsed
+			previous[index] = dropped
+			line := fmt.Sprintf("%-24s %10d %8.1f %8s  %s",
+				"(dropped)", dropped, rate, "", previewMessage(stats.lastDropped.Load(), logging))
+			if logging.Color {
+				line = colorize(line, "2")
+			}
+			sb.WriteString(line + "\n")
+
+			fmt.Print(sb.String())
+		}
+	}
+}
+
+// previewMessage formats a message for the dashboard, or a dash when there is none yet
+func previewMessage(msg *midi.Message, logging logOptions) string {
+	if msg == nil {
+		return "-"
+	}
+	return logging.format(*msg, &MessageTransformation{})
+}
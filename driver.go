@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"gitlab.com/gomidi/midi/v2/drivers"
+	"gitlab.com/gomidi/midi/v2/drivers/rtmididrv/imported/rtmidi"
+)
+
+// defaultDriver is the MIDI backend used when neither --driver nor the config's driver is set
+const defaultDriver = "rtmidi"
+
+// midiDriver is a MIDI backend that lists and opens ports, and creates virtual ports
+type midiDriver interface {
+	drivers.Driver
+	OpenVirtualIn(name string) (drivers.In, error)
+	OpenVirtualOut(name string) (drivers.Out, error)
+}
+
+// midiBackends creates the MIDI backends compiled into this build, by name. Each backend registers
+// itself from a file with the build constraints of the platforms it runs on.
+var midiBackends = map[string]func() (midiDriver, error){}
+
+// driverNames returns the names of the available backends
+func driverNames() []string {
+	return slices.Sorted(maps.Keys(midiBackends))
+}
+
+// openDriver creates the named MIDI backend, the default backend when name is empty
+func openDriver(name string) (midiDriver, error) {
+	if name == "" {
+		name = defaultDriver
+	}
+	newDriver, ok := midiBackends[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported MIDI driver: %q (must be one of %s)", name, strings.Join(driverNames(), ", "))
+	}
+	drv, err := newDriver()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create MIDI driver %s: %w", name, err)
+	}
+	return drv, nil
+}
+
+// driverAPI returns the rtmidi API a driver opens ports with, so ports opened under a custom
+// client name use the same backend
+func driverAPI(drv midiDriver) rtmidi.API {
+	if client, ok := drv.(*rtmidiClient); ok {
+		return client.api
+	}
+	return rtmidi.APIUnspecified
+}
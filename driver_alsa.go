@@ -0,0 +1,12 @@
+//go:build linux
+
+package main
+
+import "gitlab.com/gomidi/midi/v2/drivers/rtmididrv/imported/rtmidi"
+
+// The alsa backend uses the ALSA sequencer, even when rtmidi would pick another API first
+func init() {
+	midiBackends["alsa"] = func() (midiDriver, error) {
+		return newAPIClient(rtmidi.APILinuxALSA)
+	}
+}
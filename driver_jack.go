@@ -0,0 +1,13 @@
+//go:build linux || darwin
+
+package main
+
+import "gitlab.com/gomidi/midi/v2/drivers/rtmididrv/imported/rtmidi"
+
+// The jack backend uses JACK MIDI ports, for setups that route audio and MIDI through a JACK
+// server. It fails to open when rtmidi was built without JACK support.
+func init() {
+	midiBackends["jack"] = func() (midiDriver, error) {
+		return newAPIClient(rtmidi.APIUnixJack)
+	}
+}
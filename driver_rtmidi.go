@@ -0,0 +1,15 @@
+package main
+
+import "gitlab.com/gomidi/midi/v2/drivers/rtmididrv"
+
+// The rtmidi backend uses the first MIDI API compiled into rtmidi that works: CoreMIDI on macOS,
+// ALSA on Linux and Windows MM on Windows
+func init() {
+	midiBackends["rtmidi"] = func() (midiDriver, error) {
+		drv, err := rtmididrv.New()
+		if err != nil {
+			return nil, err
+		}
+		return drv, nil
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// defaultDrumChannel is the General MIDI percussion channel
+const defaultDrumChannel = 10
+
+// DrumsConfig bundles the processing an electronic drum kit usually needs, for outputs in drums
+// mode
+type DrumsConfig struct {
+	Channel         uint8           `json:"channel,omitempty"`          // 1-16, channel every message is sent on, default 10
+	NoteMap         map[uint8]uint8 `json:"note_map,omitempty"`         // pad notes sent as other notes, e.g. {"38": 40}
+	PositionCC      *uint8          `json:"position_cc,omitempty"`      // controller the kit sends the strike position on, e.g. 16
+	PositionTo      *uint8          `json:"position_to,omitempty"`      // controller the position is sent as, dropped when omitted
+	ChokeAftertouch bool            `json:"choke_aftertouch,omitempty"` // turn cymbal choke aftertouch into note offs
+}
+
+// Validate checks the drum settings
+func (dc *DrumsConfig) Validate() error {
+	if dc.Channel > 16 {
+		return fmt.Errorf("invalid channel: %d (must be 1-16)", dc.Channel)
+	}
+	for from, to := range dc.NoteMap {
+		if from > 127 || to > 127 {
+			return fmt.Errorf("invalid note_map entry: %d to %d (notes must be 0-127)", from, to)
+		}
+	}
+	for _, controller := range []*uint8{dc.PositionCC, dc.PositionTo} {
+		if controller != nil && *controller > 127 {
+			return fmt.Errorf("invalid controller: %d (must be 0-127)", *controller)
+		}
+	}
+	if dc.PositionTo != nil && dc.PositionCC == nil {
+		return fmt.Errorf("position_to requires position_cc")
+	}
+	return nil
+}
+
+// drums returns an output's drum settings, the defaults when drums mode has no drums block
+func (oc *OutputConfig) drums() *DrumsConfig {
+	if oc.Drums == nil {
+		return &DrumsConfig{}
+	}
+	return oc.Drums
+}
+
+// applyDrums maps pad notes, handles position controllers and choke aftertouch, and moves the
+// message to the drum channel. Returns false for messages the drum module should not receive.
+func applyDrums(msg midi.Message, drums *DrumsConfig, transform *MessageTransformation) (midi.Message, bool) {
+	if !hasChannelInfo(msg) {
+		return msg, true
+	}
+
+	var channel, key, velocity, controller, value uint8
+	switch {
+	case msg.GetNoteOn(&channel, &key, &velocity):
+		msg = midi.NoteOn(channel, drums.mapNote(key, transform), velocity)
+	case msg.GetNoteOff(&channel, &key, &velocity):
+		msg = midi.NoteOffVelocity(channel, drums.mapNote(key, transform), velocity)
+	case msg.GetPolyAfterTouch(&channel, &key, &value):
+		key = drums.mapNote(key, transform)
+		if drums.ChokeAftertouch {
+			// Grabbing a cymbal sends pressure, letting go sends 0
+			if value == 0 {
+				return nil, false
+			}
+			msg = midi.NoteOff(channel, key)
+		} else {
+			msg = midi.PolyAfterTouch(channel, key, value)
+		}
+	case drums.PositionCC != nil && msg.GetControlChange(&channel, &controller, &value) && controller == *drums.PositionCC:
+		if drums.PositionTo == nil {
+			return nil, false
+		}
+		msg = midi.ControlChange(channel, *drums.PositionTo, value)
+	}
+
+	drumChannel := drums.channel()
+	return applyChannelOverride(msg, &drumChannel, transform), true
+}
+
+// channel returns the 1-based channel drum messages are sent on
+func (dc *DrumsConfig) channel() uint8 {
+	if dc.Channel == 0 {
+		return defaultDrumChannel
+	}
+	return dc.Channel
+}
+
+// mapNote returns the note a pad's note is sent as
+func (dc *DrumsConfig) mapNote(key uint8, transform *MessageTransformation) uint8 {
+	mapped, ok := dc.NoteMap[key]
+	if !ok || mapped == key {
+		return key
+	}
+	transform.OriginalNote = &key
+	transform.TransformedNote = &mapped
+	return mapped
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// DryConfig sends the untouched input messages an output accepts alongside its processed ones, for
+// layering an effected copy of a part over the clean part
+type DryConfig struct {
+	Output string `json:"output,omitempty"` // sibling output that receives the untouched messages, this output when empty
+}
+
+// Validate checks the dry settings against the configured outputs
+func (dc *DryConfig) Validate(outputs []OutputConfig) error {
+	if dc.Output != "" && findOutputIndex(outputs, dc.Output) < 0 {
+		return fmt.Errorf("unknown output: %q", dc.Output)
+	}
+	return nil
+}
+
+// sendDry sends a message an output accepted, before any of its processing, to the output's dry
+// target
+func (r *router) sendDry(i int, input string, msg midi.Message) {
+	target := i
+	if output := r.config.Outputs[i].Dry.Output; output != "" {
+		// Checked by Validate
+		target = findOutputIndex(r.config.Outputs, output)
+	}
+	if !r.enabled[target].Load() {
+		return
+	}
+	if err := r.sendTo(target, msg); err != nil {
+		sendErrors.Printf(r.outputName(target), "Error sending dry message to %s: %v", r.outputName(target), err)
+		r.stats.Error(target)
+		return
+	}
+	logSuccessfulRoute(r.outputName(target), outputColor(&r.config.Outputs[target], target), msg, &MessageTransformation{Input: r.inputLabel(input) + " (dry)", DriverMS: r.driverMS}, r.logging)
+	r.stats.Routed(target, msg)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// EchoConfig repeats notes after a delay with decaying velocity
+type EchoConfig struct {
+	DelayMS     int     `json:"delay_ms,omitempty"`     // delay between repeats in milliseconds
+	DelayClocks int     `json:"delay_clocks,omitempty"` // delay between repeats in MIDI clock ticks (24 per quarter note)
+	Delay       string  `json:"delay,omitempty"`        // delay between repeats as a note value, e.g. "1/16", "1/8T" or "1/8D"
+	Repeats     int     `json:"repeats"`                // number of repeats, 1-32
+	Decay       float64 `json:"decay"`                  // velocity multiplier applied on each repeat, 0-1
+}
+
+// Validate checks the echo settings
+func (ec *EchoConfig) Validate() error {
+	delays := 0
+	for _, set := range []bool{ec.DelayMS > 0, ec.DelayClocks > 0, ec.Delay != ""} {
+		if set {
+			delays++
+		}
+	}
+	if delays != 1 {
+		return fmt.Errorf("echo needs exactly one of delay_ms, delay_clocks or delay")
+	}
+	if ec.Delay != "" {
+		if _, err := parseNoteValue(ec.Delay); err != nil {
+			return err
+		}
+	}
+	if ec.DelayMS < 0 || ec.DelayClocks < 0 {
+		return fmt.Errorf("echo delay must be positive")
+	}
+	if ec.Repeats < 1 || ec.Repeats > 32 {
+		return fmt.Errorf("echo repeats must be 1-32, got %d", ec.Repeats)
+	}
+	if ec.Decay <= 0 || ec.Decay > 1 {
+		return fmt.Errorf("echo decay must be greater than 0 and at most 1, got %g", ec.Decay)
+	}
+	return nil
+}
+
+// usesClock reports whether the echo delay follows incoming MIDI clock
+func (ec *EchoConfig) usesClock() bool {
+	return ec.DelayClocks > 0 || ec.Delay != ""
+}
+
+// delay returns the time between repeats, resolving clock ticks against the current tempo
+func (ec *EchoConfig) delay(clock *clockTracker) time.Duration {
+	if ec.DelayClocks > 0 {
+		return time.Duration(ec.DelayClocks) * clock.TickInterval()
+	}
+	if ec.Delay != "" {
+		clocks, _ := parseNoteValue(ec.Delay)
+		return time.Duration(clocks * float64(clock.TickInterval()))
+	}
+	return time.Duration(ec.DelayMS) * time.Millisecond
+}
+
+// parseNoteValue converts a note value such as "1/16", "3/16", "1/8T" (triplet) or "1/4D" (dotted)
+// to MIDI clock ticks
+func parseNoteValue(value string) (float64, error) {
+	fraction := strings.ToUpper(strings.TrimSpace(value))
+	multiplier := 1.0
+	switch {
+	case strings.HasSuffix(fraction, "T"):
+		multiplier = 2.0 / 3.0
+		fraction = strings.TrimSuffix(fraction, "T")
+	case strings.HasSuffix(fraction, "D"):
+		multiplier = 1.5
+		fraction = strings.TrimSuffix(fraction, "D")
+	}
+
+	numerator, denominator, ok := strings.Cut(fraction, "/")
+	n, nErr := strconv.Atoi(numerator)
+	d, dErr := strconv.Atoi(denominator)
+	if !ok || nErr != nil || dErr != nil || n < 1 || d < 1 || d > 128 {
+		return 0, fmt.Errorf("invalid note value: %q (use a fraction such as 1/16, with T for triplets or D for dotted notes)", value)
+	}
+
+	// A whole note is 96 clock ticks
+	return 96 * float64(n) / float64(d) * multiplier, nil
+}
+
+// applyEcho schedules the repeats of a note message on the output's sender.
+// NoteOffs are repeated with the same spacing so every echoed NoteOn is released.
+func applyEcho(msg midi.Message, echo *EchoConfig, sched *scheduler, clock *clockTracker, outputName string, send func(midi.Message) error) {
+	if echo == nil {
+		return
+	}
+
+	var channel, key, velocity uint8
+	isNoteOn := msg.GetNoteStart(&channel, &key, &velocity)
+	if !isNoteOn && !msg.GetNoteEnd(&channel, &key) {
+		return
+	}
+
+	delay := echo.delay(clock)
+	for repeat := 1; repeat <= echo.Repeats; repeat++ {
+		var echoMsg midi.Message
+		if isNoteOn {
+			// Echoed NoteOns never drop to velocity 0, which would turn them into NoteOffs
+			echoVelocity := math.Round(float64(velocity) * math.Pow(echo.Decay, float64(repeat)))
+			echoMsg = midi.NoteOn(channel, key, uint8(math.Max(1, echoVelocity)))
+		} else {
+			echoMsg = midi.NoteOff(channel, key)
+		}
+
+		sched.Schedule(time.Duration(repeat)*delay, func() {
+			if err := send(echoMsg); err != nil {
+				sendErrors.Printf(outputName, "Error sending echo to %s: %v", outputName, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const editorHelp = `Commands:
+  add <name>                  add an output
+  remove <n>                  remove output n
+  move <n> <position>         move output n to a new position
+  set <n> <field> <json>      set an output field, e.g. set 2 transpose_semitones -12
+                              or set 1 channel_filter {"channel": 3}
+  unset <n> <field>           clear an output field
+  enable <n|group>            switch output n or a group's outputs back on
+  disable <n|group>           switch output n or a group's outputs off, keeping their ports open
+  save [file]                 save the configuration
+  quit                        stop the router and exit`
+
+// runEditor runs the router while outputs are edited from the terminal. Every change is validated
+// and applied by restarting the router with the new configuration. Changes are only written to
+// disk with the save command.
+func runEditor(drv midiDriver, config *Config, filename string, logging logOptions) error {
+	if (config.RawInput != nil && config.RawInput.Path == "-") || config.readsHotkeys() {
+		return fmt.Errorf("the editor cannot be used with raw input from stdin or the tap tempo and macro hotkeys")
+	}
+	if config.writesStdout() {
+		return fmt.Errorf("the editor cannot be used with outputs that write to stdout")
+	}
+
+	// Keep the screen for the editor
+	logging.Quiet = true
+	logging.Dashboard = false
+	logging.StatsInterval = 0
+	logging.NoBanner = true
+
+	// Keep the virtual ports open across the restarts that apply each change
+	logging.Ports = newPortPool()
+	defer logging.Ports.Close()
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	saved := true
+	status := fmt.Sprintf("Editing %s", filename)
+	for {
+		done := make(chan struct{})
+		result := make(chan error, 1)
+		go func(config *Config) {
+			result <- runMIDIRouter(drv, config, logging, done)
+		}(config)
+
+		restart := false
+		for !restart {
+			drawEditor(config, saved, status)
+
+			select {
+			case err := <-result:
+				// Interrupted, or the router failed to start
+				return err
+			case line, ok := <-lines:
+				if !ok {
+					close(done)
+					return <-result
+				}
+
+				fields := strings.Fields(line)
+				if len(fields) == 0 {
+					continue
+				}
+
+				switch fields[0] {
+				case "quit", "exit":
+					close(done)
+					return <-result
+				case "save":
+					target := filename
+					if len(fields) > 1 {
+						target = fields[1]
+					}
+					if err := saveConfig(config, target); err != nil {
+						status = err.Error()
+						continue
+					}
+					filename = target
+					saved = true
+					status = fmt.Sprintf("Saved to %s", filename)
+				case "help":
+					status = editorHelp
+				default:
+					updated, err := editConfig(config, fields[0], fields[1:], line)
+					if err != nil {
+						status = err.Error()
+						continue
+					}
+
+					// Outputs are switched on and off without restarting the router
+					if fields[0] == "enable" || fields[0] == "disable" {
+						if err := switchOutputs(logging.Running, config, fields[0], fields[1:]); err != nil {
+							status = err.Error()
+							continue
+						}
+						logging.Audit.Record("editor", strings.TrimSpace(line), config, updated)
+						config = updated
+						saved = false
+						status = fmt.Sprintf("Applied: %s", strings.TrimSpace(line))
+						continue
+					}
+
+					// Apply the change by restarting the router
+					close(done)
+					if err := <-result; err != nil {
+						return err
+					}
+					logging.Audit.Record("editor", strings.TrimSpace(line), config, updated)
+					config = updated
+					saved = false
+					status = fmt.Sprintf("Applied: %s", strings.TrimSpace(line))
+					restart = true
+				}
+			}
+		}
+	}
+}
+
+// editConfig applies an editor command to a copy of the configuration and validates the result
+func editConfig(config *Config, command string, args []string, line string) (*Config, error) {
+	updated, err := cloneConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	switch command {
+	case "add":
+		if len(args) == 0 {
+			return nil, fmt.Errorf("usage: add <name>")
+		}
+		name := strings.Join(args, " ")
+		if findOutputIndex(updated.Outputs, name) >= 0 {
+			return nil, fmt.Errorf("an output named %q already exists", name)
+		}
+		updated.Outputs = append(updated.Outputs, OutputConfig{Name: name})
+	case "remove":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("usage: remove <n>")
+		}
+		i, err := parseOutputNumber(args[0], updated)
+		if err != nil {
+			return nil, err
+		}
+		updated.Outputs = append(updated.Outputs[:i], updated.Outputs[i+1:]...)
+	case "move":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("usage: move <n> <position>")
+		}
+		from, err := parseOutputNumber(args[0], updated)
+		if err != nil {
+			return nil, err
+		}
+		to, err := parseOutputNumber(args[1], updated)
+		if err != nil {
+			return nil, err
+		}
+		output := updated.Outputs[from]
+		updated.Outputs = append(updated.Outputs[:from], updated.Outputs[from+1:]...)
+		updated.Outputs = append(updated.Outputs[:to], append([]OutputConfig{output}, updated.Outputs[to:]...)...)
+	case "set", "unset":
+		if (command == "set" && len(args) < 3) || (command == "unset" && len(args) != 2) {
+			return nil, fmt.Errorf("usage: set <n> <field> <json> or unset <n> <field>")
+		}
+		i, err := parseOutputNumber(args[0], updated)
+		if err != nil {
+			return nil, err
+		}
+		value := "null"
+		if command == "set" {
+			// The value is the rest of the line so JSON objects can contain spaces
+			value = strings.TrimSpace(line)
+			for _, field := range []string{command, args[0], args[1]} {
+				value = strings.TrimSpace(strings.TrimPrefix(value, field))
+			}
+		}
+		if err := setOutputField(&updated.Outputs[i], args[1], value); err != nil {
+			return nil, err
+		}
+	case "enable", "disable":
+		switched, err := switchedOutputs(updated, command, args)
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range switched {
+			// Enabled is the default, so it isn't saved
+			updated.Outputs[i].Enabled = nil
+			if command == "disable" {
+				disabled := false
+				updated.Outputs[i].Enabled = &disabled
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown command %q, type help for a list of commands", command)
+	}
+
+	if err := validateConfigStructure(updated); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// setOutputField sets a single JSON field of an output
+func setOutputField(output *OutputConfig, field string, value string) error {
+	var raw json.RawMessage
+	if err := json.Unmarshal([]byte(value), &raw); err != nil {
+		return fmt.Errorf("invalid value for %s: %v", field, err)
+	}
+
+	data, err := json.Marshal(output)
+	if err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	fields[field] = raw
+
+	data, err = json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+
+	var updated OutputConfig
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&updated); err != nil {
+		return fmt.Errorf("invalid value for %s: %v", field, err)
+	}
+	*output = updated
+	return nil
+}
+
+// parseOutputNumber converts a 1-based output number to an index
+func parseOutputNumber(value string, config *Config) (int, error) {
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 1 || number > len(config.Outputs) {
+		return 0, fmt.Errorf("invalid output number: %s (must be 1-%d)", value, len(config.Outputs))
+	}
+	return number - 1, nil
+}
+
+// cloneConfig returns a deep copy of a configuration
+func cloneConfig(config *Config) (*Config, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy config: %w", err)
+	}
+	var clone Config
+	if err := json.Unmarshal(data, &clone); err != nil {
+		return nil, fmt.Errorf("failed to copy config: %w", err)
+	}
+	return &clone, nil
+}
+
+// drawEditor clears the terminal and shows the outputs, commands and the status of the last command
+func drawEditor(config *Config, saved bool, status string) {
+	var sb strings.Builder
+	sb.WriteString("\033[H\033[2J")
+
+	title := fmt.Sprintf("MIDI Router editor - %s", strings.Join(config.sourceNames(), ", "))
+	if !saved {
+		title += " (unsaved changes)"
+	}
+	sb.WriteString(title + "\n\n")
+
+	for i, output := range config.Outputs {
+		if output.Description != "" {
+			fmt.Fprintf(&sb, "%2d  %s - %s\n", i+1, output.Name, output.Description)
+		} else {
+			fmt.Fprintf(&sb, "%2d  %s\n", i+1, output.Name)
+		}
+		for _, setting := range outputSettings(&output) {
+			fmt.Fprintf(&sb, "      %s\n", setting)
+		}
+	}
+
+	sb.WriteString("\nadd, remove, move, set, unset, save, help, quit\n")
+	sb.WriteString(status + "\n> ")
+	fmt.Print(sb.String())
+}
+
+// outputSettings lists the fields an output sets, as field: value pairs in compact JSON
+func outputSettings(output *OutputConfig) []string {
+	data, err := json.Marshal(output)
+	if err != nil {
+		return nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil
+	}
+
+	var settings []string
+	for field, value := range fields {
+		if field == "name" || field == "description" || string(value) == "null" {
+			continue
+		}
+		settings = append(settings, fmt.Sprintf("%s: %s", field, value))
+	}
+	sort.Strings(settings)
+	return settings
+}
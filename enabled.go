@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// isEnabled reports whether an output sends messages, on unless enabled is false
+func (oc *OutputConfig) isEnabled() bool {
+	return oc.Enabled == nil || *oc.Enabled
+}
+
+// enabledSender wraps an output's sender to drop every message while the output is disabled,
+// including the clock and background senders such as LFOs
+func enabledSender(enabled *atomic.Bool, send func(midi.Message) error) func(midi.Message) error {
+	return func(msg midi.Message) error {
+		if !enabled.Load() {
+			return nil
+		}
+		return send(msg)
+	}
+}
+
+// SetOutputEnabled turns an output on or off without restarting the router, so its port stays
+// open. Notes still sounding on an output are released before it's disabled.
+func (r *router) SetOutputEnabled(i int, enabled bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if i < 0 || i >= len(r.enabled) {
+		return fmt.Errorf("invalid output number: %d", i+1)
+	}
+	r.setOutputEnabledLocked(i, enabled)
+	return nil
+}
+
+// setOutputEnabledLocked turns an output on or off
+func (r *router) setOutputEnabledLocked(i int, enabled bool) {
+	if !enabled && r.enabled[i].Load() {
+		r.allNotesOffLocked(i)
+	}
+	r.enabled[i].Store(enabled)
+}
+
+// AllNotesOff releases the notes still sounding on every enabled output, before the router is
+// replaced by one with a changed configuration that wouldn't release them
+func (r *router) AllNotesOff() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.config.Outputs {
+		if r.enabled[i].Load() {
+			r.allNotesOffLocked(i)
+		}
+	}
+}
+
+// allNotesOffLocked sends All Notes Off on every channel of an output
+func (r *router) allNotesOffLocked(i int) {
+	for channel := uint8(0); channel < 16; channel++ {
+		if err := r.sendTo(i, midi.ControlChange(channel, 123, 0)); err != nil {
+			sendErrors.Printf(r.outputName(i), "Error sending All Notes Off to %s: %v", r.outputName(i), err)
+			r.stats.Error(i)
+			return
+		}
+	}
+}
+
+// switchedOutputs returns the outputs an enable or disable command switches: one output by
+// number, or every output of a group by name
+func switchedOutputs(config *Config, command string, args []string) ([]int, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("usage: %s <n|group>", command)
+	}
+	if members := config.groupOutputs(strings.Join(args, " ")); len(members) > 0 {
+		return members, nil
+	}
+	if _, err := strconv.Atoi(args[0]); err != nil || len(args) != 1 {
+		return nil, fmt.Errorf("unknown output group: %q", strings.Join(args, " "))
+	}
+	i, err := parseOutputNumber(args[0], config)
+	if err != nil {
+		return nil, err
+	}
+	return []int{i}, nil
+}
+
+// switchOutputs applies an enable or disable command to the running router
+func switchOutputs(running *runningRouter, config *Config, command string, args []string) error {
+	switched, err := switchedOutputs(config, command, args)
+	if err != nil {
+		return err
+	}
+	for _, i := range switched {
+		if err := running.SetOutputEnabled(i, command == "enable"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runningRouter holds the router currently running, so the editor, control mode and WebSocket
+// clients can act on it without restarting it. It lasts across router restarts.
+type runningRouter struct {
+	r atomic.Pointer[router]
+}
+
+// Set replaces the running router, nil once it has stopped
+func (rr *runningRouter) Set(r *router) {
+	if rr != nil {
+		rr.r.Store(r)
+	}
+}
+
+func (rr *runningRouter) get() (*router, error) {
+	if rr == nil || rr.r.Load() == nil {
+		return nil, fmt.Errorf("the router is not running")
+	}
+	return rr.r.Load(), nil
+}
+
+// FireMacro sends the steps of a macro of the running router, by name
+func (rr *runningRouter) FireMacro(name string) error {
+	r, err := rr.get()
+	if err != nil {
+		return err
+	}
+	return r.FireMacro(name)
+}
+
+// OutputEnabled reports whether an output of the running router is switched on, which group
+// toggles change without changing the configuration. Returns configured when it isn't running.
+func (rr *runningRouter) OutputEnabled(i int, configured bool) bool {
+	r, err := rr.get()
+	if err != nil || i >= len(r.enabled) {
+		return configured
+	}
+	return r.enabled[i].Load()
+}
+
+// SetOutputEnabled turns an output of the running router on or off
+func (rr *runningRouter) SetOutputEnabled(i int, enabled bool) error {
+	r, err := rr.get()
+	if err != nil {
+		return err
+	}
+	return r.SetOutputEnabled(i, enabled)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// envelopeStep is the time between the controller values of an envelope ramp
+const envelopeStep = 10 * time.Millisecond
+
+// EnvelopeConfig sends a controller ramp when a note starts, such as a filter sweep for a synth
+// without a velocity to filter routing
+type EnvelopeConfig struct {
+	Controller uint8  `json:"controller"`           // 0-127
+	Channel    uint8  `json:"channel,omitempty"`    // 1-16, the note's channel when omitted
+	Start      uint8  `json:"start,omitempty"`      // value at the note start, and after the release
+	Peak       *uint8 `json:"peak,omitempty"`       // value reached at the end of the attack, default 127
+	Sustain    *uint8 `json:"sustain,omitempty"`    // value held after the decay, default the peak
+	AttackMS   int    `json:"attack_ms,omitempty"`  // time from start to peak
+	DecayMS    int    `json:"decay_ms,omitempty"`   // time from peak to sustain
+	ReleaseMS  int    `json:"release_ms,omitempty"` // time back to start after the last note ends
+	Velocity   bool   `json:"velocity,omitempty"`   // scale the peak and sustain by the note velocity
+}
+
+// Validate checks the envelope settings
+func (ec *EnvelopeConfig) Validate() error {
+	if ec.Controller > 127 {
+		return fmt.Errorf("invalid controller: %d (must be 0-127)", ec.Controller)
+	}
+	if ec.Channel > 16 {
+		return fmt.Errorf("invalid channel: %d (must be 1-16)", ec.Channel)
+	}
+	for _, value := range []*uint8{&ec.Start, ec.Peak, ec.Sustain} {
+		if value != nil && *value > 127 {
+			return fmt.Errorf("invalid value: %d (must be 0-127)", *value)
+		}
+	}
+	for _, ms := range []int{ec.AttackMS, ec.DecayMS, ec.ReleaseMS} {
+		if ms < 0 || ms > 60000 {
+			return fmt.Errorf("invalid time: %dms (must be 0-60000)", ms)
+		}
+	}
+	return nil
+}
+
+// levels returns the peak and sustain values for a note velocity
+func (ec *EnvelopeConfig) levels(velocity uint8) (peak, sustain int) {
+	peak = 127
+	if ec.Peak != nil {
+		peak = int(*ec.Peak)
+	}
+	sustain = peak
+	if ec.Sustain != nil {
+		sustain = int(*ec.Sustain)
+	}
+	if ec.Velocity {
+		start := int(ec.Start)
+		peak = start + (peak-start)*int(velocity)/127
+		sustain = start + (sustain-start)*int(velocity)/127
+	}
+	return peak, sustain
+}
+
+// envelopeState runs the envelope of an output. Every note start restarts the envelope, and it is
+// released when no note is held.
+type envelopeState struct {
+	config   *EnvelopeConfig
+	name     string
+	schedule func(time.Duration, func()) // runs a function later, serialized with routing
+	send     func(midi.Message) error
+
+	held    map[noteKey]bool
+	runs    uint64 // counts started ramps, so a ramp stops when a newer one starts
+	channel uint8  // 0-based channel the controller is sent on
+	value   int    // last value sent, -1 before the first
+}
+
+func newEnvelopeState(config *EnvelopeConfig, name string, schedule func(time.Duration, func()), send func(midi.Message) error) *envelopeState {
+	return &envelopeState{
+		config:   config,
+		name:     name,
+		schedule: schedule,
+		send:     send,
+		held:     make(map[noteKey]bool),
+		value:    -1,
+	}
+}
+
+// Track starts the envelope for messages with a note start and releases it when the last held
+// note ends
+func (es *envelopeState) Track(msgs []midi.Message) {
+	started := false
+	var startVelocity uint8
+	for _, msg := range msgs {
+		var channel, key, velocity uint8
+		switch {
+		case msg.GetNoteStart(&channel, &key, &velocity):
+			es.held[noteKey{channel, key}] = true
+			if !started {
+				started, startVelocity = true, velocity
+				es.channel = channel
+			}
+		case msg.GetNoteEnd(&channel, &key):
+			delete(es.held, noteKey{channel, key})
+		}
+	}
+	if es.config.Channel != 0 {
+		es.channel = es.config.Channel - 1
+	}
+
+	switch {
+	case started:
+		peak, sustain := es.config.levels(startVelocity)
+		es.set(int(es.config.Start))
+		es.ramp(int(es.config.Start), peak, es.config.AttackMS, func() {
+			es.ramp(peak, sustain, es.config.DecayMS, nil)
+		})
+	case len(es.held) == 0 && es.value >= 0 && es.value != int(es.config.Start):
+		es.ramp(es.value, int(es.config.Start), es.config.ReleaseMS, nil)
+	}
+}
+
+// ramp moves the controller from one value to another over a time, then calls then. A new ramp
+// stops the ones before it.
+func (es *envelopeState) ramp(from, to, ms int, then func()) {
+	es.runs++
+	run := es.runs
+	steps := max(1, int(time.Duration(ms)*time.Millisecond/envelopeStep))
+
+	var step func(n int)
+	step = func(n int) {
+		if es.runs != run {
+			return
+		}
+		es.set(from + (to-from)*n/steps)
+		if n < steps {
+			es.schedule(envelopeStep, func() { step(n + 1) })
+		} else if then != nil {
+			then()
+		}
+	}
+	if ms == 0 {
+		step(steps)
+	} else {
+		es.schedule(envelopeStep, func() { step(1) })
+	}
+}
+
+// set sends a controller value if it changed
+func (es *envelopeState) set(value int) {
+	if value == es.value {
+		return
+	}
+	es.value = value
+	if err := es.send(midi.ControlChange(es.channel, es.config.Controller, uint8(value))); err != nil {
+		sendErrors.Printf(es.name, "Error sending envelope to %s: %v", es.name, err)
+	}
+}
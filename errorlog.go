@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// sendErrorInterval is how often repeated send errors of an output are summarized
+const sendErrorInterval = 10 * time.Second
+
+// sendErrors throttles the logging of send errors, so a disconnected synth doesn't fill the log
+// with a line per message
+var sendErrors = newErrorThrottle(sendErrorInterval)
+
+// errorThrottle logs the first error of an output right away, then counts the errors that follow
+// for an interval and logs a summary of them at its end
+type errorThrottle struct {
+	mu       sync.Mutex
+	interval time.Duration
+	windows  map[string]*errorWindow
+}
+
+// errorWindow counts the errors of an output that were not logged
+type errorWindow struct {
+	count int
+	last  string
+}
+
+func newErrorThrottle(interval time.Duration) *errorThrottle {
+	return &errorThrottle{interval: interval, windows: make(map[string]*errorWindow)}
+}
+
+// Printf logs an error of the named output, or counts it when the output already logged one
+// within the interval. name is the output's full port name, so every sender of an output shares
+// its throttle.
+func (et *errorThrottle) Printf(name string, format string, args ...any) {
+	message := fmt.Sprintf(format, args...)
+
+	et.mu.Lock()
+	defer et.mu.Unlock()
+	if window := et.windows[name]; window != nil {
+		window.count++
+		window.last = message
+		return
+	}
+
+	log.Print(message)
+	et.windows[name] = &errorWindow{}
+	time.AfterFunc(et.interval, func() { et.flush(name) })
+}
+
+// flush ends the interval of an output, logging a summary of the errors it counted
+func (et *errorThrottle) flush(name string) {
+	et.mu.Lock()
+	defer et.mu.Unlock()
+	window := et.windows[name]
+	delete(et.windows, name)
+	if window != nil && window.count > 0 {
+		log.Printf("%s: %d more send errors in the last %s, last: %s", name, window.count, et.interval, window.last)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/drivers"
+)
+
+// failsafe holds the outputs of the running routers, so a crash can silence them before the
+// process exits and synths aren't left holding notes
+var failsafe = &failsafeOutputs{}
+
+type failsafeOutputs struct {
+	mu      sync.Mutex
+	routers []*[]drivers.Out // the outputs of each running router
+}
+
+// Arm adds a router's outputs to the ones silenced on a crash. The returned function forgets
+// them again, before they are closed.
+func (f *failsafeOutputs) Arm(outputs []drivers.Out) (disarm func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	armed := &outputs
+	f.routers = append(f.routers, armed)
+	return func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.routers = slices.DeleteFunc(f.routers, func(router *[]drivers.Out) bool { return router == armed })
+	}
+}
+
+// Silence sends All Notes Off on every channel of every output, once. Outputs are written to
+// directly, skipping senders whose locks may be held by the crashed goroutine.
+func (f *failsafeOutputs) Silence() {
+	f.mu.Lock()
+	var outputs []drivers.Out
+	for _, router := range f.routers {
+		outputs = append(outputs, *router...)
+	}
+	f.routers = nil
+	f.mu.Unlock()
+	if len(outputs) == 0 {
+		return
+	}
+
+	fmt.Fprintln(os.Stderr, "Router crashed, sending All Notes Off to every output")
+	for _, out := range outputs {
+		if out == nil {
+			continue
+		}
+		for channel := uint8(0); channel < 16; channel++ {
+			if err := out.Send(midi.ControlChange(channel, 123, 0)); err != nil {
+				break
+			}
+		}
+	}
+}
+
+// recoverFailsafe silences the outputs if the calling goroutine is panicking, then lets the panic
+// continue. It is deferred by every goroutine that routes or sends messages.
+func recoverFailsafe() {
+	if v := recover(); v != nil {
+		failsafe.Silence()
+		panic(v)
+	}
+}
+
+// goFailsafe runs fn in a new goroutine that silences the outputs if it panics
+func goFailsafe(fn func()) {
+	go func() {
+		defer recoverFailsafe()
+		fn()
+	}()
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// FilterExpr is a filter built from the output filters combined with and, or and not, such as
+// channel 1 in the lower half of the keyboard or anything on channel 10. Each node sets exactly
+// one of its fields.
+type FilterExpr struct {
+	And             []FilterExpr        `json:"and,omitempty"` // passes when every filter passes
+	Or              []FilterExpr        `json:"or,omitempty"`  // passes when any filter passes
+	Not             *FilterExpr         `json:"not,omitempty"` // passes when the filter blocks
+	ChannelFilter   *ChannelFilter      `json:"channel_filter,omitempty"`
+	NoteRangeFilter *NoteRangeFilter    `json:"note_range_filter,omitempty"`
+	MessageTypes    *MessageTypesFilter `json:"message_types,omitempty"`
+}
+
+// Validate checks that every node sets one filter or combinator, and the filters' settings
+func (fe *FilterExpr) Validate() error {
+	set := 0
+	for _, isSet := range []bool{fe.And != nil, fe.Or != nil, fe.Not != nil, fe.ChannelFilter != nil, fe.NoteRangeFilter != nil, fe.MessageTypes != nil} {
+		if isSet {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("each filter must set exactly one of and, or, not, channel_filter, note_range_filter or message_types")
+	}
+
+	switch {
+	case fe.And != nil || fe.Or != nil:
+		name, filters := "and", fe.And
+		if fe.Or != nil {
+			name, filters = "or", fe.Or
+		}
+		if len(filters) == 0 {
+			return fmt.Errorf("%s has no filters", name)
+		}
+		for i := range filters {
+			if err := filters[i].Validate(); err != nil {
+				return fmt.Errorf("%s %d: %w", name, i+1, err)
+			}
+		}
+	case fe.Not != nil:
+		if err := fe.Not.Validate(); err != nil {
+			return fmt.Errorf("not: %w", err)
+		}
+	case fe.ChannelFilter != nil:
+		return fe.ChannelFilter.Validate()
+	case fe.NoteRangeFilter != nil:
+		if fe.NoteRangeFilter.MinNote > fe.NoteRangeFilter.MaxNote || fe.NoteRangeFilter.MaxNote > 127 {
+			return fmt.Errorf("invalid note range: %d-%d", fe.NoteRangeFilter.MinNote, fe.NoteRangeFilter.MaxNote)
+		}
+	case fe.MessageTypes != nil:
+		return fe.MessageTypes.Validate()
+	}
+	return nil
+}
+
+// filterResult is how a filter decides on a message. A filter that doesn't look at a message,
+// such as a note range on a controller, doesn't decide it either way.
+type filterResult int
+
+const (
+	filterSkip filterResult = iota // the filter doesn't apply to the message
+	filterPass
+	filterFail
+)
+
+// ShouldPass tests if a MIDI message passes the filter. Messages none of the filters apply to
+// pass, like they pass the output filters.
+func (fe *FilterExpr) ShouldPass(msg midi.Message) bool {
+	return fe.match(msg) != filterFail
+}
+
+// match decides a message. and fails when any filter fails, or passes when any passes, and or
+// passes when any filter passes, or fails when any fails, so filters that don't apply are left
+// out. not swaps pass and fail. A combinator whose filters all don't apply doesn't apply either.
+func (fe *FilterExpr) match(msg midi.Message) filterResult {
+	switch {
+	case fe.And != nil || fe.Or != nil:
+		filters, decisive := fe.And, filterFail
+		if fe.Or != nil {
+			filters, decisive = fe.Or, filterPass
+		}
+		result := filterSkip
+		for i := range filters {
+			switch decided := filters[i].match(msg); decided {
+			case decisive:
+				return decisive
+			case filterSkip:
+			default:
+				result = decided
+			}
+		}
+		return result
+	case fe.Not != nil:
+		switch fe.Not.match(msg) {
+		case filterPass:
+			return filterFail
+		case filterFail:
+			return filterPass
+		}
+		return filterSkip
+	case fe.ChannelFilter != nil:
+		if !hasChannelInfo(msg) {
+			return filterSkip
+		}
+		return passOrFail(fe.ChannelFilter.ShouldPass(msg))
+	case fe.NoteRangeFilter != nil:
+		if !isNoteMessage(msg) {
+			return filterSkip
+		}
+		return passOrFail(fe.NoteRangeFilter.ShouldPass(msg))
+	case fe.MessageTypes != nil:
+		return passOrFail(fe.MessageTypes.ShouldPass(msg))
+	}
+	return filterSkip
+}
+
+// passOrFail returns the result of a filter that applies to a message
+func passOrFail(pass bool) filterResult {
+	if pass {
+		return filterPass
+	}
+	return filterFail
+}
+
+// String describes the filter, e.g. "(channel 1 and notes 36-59) or channel 10"
+func (fe *FilterExpr) String() string {
+	join := func(filters []FilterExpr, separator string) string {
+		parts := make([]string, len(filters))
+		for i := range filters {
+			parts[i] = filters[i].String()
+			if len(filters[i].And) > 1 || len(filters[i].Or) > 1 {
+				parts[i] = "(" + parts[i] + ")"
+			}
+		}
+		return strings.Join(parts, separator)
+	}
+	switch {
+	case fe.And != nil:
+		return join(fe.And, " and ")
+	case fe.Or != nil:
+		return join(fe.Or, " or ")
+	case fe.Not != nil:
+		return "not " + join([]FilterExpr{*fe.Not}, "")
+	case fe.ChannelFilter != nil:
+		return fe.ChannelFilter.String()
+	case fe.NoteRangeFilter != nil:
+		return fe.NoteRangeFilter.String()
+	case fe.MessageTypes != nil:
+		return fe.MessageTypes.String()
+	}
+	return ""
+}
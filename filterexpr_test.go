@@ -0,0 +1,126 @@
+package main
+
+import (
+	"testing"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+func TestFilterExprNotOnMixedTraffic(t *testing.T) {
+	filter := &FilterExpr{Not: &FilterExpr{NoteRangeFilter: &NoteRangeFilter{MinNote: 36, MaxNote: 59}}}
+
+	tests := []struct {
+		name string
+		msg  midi.Message
+		want bool
+	}{
+		{"note inside the range", midi.NoteOn(0, 48, 100), false},
+		{"note outside the range", midi.NoteOn(0, 72, 100), true},
+		{"note off outside the range", midi.NoteOff(0, 72), true},
+		{"control change", midi.ControlChange(0, 1, 64), true},
+		{"pitch bend", midi.Pitchbend(0, 100), true},
+		{"clock", midi.TimingClock(), true},
+	}
+	for _, test := range tests {
+		if got := filter.ShouldPass(test.msg); got != test.want {
+			t.Errorf("%s: ShouldPass = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestFilterExprOrOnMixedTraffic(t *testing.T) {
+	filter := &FilterExpr{Or: []FilterExpr{
+		{NoteRangeFilter: &NoteRangeFilter{MinNote: 36, MaxNote: 59}},
+		{ChannelFilter: &ChannelFilter{Channel: 10}},
+	}}
+
+	tests := []struct {
+		name string
+		msg  midi.Message
+		want bool
+	}{
+		{"note inside the range", midi.NoteOn(0, 48, 100), true},
+		{"note outside the range", midi.NoteOn(0, 72, 100), false},
+		{"note on channel 10", midi.NoteOn(9, 72, 100), true},
+		{"control change on channel 1", midi.ControlChange(0, 1, 64), false},
+		{"control change on channel 10", midi.ControlChange(9, 1, 64), true},
+		{"pitch bend on channel 1", midi.Pitchbend(0, 100), false},
+		{"clock", midi.TimingClock(), true},
+	}
+	for _, test := range tests {
+		if got := filter.ShouldPass(test.msg); got != test.want {
+			t.Errorf("%s: ShouldPass = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestFilterExprAndOnMixedTraffic(t *testing.T) {
+	filter := &FilterExpr{And: []FilterExpr{
+		{ChannelFilter: &ChannelFilter{Channel: 1}},
+		{Not: &FilterExpr{NoteRangeFilter: &NoteRangeFilter{MinNote: 36, MaxNote: 59}}},
+	}}
+
+	tests := []struct {
+		name string
+		msg  midi.Message
+		want bool
+	}{
+		{"note inside the range", midi.NoteOn(0, 48, 100), false},
+		{"note outside the range", midi.NoteOn(0, 72, 100), true},
+		{"note on another channel", midi.NoteOn(1, 72, 100), false},
+		{"control change on channel 1", midi.ControlChange(0, 1, 64), true},
+		{"control change on another channel", midi.ControlChange(1, 1, 64), false},
+		{"clock", midi.TimingClock(), true},
+	}
+	for _, test := range tests {
+		if got := filter.ShouldPass(test.msg); got != test.want {
+			t.Errorf("%s: ShouldPass = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestChannelFilterInverted(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter ChannelFilter
+		msg    midi.Message
+		want   bool
+	}{
+		{"channel 10 on channel 10", ChannelFilter{Channel: 10}, midi.NoteOn(9, 36, 100), true},
+		{"channel 10 on channel 1", ChannelFilter{Channel: 10}, midi.NoteOn(0, 36, 100), false},
+		{"not channel 10 on channel 10", ChannelFilter{Channel: 10, Invert: true}, midi.NoteOn(9, 36, 100), false},
+		{"not channel 10 on channel 1", ChannelFilter{Channel: 10, Invert: true}, midi.NoteOn(0, 36, 100), true},
+		{"not channel 10, control change", ChannelFilter{Channel: 10, Invert: true}, midi.ControlChange(9, 7, 100), false},
+		{"not channel 10, clock", ChannelFilter{Channel: 10, Invert: true}, midi.TimingClock(), true},
+		{"not channel 10, start", ChannelFilter{Channel: 10, Invert: true}, midi.Start(), true},
+		{"not channel 10, SysEx", ChannelFilter{Channel: 10, Invert: true}, midi.SysEx([]byte{0x7E, 0x7F}), true},
+		{"channels 1-4 on channel 4", ChannelFilter{ChannelMin: 1, ChannelMax: 4}, midi.NoteOn(3, 60, 100), true},
+		{"channels 1-4 on channel 5", ChannelFilter{ChannelMin: 1, ChannelMax: 4}, midi.NoteOn(4, 60, 100), false},
+		{"not channels 1-4 on channel 4", ChannelFilter{ChannelMin: 1, ChannelMax: 4, Invert: true}, midi.NoteOn(3, 60, 100), false},
+		{"not channels 1-4 on channel 5", ChannelFilter{ChannelMin: 1, ChannelMax: 4, Invert: true}, midi.NoteOn(4, 60, 100), true},
+		{"not channels 1-4, clock", ChannelFilter{ChannelMin: 1, ChannelMax: 4, Invert: true}, midi.TimingClock(), true},
+	}
+	for _, test := range tests {
+		if got := test.filter.ShouldPass(test.msg); got != test.want {
+			t.Errorf("%s: ShouldPass = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestChannelFilterChannels(t *testing.T) {
+	tests := []struct {
+		filter ChannelFilter
+		want   string
+	}{
+		{ChannelFilter{Channel: 10}, "10"},
+		{ChannelFilter{Channel: 10, Invert: true}, "1-9,11-16"},
+		{ChannelFilter{ChannelMin: 5, ChannelMax: 8}, "5-8"},
+		{ChannelFilter{ChannelMin: 5, ChannelMax: 8, Invert: true}, "1-4,9-16"},
+		{ChannelFilter{ChannelMin: 1, ChannelMax: 4, Invert: true}, "5-16"},
+	}
+	for _, test := range tests {
+		if got := channelSpans(test.filter.channels()); got != test.want {
+			t.Errorf("%s: channels %s, want %s", test.filter.String(), got, test.want)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// GainConfig sets an output's level with channel volume (CC7) and expression (CC11), sent when
+// the router starts, so several synths can be balanced like on a mixer
+type GainConfig struct {
+	Volume          *uint8  `json:"volume,omitempty"`           // CC7 value, 0-127
+	Expression      *uint8  `json:"expression,omitempty"`       // CC11 value, 0-127
+	Channels        []uint8 `json:"channels,omitempty"`         // 1-16, defaults to the output's channel, or all channels
+	ReassertSeconds int     `json:"reassert_seconds,omitempty"` // send the levels again this often, 0 to only send at startup
+}
+
+// Validate checks the levels and channels
+func (gc *GainConfig) Validate() error {
+	if gc.Volume == nil && gc.Expression == nil {
+		return fmt.Errorf("set volume or expression")
+	}
+	if gc.Volume != nil && *gc.Volume > 127 {
+		return fmt.Errorf("invalid volume: %d (must be 0-127)", *gc.Volume)
+	}
+	if gc.Expression != nil && *gc.Expression > 127 {
+		return fmt.Errorf("invalid expression: %d (must be 0-127)", *gc.Expression)
+	}
+	for _, channel := range gc.Channels {
+		if channel < 1 || channel > 16 {
+			return fmt.Errorf("invalid channel: %d (must be 1-16)", channel)
+		}
+	}
+	if gc.ReassertSeconds < 0 {
+		return fmt.Errorf("invalid reassert_seconds: %d", gc.ReassertSeconds)
+	}
+	return nil
+}
+
+// gainChannels returns the 1-based channels an output's levels are sent on: the configured
+// channels, else the channels the output sends or filters on, else all 16
+func gainChannels(output *OutputConfig) []uint8 {
+	switch {
+	case len(output.Gain.Channels) > 0:
+		return output.Gain.Channels
+	case output.OverrideChannel != nil:
+		return []uint8{*output.OverrideChannel}
+	case output.ChannelFilter != nil:
+		return output.ChannelFilter.channels()
+	}
+	channels := make([]uint8, 16)
+	for i := range channels {
+		channels[i] = uint8(i + 1)
+	}
+	return channels
+}
+
+// sendGain sends an output's volume and expression levels
+func sendGain(output *OutputConfig, send func(midi.Message) error) error {
+	for _, channel := range gainChannels(output) {
+		if output.Gain.Volume != nil {
+			if err := send(midi.ControlChange(channel-1, midi.VolumeMSB, *output.Gain.Volume)); err != nil {
+				return err
+			}
+		}
+		if output.Gain.Expression != nil {
+			if err := send(midi.ControlChange(channel-1, midi.ExpressionMSB, *output.Gain.Expression)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reassertGain sends an output's levels every reassert_seconds until stop is closed, restoring
+// them after a synth is power cycled or its volume knob is turned. name is the output's port name.
+func reassertGain(output *OutputConfig, name string, send func(midi.Message) error, stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Duration(output.Gain.ReassertSeconds) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := sendGain(output, send); err != nil {
+				sendErrors.Printf(name, "Error sending gain to %s: %v", name, err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// Default gesture timing
+const (
+	defaultDoubleTapMS = 300
+	defaultLongPressMS = 500
+)
+
+// GestureConfig turns a double tap or long press of a pad or key into another event, so one
+// control can do several things
+type GestureConfig struct {
+	Trigger TriggerConfig      `json:"trigger"`           // the pad, key or button
+	Gesture string             `json:"gesture"`           // "double_tap" or "long_press"
+	TimeMS  int                `json:"time_ms,omitempty"` // double tap window or long press hold time, default 300 and 500
+	Send    GestureEventConfig `json:"send"`              // event sent when the gesture is detected
+}
+
+// GestureEventConfig is the event a gesture sends. Notes and controllers are released when the
+// control is released.
+type GestureEventConfig struct {
+	Type    string `json:"type"`              // "note", "cc" or "program"
+	Number  uint8  `json:"number"`            // note, controller or program number, 0-127
+	Value   *uint8 `json:"value,omitempty"`   // note velocity or controller value, default 127
+	Channel uint8  `json:"channel,omitempty"` // 1-16, the trigger's channel when omitted
+}
+
+// Validate checks the gesture settings
+func (gc *GestureConfig) Validate() error {
+	if err := gc.Trigger.Validate(); err != nil {
+		return err
+	}
+	if gc.Gesture != "double_tap" && gc.Gesture != "long_press" {
+		return fmt.Errorf("invalid gesture: %q (must be double_tap or long_press)", gc.Gesture)
+	}
+	if gc.TimeMS < 0 {
+		return fmt.Errorf("invalid time_ms: %d", gc.TimeMS)
+	}
+
+	switch gc.Send.Type {
+	case "note", "cc", "program":
+	default:
+		return fmt.Errorf("invalid send type: %q (must be note, cc or program)", gc.Send.Type)
+	}
+	if gc.Send.Number > 127 {
+		return fmt.Errorf("invalid send number: %d (must be 0-127)", gc.Send.Number)
+	}
+	if gc.Send.Value != nil && *gc.Send.Value > 127 {
+		return fmt.Errorf("invalid send value: %d (must be 0-127)", *gc.Send.Value)
+	}
+	if gc.Send.Channel > 16 {
+		return fmt.Errorf("invalid send channel: %d (must be 1-16)", gc.Send.Channel)
+	}
+	return nil
+}
+
+func (gc *GestureConfig) duration() time.Duration {
+	switch {
+	case gc.TimeMS > 0:
+		return time.Duration(gc.TimeMS) * time.Millisecond
+	case gc.Gesture == "double_tap":
+		return defaultDoubleTapMS * time.Millisecond
+	default:
+		return defaultLongPressMS * time.Millisecond
+	}
+}
+
+// start returns the messages sent when the gesture is detected, on the trigger's channel
+// unless the event sets one
+func (gec *GestureEventConfig) start(channel uint8) midi.Message {
+	if gec.Channel != 0 {
+		channel = gec.Channel - 1
+	}
+	value := uint8(127)
+	if gec.Value != nil {
+		value = *gec.Value
+	}
+
+	switch gec.Type {
+	case "note":
+		return midi.NoteOn(channel, gec.Number, max(1, value))
+	case "cc":
+		return midi.ControlChange(channel, gec.Number, value)
+	default:
+		return midi.ProgramChange(channel, gec.Number)
+	}
+}
+
+// end returns the message sent when the control is released after the gesture, if any
+func (gec *GestureEventConfig) end(channel uint8) midi.Message {
+	if gec.Channel != 0 {
+		channel = gec.Channel - 1
+	}
+
+	switch gec.Type {
+	case "note":
+		return midi.NoteOff(channel, gec.Number)
+	case "cc":
+		return midi.ControlChange(channel, gec.Number, 0)
+	default:
+		return nil
+	}
+}
+
+// gestureState detects a gesture on its trigger. Presses and releases that aren't part of a
+// detected double tap are routed as usual.
+type gestureState struct {
+	config    *GestureConfig
+	lastPress time.Time
+	pressed   bool
+	active    bool   // the gesture was detected and its event has not been released
+	channel   uint8  // 0-based channel of the press
+	press     uint64 // counts presses, so a long press timer can tell if it is still current
+}
+
+func newGestureState(config *GestureConfig) *gestureState {
+	return &gestureState{config: config}
+}
+
+// Press handles a press of the trigger. It returns the messages to route instead of the press
+// and whether the press itself should be routed.
+func (gs *gestureState) Press(channel uint8, now time.Time) (msgs []midi.Message, routePress bool) {
+	gs.pressed = true
+	gs.channel = channel
+	gs.press++
+
+	if gs.config.Gesture == "double_tap" {
+		if !gs.lastPress.IsZero() && now.Sub(gs.lastPress) <= gs.config.duration() {
+			// The second tap becomes the gesture event, a third tap starts over
+			gs.lastPress = time.Time{}
+			gs.active = true
+			return []midi.Message{gs.config.Send.start(channel)}, false
+		}
+		gs.lastPress = now
+	}
+	return nil, true
+}
+
+// Release handles a release of the trigger. It returns the messages to route instead of the
+// release and whether the release itself should be routed.
+func (gs *gestureState) Release() (msgs []midi.Message, routeRelease bool) {
+	gs.pressed = false
+	if !gs.active {
+		return nil, true
+	}
+	gs.active = false
+
+	if end := gs.config.Send.end(gs.channel); end != nil {
+		msgs = append(msgs, end)
+	}
+	// A long press also releases the original press
+	return msgs, gs.config.Gesture == "long_press"
+}
+
+// Held is called when a long press timer started by press number press runs out. It returns the
+// gesture's event if the trigger is still held.
+func (gs *gestureState) Held(press uint64) []midi.Message {
+	if !gs.pressed || gs.press != press || gs.active {
+		return nil
+	}
+	gs.active = true
+	return []midi.Message{gs.config.Send.start(gs.channel)}
+}
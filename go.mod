@@ -4,4 +4,4 @@ go 1.24.2
 
 toolchain go1.24.6
 
-require gitlab.com/gomidi/midi/v2 v2.3.16 // indirect
+require gitlab.com/gomidi/midi/v2 v2.3.16
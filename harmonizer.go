@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// HarmonizerConfig adds harmony notes at scale intervals to every played note
+type HarmonizerConfig struct {
+	Key       string `json:"key,omitempty"`   // root of the scale, e.g. "C", "F#", "Bb", the global key when both key and scale are omitted
+	Scale     string `json:"scale,omitempty"` // scale name, e.g. "major", "minor", "dorian"
+	Intervals []int  `json:"intervals"`       // scale steps from the played note, e.g. [2, 4] adds a third and a fifth
+}
+
+// Validate checks the harmonizer settings
+func (hc *HarmonizerConfig) Validate() error {
+	if !hc.usesGlobalKey() {
+		if _, err := parseKey(hc.Key); err != nil {
+			return err
+		}
+		if _, err := lookupScale(hc.Scale); err != nil {
+			return err
+		}
+	}
+	if len(hc.Intervals) == 0 {
+		return fmt.Errorf("harmonizer has no intervals")
+	}
+	for _, interval := range hc.Intervals {
+		if interval == 0 {
+			return fmt.Errorf("harmonizer interval must not be 0")
+		}
+	}
+	return nil
+}
+
+// configureHarmonizer prompts for the harmonizer key, scale and intervals
+func configureHarmonizer(reader *bufio.Reader) (*HarmonizerConfig, error) {
+	harmonizer := &HarmonizerConfig{}
+
+	fmt.Print("Key (default: 'C'): ")
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input: %w", err)
+	}
+	harmonizer.Key = strings.TrimSpace(line)
+	if harmonizer.Key == "" {
+		harmonizer.Key = "C"
+	}
+
+	fmt.Printf("Scale (%s) (default: 'major'): ", strings.Join(scaleNames(), ", "))
+	line, err = reader.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input: %w", err)
+	}
+	harmonizer.Scale = strings.TrimSpace(line)
+	if harmonizer.Scale == "" {
+		harmonizer.Scale = "major"
+	}
+
+	fmt.Print("Intervals in scale steps, comma separated (default: '2,4' for third and fifth): ")
+	line, err = reader.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input: %w", err)
+	}
+	intervals := strings.TrimSpace(line)
+	if intervals == "" {
+		intervals = "2,4"
+	}
+	for _, part := range strings.Split(intervals, ",") {
+		interval, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval: %q", part)
+		}
+		harmonizer.Intervals = append(harmonizer.Intervals, interval)
+	}
+
+	if err := harmonizer.Validate(); err != nil {
+		return nil, err
+	}
+
+	return harmonizer, nil
+}
+
+// harmonizerState remembers which harmony notes were started for each played note,
+// so the matching NoteOffs can be sent even if the harmony settings change. A note can be
+// sounding for several reasons at once, played and as the harmony of other notes, so it is
+// only ended when the last of them is released.
+type harmonizerState struct {
+	active   map[noteKey][]uint8
+	sounding map[noteKey]int // how many played or harmony notes hold each note
+}
+
+func newHarmonizerState() *harmonizerState {
+	return &harmonizerState{active: make(map[noteKey][]uint8), sounding: make(map[noteKey]int)}
+}
+
+// hold counts one more use of a note, reporting whether it was silent before
+func (hs *harmonizerState) hold(k noteKey) bool {
+	hs.sounding[k]++
+	return hs.sounding[k] == 1
+}
+
+// release counts one use of a note less, reporting whether nothing holds it any more
+func (hs *harmonizerState) release(k noteKey) bool {
+	if hs.sounding[k] <= 1 {
+		delete(hs.sounding, k)
+		return true
+	}
+	hs.sounding[k]--
+	return false
+}
+
+// releasePlayed ends a played note's harmony notes that nothing else holds, returning their
+// NoteOffs
+func (hs *harmonizerState) releasePlayed(channel, note uint8) (offs []midi.Message, released []uint8) {
+	k := noteKey{channel, note}
+	notes, ok := hs.active[k]
+	if !ok {
+		return nil, nil
+	}
+	delete(hs.active, k)
+	for _, harmony := range notes {
+		if hs.release(noteKey{channel, harmony}) {
+			offs = append(offs, midi.NoteOff(channel, harmony))
+			released = append(released, harmony)
+		}
+	}
+	return offs, released
+}
+
+// usesGlobalKey reports whether the harmonizer follows the global key instead of its own
+func (hc *HarmonizerConfig) usesGlobalKey() bool {
+	return hc.Key == "" && hc.Scale == ""
+}
+
+// harmonyNotes computes the harmony notes for a played note, skipping any outside the MIDI range.
+// key is the current global key, used when the harmonizer has none of its own.
+func (hc *HarmonizerConfig) harmonyNotes(note uint8, key *keyState) []uint8 {
+	var root uint8
+	var scale []int
+	if hc.usesGlobalKey() {
+		if key == nil {
+			return nil
+		}
+		root, scale = key.root, key.scale
+	} else {
+		var err error
+		if root, err = parseKey(hc.Key); err != nil {
+			return nil
+		}
+		if scale, err = lookupScale(hc.Scale); err != nil {
+			return nil
+		}
+	}
+
+	var notes []uint8
+	for _, interval := range hc.Intervals {
+		harmony := scaleStepNote(note, root, scale, interval)
+		if harmony < 0 || harmony > 127 || harmony == int(note) {
+			continue
+		}
+		notes = append(notes, uint8(harmony))
+	}
+	return notes
+}
+
+// applyHarmonizer returns the message followed by any harmony notes it triggers or releases
+func applyHarmonizer(msg midi.Message, harmonizer *HarmonizerConfig, key *keyState, state *harmonizerState, transform *MessageTransformation) []midi.Message {
+	if harmonizer == nil || state == nil {
+		return []midi.Message{msg}
+	}
+
+	messages := []midi.Message{msg}
+	var channel, note, velocity uint8
+
+	if msg.GetNoteStart(&channel, &note, &velocity) {
+		k := noteKey{channel, note}
+		if _, held := state.active[k]; held {
+			// A re-triggered key gives up its previous harmony before taking the new one
+			offs, _ := state.releasePlayed(channel, note)
+			state.release(k)
+			messages = append(messages, offs...)
+		}
+		state.hold(k)
+		notes := harmonizer.harmonyNotes(note, key)
+		state.active[k] = notes
+		var started []uint8
+		for _, harmony := range notes {
+			// A harmony note that is already sounding keeps sounding without a second NoteOn
+			if state.hold(noteKey{channel, harmony}) {
+				messages = append(messages, midi.NoteOn(channel, harmony, velocity))
+				started = append(started, harmony)
+			}
+		}
+		transform.HarmonyNotes = started
+		return messages
+	}
+
+	if msg.GetNoteEnd(&channel, &note) {
+		k := noteKey{channel, note}
+		if _, held := state.active[k]; !held {
+			return messages
+		}
+		messages = nil
+		if state.release(k) {
+			messages = append(messages, msg)
+		}
+		offs, released := state.releasePlayed(channel, note)
+		messages = append(messages, offs...)
+		transform.HarmonyNotes = released
+	}
+
+	return messages
+}
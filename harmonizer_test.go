@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+func TestHarmonizerNoteOffPairing(t *testing.T) {
+	// A third above in C major: C4 adds E4, E4 adds G4, G4 adds B4
+	harmonizer := &HarmonizerConfig{Key: "C", Scale: "major", Intervals: []int{2}}
+
+	tests := []struct {
+		name  string
+		steps []midi.Message
+		want  []string // messages sent for the last step
+	}{
+		{
+			"note and its harmony",
+			[]midi.Message{midi.NoteOn(0, 60, 100)},
+			[]string{"90 3C 64", "90 40 64"},
+		},
+		{
+			"releasing a note keeps its harmony while the same key is played",
+			[]midi.Message{midi.NoteOn(0, 60, 100), midi.NoteOn(0, 64, 100), midi.NoteOff(0, 60)},
+			[]string{"80 3C 00"},
+		},
+		{
+			"the played harmony note ends with its own key",
+			[]midi.Message{midi.NoteOn(0, 60, 100), midi.NoteOn(0, 64, 100), midi.NoteOff(0, 60), midi.NoteOff(0, 64)},
+			[]string{"80 40 00", "80 43 00"},
+		},
+		{
+			"a played note that is also a harmony keeps sounding",
+			[]midi.Message{midi.NoteOn(0, 60, 100), midi.NoteOn(0, 64, 100), midi.NoteOff(0, 64)},
+			[]string{"80 43 00"},
+		},
+		{
+			"a harmony on a played key is not started again or ended early",
+			[]midi.Message{midi.NoteOn(0, 60, 100), midi.NoteOn(0, 57, 100), midi.NoteOff(0, 57)},
+			[]string{"80 39 00"},
+		},
+		{
+			"a re-triggered key releases its previous harmony",
+			[]midi.Message{midi.NoteOn(0, 60, 100), midi.NoteOn(0, 60, 90)},
+			[]string{"90 3C 5A", "80 40 00", "90 40 5A"},
+		},
+		{
+			"a note off without a note on passes",
+			[]midi.Message{midi.NoteOff(0, 60)},
+			[]string{"80 3C 00"},
+		},
+	}
+	for _, test := range tests {
+		state := newHarmonizerState()
+		var sent []midi.Message
+		for _, step := range test.steps {
+			sent = applyHarmonizer(step, harmonizer, nil, state, &MessageTransformation{})
+		}
+		var got []string
+		for _, msg := range sent {
+			got = append(got, fmt.Sprintf("% X", []byte(msg)))
+		}
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%s: sent %v, want %v", test.name, got, test.want)
+		}
+	}
+}
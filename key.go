@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// KeyConfig is the key and scale shared by harmonizers, scale quantizing and scale transposing,
+// so the whole setup changes key together
+type KeyConfig struct {
+	Root    string            `json:"root"`              // root of the scale, e.g. "C", "F#", "Bb"
+	Scale   string            `json:"scale"`             // scale name, e.g. "major", "minor", "dorian"
+	Control *KeyControlConfig `json:"control,omitempty"` // controller that picks the root while playing
+}
+
+// KeyControlConfig maps a controller's range across the 12 roots, C at 0 up to B at 127
+type KeyControlConfig struct {
+	Controller uint8 `json:"controller"`        // 0-127
+	Channel    uint8 `json:"channel,omitempty"` // 1-16, any channel when omitted
+}
+
+// Validate checks the key settings
+func (kc *KeyConfig) Validate() error {
+	if _, err := parseKey(kc.Root); err != nil {
+		return err
+	}
+	if _, err := lookupScale(kc.Scale); err != nil {
+		return err
+	}
+	if kc.Control != nil {
+		if kc.Control.Controller > 127 {
+			return fmt.Errorf("invalid control controller: %d (must be 0-127)", kc.Control.Controller)
+		}
+		if kc.Control.Channel > 16 {
+			return fmt.Errorf("invalid control channel: %d (must be 1-16)", kc.Control.Channel)
+		}
+	}
+	return nil
+}
+
+// keyState is the current key, changed by the key control
+type keyState struct {
+	config *KeyConfig
+	root   uint8
+	scale  []int
+}
+
+func newKeyState(config *KeyConfig) (*keyState, error) {
+	root, err := parseKey(config.Root)
+	if err != nil {
+		return nil, err
+	}
+	scale, err := lookupScale(config.Scale)
+	if err != nil {
+		return nil, err
+	}
+	return &keyState{config: config, root: root, scale: scale}, nil
+}
+
+// String returns the key's name, e.g. "F# minor"
+func (ks *keyState) String() string {
+	return fmt.Sprintf("%s %s", noteNames[ks.root], strings.ToLower(strings.TrimSpace(ks.config.Scale)))
+}
+
+// Control picks the root from a key control message. matched is true for any message of the
+// control, changed only when the root changed.
+func (ks *keyState) Control(msg midi.Message) (matched bool, changed bool) {
+	control := ks.config.Control
+	if control == nil {
+		return false, false
+	}
+	var channel, controller, value uint8
+	if !msg.GetControlChange(&channel, &controller, &value) || controller != control.Controller {
+		return false, false
+	}
+	if control.Channel != 0 && channel+1 != control.Channel {
+		return false, false
+	}
+
+	root := uint8(int(value) * 12 / 128)
+	if root == ks.root {
+		return true, false
+	}
+	ks.root = root
+	return true, true
+}
+
+// quantizeToScale returns the scale note nearest to note, the lower one when two are as near
+func quantizeToScale(note uint8, root uint8, scale []int) int {
+	inScale := func(n int) bool {
+		pitchClass := ((n-int(root))%12 + 12) % 12
+		for _, offset := range scale {
+			if offset == pitchClass {
+				return true
+			}
+		}
+		return false
+	}
+	for distance := 0; distance < 12; distance++ {
+		if inScale(int(note) - distance) {
+			return int(note) - distance
+		}
+		if inScale(int(note) + distance) {
+			return int(note) + distance
+		}
+	}
+	return int(note)
+}
+
+// scaleNoteState remembers the note sent for each held key of an output that quantizes or
+// transposes by the global key, so note ends match their starts after a key change
+type scaleNoteState struct {
+	sent map[noteKey]uint8
+}
+
+func newScaleNoteState() *scaleNoteState {
+	return &scaleNoteState{sent: make(map[noteKey]uint8)}
+}
+
+// applyScaleNotes snaps notes to the current key's scale and moves them by scale steps, as
+// configured. Notes that would leave the MIDI note range are sent unchanged.
+func applyScaleNotes(msg midi.Message, output *OutputConfig, key *keyState, state *scaleNoteState, transform *MessageTransformation) midi.Message {
+	if state == nil {
+		return msg
+	}
+
+	var channel, note, velocity uint8
+	switch {
+	case msg.GetNoteStart(&channel, &note, &velocity):
+		moved := int(note)
+		if output.ScaleQuantize {
+			moved = quantizeToScale(note, key.root, key.scale)
+		}
+		if output.ScaleTranspose != 0 && moved >= 0 && moved <= 127 {
+			moved = scaleStepNote(uint8(moved), key.root, key.scale, output.ScaleTranspose)
+		}
+		if moved < 0 || moved > 127 {
+			moved = int(note)
+		}
+		state.sent[noteKey{channel, note}] = uint8(moved)
+		if moved == int(note) {
+			return msg
+		}
+		recordNoteChange(note, uint8(moved), transform)
+		return midi.NoteOn(channel, uint8(moved), velocity)
+	case msg.GetNoteEnd(&channel, &note):
+		k := noteKey{channel, note}
+		moved, held := state.sent[k]
+		delete(state.sent, k)
+		if !held || moved == note {
+			return msg
+		}
+		recordNoteChange(note, moved, transform)
+		return midi.NoteOffVelocity(channel, moved, msg[2])
+	}
+	return msg
+}
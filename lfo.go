@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// lfoInterval is how often LFO values are computed. Values are only sent when they change.
+const lfoInterval = 10 * time.Millisecond
+
+// LFOConfig is an internal low frequency oscillator that sends a controller or pitch bend to
+// outputs, modulating hardware that has no LFO of its own
+type LFOConfig struct {
+	Shape      string   `json:"shape"`                // "sine", "triangle", "saw", "square" or "random"
+	RateHz     float64  `json:"rate_hz,omitempty"`    // cycles per second, 0.01-50
+	Rate       string   `json:"rate,omitempty"`       // length of a cycle as a note value synced to MIDI clock, e.g. "1/4" or "2/1"
+	Type       string   `json:"type"`                 // "cc" or "pitchbend"
+	Controller uint8    `json:"controller,omitempty"` // 0-127, for cc
+	Channel    uint8    `json:"channel"`              // 1-16
+	Depth      *float64 `json:"depth,omitempty"`      // 0-1, how far the value swings from center, default 1
+	Center     *int     `json:"center,omitempty"`     // value the LFO swings around, default 64 for cc and 0 for pitchbend
+	Outputs    []string `json:"outputs,omitempty"`    // names of outputs or output groups that receive the LFO, all outputs when empty
+}
+
+// Validate checks the LFO settings against the configured outputs
+func (lc *LFOConfig) Validate(outputs []OutputConfig) error {
+	switch lc.Shape {
+	case "sine", "triangle", "saw", "square", "random":
+	default:
+		return fmt.Errorf("invalid shape: %q (must be sine, triangle, saw, square or random)", lc.Shape)
+	}
+	if (lc.RateHz != 0) == (lc.Rate != "") {
+		return fmt.Errorf("set exactly one of rate_hz or rate")
+	}
+	if lc.RateHz != 0 && (lc.RateHz < 0.01 || lc.RateHz > 50) {
+		return fmt.Errorf("invalid rate_hz: %g (must be 0.01-50)", lc.RateHz)
+	}
+	if lc.Rate != "" {
+		if _, err := parseNoteValue(lc.Rate); err != nil {
+			return err
+		}
+	}
+	switch lc.Type {
+	case "cc":
+		if lc.Controller > 127 {
+			return fmt.Errorf("invalid controller: %d (must be 0-127)", lc.Controller)
+		}
+	case "pitchbend":
+	default:
+		return fmt.Errorf("invalid type: %q (must be cc or pitchbend)", lc.Type)
+	}
+	if lc.Channel < 1 || lc.Channel > 16 {
+		return fmt.Errorf("invalid channel: %d (must be 1-16)", lc.Channel)
+	}
+	if lc.Depth != nil && (*lc.Depth < 0 || *lc.Depth > 1) {
+		return fmt.Errorf("invalid depth: %g (must be 0-1)", *lc.Depth)
+	}
+	if lc.Center != nil {
+		if low, high := lc.valueRange(); *lc.Center < low || *lc.Center > high {
+			return fmt.Errorf("invalid center: %d (must be %d-%d)", *lc.Center, low, high)
+		}
+	}
+	for _, name := range lc.Outputs {
+		if !hasOutputOrGroup(outputs, name) {
+			return fmt.Errorf("unknown output: %q", name)
+		}
+	}
+	return nil
+}
+
+// valueRange returns the lowest and highest value the LFO's target takes
+func (lc *LFOConfig) valueRange() (int, int) {
+	if lc.Type == "pitchbend" {
+		return -8192, 8191
+	}
+	return 0, 127
+}
+
+// period returns the length of a cycle, following the tempo of incoming clock for synced rates
+func (lc *LFOConfig) period(clock *clockTracker) time.Duration {
+	if lc.Rate != "" {
+		clocks, _ := parseNoteValue(lc.Rate)
+		return time.Duration(clocks * float64(clock.TickInterval()))
+	}
+	return time.Duration(float64(time.Second) / lc.RateHz)
+}
+
+// value returns the target value at a phase of 0-1, where held is the random shape's current step
+func (lc *LFOConfig) value(phase float64, held float64) int {
+	var wave float64 // -1 to 1
+	switch lc.Shape {
+	case "sine":
+		wave = math.Sin(2 * math.Pi * phase)
+	case "triangle":
+		wave = 1 - 4*math.Abs(phase-0.5)
+	case "saw":
+		wave = 2*phase - 1
+	case "square":
+		wave = 1
+		if phase >= 0.5 {
+			wave = -1
+		}
+	case "random":
+		wave = held
+	}
+
+	low, high := lc.valueRange()
+	center := float64(low+high+1) / 2
+	if lc.Center != nil {
+		center = float64(*lc.Center)
+	}
+	depth := 1.0
+	if lc.Depth != nil {
+		depth = *lc.Depth
+	}
+	swing := depth * float64(high-low+1) / 2
+	return max(low, min(high, int(math.Round(center+wave*swing))))
+}
+
+// message returns the message that sets the LFO's target to value
+func (lc *LFOConfig) message(value int) midi.Message {
+	if lc.Type == "pitchbend" {
+		return midi.Pitchbend(lc.Channel-1, int16(value))
+	}
+	return midi.ControlChange(lc.Channel-1, lc.Controller, uint8(value))
+}
+
+// runLFOs sends the values of the configured LFOs to their outputs until stop is closed
+func runLFOs(config *Config, clock *clockTracker, send func(i int, msg midi.Message) error, stop <-chan struct{}) {
+	if len(config.LFOs) == 0 {
+		return
+	}
+
+	type lfoState struct {
+		phase float64
+		held  float64 // random shape value, changed at the start of each cycle
+		last  int
+		sent  bool
+	}
+	states := make([]lfoState, len(config.LFOs))
+	for i := range states {
+		states[i].held = rand.Float64()*2 - 1
+	}
+
+	ticker := time.NewTicker(lfoInterval)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case now := <-ticker.C:
+			elapsed := now.Sub(last)
+			last = now
+			for l := range config.LFOs {
+				lfo := &config.LFOs[l]
+				state := &states[l]
+
+				state.phase += float64(elapsed) / float64(lfo.period(clock))
+				if state.phase >= 1 {
+					state.phase = math.Mod(state.phase, 1)
+					state.held = rand.Float64()*2 - 1
+				}
+
+				value := lfo.value(state.phase, state.held)
+				if state.sent && value == state.last {
+					continue
+				}
+				state.last, state.sent = value, true
+
+				for i, output := range config.Outputs {
+					if !sendsTo(lfo.Outputs, &output) {
+						continue
+					}
+					if err := send(i, lfo.message(value)); err != nil {
+						name := config.outputPortName(i)
+						sendErrors.Printf(name, "Error sending LFO %d to %s: %v", l+1, name, err)
+					}
+				}
+			}
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// liveStateInterval is how often the live state is written while it changes
+const liveStateInterval = time.Second
+
+// liveState is what was adjusted while the router runs, without changing the configuration:
+// outputs switched off or on, octave shifts, the global key and the tempo. Outputs are named by
+// their name, and only the ones switched away from their configured setting are listed.
+type liveState struct {
+	Disabled     []string       `json:"disabled,omitempty"`      // outputs switched off, by the editor, control mode or a group toggle
+	Enabled      []string       `json:"enabled,omitempty"`       // outputs configured with enabled false that were switched on
+	OctaveShifts map[string]int `json:"octave_shifts,omitempty"` // current octave shift of each output with one
+	Key          string         `json:"key,omitempty"`           // root of the global key, e.g. "F#"
+	BPM          float64        `json:"bpm,omitempty"`           // tempo of the internal clock, e.g. after tap tempo
+}
+
+// liveStateFile keeps the live state on disk, so a crash or power cycle resumes with the same
+// adjustments. The state read at startup is restored into the first router that starts.
+type liveStateFile struct {
+	path     string
+	restore  *liveState // nil once restored, or when there was nothing to restore
+	previous []byte     // the last state written, so an unchanged state isn't written again
+}
+
+// openLiveState reads the state saved by a previous run. A missing file is not an error, there is
+// just no state to restore yet.
+func openLiveState(path string) (*liveStateFile, error) {
+	file := &liveStateFile{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return file, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load live state: %w", err)
+	}
+	var state liveState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to load live state %s: %w", path, err)
+	}
+	file.restore = &state
+	file.previous = data
+	return file, nil
+}
+
+// Run restores the saved state into a router that just started, the first time only, then writes
+// the router's state every liveStateInterval while it changes, and once more when stop is closed
+func (lf *liveStateFile) Run(r *router, stop <-chan struct{}) {
+	if lf.restore != nil {
+		r.restoreLiveState(lf.restore)
+		lf.restore = nil
+	}
+	ticker := time.NewTicker(liveStateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			lf.save(r.liveState())
+			return
+		}
+		lf.save(r.liveState())
+	}
+}
+
+// save writes the state if it changed. The file is replaced in one step, so a crash while writing
+// leaves the previous state.
+func (lf *liveStateFile) save(state liveState) {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil || bytes.Equal(data, lf.previous) {
+		return
+	}
+	temp, err := os.CreateTemp(filepath.Dir(lf.path), filepath.Base(lf.path)+".*")
+	if err == nil {
+		_, err = temp.Write(data)
+		if err == nil {
+			err = temp.Sync()
+		}
+		if closeErr := temp.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(temp.Name(), lf.path)
+		}
+		if err != nil {
+			os.Remove(temp.Name())
+		}
+	}
+	if err != nil {
+		log.Printf("Error saving live state: %v", err)
+		return
+	}
+	lf.previous = data
+}
+
+// liveState returns the router's current live adjustments
+func (r *router) liveState() liveState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var state liveState
+	for i, output := range r.config.Outputs {
+		switch enabled := r.enabled[i].Load(); {
+		case !enabled && output.isEnabled():
+			state.Disabled = append(state.Disabled, output.Name)
+		case enabled && !output.isEnabled():
+			state.Enabled = append(state.Enabled, output.Name)
+		}
+		if shift := r.octaveShifts[i]; shift != nil && shift.octaves != 0 {
+			if state.OctaveShifts == nil {
+				state.OctaveShifts = make(map[string]int)
+			}
+			state.OctaveShifts[output.Name] = shift.octaves
+		}
+	}
+	if r.key != nil {
+		state.Key = noteNames[r.key.root]
+	}
+	if r.generator != nil && !r.config.Clock.regenerates() {
+		state.BPM = r.generator.BPM()
+	}
+	return state
+}
+
+// restoreLiveState applies saved live adjustments. Outputs that are no longer configured are
+// skipped, and outputs the state doesn't list keep their configured setting.
+func (r *router) restoreLiveState(state *liveState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, name := range state.Disabled {
+		if i := findOutputIndex(r.config.Outputs, name); i >= 0 {
+			r.setOutputEnabledLocked(i, false)
+		}
+	}
+	for _, name := range state.Enabled {
+		if i := findOutputIndex(r.config.Outputs, name); i >= 0 {
+			r.setOutputEnabledLocked(i, true)
+		}
+	}
+	for name, octaves := range state.OctaveShifts {
+		if i := findOutputIndex(r.config.Outputs, name); i >= 0 && r.octaveShifts[i] != nil {
+			r.octaveShifts[i].octaves = 0
+			r.octaveShifts[i].Shift(octaves)
+		}
+	}
+	if r.key != nil && state.Key != "" {
+		if root, err := parseKey(state.Key); err == nil {
+			r.key.root = root
+		}
+	}
+	if r.generator != nil && state.BPM > 0 && !r.config.Clock.regenerates() {
+		r.generator.SetBPM(state.BPM)
+	}
+	fmt.Println("Restored live state from the previous run")
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// LogicalInputConfig merges the ports of a device that exposes several, such as a controller's
+// keys, pads and DIN input, into one input. Filters can name the logical input to take all of its
+// ports, or one of the ports.
+type LogicalInputConfig struct {
+	Name  string   `json:"name"`
+	Ports []string `json:"ports"` // input ports of the device, opened like input_devices
+}
+
+// validateLogicalInputs checks the logical inputs against the other inputs
+func validateLogicalInputs(config *Config) error {
+	inputs := config.sourceNames()
+	ports := make(map[string]string)
+	for i, logical := range config.LogicalInputs {
+		if logical.Name == "" {
+			return fmt.Errorf("logical input %d has no name", i+1)
+		}
+		if slices.Contains(inputs, logical.Name) || config.findLogicalInput(logical.Name) != &config.LogicalInputs[i] {
+			return fmt.Errorf("logical input %d has the same name as another input: %s", i+1, logical.Name)
+		}
+		if len(logical.Ports) == 0 {
+			return fmt.Errorf("logical input %s has no ports", logical.Name)
+		}
+		for _, port := range logical.Ports {
+			if port == "" {
+				return fmt.Errorf("logical input %s has an empty port", logical.Name)
+			}
+			if other, ok := ports[port]; ok {
+				return fmt.Errorf("input %s is a port of both %s and %s", port, other, logical.Name)
+			}
+			ports[port] = logical.Name
+		}
+	}
+	if len(config.LogicalInputs) > 0 && config.RawInput != nil {
+		return fmt.Errorf("logical_inputs can't be combined with raw_input")
+	}
+	return nil
+}
+
+// findLogicalInput returns the logical input with a name, or nil
+func (c *Config) findLogicalInput(name string) *LogicalInputConfig {
+	for i := range c.LogicalInputs {
+		if c.LogicalInputs[i].Name == name {
+			return &c.LogicalInputs[i]
+		}
+	}
+	return nil
+}
+
+// logicalInputOf returns the name of the logical input a port belongs to, or an empty string
+func (c *Config) logicalInputOf(port string) string {
+	for _, logical := range c.LogicalInputs {
+		if slices.Contains(logical.Ports, port) {
+			return logical.Name
+		}
+	}
+	return ""
+}
+
+// inputListed reports whether an input is in a list of input names, directly or by the logical
+// input whose name stands for all of its ports
+func (c *Config) inputListed(names []string, input string) bool {
+	if slices.Contains(names, input) {
+		return true
+	}
+	logical := c.logicalInputOf(input)
+	return logical != "" && slices.Contains(names, logical)
+}
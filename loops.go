@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// Feedback loop detection
+const (
+	loopWindow          = 100 * time.Millisecond // how long after being sent a message counts as coming back
+	loopThreshold       = 100                    // messages coming back on an input within a second that mean a loop
+	loopQuiet           = time.Second            // how long a looping input goes without echoes before it is trusted again
+	maxLoopFingerprints = 4096                   // sent messages remembered, so a storm can't use up memory
+)
+
+// detectsLoops reports whether messages coming back from the outputs in a feedback loop are
+// dropped, on unless loop_detection is false
+func (c *Config) detectsLoops() bool {
+	return c.LoopDetection == nil || *c.LoopDetection
+}
+
+// isVirtual reports whether an output creates a virtual port, rather than sending to a device,
+// file, socket or broker
+func (oc *OutputConfig) isVirtual() bool {
+	return oc.Device == "" && oc.RawFile == nil && oc.Network == nil && oc.Socket == nil && oc.OSC == nil && oc.MQTT == nil
+}
+
+// checkSelfInputs refuses inputs that are the router's own virtual outputs, which would send every
+// message around in a loop
+func checkSelfInputs(config *Config) error {
+	for _, name := range config.inputNames() {
+		for i, output := range config.Outputs {
+			if output.isVirtual() && portNameMatches(name, config.outputPortName(i)) {
+				return fmt.Errorf("input %s is the router's own output %s, which would route messages in a loop", name, output.Name)
+			}
+		}
+		if config.DebugOutput != "" && portNameMatches(name, config.debugPortName()) {
+			return fmt.Errorf("input %s is the router's own debug output, which would route messages in a loop", name)
+		}
+	}
+	return nil
+}
+
+// sentFingerprint is a message sent to an output, remembered for loopWindow
+type sentFingerprint struct {
+	key string
+	at  time.Time
+}
+
+// echoCount counts the messages that came back on an input within a second
+type echoCount struct {
+	start time.Time
+	count int
+	last  time.Time // when the last message came back
+}
+
+// loopDetector notices outputs connected back to an input. It remembers the messages recently
+// sent to the outputs, and when too many of them come back on an input, that input is looping:
+// from then on the messages it receives that were just sent are dropped, which breaks the loop,
+// while new messages from it are still routed. The input is trusted again once nothing came back
+// on it for loopQuiet. Realtime messages are left out, since every clock tick looks the same.
+type loopDetector struct {
+	mu      sync.Mutex
+	sent    []sentFingerprint // oldest first
+	pending map[string]int    // how many times each message is in sent
+	echoes  map[string]*echoCount
+	looping map[string]bool // inputs a loop was detected on
+}
+
+func newLoopDetector() *loopDetector {
+	return &loopDetector{
+		pending: make(map[string]int),
+		echoes:  make(map[string]*echoCount),
+		looping: make(map[string]bool),
+	}
+}
+
+// Sent remembers a message sent to an output
+func (ld *loopDetector) Sent(msg midi.Message, now time.Time) {
+	if ld == nil || (len(msg) > 0 && msg[0] >= 0xF8) {
+		return
+	}
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+	ld.pruneLocked(now)
+	if len(ld.sent) >= maxLoopFingerprints {
+		ld.forgetLocked()
+	}
+	key := string(msg)
+	ld.sent = append(ld.sent, sentFingerprint{key, now})
+	ld.pending[key]++
+}
+
+// sender wraps an output's sender to remember the messages it sends
+func (ld *loopDetector) sender(send func(midi.Message) error) func(midi.Message) error {
+	return func(msg midi.Message) error {
+		if err := send(msg); err != nil {
+			return err
+		}
+		ld.Sent(msg, time.Now())
+		return nil
+	}
+}
+
+// Looping reports whether a message received on an input came back from the outputs in a
+// feedback loop, and should be dropped
+func (ld *loopDetector) Looping(input string, msg midi.Message, now time.Time) bool {
+	if ld == nil || (len(msg) > 0 && msg[0] >= 0xF8) {
+		return false
+	}
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+	ld.pruneLocked(now)
+	if ld.pending[string(msg)] == 0 {
+		return false
+	}
+
+	echoes := ld.echoes[input]
+	if ld.looping[input] {
+		if now.Sub(echoes.last) <= loopQuiet {
+			echoes.last = now
+			return true
+		}
+		delete(ld.looping, input)
+		log.Printf("No more feedback on input %s, routing all of its messages again", input)
+	}
+	if echoes == nil || now.Sub(echoes.start) > time.Second {
+		echoes = &echoCount{start: now}
+		ld.echoes[input] = echoes
+	}
+	echoes.count++
+	echoes.last = now
+	if echoes.count < loopThreshold {
+		return false
+	}
+	ld.looping[input] = true
+	log.Printf("Feedback loop detected: messages sent to the outputs are coming back on input %s, dropping them. Check for an output connected back to the router.", input)
+	return true
+}
+
+// pruneLocked forgets the messages sent longer ago than loopWindow
+func (ld *loopDetector) pruneLocked(now time.Time) {
+	for len(ld.sent) > 0 && now.Sub(ld.sent[0].at) > loopWindow {
+		ld.forgetLocked()
+	}
+}
+
+// forgetLocked forgets the oldest sent message
+func (ld *loopDetector) forgetLocked() {
+	key := ld.sent[0].key
+	ld.sent = ld.sent[1:]
+	if ld.pending[key]--; ld.pending[key] <= 0 {
+		delete(ld.pending, key)
+	}
+}
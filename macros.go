@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// MacroConfig is a named sequence of messages sent to outputs, such as the program changes that
+// switch every synth to the patches of a song. Macros are fired by a trigger, a hotkey, control
+// mode or WebSocket clients.
+type MacroConfig struct {
+	Name    string            `json:"name"`
+	Trigger *TriggerConfig    `json:"trigger,omitempty"` // input note or controller that fires the macro
+	Hotkey  string            `json:"hotkey,omitempty"`  // fires the macro when typed in the terminal followed by Enter
+	Steps   []MacroStepConfig `json:"steps"`
+}
+
+// MacroStepConfig is a message a macro sends, after waiting for the step's delay
+type MacroStepConfig struct {
+	Data    string   `json:"data"`               // message bytes in hex, e.g. "C0 04"
+	DelayMS int      `json:"delay_ms,omitempty"` // wait after the previous step
+	Outputs []string `json:"outputs,omitempty"`  // names of outputs or output groups the message is sent to, all outputs when empty
+}
+
+// Validate checks the macro settings against the configured outputs
+func (mc *MacroConfig) Validate(outputs []OutputConfig) error {
+	if mc.Name == "" {
+		return fmt.Errorf("missing name")
+	}
+	if mc.Trigger != nil {
+		if err := mc.Trigger.Validate(); err != nil {
+			return fmt.Errorf("invalid trigger: %w", err)
+		}
+	}
+	if len(mc.Steps) == 0 {
+		return fmt.Errorf("no steps")
+	}
+	for i, step := range mc.Steps {
+		if _, err := parseHexMessage(step.Data); err != nil {
+			return fmt.Errorf("step %d has invalid data: %w", i+1, err)
+		}
+		if step.DelayMS < 0 {
+			return fmt.Errorf("step %d has invalid delay_ms: %d", i+1, step.DelayMS)
+		}
+		for _, name := range step.Outputs {
+			if !hasOutputOrGroup(outputs, name) {
+				return fmt.Errorf("step %d has unknown output: %q", i+1, name)
+			}
+		}
+	}
+	return nil
+}
+
+// findMacro returns the macro with a name, or nil
+func (c *Config) findMacro(name string) *MacroConfig {
+	for i := range c.Macros {
+		if c.Macros[i].Name == name {
+			return &c.Macros[i]
+		}
+	}
+	return nil
+}
+
+// macroHotkey returns the macro fired by a line typed in the terminal, or nil
+func (c *Config) macroHotkey(line string) *MacroConfig {
+	for i := range c.Macros {
+		if c.Macros[i].Hotkey != "" && c.Macros[i].Hotkey == line {
+			return &c.Macros[i]
+		}
+	}
+	return nil
+}
+
+// hasMacroHotkeys reports whether any macro is fired from the terminal
+func (c *Config) hasMacroHotkeys() bool {
+	for _, macro := range c.Macros {
+		if macro.Hotkey != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// readsHotkeys reports whether the router reads hotkeys from stdin, for tap tempo or macros
+func (c *Config) readsHotkeys() bool {
+	return (c.Clock != nil && c.Clock.TapTempo != nil && c.Clock.TapTempo.Hotkey) || c.hasMacroHotkeys()
+}
+
+// FireMacro sends the steps of a macro, by name
+func (r *router) FireMacro(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	macro := r.config.findMacro(name)
+	if macro == nil {
+		return fmt.Errorf("unknown macro: %q", name)
+	}
+	r.fireMacroLocked(macro)
+	return nil
+}
+
+// fireMacroLocked sends the first steps of a macro that have no delay, and schedules the rest
+func (r *router) fireMacroLocked(macro *MacroConfig) {
+	fmt.Printf("Macro: %s\n", macro.Name)
+	var at time.Duration
+	for i := range macro.Steps {
+		step := &macro.Steps[i]
+		at += time.Duration(step.DelayMS) * time.Millisecond
+		if at == 0 {
+			r.sendMacroStep(macro, step)
+			continue
+		}
+		r.scheduleLocked(at, func() { r.sendMacroStep(macro, step) })
+	}
+}
+
+// sendMacroStep sends a step's message to its outputs
+func (r *router) sendMacroStep(macro *MacroConfig, step *MacroStepConfig) {
+	// Checked by Validate
+	data, _ := parseHexMessage(step.Data)
+	msg := midi.Message(data)
+	for i, output := range r.config.Outputs {
+		if !sendsTo(step.Outputs, &output) || !r.enabled[i].Load() {
+			continue
+		}
+		if err := r.sendTo(i, msg); err != nil {
+			sendErrors.Printf(r.outputName(i), "Error sending macro %s to %s: %v", macro.Name, r.outputName(i), err)
+			r.stats.Error(i)
+			continue
+		}
+		logSuccessfulRoute(r.outputName(i), outputColor(&output, i), msg, &MessageTransformation{Input: "macro " + macro.Name}, r.logging)
+		r.stats.Routed(i, msg)
+	}
+}
+
+// handleMacroTriggers fires the macros whose trigger is pressed. Returns false if msg is not a
+// macro trigger.
+func (r *router) handleMacroTriggers(msg midi.Message) bool {
+	matchedAny := false
+	for i := range r.config.Macros {
+		macro := &r.config.Macros[i]
+		if macro.Trigger == nil {
+			continue
+		}
+		matched, fired := macro.Trigger.Match(msg)
+		if !matched {
+			continue
+		}
+		matchedAny = true
+		if fired {
+			r.fireMacroLocked(macro)
+		}
+	}
+	return matchedAny
+}
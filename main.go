@@ -7,48 +7,174 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"os"
 	"os/signal"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"gitlab.com/gomidi/midi/v2"
 	"gitlab.com/gomidi/midi/v2/drivers"
-	"gitlab.com/gomidi/midi/v2/drivers/rtmididrv"
 )
 
-// ChannelFilter represents a MIDI channel filter
+// ChannelFilter represents a MIDI channel filter, passing one channel or a range of channels
 type ChannelFilter struct {
-	Channel uint8 `json:"channel"` // 1-16
+	Channel    uint8 `json:"channel,omitempty"`     // 1-16
+	ChannelMin uint8 `json:"channel_min,omitempty"` // 1-16, lowest channel of a range, default 1
+	ChannelMax uint8 `json:"channel_max,omitempty"` // 1-16, highest channel of a range, default 16
+	Invert     bool  `json:"invert,omitempty"`      // pass every channel except these
 }
 
-// ShouldPass tests if a MIDI message should pass through this channel filter
+// Validate checks that the filter sets either a channel or a range
+func (cf *ChannelFilter) Validate() error {
+	if cf.ChannelMin == 0 && cf.ChannelMax == 0 {
+		if cf.Channel < 1 || cf.Channel > 16 {
+			return fmt.Errorf("invalid channel: %d (must be 1-16)", cf.Channel)
+		}
+		return nil
+	}
+	if cf.Channel != 0 {
+		return fmt.Errorf("channel can't be combined with channel_min or channel_max")
+	}
+	if cf.ChannelMin > 16 {
+		return fmt.Errorf("invalid channel_min: %d (must be 1-16)", cf.ChannelMin)
+	}
+	if cf.ChannelMax > 16 {
+		return fmt.Errorf("invalid channel_max: %d (must be 1-16)", cf.ChannelMax)
+	}
+	if min, max := cf.bounds(); min > max {
+		return fmt.Errorf("invalid channel range: %d-%d", min, max)
+	}
+	return nil
+}
+
+// bounds returns the lowest and highest 1-based channel the filter passes
+func (cf *ChannelFilter) bounds() (uint8, uint8) {
+	if cf.ChannelMin == 0 && cf.ChannelMax == 0 {
+		return cf.Channel, cf.Channel
+	}
+	min, max := cf.ChannelMin, cf.ChannelMax
+	if min == 0 {
+		min = 1
+	}
+	if max == 0 {
+		max = 16
+	}
+	return min, max
+}
+
+// channels returns the 1-based channels the filter passes, every channel outside the range
+// when inverted
+func (cf *ChannelFilter) channels() []uint8 {
+	min, max := cf.bounds()
+	var channels []uint8
+	for channel := uint8(1); channel <= 16; channel++ {
+		if (channel >= min && channel <= max) != cf.Invert {
+			channels = append(channels, channel)
+		}
+	}
+	return channels
+}
+
+// String describes the filter, e.g. "channel 10", "channels 1-8" or "not channel 10"
+func (cf *ChannelFilter) String() string {
+	description := fmt.Sprintf("channel %d", cf.Channel)
+	if min, max := cf.bounds(); min != max {
+		description = fmt.Sprintf("channels %d-%d", min, max)
+	}
+	if cf.Invert {
+		return "not " + description
+	}
+	return description
+}
+
+// ShouldPass tests if a MIDI message should pass through this channel filter. An inverted
+// filter passes system messages, which have no channel.
 func (cf *ChannelFilter) ShouldPass(msg midi.Message) bool {
+	if cf.Invert {
+		return len(msg) == 0 || msg[0] >= 0xF0 || !cf.matches(msg)
+	}
+	return cf.matches(msg)
+}
+
+// matches tests if a message is on the filter's channels
+func (cf *ChannelFilter) matches(msg midi.Message) bool {
+	min, max := cf.bounds()
 	var channel, key, velocity uint8
 	if msg.GetNoteOn(&channel, &key, &velocity) || msg.GetNoteOff(&channel, &key, &velocity) {
-		return channel+1 == cf.Channel
+		return channel+1 >= min && channel+1 <= max
 	}
 	// For other message types, try to get channel
 	if len(msg) >= 1 {
 		msgChannel := (msg[0] & 0x0F) + 1
-		return msgChannel == cf.Channel
+		return msgChannel >= min && msgChannel <= max
 	}
 	return true
 }
 
 // NoteRangeFilter represents a note range filter
 type NoteRangeFilter struct {
-	MinNote uint8 `json:"min_note"` // MIDI note number 0-127
-	MaxNote uint8 `json:"max_note"` // MIDI note number 0-127
+	MinNote uint8 `json:"min_note"`         // MIDI note number 0-127
+	MaxNote uint8 `json:"max_note"`         // MIDI note number 0-127
+	Invert  bool  `json:"invert,omitempty"` // pass the notes outside the range instead
+}
+
+// String describes the filter, e.g. "notes 36-59"
+func (nrf *NoteRangeFilter) String() string {
+	description := fmt.Sprintf("notes %d-%d", nrf.MinNote, nrf.MaxNote)
+	if nrf.Invert {
+		return "not " + description
+	}
+	return description
 }
 
 // ShouldPass tests if a MIDI message should pass through this note range filter
 func (nrf *NoteRangeFilter) ShouldPass(msg midi.Message) bool {
 	var channel, key, velocity uint8
 	if msg.GetNoteOn(&channel, &key, &velocity) || msg.GetNoteOff(&channel, &key, &velocity) {
-		return key >= nrf.MinNote && key <= nrf.MaxNote
+		return (key >= nrf.MinNote && key <= nrf.MaxNote) != nrf.Invert
+	}
+	// Non-note messages pass through
+	return true
+}
+
+// noteKey identifies a sounding note by its 0-based channel and note number
+type noteKey struct {
+	Channel uint8
+	Note    uint8
+}
+
+// probabilityGate randomly lets notes through and remembers which ones passed,
+// so each NoteOff follows the decision made for its NoteOn
+type probabilityGate struct {
+	probability float64
+	active      map[noteKey]bool
+}
+
+func newProbabilityGate(probability float64) *probabilityGate {
+	return &probabilityGate{probability: probability, active: make(map[noteKey]bool)}
+}
+
+// ShouldPass tests if a MIDI message should pass through this probability gate
+func (pg *probabilityGate) ShouldPass(msg midi.Message) bool {
+	var channel, key, velocity uint8
+	if msg.GetNoteStart(&channel, &key, &velocity) {
+		pass := rand.Float64() < pg.probability
+		if pass {
+			pg.active[noteKey{channel, key}] = true
+		}
+		return pass
+	}
+	if msg.GetNoteEnd(&channel, &key) {
+		k := noteKey{channel, key}
+		pass := pg.active[k]
+		delete(pg.active, k)
+		return pass
 	}
 	// Non-note messages pass through
 	return true
@@ -56,65 +182,394 @@ func (nrf *NoteRangeFilter) ShouldPass(msg midi.Message) bool {
 
 // OutputConfig represents the configuration for a single output
 type OutputConfig struct {
-	Name               string           `json:"name"`
-	ChannelFilter      *ChannelFilter   `json:"channel_filter"`
-	NoteRangeFilter    *NoteRangeFilter `json:"note_range_filter"`
-	OverrideChannel    *uint8           `json:"override_channel"`    // 1-16, optional
-	TransposeSemitones *int8            `json:"transpose_semitones"` // -127 to +127, optional
+	Name               string                    `json:"name"`
+	Group              string                    `json:"group,omitempty"`       // output group whose settings this output starts from
+	Pipeline           string                    `json:"pipeline,omitempty"`    // named pipeline whose processing settings override the group's
+	Description        string                    `json:"description,omitempty"` // what the output is for, e.g. "Left hand bass to Minitaur", shown by the dashboard, editor and graph
+	Enabled            *bool                     `json:"enabled,omitempty"`     // false switches the output off, which the editor and control mode can change while running
+	Thru               bool                      `json:"thru,omitempty"`        // copy every message of the inputs unchanged, skipping all filters and processing
+	Mode               string                    `json:"mode,omitempty"`        // "drums" for electronic drum kit processing, set up by drums
+	Drums              *DrumsConfig              `json:"drums,omitempty"`
+	ChannelFilter      *ChannelFilter            `json:"channel_filter"`
+	NoteRangeFilter    *NoteRangeFilter          `json:"note_range_filter"`
+	Filter             *FilterExpr               `json:"filter,omitempty"`          // channel, note range and message type filters combined with and, or and not
+	ChordFilter        *ChordFilterConfig        `json:"chord_filter,omitempty"`    // pass notes by the number of held keys
+	MinVelocity        *uint8                    `json:"min_velocity,omitempty"`    // 1-127, drop note ons softer than this and their note offs
+	OverrideChannel    *uint8                    `json:"override_channel"`          // 1-16, optional
+	TransposeSemitones *int8                     `json:"transpose_semitones"`       // -127 to +127, optional
+	OctaveShift        *OctaveShiftConfig        `json:"octave_shift,omitempty"`    // octaves added after the transposition, changed with up and down controls
+	ScaleQuantize      bool                      `json:"scale_quantize,omitempty"`  // snap notes to the nearest note of the global key's scale
+	ScaleTranspose     int                       `json:"scale_transpose,omitempty"` // scale steps notes move within the global key, e.g. 2 for a third
+	MinNote            *uint8                    `json:"min_note,omitempty"`        // lowest note sent after transposition, lower notes move up by octaves
+	MaxNote            *uint8                    `json:"max_note,omitempty"`        // highest note sent after transposition, higher notes move down by octaves
+	CCRanges           []CCRangeConfig           `json:"cc_ranges,omitempty"`       // controllers rescaled to a new range
+	SoftTakeover       bool                      `json:"soft_takeover,omitempty"`   // after a config change, hold back controls until they reach the output's value
+	VelocityCompressor *VelocityCompressorConfig `json:"velocity_compressor,omitempty"`
+	Harmonizer         *HarmonizerConfig         `json:"harmonizer,omitempty"`
+	Echo               *EchoConfig               `json:"echo,omitempty"`
+	Strum              *StrumConfig              `json:"strum,omitempty"`
+	NoteRepeat         *NoteRepeatConfig         `json:"note_repeat,omitempty"`
+	Envelope           *EnvelopeConfig           `json:"envelope,omitempty"` // controller ramp started by each note
+	ChannelRotation    *ChannelRotationConfig    `json:"channel_rotation,omitempty"`
+	ChokeGroups        [][]uint8                 `json:"choke_groups,omitempty"`          // notes that cut each other off, e.g. [[42, 44, 46]] for closed, pedal and open hi-hats
+	DuplicateChannels  []uint8                   `json:"duplicate_to_channels,omitempty"` // 1-16, extra channels every channel message is copied to
+	Tuning             *TuningConfig             `json:"tuning,omitempty"`
+	MTS                *MTSConfig                `json:"mts,omitempty"`
+	SongPosition       *SongPositionConfig       `json:"song_position,omitempty"`
+	TransportMap       *TransportMapConfig       `json:"transport_map,omitempty"`
+	ClockWhileRunning  bool                      `json:"clock_while_running,omitempty"` // only send clock between Start or Continue and Stop
+	SystemMessages     *SystemMessagesConfig     `json:"system_messages,omitempty"`
+	MessageTypes       *MessageTypesFilter       `json:"message_types,omitempty"` // allow or deny notes, cc, program_change, pitch_bend, aftertouch, sysex and realtime
+	SysExPacing        *SysExPacingConfig        `json:"sysex_pacing,omitempty"`
+	Startup            *StartupConfig            `json:"startup,omitempty"`
+	Gain               *GainConfig               `json:"gain,omitempty"`          // volume and expression sent at startup
+	Probability        *float64                  `json:"probability,omitempty"`   // 0-1, chance that a note is routed here, optional
+	InputDevice        string                    `json:"input_device,omitempty"`  // only route messages from this input, which is opened even if not listed globally
+	InputFilter        []string                  `json:"input_filter,omitempty"`  // only route messages from these inputs, by device name
+	InputExclude       []string                  `json:"input_exclude,omitempty"` // never route messages from these inputs, by device name
+	SourceFilter       *SourceFilter             `json:"source_filter,omitempty"` // allow or deny the messages of merged inputs by where they came from
+	RouteGroup         string                    `json:"route_group,omitempty"`   // only the first matching output in a group receives a message
+	Continue           bool                      `json:"continue,omitempty"`      // let later outputs in the route group, or any later output with exclusive_routing, match as well
+	StopOnMatch        bool                      `json:"stop_on_match,omitempty"` // later outputs don't receive the messages this output takes
+	CatchDropped       bool                      `json:"catch_dropped,omitempty"` // only receive the messages no other output accepted
+	Dry                *DryConfig                `json:"dry,omitempty"`           // also send the accepted input messages untouched, to this or a sibling output
+	Device             string                    `json:"device,omitempty"`        // send to this existing MIDI output, such as a hardware synth, instead of a virtual port
+	RawFile            *RawFileConfig            `json:"raw_file,omitempty"`      // write to a file or FIFO instead of a virtual port
+	Network            *NetworkConfig            `json:"network,omitempty"`       // send to an RTP-MIDI session on another machine instead of a virtual port
+	Socket             *SocketConfig             `json:"socket,omitempty"`        // stream raw MIDI bytes over TCP or UDP instead of a virtual port
+	OSC                *OSCConfig                `json:"osc,omitempty"`           // send notes, controllers and pitch bend as OSC messages over UDP instead of a virtual port
+	MQTT               *MQTTOutputConfig         `json:"mqtt,omitempty"`          // publish to MQTT topics on the mqtt broker instead of a virtual port
+	Color              string                    `json:"color,omitempty"`         // log line color, picked from the output's position when empty
+	PortName           string                    `json:"port_name,omitempty"`     // template for this output's port name, overriding the configuration's port_name
 }
 
 // Config represents the complete router configuration
 type Config struct {
-	InputDevice string         `json:"input_device"`
-	OutputBase  string         `json:"output_base"`
-	Outputs     []OutputConfig `json:"outputs"`
+	InputDevice        string                 `json:"input_device"`
+	InputDevicePattern string                 `json:"input_device_pattern,omitempty"` // text or regular expression picking the input_device from the connected devices
+	InputDevices       []string               `json:"input_devices,omitempty"`        // more inputs merged with input_device
+	LogicalInputs      []LogicalInputConfig   `json:"logical_inputs,omitempty"`       // devices with several ports, merged into one input that filters can name
+	VirtualInput       string                 `json:"virtual_input,omitempty"`        // name of a virtual input port other programs can send to, merged with the inputs
+	VirtualInputs      []string               `json:"virtual_inputs,omitempty"`       // more virtual input ports, e.g. one for each program sending in
+	DebugOutput        string                 `json:"debug_output,omitempty"`         // name of a virtual output that mirrors every input message unfiltered, e.g. for recording the raw performance
+	NetworkInput       *NetworkInputConfig    `json:"network_input,omitempty"`        // RTP-MIDI session other machines join to send in, merged with the inputs
+	WebSocketInput     string                 `json:"websocket_input,omitempty"`      // name of the input WebSocket clients send to, needs --websocket-port
+	WebSocketTokens    []WebSocketTokenConfig `json:"websocket_tokens,omitempty"`     // tokens WebSocket clients need to connect, each watching or also sending
+	MQTT               *MQTTConfig            `json:"mqtt,omitempty"`                 // broker the mqtt outputs publish to, optionally subscribed to as an input
+	LoopDetection      *bool                  `json:"loop_detection,omitempty"`       // drop messages that come back from the outputs in a feedback loop, default true
+	ExclusiveRouting   bool                   `json:"exclusive_routing,omitempty"`    // only the first matching output receives a message
+	OutputBase         string                 `json:"output_base"`
+	PortName           string                 `json:"port_name,omitempty"` // template for the output port names, default "{base} {name}"
+	Outputs            []OutputConfig         `json:"outputs"`
+	AutoOutputs        *AutoOutputsConfig     `json:"auto_outputs,omitempty"`  // numbered outputs added after outputs, e.g. one per channel
+	OutputGroups       []OutputGroupConfig    `json:"output_groups,omitempty"` // settings shared by the outputs that join a group
+	Pipelines          []PipelineConfig       `json:"pipelines,omitempty"`     // processing settings shared by the outputs that use a pipeline
+	Clock              *ClockConfig           `json:"clock,omitempty"`
+	ReusePorts         bool                   `json:"reuse_ports,omitempty"` // open existing ports with the output names instead of creating virtual ports
+	ClientName         string                 `json:"client_name,omitempty"` // MIDI client name shown by ALSA/CoreMIDI, rtmidi's default when empty
+	Driver             string                 `json:"driver,omitempty"`      // MIDI backend: rtmidi (default), alsa or jack
+	RawInput           *RawFileConfig         `json:"raw_input,omitempty"`   // read from stdin, a file or a FIFO instead of input_device
+	SysEx              *SysExConfig           `json:"sysex,omitempty"`       // receive SysEx from the input, which is ignored otherwise
+	Gestures           []GestureConfig        `json:"gestures,omitempty"`    // double taps and long presses that send other events
+	Macros             []MacroConfig          `json:"macros,omitempty"`      // named message sequences fired by triggers, hotkeys, control mode or WebSocket clients
+	LFOs               []LFOConfig            `json:"lfos,omitempty"`        // internal LFOs sending controllers or pitch bend
+	RandomCCs          []RandomCCConfig       `json:"random_ccs,omitempty"`  // controllers that wander randomly, for generative patches
+	Key                *KeyConfig             `json:"key,omitempty"`         // key and scale shared by harmonizers, scale quantizing and scale transposing
 }
 
 // MessageTransformation tracks transformations applied to a MIDI message
 type MessageTransformation struct {
-	OriginalChannel    *uint8 // nil if no channel info or no change
-	TransformedChannel *uint8
-	OriginalNote       *uint8 // nil if not a note message or no change
-	TransformedNote    *uint8
+	OriginalChannel     *uint8 // nil if no channel info or no change
+	TransformedChannel  *uint8
+	OriginalNote        *uint8 // nil if not a note message or no change
+	TransformedNote     *uint8
+	HarmonyNotes        []uint8 // harmony notes started or released alongside a note message
+	DuplicateChannels   []uint8 // extra channels the message was copied to
+	OriginalValue       *uint8  // nil if not a rescaled control change
+	TransformedValue    *uint8
+	OriginalVelocity    *uint8 // nil if the velocity was not changed
+	TransformedVelocity *uint8
+	Input               string         // input the message arrived on, only set when several inputs are merged
+	DriverMS            *int32         // the driver's timestamp of the incoming message, nil if it had none
+	Sent                []midi.Message // messages sent to the output, nil when it was sent unchanged
+}
+
+// exitHooks finish the recordings and save the controller state when main returns, and also
+// before fatalf exits, which skips deferred calls
+var exitHooks []func()
+
+// atExit adds a hook to run when the program exits
+func atExit(fn func()) {
+	exitHooks = append(exitHooks, fn)
+}
+
+// runExitHooks runs the exit hooks once, the last added first
+func runExitHooks() {
+	hooks := exitHooks
+	exitHooks = nil
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hooks[i]()
+	}
+}
+
+// fatalf logs an error and exits like log.Fatalf, after running the exit hooks
+func fatalf(format string, v ...any) {
+	log.Printf(format, v...)
+	runExitHooks()
+	os.Exit(1)
 }
 
 func main() {
+	// Subcommands that don't run the router
+	if len(os.Args) > 1 {
+		if command, ok := subcommands[os.Args[1]]; ok {
+			if err := command.run(os.Args[2:]); err != nil {
+				log.Fatalf("%v", err)
+			}
+			return
+		}
+		if os.Args[1] == "run" {
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+		}
+	}
+
 	// Define command-line flags
 	saveConfigFile := flag.String("save-config", "", "Save result of configuration to specified file and exit (does not run router)")
-	configFile := flag.String("config", "", "Load configuration from specified file and start router")
+	configFile := flag.String("config", "", "Load configuration from specified file or http(s) URL and start router")
+	configRefresh := flag.Duration("config-refresh", 0, "How often to check a --config URL for changes and apply them (0 to only load it at startup)")
 	quiet := flag.Bool("quiet", false, "Suppress MIDI message logging during operation")
+	logHex := flag.Bool("log-hex", false, "Include the raw bytes of every message in hex when logging, and the bytes sent when they differ")
+	edit := flag.Bool("edit", false, "Edit outputs from the terminal while the router runs")
+	dashboard := flag.Bool("dashboard", false, "Show a live table of message counts per output instead of logging each message")
+	statsInterval := flag.Duration("stats-interval", 10*time.Second, "How often to print message counts when --quiet is set (0 to disable)")
+	colorMode := flag.String("color", "auto", "Color log lines per output: auto (only on a terminal), always or never")
+	captureDropped := flag.String("capture-dropped", "", "Record messages that matched no output to a .jsonl or .mid file")
+	recordInput := flag.String("record-input", "", "Record every incoming message to a .jsonl or .mid file, for replay")
+	recordOutput := flag.String("record-output", "", "Record every message sent to the outputs to a .jsonl or .mid file")
+	auditLogFile := flag.String("audit-log", "", "Append every change made to the running configuration to this .jsonl file")
+	stateFile := flag.String("state-file", "", "Load controller state from this file at startup and save it on exit, and on SIGUSR2 while running")
+	liveStateFile := flag.String("live-state", "", "Keep the outputs switched off, octave shifts, key and tempo in this file while running, and restore them at startup")
+	waitForDevice := flag.Bool("wait-for-device", false, "Wait for the configured devices to be connected instead of failing or asking for another input")
+	driverName := flag.String("driver", "", "MIDI backend: "+strings.Join(driverNames(), ", ")+" (overrides driver in the config, default "+defaultDriver+")")
+	webSocketPort := flag.Int("websocket-port", 0, "Serve the messages sent to the outputs to WebSocket clients on this port")
+	webSocketHost := flag.String("websocket-host", "127.0.0.1", "Address the WebSocket bridge listens on, 0.0.0.0 to accept clients from other machines")
+	webSocketOrigins := flag.String("websocket-origins", "", "Comma-separated origins of other web pages allowed to connect to the WebSocket bridge, e.g. http://localhost:3000")
+	webSocketInput := flag.String("websocket-input", "", "Route messages from WebSocket clients as an input with this name (overrides websocket_input in the config)")
+	timestamps := flag.String("timestamps", "", "Start log lines with a timestamp: "+strings.Join(timestampSources, " or ")+" (default none)")
+	pipe := flag.Bool("pipe", false, "Read raw MIDI from stdin and write the outputs without a destination to stdout, for unix pipelines")
+	controlMode := flag.String("control", "", "Let a parent process manage the router with JSON-RPC: "+strings.Join(controlModes, " or ")+" (needs --config)")
+	clientName := flag.String("client-name", "", "MIDI client name to register with ALSA/CoreMIDI (overrides client_name in the config)")
+	flag.Usage = printSubcommandUsage
 	flag.Parse()
 
-	drv, err := rtmididrv.New()
+	color, err := useColor(*colorMode)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := validateTimestampSource(*timestamps); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := validateControlMode(*controlMode); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	// The router currently running, which control mode, the editor and WebSocket clients act on
+	running := &runningRouter{}
+
+	var control *controlServer
+	if *controlMode != "" {
+		if *configFile == "" || *edit || *dashboard || *configRefresh > 0 {
+			log.Fatalf("--control needs --config, and can't be used with --edit, --dashboard or --config-refresh")
+		}
+		// Keep stdout for the protocol, everything else that is printed goes to stderr
+		control = newControlServer(os.Stdout, running)
+		os.Stdout = os.Stderr
+	}
+	if *pipe {
+		if *edit || *dashboard || *configRefresh > 0 || *controlMode != "" {
+			log.Fatalf("--pipe can't be used with --edit, --dashboard, --config-refresh or --control")
+		}
+		// Keep stdout for the routed messages
+		os.Stdout = os.Stderr
+	}
+
+	// A configuration file can list several routers, which all run in this process
+	var routers []*Config
+	if *configFile != "" && !isConfigURL(*configFile) {
+		routers, err = loadRouters(*configFile)
+		if err != nil {
+			log.Fatalf("Failed to load config: %v", err)
+		}
+	}
+	if routers != nil {
+		if *edit || *dashboard || *configRefresh > 0 || *controlMode != "" || *pipe || *saveConfigFile != "" || *webSocketInput != "" || *liveStateFile != "" {
+			log.Fatalf("a configuration with several routers can't be used with --edit, --dashboard, --config-refresh, --control, --pipe, --save-config, --websocket-input or --live-state")
+		}
+		if *driverName == "" {
+			*driverName = routers[0].Driver
+		}
+	}
+
+	// The config's driver is needed before the config can be checked against the connected devices
+	if *driverName == "" && *configFile != "" && !isConfigURL(*configFile) && routers == nil {
+		if config, err := loadConfig(*configFile); err == nil {
+			*driverName = config.Driver
+		}
+	}
+	drv, err := openDriver(*driverName)
 	if err != nil {
-		log.Fatalf("Failed to create MIDI driver: %v", err)
+		log.Fatalf("%v", err)
 	}
 	defer drv.Close()
 
+	logging := logOptions{
+		Quiet:         *quiet || *dashboard,
+		Hex:           *logHex,
+		Color:         color,
+		StatsInterval: *statsInterval,
+		Dashboard:     *dashboard,
+		Timestamps:    *timestamps,
+		Running:       running,
+	}
+
+	// Recordings last across router restarts and are finished on exit, including fatal errors
+	defer runExitHooks()
+	for _, recording := range []struct {
+		filename string
+		recorder **messageRecorder
+	}{
+		{*captureDropped, &logging.CaptureDropped},
+		{*recordInput, &logging.RecordInput},
+		{*recordOutput, &logging.RecordOutput},
+	} {
+		if recording.filename == "" {
+			continue
+		}
+		recorder, err := newMessageRecorder(recording.filename)
+		if err != nil {
+			fatalf("Failed to start recording: %v", err)
+		}
+		atExit(func() {
+			if err := recorder.Close(); err != nil {
+				log.Printf("Warning: %v", err)
+			}
+		})
+		*recording.recorder = recorder
+	}
+
+	if *webSocketPort > 0 {
+		var origins []string
+		for _, origin := range strings.Split(*webSocketOrigins, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				origins = append(origins, origin)
+			}
+		}
+		bridge, err := startWebSocketBridge(*webSocketHost, *webSocketPort, origins, running)
+		if err != nil {
+			fatalf("Failed to start WebSocket bridge: %v", err)
+		}
+		fmt.Printf("Serving WebSocket clients on %s port %d\n", *webSocketHost, *webSocketPort)
+		logging.WebSocket = bridge
+	}
+
+	if *auditLogFile != "" {
+		audit, err := openAuditLog(*auditLogFile)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		defer audit.Close()
+		logging.Audit = audit
+	}
+
+	if *stateFile != "" {
+		if err := sentControllers.Load(*stateFile); err != nil {
+			fatalf("%v", err)
+		}
+		atExit(func() {
+			if err := sentControllers.Save(*stateFile); err != nil {
+				log.Printf("Warning: %v", err)
+			}
+		})
+		logging.StateFile = *stateFile
+	}
+
+	if *liveStateFile != "" {
+		liveState, err := openLiveState(*liveStateFile)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		logging.LiveState = liveState
+	}
+
+	if routers != nil {
+		for _, config := range routers {
+			if *clientName != "" {
+				config.ClientName = *clientName
+			}
+			config.Driver = *driverName
+		}
+		if err := checkRouterDevices(routers, drv, *waitForDevice); err != nil {
+			fatalf("Failed to load config: %v", err)
+		}
+		if err := runRouters(drv, routers, logging); err != nil {
+			fatalf("MIDI router error: %v", err)
+		}
+		return
+	}
+
 	var config *Config
+	var remote *remoteConfig
 
 	// Check execution mode
 	if *configFile != "" {
 		// Config file mode: load existing config and run router
 
-		config, err = loadConfigWithFallback(*configFile, drv)
+		if isConfigURL(*configFile) {
+			remote = newRemoteConfig(*configFile)
+		}
+		if *pipe {
+			// The configured inputs are replaced by stdin, so they don't need to be connected
+			config, err = loadConfig(*configFile)
+			if err == nil {
+				config.usePipe()
+				err = validateConfigStructure(config)
+			}
+		} else {
+			config, err = loadConfigWithFallback(*configFile, remote, drv, *waitForDevice)
+		}
 		if err != nil {
-			log.Fatalf("Failed to load config: %v", err)
+			fatalf("Failed to load config: %v", err)
 		}
 
+	} else if *pipe {
+		// Copy stdin to stdout unchanged
+		config = pipeConfig()
+
 	} else {
 		// Interactive mode
 
-		config, err = interactiveConfig(drv)
-		if err != nil {
-			log.Fatalf("Configuration error: %v", err)
+		for {
+			config, err = interactiveConfig(drv)
+			if err != nil {
+				fatalf("Configuration error: %v", err)
+			}
+
+			// Preview with full message logging so the routing can be checked
+			keep, err := previewConfig(drv, config, logOptions{Hex: logging.Hex, Color: logging.Color})
+			if err != nil {
+				fatalf("Preview error: %v", err)
+			}
+			if keep {
+				break
+			}
+			fmt.Println("Starting configuration again...")
 		}
 
 		// Check if we're in save-only mode
 		if *saveConfigFile != "" {
 			err = saveConfig(config, *saveConfigFile)
 			if err != nil {
-				log.Fatalf("Failed to save config: %v", err)
+				fatalf("Failed to save config: %v", err)
 			}
 			fmt.Printf("Configuration saved to %s\n", *saveConfigFile)
 			return
@@ -127,16 +582,68 @@ func main() {
 		}
 	}
 
+	// Settings from flags override the config, including configs fetched later
+	prepareConfig := func(config *Config) {
+		if *clientName != "" {
+			config.ClientName = *clientName
+		}
+		if *driverName != "" {
+			config.Driver = *driverName
+		}
+		if *webSocketInput != "" {
+			config.WebSocketInput = *webSocketInput
+		}
+	}
+	prepareConfig(config)
+
+	// Outputs to stdout keep it for their messages, everything else printed goes to stderr
+	if config.writesStdout() {
+		os.Stdout = os.Stderr
+	}
+
+	if *edit {
+		filename := *configFile
+		if filename == "" || isConfigURL(filename) {
+			filename = "config.json"
+		}
+		if err := runEditor(drv, config, filename, logging); err != nil {
+			fatalf("MIDI router error: %v", err)
+		}
+		return
+	}
+
+	if control != nil {
+		if err := runControl(drv, config, *configFile, control, prepareConfig, logging); err != nil {
+			fatalf("MIDI router error: %v", err)
+		}
+		return
+	}
+
+	if remote != nil && *configRefresh > 0 {
+		if err := runWithRemoteConfig(drv, config, remote, *configRefresh, prepareConfig, logging); err != nil {
+			fatalf("MIDI router error: %v", err)
+		}
+		return
+	}
+
 	// Run the router with the loaded/configured setup
-	err = runMIDIRouter(drv, config, *quiet)
+	err = runMIDIRouter(drv, config, logging, nil)
 	if err != nil {
-		log.Fatalf("MIDI router error: %v", err)
+		fatalf("MIDI router error: %v", err)
 	}
 }
 
 // saveConfig saves the configuration to a JSON file or prints to stdout if filename is empty
 func saveConfig(config *Config, filename string) error {
-	data, err := json.MarshalIndent(config, "", "  ")
+	// Groups, pipelines and auto_outputs are saved as written rather than expanded into the outputs
+	written := *config
+	if err := collapseOutputGroups(&written); err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+	if err := collapseAutoOutputs(&written); err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+	data, err := json.MarshalIndent(&written, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal config: %w", err)
 	}
@@ -154,18 +661,33 @@ func saveConfig(config *Config, filename string) error {
 	return nil
 }
 
-// loadConfig loads configuration from a JSON file
+// loadConfig loads configuration from a JSON file or an http(s) URL
 func loadConfig(filename string) (*Config, error) {
+	if isConfigURL(filename) {
+		return newRemoteConfig(filename).Load()
+	}
+
 	data, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
+	return parseConfig(data)
+}
+
+// parseConfig decodes a JSON configuration
+func parseConfig(data []byte) (*Config, error) {
 	var config Config
-	err = json.Unmarshal(data, &config)
+	err := json.Unmarshal(data, &config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
+	if err := applyOutputGroups(&config, data); err != nil {
+		return nil, fmt.Errorf("failed to apply output groups and pipelines: %w", err)
+	}
+	if err := expandAutoOutputs(&config); err != nil {
+		return nil, err
+	}
 
 	return &config, nil
 }
@@ -176,29 +698,531 @@ func validateConfigStructure(config *Config) error {
 		return fmt.Errorf("no outputs configured")
 	}
 
+	if _, ok := midiBackends[config.Driver]; config.Driver != "" && !ok {
+		return fmt.Errorf("unsupported driver: %q (must be one of %s)", config.Driver, strings.Join(driverNames(), ", "))
+	}
+	if config.InputDevicePattern != "" {
+		if config.RawInput != nil {
+			return fmt.Errorf("input_device_pattern can't be combined with raw_input")
+		}
+	}
+	for i, name := range config.InputDevices {
+		if name == "" {
+			return fmt.Errorf("input_devices entry %d is empty", i+1)
+		}
+	}
+	for i, name := range config.VirtualInputs {
+		if name == "" {
+			return fmt.Errorf("virtual_inputs entry %d is empty", i+1)
+		}
+	}
+	inputs := config.sourceNames()
+	for i, name := range inputs {
+		if slices.Contains(inputs[:i], name) {
+			return fmt.Errorf("input %s is listed more than once", name)
+		}
+	}
+	if err := validateLogicalInputs(config); err != nil {
+		return err
+	}
+	if config.PortName != "" {
+		if err := validatePortName(config.PortName); err != nil {
+			return fmt.Errorf("invalid port_name: %w", err)
+		}
+	}
+
 	for i, output := range config.Outputs {
 		if output.Name == "" {
 			return fmt.Errorf("output %d has no name", i+1)
 		}
-		if output.ChannelFilter != nil && (output.ChannelFilter.Channel < 1 || output.ChannelFilter.Channel > 16) {
-			return fmt.Errorf("output %d has invalid channel: %d (must be 1-16)", i+1, output.ChannelFilter.Channel)
+		for _, name := range slices.Concat(output.InputFilter, output.InputExclude) {
+			if !slices.Contains(inputs, name) && config.findLogicalInput(name) == nil {
+				return fmt.Errorf("output %d filters on unknown input: %s", i+1, name)
+			}
+		}
+		if output.SourceFilter != nil {
+			if err := output.SourceFilter.Validate(config); err != nil {
+				return fmt.Errorf("output %d has invalid source filter: %w", i+1, err)
+			}
+		}
+		if output.InputDevice != "" && config.RawInput != nil {
+			return fmt.Errorf("output %d sets input_device, which can't be combined with raw_input", i+1)
+		}
+		if output.InputDevice != "" && len(output.InputFilter) > 0 {
+			return fmt.Errorf("output %d sets both input_device and input_filter", i+1)
+		}
+		if output.Device != "" && output.RawFile != nil {
+			return fmt.Errorf("output %d sets both device and raw_file", i+1)
+		}
+		if output.Network != nil && (output.Device != "" || output.RawFile != nil) {
+			return fmt.Errorf("output %d sets network with device or raw_file", i+1)
+		}
+		if output.Socket != nil && (output.Device != "" || output.RawFile != nil || output.Network != nil) {
+			return fmt.Errorf("output %d sets socket with device, raw_file or network", i+1)
+		}
+		if output.OSC != nil && (output.Device != "" || output.RawFile != nil || output.Network != nil || output.Socket != nil) {
+			return fmt.Errorf("output %d sets osc with device, raw_file, network or socket", i+1)
+		}
+		if output.MQTT != nil && (output.Device != "" || output.RawFile != nil || output.Network != nil || output.Socket != nil || output.OSC != nil) {
+			return fmt.Errorf("output %d sets mqtt with device, raw_file, network, socket or osc", i+1)
+		}
+		if output.MQTT != nil && config.MQTT == nil {
+			return fmt.Errorf("output %d sets mqtt without an mqtt broker", i+1)
+		}
+		switch output.Mode {
+		case "", "drums":
+		default:
+			return fmt.Errorf("output %d has invalid mode: %q (must be drums)", i+1, output.Mode)
+		}
+		if output.Drums != nil {
+			if output.Mode != "drums" {
+				return fmt.Errorf("output %d sets drums without mode drums", i+1)
+			}
+			if err := output.Drums.Validate(); err != nil {
+				return fmt.Errorf("output %d has invalid drums: %w", i+1, err)
+			}
+		}
+		if output.ChannelFilter != nil {
+			if err := output.ChannelFilter.Validate(); err != nil {
+				return fmt.Errorf("output %d has invalid channel filter: %w", i+1, err)
+			}
 		}
 		if output.NoteRangeFilter != nil && output.NoteRangeFilter.MinNote > output.NoteRangeFilter.MaxNote {
 			return fmt.Errorf("output %d has invalid note range: %d-%d", i+1, output.NoteRangeFilter.MinNote, output.NoteRangeFilter.MaxNote)
 		}
+		if output.MinVelocity != nil && (*output.MinVelocity < 1 || *output.MinVelocity > 127) {
+			return fmt.Errorf("output %d has invalid min velocity: %d (must be 1-127)", i+1, *output.MinVelocity)
+		}
+		if output.ChordFilter != nil {
+			if err := output.ChordFilter.Validate(); err != nil {
+				return fmt.Errorf("output %d has invalid chord filter: %w", i+1, err)
+			}
+		}
 		if output.OverrideChannel != nil && (*output.OverrideChannel < 1 || *output.OverrideChannel > 16) {
 			return fmt.Errorf("output %d has invalid override channel: %d (must be 1-16)", i+1, *output.OverrideChannel)
 		}
 		if output.TransposeSemitones != nil && (*output.TransposeSemitones < -127 || *output.TransposeSemitones > 127) {
 			return fmt.Errorf("output %d has invalid transpose semitones: %d (must be -127 to 127)", i+1, *output.TransposeSemitones)
 		}
+		if output.OctaveShift != nil {
+			if err := output.OctaveShift.Validate(); err != nil {
+				return fmt.Errorf("output %d has invalid octave shift: %w", i+1, err)
+			}
+		}
+		if (output.ScaleQuantize || output.ScaleTranspose != 0) && config.Key == nil {
+			return fmt.Errorf("output %d quantizes or transposes by scale without a global key", i+1)
+		}
+		if output.ScaleTranspose < -21 || output.ScaleTranspose > 21 {
+			return fmt.Errorf("output %d has invalid scale transpose: %d (must be -21 to 21)", i+1, output.ScaleTranspose)
+		}
+		if output.MinNote != nil && *output.MinNote > 127 {
+			return fmt.Errorf("output %d has invalid min note: %d (must be 0-127)", i+1, *output.MinNote)
+		}
+		if output.MaxNote != nil && *output.MaxNote > 127 {
+			return fmt.Errorf("output %d has invalid max note: %d (must be 0-127)", i+1, *output.MaxNote)
+		}
+		if output.MinNote != nil && output.MaxNote != nil && *output.MinNote > *output.MaxNote {
+			return fmt.Errorf("output %d has invalid note limits: %d-%d", i+1, *output.MinNote, *output.MaxNote)
+		}
+		if _, ok := ansiColors[output.Color]; output.Color != "" && !ok {
+			return fmt.Errorf("output %d has invalid color: %q (must be one of %s)", i+1, output.Color, strings.Join(colorNames(), ", "))
+		}
+		if output.Continue && output.RouteGroup == "" && !config.ExclusiveRouting {
+			return fmt.Errorf("output %d sets continue without a route_group or exclusive_routing", i+1)
+		}
+		if output.Continue && output.StopOnMatch {
+			return fmt.Errorf("output %d sets stop_on_match with continue", i+1)
+		}
+		if config.DebugOutput != "" && output.Name == config.DebugOutput {
+			return fmt.Errorf("output %d has the same name as the debug_output", i+1)
+		}
+		if output.PortName != "" {
+			if err := validatePortName(output.PortName); err != nil {
+				return fmt.Errorf("output %d has invalid port_name: %w", i+1, err)
+			}
+		}
+		if output.CatchDropped && output.Thru {
+			return fmt.Errorf("output %d sets catch_dropped with thru", i+1)
+		}
+		if output.Dry != nil {
+			if output.Thru {
+				return fmt.Errorf("output %d sets dry with thru", i+1)
+			}
+			if err := output.Dry.Validate(config.Outputs); err != nil {
+				return fmt.Errorf("output %d has invalid dry: %w", i+1, err)
+			}
+		}
+		if output.Startup != nil {
+			if err := output.Startup.Validate(); err != nil {
+				return fmt.Errorf("output %d has invalid startup: %w", i+1, err)
+			}
+		}
+		if output.Probability != nil && (*output.Probability < 0 || *output.Probability > 1) {
+			return fmt.Errorf("output %d has invalid probability: %g (must be 0-1)", i+1, *output.Probability)
+		}
+		for _, ccRange := range output.CCRanges {
+			if err := ccRange.Validate(); err != nil {
+				return fmt.Errorf("output %d has invalid cc range: %w", i+1, err)
+			}
+		}
+		if output.VelocityCompressor != nil {
+			if err := output.VelocityCompressor.Validate(); err != nil {
+				return fmt.Errorf("output %d has invalid velocity compressor: %w", i+1, err)
+			}
+		}
+		if output.Harmonizer != nil {
+			if err := output.Harmonizer.Validate(); err != nil {
+				return fmt.Errorf("output %d has invalid harmonizer: %w", i+1, err)
+			}
+			if output.Harmonizer.usesGlobalKey() && config.Key == nil {
+				return fmt.Errorf("output %d has a harmonizer without a key and no global key", i+1)
+			}
+		}
+		if output.Echo != nil {
+			if err := output.Echo.Validate(); err != nil {
+				return fmt.Errorf("output %d has invalid echo: %w", i+1, err)
+			}
+		}
+		if err := validateChokeGroups(output.ChokeGroups); err != nil {
+			return fmt.Errorf("output %d has invalid choke groups: %w", i+1, err)
+		}
+		if output.Strum != nil {
+			if err := output.Strum.Validate(); err != nil {
+				return fmt.Errorf("output %d has invalid strum: %w", i+1, err)
+			}
+		}
+		if output.NoteRepeat != nil {
+			if err := output.NoteRepeat.Validate(); err != nil {
+				return fmt.Errorf("output %d has invalid note repeat: %w", i+1, err)
+			}
+		}
+		if output.Envelope != nil {
+			if err := output.Envelope.Validate(); err != nil {
+				return fmt.Errorf("output %d has invalid envelope: %w", i+1, err)
+			}
+		}
+		for _, channel := range output.DuplicateChannels {
+			if channel < 1 || channel > 16 {
+				return fmt.Errorf("output %d has invalid duplicate channel: %d (must be 1-16)", i+1, channel)
+			}
+		}
+		if output.ChannelRotation != nil {
+			if len(output.DuplicateChannels) > 0 {
+				return fmt.Errorf("output %d cannot use both duplicate_to_channels and channel_rotation", i+1)
+			}
+			if output.OverrideChannel != nil {
+				return fmt.Errorf("output %d cannot use both override_channel and channel_rotation", i+1)
+			}
+			if err := output.ChannelRotation.Validate(); err != nil {
+				return fmt.Errorf("output %d has invalid channel rotation: %w", i+1, err)
+			}
+		}
+		if output.Tuning != nil {
+			if output.OverrideChannel != nil || output.ChannelRotation != nil || len(output.DuplicateChannels) > 0 {
+				return fmt.Errorf("output %d cannot combine tuning with override_channel, channel_rotation or duplicate_to_channels", i+1)
+			}
+			if err := output.Tuning.Validate(); err != nil {
+				return fmt.Errorf("output %d has invalid tuning: %w", i+1, err)
+			}
+		}
+		if output.SongPosition != nil {
+			if err := output.SongPosition.Validate(); err != nil {
+				return fmt.Errorf("output %d has invalid song position: %w", i+1, err)
+			}
+		}
+		if output.TransportMap != nil {
+			if err := output.TransportMap.Validate(); err != nil {
+				return fmt.Errorf("output %d has invalid transport map: %w", i+1, err)
+			}
+		}
+		if output.MessageTypes != nil {
+			if err := output.MessageTypes.Validate(); err != nil {
+				return fmt.Errorf("output %d has invalid message types: %w", i+1, err)
+			}
+		}
+		if output.Filter != nil {
+			if err := output.Filter.Validate(); err != nil {
+				return fmt.Errorf("output %d has invalid filter: %w", i+1, err)
+			}
+		}
+		if output.SystemMessages != nil {
+			if err := output.SystemMessages.Validate(); err != nil {
+				return fmt.Errorf("output %d has invalid system messages: %w", i+1, err)
+			}
+		}
+		if output.Gain != nil {
+			if err := output.Gain.Validate(); err != nil {
+				return fmt.Errorf("output %d has invalid gain: %w", i+1, err)
+			}
+		}
+		if output.SysExPacing != nil {
+			if err := output.SysExPacing.Validate(); err != nil {
+				return fmt.Errorf("output %d has invalid sysex pacing: %w", i+1, err)
+			}
+		}
+		if output.RawFile != nil {
+			if err := output.RawFile.Validate(); err != nil {
+				return fmt.Errorf("output %d has invalid raw file: %w", i+1, err)
+			}
+		}
+		if output.Network != nil {
+			if err := output.Network.Validate(); err != nil {
+				return fmt.Errorf("output %d has invalid network: %w", i+1, err)
+			}
+		}
+		if output.Socket != nil {
+			if err := output.Socket.Validate(); err != nil {
+				return fmt.Errorf("output %d has invalid socket: %w", i+1, err)
+			}
+		}
+		if output.OSC != nil {
+			if err := output.OSC.Validate(); err != nil {
+				return fmt.Errorf("output %d has invalid osc: %w", i+1, err)
+			}
+		}
+		if output.MQTT != nil {
+			if err := output.MQTT.Validate(); err != nil {
+				return fmt.Errorf("output %d has invalid mqtt: %w", i+1, err)
+			}
+		}
+		if output.MTS != nil {
+			if output.Tuning != nil {
+				return fmt.Errorf("output %d cannot use both tuning and mts", i+1)
+			}
+			if err := output.MTS.Validate(); err != nil {
+				return fmt.Errorf("output %d has invalid mts: %w", i+1, err)
+			}
+		}
+	}
+	if err := checkPortNames(config); err != nil {
+		return err
+	}
+
+	if config.Clock != nil {
+		if err := config.Clock.Validate(config.Outputs); err != nil {
+			return fmt.Errorf("invalid clock: %w", err)
+		}
+	}
+
+	for i := range config.Gestures {
+		if err := config.Gestures[i].Validate(); err != nil {
+			return fmt.Errorf("gesture %d is invalid: %w", i+1, err)
+		}
+	}
+
+	for i := range config.OutputGroups {
+		if err := config.OutputGroups[i].Validate(config.Outputs); err != nil {
+			return fmt.Errorf("output group %d is invalid: %w", i+1, err)
+		}
+	}
+
+	macroNames := make(map[string]bool)
+	macroHotkeys := make(map[string]bool)
+	for i := range config.Macros {
+		macro := &config.Macros[i]
+		if err := macro.Validate(config.Outputs); err != nil {
+			return fmt.Errorf("macro %d is invalid: %w", i+1, err)
+		}
+		if macroNames[macro.Name] {
+			return fmt.Errorf("macro %d has duplicate name: %q", i+1, macro.Name)
+		}
+		macroNames[macro.Name] = true
+		if macro.Hotkey != "" {
+			if macroHotkeys[macro.Hotkey] {
+				return fmt.Errorf("macro %d has duplicate hotkey: %q", i+1, macro.Hotkey)
+			}
+			macroHotkeys[macro.Hotkey] = true
+		}
+	}
+
+	for i := range config.LFOs {
+		if err := config.LFOs[i].Validate(config.Outputs); err != nil {
+			return fmt.Errorf("lfo %d is invalid: %w", i+1, err)
+		}
+	}
+	for i := range config.RandomCCs {
+		if err := config.RandomCCs[i].Validate(config.Outputs); err != nil {
+			return fmt.Errorf("random cc %d is invalid: %w", i+1, err)
+		}
+	}
+
+	if config.SysEx != nil {
+		if err := config.SysEx.Validate(); err != nil {
+			return fmt.Errorf("invalid sysex: %w", err)
+		}
+	}
+
+	if config.Key != nil {
+		if err := config.Key.Validate(); err != nil {
+			return fmt.Errorf("invalid key: %w", err)
+		}
+	}
+
+	if config.NetworkInput != nil {
+		if err := config.NetworkInput.Validate(); err != nil {
+			return fmt.Errorf("invalid network input: %w", err)
+		}
+	}
+
+	for i, token := range config.WebSocketTokens {
+		if err := token.Validate(); err != nil {
+			return fmt.Errorf("websocket token %d is invalid: %w", i+1, err)
+		}
+		for _, other := range config.WebSocketTokens[:i] {
+			if other.Token == token.Token {
+				return fmt.Errorf("websocket token %d is listed more than once", i+1)
+			}
+		}
+	}
+
+	if config.MQTT != nil {
+		if err := config.MQTT.Validate(); err != nil {
+			return fmt.Errorf("invalid mqtt: %w", err)
+		}
+	}
+
+	if config.RawInput != nil {
+		if err := config.RawInput.Validate(); err != nil {
+			return fmt.Errorf("invalid raw input: %w", err)
+		}
+		if config.RawInput.Path == "-" && config.readsHotkeys() {
+			return fmt.Errorf("the tap tempo and macro hotkeys cannot be used while reading raw input from stdin")
+		}
 	}
 
 	return nil
 }
 
+// findOutputIndex returns the index of the output with the given name, or -1 if there is none
+func findOutputIndex(outputs []OutputConfig, name string) int {
+	for i, output := range outputs {
+		if output.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// inputNames returns the configured input devices: input_device, input_devices, the ports of
+// logical inputs, then the inputs of outputs that are not listed yet. A raw input is named by its path.
+func (c *Config) inputNames() []string {
+	if c.RawInput != nil {
+		return []string{c.RawInput.Path}
+	}
+	var names []string
+	if c.InputDevice != "" {
+		names = append(names, c.InputDevice)
+	}
+	names = append(names, c.InputDevices...)
+	for _, logical := range c.LogicalInputs {
+		for _, port := range logical.Ports {
+			if !slices.Contains(names, port) {
+				names = append(names, port)
+			}
+		}
+	}
+	for _, output := range c.Outputs {
+		if output.InputDevice != "" && !slices.Contains(names, output.InputDevice) {
+			names = append(names, output.InputDevice)
+		}
+	}
+	if len(names) == 0 && len(c.virtualInputNames()) == 0 && c.NetworkInput == nil && c.WebSocketInput == "" && c.mqttInputName() == "" {
+		// Reported as a missing device
+		names = append(names, c.InputDevice)
+	}
+	return names
+}
+
+// virtualInputNames returns the names of the virtual input ports
+func (c *Config) virtualInputNames() []string {
+	var names []string
+	if c.VirtualInput != "" {
+		names = append(names, c.VirtualInput)
+	}
+	return append(names, c.VirtualInputs...)
+}
+
+// sourceNames returns the names of everything messages arrive from: the input devices, the
+// virtual inputs, the network input, the WebSocket input and the MQTT input
+func (c *Config) sourceNames() []string {
+	names := c.inputNames()
+	names = append(names, c.virtualInputNames()...)
+	if c.NetworkInput != nil {
+		names = append(names, c.NetworkInput.name())
+	}
+	if c.WebSocketInput != "" {
+		names = append(names, c.WebSocketInput)
+	}
+	if name := c.mqttInputName(); name != "" {
+		names = append(names, name)
+	}
+	return names
+}
+
+// validateInputDevices checks that every configured input device exists, after picking the
+// input_device matched by input_device_pattern
+func validateInputDevices(config *Config, drv midiDriver) error {
+	if config.InputDevicePattern != "" {
+		if err := resolveInputDevicePattern(config, drv); err != nil {
+			return err
+		}
+	}
+	if err := checkSelfInputs(config); err != nil {
+		return err
+	}
+	for _, name := range config.inputNames() {
+		if isMIDIFile(name) {
+			if _, err := os.Stat(name); err != nil {
+				return fmt.Errorf("configured MIDI file not found: %w", err)
+			}
+			continue
+		}
+		if err := validateInputDevice(name, drv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findOutputDevice returns the existing output port for an output's device, matching the full
+// port name or the name without ALSA client and port numbers
+func findOutputDevice(outs []drivers.Out, device string) (drivers.Out, error) {
+	for _, out := range outs {
+		if out.String() == device {
+			return out, nil
+		}
+	}
+	for _, out := range outs {
+		if portNameMatches(out.String(), device) {
+			return out, nil
+		}
+	}
+	names := make([]string, len(outs))
+	for i, out := range outs {
+		names[i] = out.String()
+	}
+	return nil, fmt.Errorf("output device not found: %s\nAvailable devices: %v", device, names)
+}
+
+// validateOutputDevices checks that the existing output ports used by outputs are available
+func validateOutputDevices(config *Config, drv midiDriver) error {
+	outs, err := drv.Outs()
+	if err != nil {
+		return fmt.Errorf("failed to get MIDI outputs: %w", err)
+	}
+	for _, output := range config.Outputs {
+		if output.Device == "" {
+			continue
+		}
+		if _, err := findOutputDevice(outs, output.Device); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // validateInputDevice checks if the input device exists in the available devices
-func validateInputDevice(deviceName string, drv *rtmididrv.Driver) error {
+func validateInputDevice(deviceName string, drv midiDriver) error {
 	ins, err := drv.Ins()
 	if err != nil {
 		return fmt.Errorf("failed to get MIDI inputs: %w", err)
@@ -214,9 +1238,49 @@ func validateInputDevice(deviceName string, drv *rtmididrv.Driver) error {
 		deviceName, getDeviceNames(ins))
 }
 
-// loadConfigWithFallback loads config and falls back to interactive input selection if device not found
-func loadConfigWithFallback(filename string, drv *rtmididrv.Driver) (*Config, error) {
-	config, err := loadConfig(filename)
+// resolveInputDevicePattern sets input_device to the first connected input whose name matches
+// input_device_pattern, for devices whose names change between sessions, such as port numbers.
+// The pattern is first looked for as plain text, so names with characters such as brackets or
+// dots match themselves, and only used as a regular expression when no name contains it.
+func resolveInputDevicePattern(config *Config, drv midiDriver) error {
+	ins, err := drv.Ins()
+	if err != nil {
+		return fmt.Errorf("failed to get MIDI inputs: %w", err)
+	}
+
+	for _, in := range ins {
+		if strings.Contains(in.String(), config.InputDevicePattern) {
+			config.InputDevice = in.String()
+			return nil
+		}
+	}
+	if pattern, err := regexp.Compile(config.InputDevicePattern); err == nil {
+		for _, in := range ins {
+			if pattern.MatchString(in.String()) {
+				config.InputDevice = in.String()
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("no input device matches pattern: %s\nAvailable devices: %v",
+		config.InputDevicePattern, getDeviceNames(ins))
+}
+
+// devicePollInterval is how often --wait-for-device checks for the configured devices
+const devicePollInterval = time.Second
+
+// loadConfigWithFallback loads config and falls back to interactive input selection if device not found,
+// or waits for the devices to be connected when wait is set.
+// Configs from a URL are loaded through remote, which keeps track of the cached copy.
+func loadConfigWithFallback(filename string, remote *remoteConfig, drv midiDriver, wait bool) (*Config, error) {
+	var config *Config
+	var err error
+	if remote != nil {
+		config, err = remote.Load()
+	} else {
+		config, err = loadConfig(filename)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -226,8 +1290,20 @@ func loadConfigWithFallback(filename string, drv *rtmididrv.Driver) (*Config, er
 		return nil, err
 	}
 
+	if wait {
+		waitForDevices(config, drv)
+		return config, nil
+	}
+
 	// Check if input device exists
-	if err := validateInputDevice(config.InputDevice, drv); err != nil {
+	if config.RawInput != nil {
+		return config, nil
+	}
+	if err := validateInputDevices(config, drv); err != nil {
+		if names := config.inputNames(); len(names) > 1 || names[0] != config.InputDevice {
+			// Only a single global input can be replaced by picking one
+			return nil, err
+		}
 		fmt.Printf("Warning: %s\n", err.Error())
 
 		selectedInput, err := selectInputDevice(drv)
@@ -241,8 +1317,33 @@ func loadConfigWithFallback(filename string, drv *rtmididrv.Driver) (*Config, er
 	return config, nil
 }
 
+// waitForDevices polls until the configured input devices and the hardware outputs are connected,
+// for starting the router at boot before a controller is plugged in
+func waitForDevices(config *Config, drv midiDriver) {
+	waiting := ""
+	for {
+		err := validateOutputDevices(config, drv)
+		if err == nil && config.RawInput == nil {
+			err = validateInputDevices(config, drv)
+		}
+		if err == nil {
+			if waiting != "" {
+				fmt.Println("Devices connected")
+			}
+			return
+		}
+
+		// The error lists the available devices, only print it when something changed
+		if err.Error() != waiting {
+			fmt.Printf("Waiting for device: %v\n", err)
+			waiting = err.Error()
+		}
+		time.Sleep(devicePollInterval)
+	}
+}
+
 // loadAndValidateConfig loads configuration from file and validates it
-func loadAndValidateConfig(filename string, drv *rtmididrv.Driver) (*Config, error) {
+func loadAndValidateConfig(filename string, drv midiDriver) (*Config, error) {
 	config, err := loadConfig(filename)
 	if err != nil {
 		return nil, err
@@ -254,7 +1355,10 @@ func loadAndValidateConfig(filename string, drv *rtmididrv.Driver) (*Config, err
 	}
 
 	// Validate input device
-	if err := validateInputDevice(config.InputDevice, drv); err != nil {
+	if config.RawInput != nil {
+		return config, nil
+	}
+	if err := validateInputDevices(config, drv); err != nil {
 		return nil, err
 	}
 
@@ -270,8 +1374,27 @@ func getDeviceNames(devices []drivers.In) []string {
 	return names
 }
 
+// portBaseName strips the trailing ALSA client and port numbers ("Name 128:0") from a port name
+func portBaseName(name string) string {
+	if i := strings.LastIndex(name, " "); i >= 0 {
+		suffix := name[i+1:]
+		if client, port, ok := strings.Cut(suffix, ":"); ok && client != "" && port != "" &&
+			strings.Trim(client, "0123456789") == "" && strings.Trim(port, "0123456789") == "" {
+			return name[:i]
+		}
+	}
+	return name
+}
+
+// portNameMatches checks a port name against a configured name, ignoring ALSA port numbers and
+// client or device name prefixes ("Client:Name", "IAC Driver Name")
+func portNameMatches(portName string, name string) bool {
+	base := portBaseName(portName)
+	return base == name || strings.HasSuffix(base, ":"+name) || strings.HasSuffix(base, " "+name)
+}
+
 // selectInputDevice presents available MIDI input devices and lets user select one
-func selectInputDevice(drv *rtmididrv.Driver) (drivers.In, error) {
+func selectInputDevice(drv midiDriver) (drivers.In, error) {
 	reader := bufio.NewReader(os.Stdin)
 
 	// Get available input devices
@@ -305,7 +1428,7 @@ func selectInputDevice(drv *rtmididrv.Driver) (drivers.In, error) {
 }
 
 // interactiveConfig guides the user through configuration setup
-func interactiveConfig(drv *rtmididrv.Driver) (*Config, error) {
+func interactiveConfig(drv midiDriver) (*Config, error) {
 	reader := bufio.NewReader(os.Stdin)
 	config := &Config{}
 
@@ -343,12 +1466,35 @@ func interactiveConfig(drv *rtmididrv.Driver) (*Config, error) {
 		return nil, fmt.Errorf("invalid number of outputs (must be 1-16)")
 	}
 
+	profiles, err := builtinProfiles()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load device profiles: %w", err)
+	}
+
 	// Configure each output
 	config.Outputs = make([]OutputConfig, numOutputs)
 	for i := 0; i < numOutputs; i++ {
 		defaultOutputName := fmt.Sprintf("Out %d", i+1)
 		fmt.Printf("Configuring output %d...\n", i+1)
 
+		// A device profile sets up the whole output, leaving only its name to pick
+		fmt.Print("Start from a device profile? (y/N): ")
+		line, err = reader.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("failed to read input: %w", err)
+		}
+
+		var profile *OutputSnippet
+		if strings.ToLower(strings.TrimSpace(line)) == "y" {
+			profile, err = selectProfile(reader, profiles)
+			if err != nil {
+				return nil, err
+			}
+			// Checked when the profile was loaded
+			json.Unmarshal(profile.Output, &config.Outputs[i])
+			defaultOutputName = config.Outputs[i].Name
+		}
+
 		fmt.Printf("Enter output name: (default: '%s'): ", defaultOutputName)
 		line, err = reader.ReadString('\n')
 		if err != nil {
@@ -361,6 +1507,10 @@ func interactiveConfig(drv *rtmididrv.Driver) (*Config, error) {
 		}
 
 		config.Outputs[i].Name = outputName
+		if profile != nil {
+			fmt.Printf("Using profile %s\n", profile.Name)
+			continue
+		}
 
 		// Channel filter
 		fmt.Print("Enable channel filter? (y/N): ")
@@ -386,19 +1536,42 @@ func interactiveConfig(drv *rtmididrv.Driver) (*Config, error) {
 			}
 		}
 
-		// Note range filter
-		fmt.Print("Enable note range filter? (y/N): ")
+		// Note range filter
+		fmt.Print("Enable note range filter? (y/N): ")
+		line, err = reader.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("failed to read input: %w", err)
+		}
+
+		if strings.ToLower(strings.TrimSpace(line)) == "y" {
+			noteRange, err := configureNoteRange(selectedInput, config.Outputs[i].ChannelFilter)
+			if err != nil {
+				return nil, fmt.Errorf("failed to configure note range: %w", err)
+			}
+			config.Outputs[i].NoteRangeFilter = noteRange
+		}
+
+		// CC range scaling, learned by sweeping each control
+		fmt.Print("Rescale a controller's range? (y/N): ")
 		line, err = reader.ReadString('\n')
 		if err != nil {
 			return nil, fmt.Errorf("failed to read input: %w", err)
 		}
 
-		if strings.ToLower(strings.TrimSpace(line)) == "y" {
-			noteRange, err := configureNoteRange(selectedInput)
+		for strings.ToLower(strings.TrimSpace(line)) == "y" {
+			ccRange, err := configureCCRange(reader, selectedInput, config.Outputs[i].ChannelFilter)
 			if err != nil {
-				return nil, fmt.Errorf("failed to configure note range: %w", err)
+				return nil, fmt.Errorf("failed to configure cc range: %w", err)
+			}
+			if ccRange != nil {
+				config.Outputs[i].CCRanges = append(config.Outputs[i].CCRanges, *ccRange)
+			}
+
+			fmt.Print("Rescale another controller? (y/N): ")
+			line, err = reader.ReadString('\n')
+			if err != nil {
+				return nil, fmt.Errorf("failed to read input: %w", err)
 			}
-			config.Outputs[i].NoteRangeFilter = noteRange
 		}
 
 		// Override channel
@@ -446,31 +1619,107 @@ func interactiveConfig(drv *rtmididrv.Driver) (*Config, error) {
 			transposeSemitones := int8(transpose)
 			config.Outputs[i].TransposeSemitones = &transposeSemitones
 		}
+
+		// Harmonizer
+		fmt.Print("Enable harmonizer? (y/N): ")
+		line, err = reader.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("failed to read input: %w", err)
+		}
+
+		if strings.ToLower(strings.TrimSpace(line)) == "y" {
+			harmonizer, err := configureHarmonizer(reader)
+			if err != nil {
+				return nil, fmt.Errorf("failed to configure harmonizer: %w", err)
+			}
+			config.Outputs[i].Harmonizer = harmonizer
+		}
 	}
 
 	return config, nil
 }
 
+// previewConfig offers to run the router with a new configuration before it is saved.
+// It returns false when the user wants to go back and change the configuration.
+func previewConfig(drv midiDriver, config *Config, logging logOptions) (bool, error) {
+	reader := bufio.NewReader(os.Stdin)
+
+	fmt.Print("\nTest this configuration now? (y/N): ")
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return false, fmt.Errorf("failed to read input: %w", err)
+	}
+	if strings.ToLower(strings.TrimSpace(line)) != "y" {
+		return true, nil
+	}
+
+	fmt.Println("Play some notes to see how they are routed, then press Enter to end the test.")
+	stop := make(chan struct{})
+	result := make(chan error, 1)
+	go func() {
+		result <- runMIDIRouter(drv, config, logging, stop)
+	}()
+
+	lineRead := readLine(reader)
+	select {
+	case err := <-result:
+		// The router failed to start or was interrupted
+		if err != nil {
+			return false, err
+		}
+		fmt.Print("Press Enter to continue...")
+		<-lineRead
+	case <-lineRead:
+		close(stop)
+		if err := <-result; err != nil {
+			return false, err
+		}
+	}
+
+	fmt.Print("Keep this configuration? (Y/n): ")
+	line, err = reader.ReadString('\n')
+	if err != nil {
+		return false, fmt.Errorf("failed to read input: %w", err)
+	}
+	return strings.ToLower(strings.TrimSpace(line)) != "n", nil
+}
+
+// readLine reads a line in the background and signals when it has been read
+func readLine(reader *bufio.Reader) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		reader.ReadString('\n')
+		close(done)
+	}()
+	return done
+}
+
 // noteToName converts a MIDI note number to note name
 func noteToName(note uint8) string {
-	noteNames := []string{"C", "C#", "D", "D#", "E", "F", "F#", "G", "G#", "A", "A#", "B"}
 	octave := int(note)/12 - 1
 	noteName := noteNames[note%12]
 	return fmt.Sprintf("%s%d", noteName, octave)
 }
 
-// configureNoteRange configures note range by listening to actual MIDI input
-func configureNoteRange(inputPort drivers.In) (*NoteRangeFilter, error) {
+// configureNoteRange configures note range by listening to actual MIDI input. With a channel
+// filter only notes on that channel are captured, so other zones of the controller are ignored.
+func configureNoteRange(inputPort drivers.In, channelFilter *ChannelFilter) (*NoteRangeFilter, error) {
+	var channel uint8
+	if channelFilter != nil {
+		channel = channelFilter.Channel
+		fmt.Printf("  Listening on channel %d only\n", channel)
+	}
+
 	fmt.Printf("  Play the LOWEST note: ")
 
-	minNote, err := captureNote(inputPort)
+	minNote, err := captureNote(inputPort, channel)
 	if err != nil {
 		return nil, fmt.Errorf("failed to capture min note: %w", err)
 	}
 
 	fmt.Printf("  Play the HIGHEST note: ")
 
-	maxNote, err := captureNote(inputPort)
+	maxNote, err := captureNote(inputPort, channel)
 	if err != nil {
 		return nil, fmt.Errorf("failed to capture max note: %w", err)
 	}
@@ -497,8 +1746,9 @@ func configureNoteRange(inputPort drivers.In) (*NoteRangeFilter, error) {
 	}, nil
 }
 
-// captureNote listens for a single Note On event and returns the note number
-func captureNote(inputPort drivers.In) (uint8, error) {
+// captureNote listens for a single Note On event and returns the note number.
+// onlyChannel (1-16) restricts the capture to one channel, 0 accepts any channel.
+func captureNote(inputPort drivers.In, onlyChannel uint8) (uint8, error) {
 	noteChan := make(chan uint8, 1)
 	errorChan := make(chan error, 1)
 
@@ -506,7 +1756,11 @@ func captureNote(inputPort drivers.In) (uint8, error) {
 	stop, err := midi.ListenTo(inputPort, func(msg midi.Message, timestampms int32) {
 		var channel, key, velocity uint8
 		if msg.GetNoteOn(&channel, &key, &velocity) && velocity > 0 {
-			fmt.Printf("%s\n", noteToName(key))
+			if onlyChannel != 0 && channel+1 != onlyChannel {
+				return
+			}
+			// Show the channel so notes from another zone of the controller are noticed
+			fmt.Printf("%s (channel %d)\n", noteToName(key), channel+1)
 			select {
 			case noteChan <- key:
 			default:
@@ -560,6 +1814,35 @@ func applyChannelOverride(msg midi.Message, overrideChannel *uint8, transform *M
 	return newMsg
 }
 
+// applyChannelDuplication adds a copy of every channel message on each of the duplicate channels.
+// Copies on the message's own channel are skipped.
+func applyChannelDuplication(msgs []midi.Message, duplicateChannels []uint8, transform *MessageTransformation) []midi.Message {
+	if len(duplicateChannels) == 0 {
+		return msgs
+	}
+
+	var duplicated []midi.Message
+	for i, msg := range msgs {
+		duplicated = append(duplicated, msg)
+		if !hasChannelInfo(msg) {
+			continue
+		}
+
+		ownChannel := extractChannelFromMessage(msg)
+		for _, channel := range duplicateChannels {
+			if channel == ownChannel {
+				continue
+			}
+			duplicated = append(duplicated, withChannel(msg, channel-1))
+			// Record the copies of the played message for logging
+			if i == 0 {
+				transform.DuplicateChannels = append(transform.DuplicateChannels, channel)
+			}
+		}
+	}
+	return duplicated
+}
+
 // applyNoteTransposition modifies note numbers in MIDI Note On/Off messages if configured
 // Returns the modified message and updates transformation info
 func applyNoteTransposition(msg midi.Message, transposeSemitones *int8, transform *MessageTransformation) midi.Message {
@@ -615,6 +1898,63 @@ func applyNoteTransposition(msg midi.Message, transposeSemitones *int8, transfor
 	return msg
 }
 
+// limitNote moves a note into the range minNote-maxNote by octaves, keeping its pitch class. A
+// range narrower than an octave may not contain the pitch class, then the note is clamped to the
+// nearest limit.
+func limitNote(note, minNote, maxNote uint8) uint8 {
+	n := int(note)
+	for n < int(minNote) {
+		n += 12
+	}
+	for n > int(maxNote) {
+		n -= 12
+	}
+	return uint8(max(int(minNote), min(n, int(maxNote))))
+}
+
+// applyNoteLimits keeps note on/off messages within an output's min_note and max_note. Note offs
+// are moved the same way as their note ons, since the mapping only depends on the note number.
+func applyNoteLimits(msgs []midi.Message, output *OutputConfig, transform *MessageTransformation) []midi.Message {
+	if output.MinNote == nil && output.MaxNote == nil {
+		return msgs
+	}
+	minNote, maxNote := uint8(0), uint8(127)
+	if output.MinNote != nil {
+		minNote = *output.MinNote
+	}
+	if output.MaxNote != nil {
+		maxNote = *output.MaxNote
+	}
+
+	for i, msg := range msgs {
+		var channel, key, velocity uint8
+		if !msg.GetNoteOn(&channel, &key, &velocity) && !msg.GetNoteOff(&channel, &key, &velocity) {
+			continue
+		}
+		limited := limitNote(key, minNote, maxNote)
+		if limited == key {
+			continue
+		}
+
+		newMsg := make(midi.Message, len(msg))
+		copy(newMsg, msg)
+		newMsg[1] = limited
+		msgs[i] = newMsg
+
+		// Record the change of the played note for logging
+		if i == 0 {
+			if transform.OriginalNote == nil {
+				transform.OriginalNote = &key
+			}
+			transform.TransformedNote = &limited
+		}
+	}
+	return msgs
+}
+
+// maxLoggedSysEx is how many bytes of a SysEx message are logged
+const maxLoggedSysEx = 16
+
 // formatMessageWithTransformations creates a formatted string showing MIDI message with transformations
 func formatMessageWithTransformations(originalMsg midi.Message, transform *MessageTransformation) string {
 	// Get the message type name from the MIDI library
@@ -630,10 +1970,23 @@ func formatMessageWithTransformations(originalMsg midi.Message, transform *Messa
 			var channel, key, velocity uint8
 			if originalMsg.GetNoteOn(&channel, &key, &velocity) || originalMsg.GetNoteOff(&channel, &key, &velocity) {
 				noteStr := formatNoteTransformation(key, transform)
-				return fmt.Sprintf("%s %s, %s, velocity: %d", messageType, channelStr, noteStr, velocity)
+				if len(transform.HarmonyNotes) > 0 {
+					noteStr = fmt.Sprintf("%s, harmony: %v", noteStr, transform.HarmonyNotes)
+				}
+				velocityStr := fmt.Sprintf("velocity: %d", velocity)
+				if transform.OriginalVelocity != nil && transform.TransformedVelocity != nil {
+					velocityStr = fmt.Sprintf("velocity: %d->%d", *transform.OriginalVelocity, *transform.TransformedVelocity)
+				}
+				return fmt.Sprintf("%s %s, %s, %s", messageType, channelStr, noteStr, velocityStr)
 			}
 		}
 
+		// Show rescaled control change values
+		var channel, controller, value uint8
+		if transform.OriginalValue != nil && transform.TransformedValue != nil && originalMsg.GetControlChange(&channel, &controller, &value) {
+			return fmt.Sprintf("%s %s, controller: %d, value: %d->%d", messageType, channelStr, controller, *transform.OriginalValue, *transform.TransformedValue)
+		}
+
 		// Handle other channel messages (ControlChange, ProgramChange, Pitchbend, etc.)
 		if len(originalMsg) > 1 {
 			return fmt.Sprintf("%s %s, data: %v", messageType, channelStr, originalMsg[1:])
@@ -641,6 +1994,11 @@ func formatMessageWithTransformations(originalMsg midi.Message, transform *Messa
 		return fmt.Sprintf("%s %s", messageType, channelStr)
 	}
 
+	// Show the size of SysEx dumps instead of every byte
+	if originalMsg.Is(midi.SysExMsg) && len(originalMsg) > maxLoggedSysEx {
+		return fmt.Sprintf("%s %d bytes, data: %v...", messageType, len(originalMsg), originalMsg[1:maxLoggedSysEx])
+	}
+
 	// Handle system messages (no channel information)
 	if len(originalMsg) > 1 {
 		return fmt.Sprintf("%s data: %v", messageType, originalMsg[1:])
@@ -650,10 +2008,14 @@ func formatMessageWithTransformations(originalMsg midi.Message, transform *Messa
 
 // formatChannelTransformation formats channel info with before->after if changed
 func formatChannelTransformation(originalChannel uint8, transform *MessageTransformation) string {
+	channelStr := fmt.Sprintf("channel: %d", originalChannel)
 	if transform.OriginalChannel != nil && transform.TransformedChannel != nil {
-		return fmt.Sprintf("channel: %d->%d", *transform.OriginalChannel, *transform.TransformedChannel)
+		channelStr = fmt.Sprintf("channel: %d->%d", *transform.OriginalChannel, *transform.TransformedChannel)
 	}
-	return fmt.Sprintf("channel: %d", originalChannel)
+	if len(transform.DuplicateChannels) > 0 {
+		channelStr = fmt.Sprintf("%s (+%v)", channelStr, transform.DuplicateChannels)
+	}
+	return channelStr
 }
 
 // formatNoteTransformation formats note info with before->after if changed
@@ -664,6 +2026,12 @@ func formatNoteTransformation(originalNote uint8, transform *MessageTransformati
 	return fmt.Sprintf("note: %d", originalNote)
 }
 
+// isNoteStart checks if a message is a Note On with a velocity above 0
+func isNoteStart(msg midi.Message) bool {
+	var channel, key, velocity uint8
+	return msg.GetNoteStart(&channel, &key, &velocity)
+}
+
 // isNoteMessage checks if a message is a Note On or Note Off message
 func isNoteMessage(msg midi.Message) bool {
 	var channel, key, velocity uint8
@@ -691,26 +2059,108 @@ func extractChannelFromMessage(msg midi.Message) uint8 {
 	return 0
 }
 
+// logOptions controls how routed and dropped messages are logged
+type logOptions struct {
+	Quiet bool // suppress message logging
+	Hex   bool // show the raw bytes of each message
+	Color bool // color each output's log lines
+
+	StatsInterval time.Duration // how often to print message counts in quiet mode, 0 to disable
+	Dashboard     bool          // show live counters instead of logging each message
+	NoBanner      bool          // don't print the configuration when the router starts
+
+	CaptureDropped *messageRecorder // records messages that no output received, optional
+	RecordInput    *messageRecorder // records every incoming message, optional
+	RecordOutput   *messageRecorder // records every message sent to an output, optional
+
+	Ports *portPool // keeps the virtual ports open across restarts, optional
+
+	StateFile string         // where controller state snapshots are saved, optional
+	LiveState *liveStateFile // keeps the live adjustments on disk across crashes, optional
+
+	Audit *auditLog // records changes made to the running configuration, optional
+
+	WebSocket *webSocketBridge // serves sent messages to WebSocket clients, optional
+	Control   *controlServer   // reports to a parent process over JSON-RPC, optional
+	Running   *runningRouter   // the router currently running, for the editor, control mode and WebSocket clients
+
+	Timestamps string // "monotonic" or "driver" to start log lines with a timestamp, empty for none
+}
+
+// format formats a message for the log, with its raw bytes when enabled
+func (lo logOptions) format(originalMsg midi.Message, transform *MessageTransformation) string {
+	formattedMsg := formatMessageWithTransformations(originalMsg, transform)
+	if lo.Hex {
+		// The bytes as received, followed by the bytes that went out when the transformations
+		// changed them
+		formattedMsg += fmt.Sprintf(" [% X]", []byte(originalMsg))
+		if len(transform.Sent) != 1 || !slices.Equal(transform.Sent[0], originalMsg) {
+			for i, msg := range transform.Sent {
+				separator := " "
+				if i == 0 {
+					separator = " -> "
+				}
+				formattedMsg += fmt.Sprintf("%s[% X]", separator, []byte(msg))
+			}
+		}
+	}
+	return formattedMsg
+}
+
 // logSuccessfulRoute logs a successful message route to a specific output
-func logSuccessfulRoute(outputName string, originalMsg midi.Message, transform *MessageTransformation, quiet bool) {
-	if quiet {
+func logSuccessfulRoute(outputName string, color string, originalMsg midi.Message, transform *MessageTransformation, logging logOptions) {
+	// Clock ticks arrive 24 times per quarter note and Active Sensing every 300ms, they would
+	// drown out everything else
+	if logging.Quiet || originalMsg.Is(midi.TimingClockMsg) || originalMsg.Is(midi.ActiveSenseMsg) {
 		return
 	}
 
-	formattedMsg := formatMessageWithTransformations(originalMsg, transform)
-	fmt.Printf("[%s] %s\n", outputName, formattedMsg)
+	formattedMsg := logging.format(originalMsg, transform)
+	line := fmt.Sprintf("[%s] %s", outputName, formattedMsg)
+	if transform.Input != "" {
+		line = fmt.Sprintf("[%s > %s] %s", transform.Input, outputName, formattedMsg)
+	}
+	line = logging.timestamp(transform.DriverMS) + line
+	if logging.Color {
+		line = colorize(line, color)
+	}
+	fmt.Println(line)
 }
 
-// logDroppedMessage logs when a message was not routed to any output
-func logDroppedMessage(originalMsg midi.Message, quiet bool) {
-	if quiet {
+// logDroppedMessage logs when a message was not routed to any output. input is shown when several
+// inputs are merged. driverMS is the driver's timestamp of the message, if any.
+func logDroppedMessage(originalMsg midi.Message, input string, driverMS *int32, logging logOptions) {
+	if logging.Quiet || originalMsg.Is(midi.TimingClockMsg) || originalMsg.Is(midi.ActiveSenseMsg) {
 		return
 	}
 
 	// Use empty transformation for dropped messages (no transformations applied)
 	emptyTransform := &MessageTransformation{}
-	formattedMsg := formatMessageWithTransformations(originalMsg, emptyTransform)
-	fmt.Printf("\033[2m[DROPPED] %s\033[0m\n", formattedMsg)
+	formattedMsg := logging.format(originalMsg, emptyTransform)
+	line := fmt.Sprintf("[DROPPED] %s", formattedMsg)
+	if input != "" {
+		line = fmt.Sprintf("[%s > DROPPED] %s", input, formattedMsg)
+	}
+	line = logging.timestamp(driverMS) + line
+	if logging.Color {
+		// Dim
+		line = colorize(line, "2")
+	}
+	fmt.Println(line)
+}
+
+// acceptsInput checks if an output receives messages from the named input
+func (oc *OutputConfig) acceptsInput(config *Config, input string) bool {
+	if config.inputListed(oc.InputExclude, input) {
+		return false
+	}
+	if oc.SourceFilter != nil && !oc.SourceFilter.ShouldPass(config, input) {
+		return false
+	}
+	if oc.InputDevice != "" {
+		return oc.InputDevice == input
+	}
+	return len(oc.InputFilter) == 0 || config.inputListed(oc.InputFilter, input)
 }
 
 // shouldRouteMessage checks if a message should be routed to a specific output
@@ -729,99 +2179,541 @@ func shouldRouteMessage(msg midi.Message, outputConfig *OutputConfig) bool {
 		}
 	}
 
+	// Message types
+	if outputConfig.MessageTypes != nil {
+		if !outputConfig.MessageTypes.ShouldPass(msg) {
+			return false
+		}
+	}
+
+	// Combined filters
+	if outputConfig.Filter != nil {
+		if !outputConfig.Filter.ShouldPass(msg) {
+			return false
+		}
+	}
+
+	// Active Sensing, undefined and system common messages
+	if outputConfig.SystemMessages != nil {
+		if !outputConfig.SystemMessages.ShouldPass(msg) {
+			return false
+		}
+	}
+
 	return true
 }
 
-func runMIDIRouter(drv *rtmididrv.Driver, config *Config, quiet bool) error {
+// configNeedsClock reports whether any output relies on incoming MIDI clock
+func configNeedsClock(config *Config) bool {
+	if config.Clock != nil && config.Clock.regenerates() {
+		return true
+	}
+	for _, output := range config.Outputs {
+		if output.Echo != nil && output.Echo.usesClock() {
+			return true
+		}
+		if output.NoteRepeat != nil {
+			return true
+		}
+	}
+	for _, lfo := range config.LFOs {
+		if lfo.Rate != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// synchronizedSender serializes sends so the listener and scheduler goroutines can share an output
+func synchronizedSender(send func(midi.Message) error) func(midi.Message) error {
+	var mu sync.Mutex
+	return func(msg midi.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return send(msg)
+	}
+}
+
+// runMIDIRouter routes messages until it is interrupted, the raw input ends, or done is closed
+func runMIDIRouter(drv midiDriver, config *Config, logging logOptions, done <-chan struct{}) error {
 	// Find the configured input device
 	ins, err := drv.Ins()
 	if err != nil {
 		return fmt.Errorf("failed to get MIDI inputs: %w", err)
 	}
 
-	var selectedInput drivers.In
-	for _, in := range ins {
-		if in.String() == config.InputDevice {
-			selectedInput = in
-			break
+	// Open ports under the configured client name instead of rtmidi's default
+	var client *rtmidiClient
+	if config.ClientName != "" {
+		client = &rtmidiClient{name: config.ClientName, api: driverAPI(drv)}
+	}
+
+	// One port for each input, named like the configured inputs
+	inputNames := config.inputNames()
+	selectedInputs := make([]drivers.In, len(inputNames))
+	var rawInput *rawFileIn
+	var fileInputs []*midiFileIn
+	if config.RawInput != nil {
+		rawInput = newRawFileIn(config.RawInput)
+		selectedInputs[0] = rawInput
+	} else {
+		for j, name := range inputNames {
+			// Inputs named after a MIDI file play it
+			if isMIDIFile(name) {
+				fileIn, err := newMIDIFileIn(name)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Playing %s (%s)\n", name, fileIn.Duration().Round(time.Second))
+				selectedInputs[j] = fileIn
+				fileInputs = append(fileInputs, fileIn)
+				continue
+			}
+
+			for _, in := range ins {
+				if in.String() == name {
+					selectedInputs[j] = in
+					break
+				}
+			}
+
+			if selectedInputs[j] == nil {
+				return fmt.Errorf("configured input device not found: %s", name)
+			}
+
+			if client != nil {
+				selectedInputs[j] = client.WrapIn(selectedInputs[j])
+				defer selectedInputs[j].Close()
+			} else if config.receivesSysEx() {
+				// Drop SysEx larger than the limit instead of overflowing the driver's buffer
+				selectedInputs[j] = sysExIn{selectedInputs[j]}
+			}
+		}
+	}
+
+	// Virtual ports are taken from the port pool when there is one, which keeps them open across
+	// restarts, and closed when the router stops otherwise
+	openVirtualIn := func(name string) (drivers.In, error) {
+		if client != nil {
+			return client.OpenVirtualIn(name)
+		}
+		return drv.OpenVirtualIn(name)
+	}
+	openVirtualOut := func(name string) (drivers.Out, error) {
+		if client != nil {
+			return client.OpenVirtualOut(name)
+		}
+		return drv.OpenVirtualOut(name)
+	}
+	if logging.Ports != nil {
+		logging.Ports.Begin()
+	}
+
+	// Virtual inputs let other programs on this machine send into the router
+	for _, name := range config.virtualInputNames() {
+		var virtualIn drivers.In
+		if logging.Ports != nil {
+			virtualIn, err = logging.Ports.In(config.ClientName, name, openVirtualIn)
+		} else if virtualIn, err = openVirtualIn(name); err == nil {
+			defer virtualIn.Close()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create virtual input %s: %w", name, err)
+		}
+		if client == nil && config.receivesSysEx() {
+			virtualIn = sysExIn{virtualIn}
+		}
+		fmt.Printf("Created virtual input: %s\n", name)
+		selectedInputs = append(selectedInputs, virtualIn)
+		inputNames = append(inputNames, name)
+	}
+
+	// A network input lets other machines send into the router over RTP-MIDI
+	if config.NetworkInput != nil {
+		networkIn := newRTPMIDIIn(config.NetworkInput)
+		if err := networkIn.Open(); err != nil {
+			return fmt.Errorf("failed to open network input: %w", err)
+		}
+		defer networkIn.Close()
+		fmt.Printf("Accepting RTP-MIDI session %s on port %d\n", networkIn.String(), config.NetworkInput.port())
+		selectedInputs = append(selectedInputs, networkIn)
+		inputNames = append(inputNames, networkIn.String())
+	}
+
+	if logging.WebSocket != nil {
+		logging.WebSocket.SetTokens(config.WebSocketTokens)
+	} else if len(config.WebSocketTokens) > 0 {
+		return fmt.Errorf("websocket_tokens needs the WebSocket bridge, start it with --websocket-port")
+	}
+
+	// Browser apps can send into the router through the WebSocket bridge
+	if config.WebSocketInput != "" {
+		if logging.WebSocket == nil {
+			return fmt.Errorf("websocket_input needs the WebSocket bridge, start it with --websocket-port")
+		}
+		selectedInputs = append(selectedInputs, &webSocketIn{bridge: logging.WebSocket, name: config.WebSocketInput})
+		inputNames = append(inputNames, config.WebSocketInput)
+	}
+
+	// The MQTT outputs and input share one connection to the broker
+	var mqtt *mqttClient
+	if config.MQTT != nil {
+		fmt.Printf("Connecting to MQTT broker %s...\n", config.MQTT.Broker)
+		mqtt = startMQTTClient(config.MQTT)
+		defer mqtt.Close()
+		if name := config.MQTT.inputName(); name != "" {
+			selectedInputs = append(selectedInputs, &mqttIn{client: mqtt, name: name})
+			inputNames = append(inputNames, name)
 		}
 	}
 
-	if selectedInput == nil {
-		return fmt.Errorf("configured input device not found: %s", config.InputDevice)
+	// Outputs with startup settings wait for their devices instead of failing
+	if ready, err := waitForStartupDevices(drv, config, done); !ready {
+		return err
+	}
+
+	// Existing output ports, for reuse_ports and the outputs that send to a device
+	existingOuts, err := drv.Outs()
+	if err != nil {
+		return fmt.Errorf("failed to get MIDI outputs: %w", err)
 	}
 
 	// Create virtual outputs
 	outputs := make([]drivers.Out, len(config.Outputs))
 	senders := make([]func(midi.Message) error, len(config.Outputs))
+	deviceSenders := make(map[string]func(midi.Message) error)
 
 	for i, outputConfig := range config.Outputs {
-		fullName := fmt.Sprintf("%s %s", config.OutputBase, outputConfig.Name)
-		virtualOut, err := drv.OpenVirtualOut(fullName)
-		if err != nil {
-			return fmt.Errorf("failed to create virtual output %d: %w", i+1, err)
+		fullName := config.outputPortName(i)
+
+		var virtualOut drivers.Out
+		pooled := false
+		if outputConfig.RawFile != nil {
+			fmt.Printf("Opening %s for output %d...\n", outputConfig.RawFile.Path, i+1)
+			rawOut := newRawFileOut(fullName, outputConfig.RawFile)
+			if err := rawOut.Open(); err != nil {
+				return fmt.Errorf("failed to open raw file for output %d: %w", i+1, err)
+			}
+			virtualOut = rawOut
+		} else if outputConfig.Network != nil {
+			fmt.Printf("Joining RTP-MIDI session at %s for output %d...\n", outputConfig.Network.Host, i+1)
+			networkOut := newRTPMIDIOut(fullName, outputConfig.Network)
+			if err := networkOut.Open(); err != nil {
+				return fmt.Errorf("failed to join RTP-MIDI session for output %d: %w", i+1, err)
+			}
+			virtualOut = networkOut
+		} else if outputConfig.Socket != nil {
+			fmt.Printf("Connecting to %s for output %d...\n", outputConfig.Socket.Address, i+1)
+			socket := newSocketOut(fullName, outputConfig.Socket)
+			if err := socket.Open(); err != nil {
+				return fmt.Errorf("failed to open socket for output %d: %w", i+1, err)
+			}
+			virtualOut = socket
+		} else if outputConfig.OSC != nil {
+			fmt.Printf("Sending OSC to %s for output %d\n", outputConfig.OSC.Address, i+1)
+			osc := newOSCOut(fullName, outputConfig.OSC)
+			if err := osc.Open(); err != nil {
+				return fmt.Errorf("failed to open OSC for output %d: %w", i+1, err)
+			}
+			virtualOut = osc
+		} else if outputConfig.MQTT != nil {
+			fmt.Printf("Publishing to MQTT topic %s for output %d\n", outputConfig.MQTT.Topic, i+1)
+			mqttOut := newMQTTOut(mqtt, fullName, outputConfig.Name, outputConfig.MQTT)
+			if err := mqttOut.Open(); err != nil {
+				return fmt.Errorf("failed to open MQTT for output %d: %w", i+1, err)
+			}
+			virtualOut = mqttOut
+		} else if outputConfig.Device != "" {
+			virtualOut, err = findOutputDevice(existingOuts, outputConfig.Device)
+			if err != nil {
+				return fmt.Errorf("output %d: %w", i+1, err)
+			}
+			fmt.Printf("Sending output %d to %s\n", i+1, virtualOut.String())
+		} else {
+			if config.ReusePorts {
+				for _, out := range existingOuts {
+					if portNameMatches(out.String(), fullName) {
+						virtualOut = out
+						break
+					}
+				}
+			}
+			if virtualOut != nil {
+				fmt.Printf("Reusing existing port for output %d: %s\n", i+1, virtualOut.String())
+			} else {
+				kept := false
+				if logging.Ports != nil {
+					virtualOut, kept, err = logging.Ports.Out(config.ClientName, fullName, openVirtualOut)
+					pooled = true
+				} else {
+					virtualOut, err = openVirtualOut(fullName)
+				}
+				if err != nil {
+					return fmt.Errorf("failed to create virtual output %d: %w", i+1, err)
+				}
+				if !kept && slices.ContainsFunc(ins, func(in drivers.In) bool { return portNameMatches(in.String(), fullName) }) {
+					fmt.Printf("Warning: a port named %q already exists, is another router running?\n", fullName)
+				}
+			}
 		}
-		defer virtualOut.Close()
+		// Outputs can share a device, which is opened once and sent to one message at a time
+		sender, shared := deviceSenders[virtualOut.String()]
+		if !shared || outputConfig.Device == "" {
+			if !pooled {
+				defer virtualOut.Close()
+			}
 
-		sender, err := midi.SendTo(virtualOut)
-		if err != nil {
-			return fmt.Errorf("failed to create sender for output %d: %w", i+1, err)
+			sender, err = midi.SendTo(virtualOut)
+			if err != nil {
+				return fmt.Errorf("failed to create sender for output %d: %w", i+1, err)
+			}
+			if outputConfig.Device != "" {
+				sender = synchronizedSender(sender)
+				deviceSenders[virtualOut.String()] = sender
+			}
+		}
+
+		if logging.RecordOutput != nil {
+			sender = recordingSender(logging.RecordOutput, outputConfig.Name, sender)
+		}
+		if logging.WebSocket != nil {
+			sender = logging.WebSocket.sender(outputConfig.Name, sender)
+		}
+		if logging.Control != nil {
+			sender = logging.Control.sender(outputConfig.Name, sender)
+		}
+
+		if outputConfig.SysExPacing != nil {
+			paced, stopPacing := pacedSender(sender, outputConfig.SysExPacing, fullName)
+			defer stopPacing()
+			sender = paced
 		}
 
 		outputs[i] = virtualOut
-		senders[i] = sender
+		senders[i] = synchronizedSender(sender)
+	}
+
+	// The debug output mirrors every input message, next to the outputs
+	var mirror func(midi.Message) error
+	if config.DebugOutput != "" {
+		fullName := config.debugPortName()
+		var debugOut drivers.Out
+		if logging.Ports != nil {
+			debugOut, _, err = logging.Ports.Out(config.ClientName, fullName, openVirtualOut)
+		} else if debugOut, err = openVirtualOut(fullName); err == nil {
+			defer debugOut.Close()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create debug output: %w", err)
+		}
+		send, err := midi.SendTo(debugOut)
+		if err != nil {
+			return fmt.Errorf("failed to create sender for debug output: %w", err)
+		}
+		mirror = synchronizedSender(send)
+		fmt.Printf("Mirroring every input message to %s\n", fullName)
+	}
+	if logging.Ports != nil {
+		logging.Ports.CloseUnused()
+	}
+
+	// Silence every output if the router crashes from here on
+	defer failsafe.Arm(outputs)()
+	defer recoverFailsafe()
+
+	// Routing starts once the outputs with startup settings are ready
+	if started, err := startOutputs(drv, config, senders, done); !started {
+		return err
+	}
+
+	r, err := newRouter(config, senders, logging)
+	if err != nil {
+		return err
+	}
+	if mirror != nil {
+		r.mirrorTo(mirror)
+	}
+	if err := r.Start(); err != nil {
+		r.Stop()
+		return err
+	}
+	defer r.Stop()
+	if logging.Control != nil {
+		logging.Control.attach(r.stats)
+	}
+	logging.Running.Set(r)
+	defer logging.Running.Set(nil)
+	if logging.LiveState != nil {
+		stopLiveState := make(chan struct{})
+		liveStateDone := make(chan struct{})
+		go func() {
+			logging.LiveState.Run(r, stopLiveState)
+			close(liveStateDone)
+		}()
+		defer func() {
+			close(stopLiveState)
+			<-liveStateDone
+		}()
 	}
 
 	configJSON, err := json.MarshalIndent(config, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal configuration: %w", err)
 	}
-	fmt.Printf("Running with configuration:\n%s\n", configJSON)
-	fmt.Println("Press Ctrl+C to stop...")
-
-	// Start routing
-	stop, err := midi.ListenTo(selectedInput, func(msg midi.Message, timestampms int32) {
-		anyRouted := false
+	if !logging.NoBanner {
+		fmt.Printf("Running with configuration:\n%s\n", configJSON)
+		fmt.Println("Press Ctrl+C to stop...")
+	}
 
-		for i, outputConfig := range config.Outputs {
-			if shouldRouteMessage(msg, &outputConfig) {
-				fullName := fmt.Sprintf("%s %s", config.OutputBase, outputConfig.Name)
+	if logging.Dashboard {
+		colors := make([]string, len(config.Outputs))
+		descriptions := make([]string, len(config.Outputs))
+		for i := range config.Outputs {
+			colors[i] = outputColor(&config.Outputs[i], i)
+			descriptions[i] = config.Outputs[i].Description
+		}
+		stopDashboard := make(chan struct{})
+		defer close(stopDashboard)
+		go runDashboard(r.stats, colors, descriptions, logging, stopDashboard)
+	} else if logging.Quiet && logging.StatsInterval > 0 {
+		stopStats := make(chan struct{})
+		defer close(stopStats)
+		go r.stats.Report(logging.StatsInterval, stopStats)
+	}
 
-				// Initialize transformation tracking for this output
-				outputTransform := &MessageTransformation{}
+	if config.readsHotkeys() {
+		tapHotkey := config.Clock != nil && config.Clock.TapTempo != nil && config.Clock.TapTempo.Hotkey
+		if tapHotkey {
+			fmt.Println("Press Enter to tap the tempo")
+		}
+		if config.hasMacroHotkeys() {
+			fmt.Println("Type a macro's hotkey and press Enter to fire it")
+		}
+		go func() {
+			scanner := bufio.NewScanner(os.Stdin)
+			for scanner.Scan() {
+				if macro := config.macroHotkey(strings.TrimSpace(scanner.Text())); macro != nil {
+					r.FireMacro(macro.Name)
+				} else if tapHotkey {
+					r.TapTempo()
+				}
+			}
+		}()
+	}
 
-				// Apply channel override if configured
-				msgToSend := applyChannelOverride(msg, outputConfig.OverrideChannel, outputTransform)
-				// Apply note transposition if configured
-				msgToSend = applyNoteTransposition(msgToSend, outputConfig.TransposeSemitones, outputTransform)
+	// Thru outputs receive every message
+	thru := configHasThru(config)
+	var listenOptions []midi.Option
+	if configNeedsClock(config) || thru {
+		// MIDI clock is filtered out by the driver unless requested
+		listenOptions = append(listenOptions, midi.UseTimeCode())
+	}
+	if configForwardsActiveSensing(config) || thru {
+		listenOptions = append(listenOptions, midi.UseActiveSense())
+	}
+	if config.receivesSysEx() {
+		listenOptions = append(listenOptions, midi.UseSysEx(), midi.SysExBufferSize(uint32(config.sysExMaxSize())))
+	}
 
-				err := senders[i](msgToSend)
-				if err != nil {
-					log.Printf("Error sending to %s: %v", fullName, err)
-				} else {
-					// Log successful route immediately with per-output transformations
-					logSuccessfulRoute(fullName, msg, outputTransform, quiet)
-					anyRouted = true
-				}
+	// MIDI ports give the thru outputs and the debug output their messages before parsing, so
+	// they get them byte for byte
+	if thru {
+		for j, in := range selectedInputs {
+			if !receivesRaw(in) {
+				continue
 			}
+			name := inputNames[j]
+			r.listenRaw(name)
+			selectedInputs[j] = thruIn{in, func(data []byte, ms int32) bool {
+				defer recoverFailsafe()
+				return r.HandleRawAt(name, data, ms)
+			}}
 		}
+	}
 
-		// Log dropped message if no outputs were successful
-		if !anyRouted {
-			logDroppedMessage(msg, quiet)
+	// Start routing, merging the messages of all inputs
+	var stops []func()
+	stop := func() {
+		for _, stopInput := range stops {
+			stopInput()
 		}
-	})
+	}
+	for j, in := range selectedInputs {
+		name := inputNames[j]
+		stopInput, err := midi.ListenTo(in, func(msg midi.Message, timestampms int32) {
+			defer recoverFailsafe()
+			r.HandleMessageAt(name, msg, timestampms)
+		}, listenOptions...)
+		if err != nil {
+			stop()
+			return fmt.Errorf("failed to start listening to %s: %w", name, err)
+		}
+		stops = append(stops, stopInput)
+	}
 
-	if err != nil {
-		return fmt.Errorf("failed to start listening: %w", err)
+	// A raw input that reaches its end stops the router, as do MIDI files once they have all
+	// played when there are no other inputs. A nil channel never does.
+	var inputDone <-chan struct{}
+	if rawInput != nil {
+		inputDone = rawInput.Done()
+	} else if len(fileInputs) > 0 && len(fileInputs) == len(inputNames) {
+		inputDone = allDone(fileInputs)
 	}
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-	<-sigChan
+	defer signal.Stop(sigChan)
+
+	// Controller state signals, not available on Windows
+	stateChan := make(chan os.Signal, 1)
+	if restoreSignal != nil {
+		signal.Notify(stateChan, restoreSignal, snapshotSignal)
+		defer signal.Stop(stateChan)
+	}
+
+	stopped := false
+wait:
+	for {
+		select {
+		case <-sigChan:
+			break wait
+		case <-done:
+			stopped = true
+			break wait
+		case <-inputDone:
+			if rawInput != nil {
+				fmt.Printf("Input %s ended\n", rawInput.String())
+			} else {
+				fmt.Println("Finished playing")
+			}
+			break wait
+		case sig := <-stateChan:
+			switch {
+			case sig == restoreSignal:
+				r.RestoreControllers()
+			case logging.StateFile == "":
+				log.Printf("Ignoring %v, no --state-file to save controller state to", sig)
+			default:
+				if err := sentControllers.Save(logging.StateFile); err != nil {
+					log.Printf("Warning: %v", err)
+				} else {
+					fmt.Printf("Saved controller state to %s\n", logging.StateFile)
+				}
+			}
+		}
+	}
 
 	fmt.Println("Shutting down...")
 	stop()
+	if stopped {
+		// The router is being restarted with a changed configuration, which wouldn't release
+		// the notes this one started
+		r.AllNotesOff()
+	}
+
+	if logging.Dashboard || (logging.Quiet && logging.StatsInterval > 0) {
+		fmt.Printf("[STATS] %s\n", r.stats.Summary())
+	}
 
 	return nil
 }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// mergeSource is a virtual input of a merger and the channel its messages are stamped with, 0 to
+// keep their channels
+type mergeSource struct {
+	name    string
+	channel uint8
+}
+
+// runMergeCommand writes the configuration of a merger, the reverse of a splitter: a virtual input
+// for each program sending in, all merged into one output device. Each input's messages can be
+// stamped with its own channel, so several programs can play the parts of one multitimbral synth.
+func runMergeCommand(args []string) error {
+	flags := flag.NewFlagSet("merge", flag.ExitOnError)
+	device := flags.String("output", "", "Output device the inputs are merged into")
+	base := flags.String("base", "MIDI Router", "Base name for the virtual inputs")
+	save := flags.String("save", "", "Save the configuration to this file instead of printing it")
+	flags.Parse(args)
+	if *device == "" || flags.NArg() == 0 {
+		return fmt.Errorf("usage: midirouter merge --output device [--base name] [--save file] name[=channel]...")
+	}
+
+	var sources []mergeSource
+	for _, arg := range flags.Args() {
+		source, err := parseMergeSource(arg, len(sources)+1)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, source)
+	}
+
+	config := mergeConfig(*base, *device, sources)
+	if err := validateConfigStructure(config); err != nil {
+		return err
+	}
+
+	if err := saveConfig(config, *save); err != nil {
+		return err
+	}
+	if *save != "" {
+		fmt.Printf("Configuration saved to %s\n", *save)
+	} else {
+		fmt.Println()
+	}
+	return nil
+}
+
+// parseMergeSource parses a name=channel argument. A name on its own keeps the channels of its
+// messages.
+func parseMergeSource(arg string, position int) (mergeSource, error) {
+	name, value, found := strings.Cut(arg, "=")
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return mergeSource{}, fmt.Errorf("input %d has no name", position)
+	}
+	if !found {
+		return mergeSource{name: name}, nil
+	}
+	channel, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || channel < 1 || channel > 16 {
+		return mergeSource{}, fmt.Errorf("input %s has invalid channel: %q (must be 1-16)", name, value)
+	}
+	return mergeSource{name: name, channel: uint8(channel)}, nil
+}
+
+// mergeConfig creates a virtual input for each source, named after the base, and an output to the
+// device for each one that only passes that input and overrides its channel
+func mergeConfig(base, device string, sources []mergeSource) *Config {
+	config := &Config{OutputBase: base}
+	for _, source := range sources {
+		input := fmt.Sprintf("%s %s", base, source.name)
+		config.VirtualInputs = append(config.VirtualInputs, input)
+		output := OutputConfig{
+			Name:        source.name,
+			Device:      device,
+			InputFilter: []string{input},
+		}
+		if source.channel != 0 {
+			channel := source.channel
+			output.OverrideChannel = &channel
+		}
+		config.Outputs = append(config.Outputs, output)
+	}
+	return config
+}
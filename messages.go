@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// MessageSpec describes a MIDI message to send, for settings that generate messages
+type MessageSpec struct {
+	Type    string `json:"type"`              // note_on, note_off, cc, program_change, start, stop or continue
+	Channel uint8  `json:"channel,omitempty"` // 1-16, for channel messages
+	Number  uint8  `json:"number,omitempty"`  // note, controller or program number, 0-127
+	Value   uint8  `json:"value,omitempty"`   // velocity or controller value, 0-127
+}
+
+// Validate checks the message settings
+func (ms *MessageSpec) Validate() error {
+	switch ms.Type {
+	case "note_on", "note_off", "cc", "program_change":
+		if ms.Channel < 1 || ms.Channel > 16 {
+			return fmt.Errorf("invalid %s channel: %d (must be 1-16)", ms.Type, ms.Channel)
+		}
+		if ms.Number > 127 || ms.Value > 127 {
+			return fmt.Errorf("invalid %s number or value (must be 0-127)", ms.Type)
+		}
+	case "start", "stop", "continue":
+	default:
+		return fmt.Errorf("invalid message type: %q", ms.Type)
+	}
+	return nil
+}
+
+// Message builds the MIDI message
+func (ms *MessageSpec) Message() midi.Message {
+	channel := ms.Channel - 1
+	switch ms.Type {
+	case "note_on":
+		return midi.NoteOn(channel, ms.Number, ms.Value)
+	case "note_off":
+		return midi.NoteOff(channel, ms.Number)
+	case "cc":
+		return midi.ControlChange(channel, ms.Number, ms.Value)
+	case "program_change":
+		return midi.ProgramChange(channel, ms.Number)
+	case "start":
+		return midi.Start()
+	case "stop":
+		return midi.Stop()
+	case "continue":
+		return midi.Continue()
+	}
+	return nil
+}
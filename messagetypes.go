@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// messageTypes lists the message_types names
+var messageTypes = []string{"note", "cc", "program_change", "pitch_bend", "aftertouch", "sysex", "realtime"}
+
+// MessageTypesFilter passes or blocks messages by their type, such as only notes to a sampler or
+// only controllers to a lighting rig
+type MessageTypesFilter struct {
+	Allow  []string `json:"allow,omitempty"`  // only pass these types, every type when empty
+	Deny   []string `json:"deny,omitempty"`   // never pass these types
+	Invert bool     `json:"invert,omitempty"` // pass the messages the lists block instead
+}
+
+// Validate checks the type names
+func (mtf *MessageTypesFilter) Validate() error {
+	if len(mtf.Allow) == 0 && len(mtf.Deny) == 0 {
+		return fmt.Errorf("needs allow or deny")
+	}
+	for _, name := range slices.Concat(mtf.Allow, mtf.Deny) {
+		if !slices.Contains(messageTypes, name) {
+			return fmt.Errorf("unknown message type: %q (must be one of %s)", name, strings.Join(messageTypes, ", "))
+		}
+	}
+	return nil
+}
+
+// ShouldPass tests if a message's type passes the filter. Messages of no listed type, such as
+// MIDI Time Code, only pass without an allow list.
+func (mtf *MessageTypesFilter) ShouldPass(msg midi.Message) bool {
+	kind := messageType(msg)
+	pass := !slices.Contains(mtf.Deny, kind) && (len(mtf.Allow) == 0 || slices.Contains(mtf.Allow, kind))
+	return pass != mtf.Invert
+}
+
+// String describes the filter, e.g. "only note, cc" or "no realtime"
+func (mtf *MessageTypesFilter) String() string {
+	var types []string
+	if len(mtf.Allow) > 0 {
+		types = append(types, "only "+strings.Join(mtf.Allow, ", "))
+	}
+	if len(mtf.Deny) > 0 {
+		types = append(types, "no "+strings.Join(mtf.Deny, ", "))
+	}
+	description := strings.Join(types, " and ")
+	if mtf.Invert {
+		return "not " + description
+	}
+	return description
+}
+
+// messageType returns the message_types name of a message, or an empty string for system common
+// and undefined messages
+func messageType(msg midi.Message) string {
+	if len(msg) == 0 {
+		return ""
+	}
+	switch status := msg[0]; {
+	case status >= 0xF8:
+		return "realtime"
+	case status == 0xF0:
+		return "sysex"
+	case status >= 0xF0:
+		return ""
+	}
+	switch msg[0] & 0xF0 {
+	case 0x80, 0x90:
+		return "note"
+	case 0xA0, 0xD0:
+		return "aftertouch"
+	case 0xB0:
+		return "cc"
+	case 0xC0:
+		return "program_change"
+	case 0xE0:
+		return "pitch_bend"
+	}
+	return ""
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/drivers"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// isMIDIFile reports whether an input name is a Standard MIDI File to play rather than a device
+func isMIDIFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".mid", ".midi":
+		return true
+	}
+	return false
+}
+
+// midiFileEvent is a message of a MIDI file at its time from the start of the file
+type midiFileEvent struct {
+	at  time.Duration
+	msg []byte
+}
+
+// midiFileIn is an input port that plays a Standard MIDI File, sending the messages of all its
+// tracks with the file's timing and tempo changes. The input ends when the file has been played.
+type midiFileIn struct {
+	path   string
+	events []midiFileEvent
+	done   chan struct{}
+}
+
+// newMIDIFileIn reads a MIDI file to play
+func newMIDIFileIn(path string) (*midiFileIn, error) {
+	file, err := smf.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read MIDI file %s: %w", path, err)
+	}
+	return &midiFileIn{path: path, events: midiFileEvents(file), done: make(chan struct{})}, nil
+}
+
+// midiFileEvents merges the messages of every track in time order, leaving out meta events
+func midiFileEvents(file *smf.SMF) []midiFileEvent {
+	type tickEvent struct {
+		ticks int64
+		msg   []byte
+	}
+	var merged []tickEvent
+	for _, track := range file.Tracks {
+		var ticks int64
+		for _, event := range track {
+			ticks += int64(event.Delta)
+			// gomidi doesn't count SysEx as playable
+			if event.Message.IsPlayable() || event.Message.Is(midi.SysExMsg) {
+				merged = append(merged, tickEvent{ticks, event.Message.Bytes()})
+			}
+		}
+	}
+	// Stable, so messages at the same tick keep their track order
+	sort.SliceStable(merged, func(a, b int) bool { return merged[a].ticks < merged[b].ticks })
+
+	events := make([]midiFileEvent, len(merged))
+	for i, event := range merged {
+		var at time.Duration
+		switch timeFormat := file.TimeFormat.(type) {
+		case smf.MetricTicks:
+			at = time.Duration(file.TimeAt(event.ticks)) * time.Microsecond
+		case smf.TimeCode:
+			ticksPerSecond := int64(timeFormat.FramesPerSecond) * int64(timeFormat.SubFrames)
+			if ticksPerSecond > 0 {
+				at = time.Duration(event.ticks) * time.Second / time.Duration(ticksPerSecond)
+			}
+		}
+		events[i] = midiFileEvent{at: at, msg: event.msg}
+	}
+	return events
+}
+
+func (i *midiFileIn) Open() error             { return nil }
+func (i *midiFileIn) Close() error            { return nil }
+func (i *midiFileIn) IsOpen() bool            { return true }
+func (i *midiFileIn) Number() int             { return -1 }
+func (i *midiFileIn) String() string          { return i.path }
+func (i *midiFileIn) Underlying() interface{} { return nil }
+
+// Done is closed when the file has been played
+func (i *midiFileIn) Done() <-chan struct{} {
+	return i.done
+}
+
+// Duration returns the time of the file's last message
+func (i *midiFileIn) Duration() time.Duration {
+	if len(i.events) == 0 {
+		return 0
+	}
+	return i.events[len(i.events)-1].at
+}
+
+// Listen plays the file in the background, passing each message to onMsg at its time
+func (i *midiFileIn) Listen(onMsg func(msg []byte, milliseconds int32), config drivers.ListenConfig) (func(), error) {
+	stop := make(chan struct{})
+	var once sync.Once
+
+	go func() {
+		defer close(i.done)
+		reader := newSysExReader(config, onMsg)
+		started := time.Now()
+		var last time.Duration
+		for _, event := range i.events {
+			if wait := time.Until(started.Add(event.at)); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-stop:
+					return
+				}
+			}
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			reader.EachMessage(event.msg, int32((event.at - last).Milliseconds()))
+			last = event.at
+		}
+	}()
+
+	return func() { once.Do(func() { close(stop) }) }, nil
+}
+
+// allDone returns a channel that is closed once every MIDI file input has been played
+func allDone(inputs []*midiFileIn) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		for _, input := range inputs {
+			<-input.Done()
+		}
+		close(done)
+	}()
+	return done
+}
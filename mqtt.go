@@ -0,0 +1,541 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/drivers"
+)
+
+// defaultMQTTClientID identifies the router to the broker when client_id isn't set
+const defaultMQTTClientID = "midirouter"
+
+// defaultMQTTInputName is the name of the MQTT input when input_name isn't set
+const defaultMQTTInputName = "MQTT"
+
+// mqttKeepAlive is how often the broker expects to hear from the router
+const mqttKeepAlive = 60 * time.Second
+
+// mqttReconnectInterval is how long the client waits between connection attempts
+const mqttReconnectInterval = time.Second
+
+// mqttMaxPacket is the largest packet accepted from the broker
+const mqttMaxPacket = 64 * 1024
+
+// MQTT packet types, in the high nibble of the first byte
+const (
+	mqttConnect    = 1
+	mqttConnAck    = 2
+	mqttPublish    = 3
+	mqttSubscribe  = 8
+	mqttSubAck     = 9
+	mqttPingReq    = 12
+	mqttDisconnect = 14
+)
+
+// MQTTConfig is the MQTT broker the mqtt outputs publish to, and the topic subscribed to as an
+// input, for home automation
+type MQTTConfig struct {
+	Broker     string `json:"broker"`                // host and port of the broker, e.g. "localhost:1883"
+	ClientID   string `json:"client_id,omitempty"`   // default "midirouter"
+	Username   string `json:"username,omitempty"`    // sent when the broker needs a login
+	Password   string `json:"password,omitempty"`    // sent with username
+	InputTopic string `json:"input_topic,omitempty"` // topic whose messages are routed as an input, wildcards allowed
+	InputName  string `json:"input_name,omitempty"`  // name of the input, default "MQTT"
+}
+
+// Validate checks the broker settings
+func (mc *MQTTConfig) Validate() error {
+	if _, _, err := net.SplitHostPort(mc.Broker); err != nil {
+		return fmt.Errorf("invalid broker: %w", err)
+	}
+	if mc.Password != "" && mc.Username == "" {
+		return fmt.Errorf("password needs a username")
+	}
+	if mc.InputName != "" && mc.InputTopic == "" {
+		return fmt.Errorf("input_name needs an input_topic")
+	}
+	return nil
+}
+
+func (mc *MQTTConfig) clientID() string {
+	if mc.ClientID == "" {
+		return defaultMQTTClientID
+	}
+	return mc.ClientID
+}
+
+// inputName returns the name of the MQTT input, empty when no topic is subscribed
+func (mc *MQTTConfig) inputName() string {
+	if mc.InputTopic == "" {
+		return ""
+	}
+	if mc.InputName == "" {
+		return defaultMQTTInputName
+	}
+	return mc.InputName
+}
+
+// MQTTOutputConfig publishes an output's messages to MQTT topics instead of a virtual port
+type MQTTOutputConfig struct {
+	Topic   string `json:"topic"`             // topic template, e.g. "home/midi/{type}/{channel}/{number}"
+	Payload string `json:"payload,omitempty"` // "value" (default) for the value as text, "json" for every field, "hex" for the message bytes
+}
+
+// Validate checks the topic template and payload format
+func (mc *MQTTOutputConfig) Validate() error {
+	if mc.Topic == "" {
+		return fmt.Errorf("topic is required")
+	}
+	if strings.ContainsAny(mc.Topic, "+#") {
+		return fmt.Errorf("invalid topic: %q (wildcards can't be published to)", mc.Topic)
+	}
+	switch mc.Payload {
+	case "", "value", "json", "hex":
+	default:
+		return fmt.Errorf("invalid payload: %q (must be value, json or hex)", mc.Payload)
+	}
+	return nil
+}
+
+// mqttFields are the parts of a MIDI message topics and payloads are built from
+type mqttFields struct {
+	Type    string `json:"type"`    // note, cc, pitchbend, aftertouch, polytouch or program
+	Channel int    `json:"channel"` // 1-based
+	Number  int    `json:"number"`  // note or controller number, 0 for other types
+	Value   int    `json:"value"`   // velocity (0 for note off), controller value, bend or pressure
+}
+
+// messageFields splits a message into its MQTT fields. Returns false for messages MQTT outputs
+// don't publish.
+func messageFields(msg midi.Message) (mqttFields, bool) {
+	var channel, key, velocity, controller, value uint8
+	var relative int16
+	var absolute uint16
+	switch {
+	case msg.GetNoteStart(&channel, &key, &velocity):
+		return mqttFields{"note", int(channel) + 1, int(key), int(velocity)}, true
+	case msg.GetNoteEnd(&channel, &key):
+		return mqttFields{"note", int(channel) + 1, int(key), 0}, true
+	case msg.GetControlChange(&channel, &controller, &value):
+		return mqttFields{"cc", int(channel) + 1, int(controller), int(value)}, true
+	case msg.GetPitchBend(&channel, &relative, &absolute):
+		return mqttFields{"pitchbend", int(channel) + 1, 0, int(relative)}, true
+	case msg.GetAfterTouch(&channel, &value):
+		return mqttFields{"aftertouch", int(channel) + 1, 0, int(value)}, true
+	case msg.GetPolyAfterTouch(&channel, &key, &value):
+		return mqttFields{"polytouch", int(channel) + 1, int(key), int(value)}, true
+	case msg.GetProgramChange(&channel, &value):
+		return mqttFields{"program", int(channel) + 1, 0, int(value)}, true
+	}
+	return mqttFields{}, false
+}
+
+// topicAndPayload builds the topic and payload a message is published with. {output}, {type},
+// {channel} and {number} in the topic template are replaced by the message's fields.
+func (mc *MQTTOutputConfig) topicAndPayload(output string, msg midi.Message) (string, []byte, bool) {
+	fields, ok := messageFields(msg)
+	if !ok {
+		return "", nil, false
+	}
+	topic := strings.NewReplacer(
+		"{output}", output,
+		"{type}", fields.Type,
+		"{channel}", strconv.Itoa(fields.Channel),
+		"{number}", strconv.Itoa(fields.Number),
+	).Replace(mc.Topic)
+
+	switch mc.Payload {
+	case "json":
+		payload, _ := json.Marshal(fields)
+		return topic, payload, true
+	case "hex":
+		return topic, []byte(fmt.Sprintf("% X", []byte(msg))), true
+	}
+	return topic, []byte(strconv.Itoa(fields.Value)), true
+}
+
+// mqttClient is a connection to the broker shared by the MQTT outputs and input. It connects in
+// the background and reconnects when the connection drops, and publishing fails while it's
+// disconnected.
+type mqttClient struct {
+	mu     sync.Mutex
+	config *MQTTConfig
+	conn   net.Conn
+	onMsg  func(msg []byte) // the MQTT input's listener, nil when not listening
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// startMQTTClient starts connecting to the broker in the background
+func startMQTTClient(config *MQTTConfig) *mqttClient {
+	c := &mqttClient{config: config, stop: make(chan struct{})}
+	c.wg.Add(1)
+	go c.run()
+	return c
+}
+
+// run keeps the client connected until it's closed
+func (c *mqttClient) run() {
+	defer c.wg.Done()
+	defer recoverFailsafe()
+	loggedFailure := false
+	for {
+		conn, reader, err := c.connect()
+		if err == nil {
+			log.Printf("Connected to MQTT broker %s", c.config.Broker)
+			loggedFailure = false
+			err = c.serve(conn, reader)
+			select {
+			case <-c.stop:
+				return
+			default:
+			}
+			log.Printf("Lost connection to MQTT broker %s, reconnecting: %v", c.config.Broker, err)
+		} else if !loggedFailure {
+			log.Printf("Failed to connect to MQTT broker %s, retrying: %v", c.config.Broker, err)
+			loggedFailure = true
+		}
+
+		select {
+		case <-time.After(mqttReconnectInterval):
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// connect opens a session with the broker and subscribes to the input topic
+func (c *mqttClient) connect() (net.Conn, *bufio.Reader, error) {
+	conn, err := net.DialTimeout("tcp", c.config.Broker, socketDialTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader := bufio.NewReader(conn)
+	conn.SetDeadline(time.Now().Add(socketDialTimeout))
+
+	// Clean session, so subscriptions don't outlive the connection
+	flags := byte(0x02)
+	if c.config.Username != "" {
+		flags |= 0x80
+	}
+	if c.config.Password != "" {
+		flags |= 0x40
+	}
+	body := appendMQTTString(nil, "MQTT")
+	body = append(body, 4, flags) // protocol level 3.1.1
+	body = binary.BigEndian.AppendUint16(body, uint16(mqttKeepAlive/time.Second))
+	body = appendMQTTString(body, c.config.clientID())
+	if c.config.Username != "" {
+		body = appendMQTTString(body, c.config.Username)
+	}
+	if c.config.Password != "" {
+		body = appendMQTTString(body, c.config.Password)
+	}
+	if _, err := conn.Write(mqttPacket(mqttConnect<<4, body)); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	packetType, ack, err := readMQTTPacket(reader)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if packetType>>4 != mqttConnAck || len(ack) < 2 {
+		conn.Close()
+		return nil, nil, errors.New("unexpected reply to connect")
+	}
+	if ack[1] != 0 {
+		conn.Close()
+		return nil, nil, fmt.Errorf("connection refused with code %d", ack[1])
+	}
+
+	if c.config.InputTopic != "" {
+		subscribe := binary.BigEndian.AppendUint16(nil, 1)
+		subscribe = appendMQTTString(subscribe, c.config.InputTopic)
+		subscribe = append(subscribe, 0) // QoS 0
+		if _, err := conn.Write(mqttPacket(mqttSubscribe<<4|0x02, subscribe)); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+	}
+	conn.SetDeadline(time.Time{})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-c.stop:
+		conn.Close()
+		return nil, nil, errors.New("client closed")
+	default:
+	}
+	c.conn = conn
+	return conn, reader, nil
+}
+
+// serve pings the broker and passes the messages published to the input topic to the input
+// until the connection drops or the client is closed
+func (c *mqttClient) serve(conn net.Conn, reader *bufio.Reader) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(mqttKeepAlive / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.mu.Lock()
+				conn.Write(mqttPacket(mqttPingReq<<4, nil))
+				c.mu.Unlock()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	defer func() {
+		c.mu.Lock()
+		c.conn = nil
+		c.mu.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(mqttKeepAlive * 3 / 2))
+		packetType, body, err := readMQTTPacket(reader)
+		if err != nil {
+			return err
+		}
+		switch packetType >> 4 {
+		case mqttPublish:
+			topic, payload, err := parseMQTTPublish(packetType, body)
+			if err != nil {
+				return err
+			}
+			c.receive(topic, payload)
+		case mqttSubAck:
+			if len(body) >= 3 && body[2] == 0x80 {
+				log.Printf("MQTT broker refused the subscription to %s", c.config.InputTopic)
+			}
+		}
+	}
+}
+
+// receive passes a message published to the input topic to the input. The payload holds the
+// message bytes in hex, e.g. "90 3C 64".
+func (c *mqttClient) receive(topic string, payload []byte) {
+	c.mu.Lock()
+	onMsg := c.onMsg
+	c.mu.Unlock()
+	if onMsg == nil {
+		return
+	}
+	data, err := (&recordedMessage{Data: string(payload)}).bytes()
+	if err != nil {
+		log.Printf("Ignoring invalid MQTT message on %s: %v", topic, err)
+		return
+	}
+	onMsg(data)
+}
+
+// Publish sends a payload to a topic with QoS 0
+func (c *mqttClient) Publish(topic string, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return fmt.Errorf("not connected to MQTT broker %s", c.config.Broker)
+	}
+	body := appendMQTTString(nil, topic)
+	if _, err := c.conn.Write(mqttPacket(mqttPublish<<4, append(body, payload...))); err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", topic, err)
+	}
+	return nil
+}
+
+// Close disconnects from the broker and stops reconnecting
+func (c *mqttClient) Close() error {
+	close(c.stop)
+	c.mu.Lock()
+	if c.conn != nil {
+		c.conn.Write(mqttPacket(mqttDisconnect<<4, nil))
+		c.conn.Close()
+	}
+	c.mu.Unlock()
+	c.wg.Wait()
+	return nil
+}
+
+// mqttPacket encodes a packet with its remaining length
+func mqttPacket(header byte, body []byte) []byte {
+	packet := []byte{header}
+	length := len(body)
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		packet = append(packet, digit)
+		if length == 0 {
+			break
+		}
+	}
+	return append(packet, body...)
+}
+
+// appendMQTTString appends a length-prefixed string
+func appendMQTTString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// readMQTTPacket reads a packet from the broker, returning its first byte and its body
+func readMQTTPacket(reader *bufio.Reader) (byte, []byte, error) {
+	header, err := reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		digit, err := reader.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(digit&0x7F) * multiplier
+		multiplier *= 128
+		if digit&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, nil, errors.New("invalid MQTT packet length")
+		}
+	}
+	if length > mqttMaxPacket {
+		return 0, nil, errors.New("MQTT packet too large")
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+// parseMQTTPublish splits a publish packet into its topic and payload
+func parseMQTTPublish(header byte, body []byte) (string, []byte, error) {
+	if len(body) < 2 {
+		return "", nil, errors.New("short MQTT publish")
+	}
+	topicLength := int(binary.BigEndian.Uint16(body))
+	if len(body) < 2+topicLength {
+		return "", nil, errors.New("short MQTT publish")
+	}
+	topic := string(body[2 : 2+topicLength])
+	payload := body[2+topicLength:]
+	// QoS 1 and 2 messages carry a packet identifier
+	if header&0x06 != 0 {
+		if len(payload) < 2 {
+			return "", nil, errors.New("short MQTT publish")
+		}
+		payload = payload[2:]
+	}
+	return topic, payload, nil
+}
+
+// mqttOut is an output port that publishes its messages to the broker
+type mqttOut struct {
+	mu     sync.Mutex
+	client *mqttClient
+	config *MQTTOutputConfig
+	name   string // output name, for {output} in topics
+	port   string
+	open   bool
+}
+
+func newMQTTOut(client *mqttClient, port, name string, config *MQTTOutputConfig) *mqttOut {
+	return &mqttOut{client: client, config: config, name: name, port: port}
+}
+
+func (o *mqttOut) Number() int             { return -1 }
+func (o *mqttOut) String() string          { return o.port }
+func (o *mqttOut) Underlying() interface{} { return o.client }
+
+// IsOpen returns whether the port is open, connected or not
+func (o *mqttOut) IsOpen() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.open
+}
+
+// Open opens the port. The broker connection is shared and managed by the client.
+func (o *mqttOut) Open() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.open = true
+	return nil
+}
+
+// Close closes the port
+func (o *mqttOut) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.open = false
+	return nil
+}
+
+// Send publishes a message. Messages without an MQTT form, such as clock and SysEx, are skipped.
+func (o *mqttOut) Send(data []byte) error {
+	if !o.IsOpen() {
+		return drivers.ErrPortClosed
+	}
+	topic, payload, ok := o.config.topicAndPayload(o.name, midi.Message(data))
+	if !ok {
+		return nil
+	}
+	return o.client.Publish(topic, payload)
+}
+
+// mqttIn is the input port for the messages published to the input topic
+type mqttIn struct {
+	client *mqttClient
+	name   string
+}
+
+func (i *mqttIn) Open() error             { return nil }
+func (i *mqttIn) Close() error            { return nil }
+func (i *mqttIn) IsOpen() bool            { return true }
+func (i *mqttIn) Number() int             { return -1 }
+func (i *mqttIn) String() string          { return i.name }
+func (i *mqttIn) Underlying() interface{} { return i.client }
+
+// Listen passes the messages published to the input topic to onMsg until stopped
+func (i *mqttIn) Listen(onMsg func(msg []byte, milliseconds int32), config drivers.ListenConfig) (func(), error) {
+	reader := newSysExReader(config, onMsg)
+	i.client.mu.Lock()
+	i.client.onMsg = func(msg []byte) { reader.EachMessage(msg, 0) }
+	i.client.mu.Unlock()
+	return func() {
+		i.client.mu.Lock()
+		defer i.client.mu.Unlock()
+		i.client.onMsg = nil
+	}, nil
+}
+
+// mqttInputName returns the name of the MQTT input, empty when there is none
+func (c *Config) mqttInputName() string {
+	if c.MQTT == nil {
+		return ""
+	}
+	return c.MQTT.inputName()
+}
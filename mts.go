@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// MTSConfig sends a MIDI Tuning Standard SysEx dump of a tuning file to the output when the router starts
+type MTSConfig struct {
+	File          string `json:"file"`                     // Scala .scl or AnaMark .tun file
+	ReferenceNote *uint8 `json:"reference_note,omitempty"` // note the .scl scale starts on, default 60
+	Mode          string `json:"mode,omitempty"`           // "bulk" (default) or "realtime"
+	DeviceID      *uint8 `json:"device_id,omitempty"`      // 0-127, default 127 (all devices)
+	Program       uint8  `json:"program,omitempty"`        // tuning program 0-127
+	Name          string `json:"name,omitempty"`           // bulk dump name, up to 16 ASCII characters
+}
+
+// Validate checks the MTS settings and that the tuning file can be loaded
+func (mc *MTSConfig) Validate() error {
+	switch mc.Mode {
+	case "", "bulk", "realtime":
+	default:
+		return fmt.Errorf("invalid mts mode: %q (must be bulk or realtime)", mc.Mode)
+	}
+	if mc.DeviceID != nil && *mc.DeviceID > 127 {
+		return fmt.Errorf("invalid device id: %d (must be 0-127)", *mc.DeviceID)
+	}
+	if mc.Program > 127 {
+		return fmt.Errorf("invalid tuning program: %d (must be 0-127)", mc.Program)
+	}
+	if len(mc.Name) > 16 {
+		return fmt.Errorf("tuning name %q is longer than 16 characters", mc.Name)
+	}
+	_, err := mc.messages()
+	return err
+}
+
+// messages loads the tuning file and builds the SysEx messages for the configured mode
+func (mc *MTSConfig) messages() ([]midi.Message, error) {
+	referenceNote := uint8(60)
+	if mc.ReferenceNote != nil {
+		referenceNote = *mc.ReferenceNote
+	}
+	table, err := loadTuningFile(mc.File, referenceNote)
+	if err != nil {
+		return nil, err
+	}
+
+	deviceID := uint8(0x7F)
+	if mc.DeviceID != nil {
+		deviceID = *mc.DeviceID
+	}
+
+	if mc.Mode == "realtime" {
+		return mtsSingleNoteTuning(table, deviceID, mc.Program), nil
+	}
+
+	name := mc.Name
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(mc.File), filepath.Ext(mc.File))
+	}
+	return []midi.Message{mtsBulkDump(table, deviceID, mc.Program, name)}, nil
+}
+
+// mtsFrequency encodes a pitch in cents above MIDI note 0 as the 3 byte MTS frequency word:
+// the semitone below the pitch followed by a 14 bit fraction of a semitone
+func mtsFrequency(cents float64) [3]byte {
+	semitone := math.Floor(cents / 100)
+	fraction := math.Round((cents - semitone*100) / 100 * 16384)
+	if fraction >= 16384 {
+		semitone++
+		fraction = 0
+	}
+
+	switch {
+	case semitone < 0:
+		return [3]byte{0, 0, 0}
+	case semitone > 127 || (semitone == 127 && fraction > 16382):
+		// 7F 7F 7F is reserved for "no change"
+		return [3]byte{0x7F, 0x7F, 0x7E}
+	}
+
+	value := uint16(fraction)
+	return [3]byte{byte(semitone), byte(value>>7) & 0x7F, byte(value) & 0x7F}
+}
+
+// mtsBulkDump builds a non-real-time bulk tuning dump covering all 128 notes
+func mtsBulkDump(table *tuningTable, deviceID, program uint8, name string) midi.Message {
+	data := []byte{0x7E, deviceID, 0x08, 0x01, program}
+
+	// The name is exactly 16 ASCII characters, padded with spaces
+	nameBytes := []byte(fmt.Sprintf("%-16.16s", name))
+	for i := range nameBytes {
+		nameBytes[i] &= 0x7F
+	}
+	data = append(data, nameBytes...)
+
+	for note := range table {
+		frequency := mtsFrequency(table[note])
+		data = append(data, frequency[:]...)
+	}
+
+	// Checksum is the XOR of every byte after F0 and before the checksum
+	var checksum byte
+	for _, b := range data {
+		checksum ^= b
+	}
+	data = append(data, checksum&0x7F)
+
+	return midi.SysEx(data)
+}
+
+// mtsSingleNoteTuning builds real-time single note tuning changes for all 128 notes.
+// A message can carry at most 127 notes, so the table is split over two messages.
+func mtsSingleNoteTuning(table *tuningTable, deviceID, program uint8) []midi.Message {
+	var messages []midi.Message
+	for start := 0; start < len(table); start += 127 {
+		end := start + 127
+		if end > len(table) {
+			end = len(table)
+		}
+
+		data := []byte{0x7F, deviceID, 0x08, 0x02, program, byte(end - start)}
+		for note := start; note < end; note++ {
+			frequency := mtsFrequency(table[note])
+			data = append(data, byte(note))
+			data = append(data, frequency[:]...)
+		}
+		messages = append(messages, midi.SysEx(data))
+	}
+	return messages
+}
+
+// sendMTS sends the configured tuning to an output
+func sendMTS(config *MTSConfig, send func(midi.Message) error) error {
+	messages, err := config.messages()
+	if err != nil {
+		return err
+	}
+	for _, msg := range messages {
+		if err := send(msg); err != nil {
+			return fmt.Errorf("failed to send tuning: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// multitimbralDestination is an output of a multitimbral rig and the channel its synth or part
+// listens on
+type multitimbralDestination struct {
+	name    string
+	channel uint8
+}
+
+// runMultitimbralCommand writes the configuration of a multitimbral rig in one step. Each output
+// takes one input channel, 1 to N in order, and sends on the channel its destination listens on.
+// Destinations are given as name=channel arguments, or asked for when there are none.
+func runMultitimbralCommand(args []string) error {
+	flags := flag.NewFlagSet("multitimbral", flag.ExitOnError)
+	input := flags.String("input", "", "Input device, picked from the connected devices when empty")
+	base := flags.String("base", "MIDI Router", "Base name for the outputs")
+	save := flags.String("save", "", "Save the configuration to this file instead of printing it")
+	flags.Parse(args)
+
+	var destinations []multitimbralDestination
+	for _, arg := range flags.Args() {
+		destination, err := parseMultitimbralDestination(arg, len(destinations)+1)
+		if err != nil {
+			return err
+		}
+		destinations = append(destinations, destination)
+	}
+	if len(destinations) == 0 {
+		var err error
+		if destinations, err = askMultitimbralDestinations(); err != nil {
+			return err
+		}
+	}
+
+	config, err := multitimbralConfig(*base, destinations)
+	if err != nil {
+		return err
+	}
+	config.InputDevice = *input
+	if config.InputDevice == "" {
+		drv, err := openDriver("")
+		if err != nil {
+			return err
+		}
+		defer drv.Close()
+		in, err := selectInputDevice(drv)
+		if err != nil {
+			return err
+		}
+		config.InputDevice = in.String()
+	}
+	if err := validateConfigStructure(config); err != nil {
+		return err
+	}
+
+	if err := saveConfig(config, *save); err != nil {
+		return err
+	}
+	if *save != "" {
+		fmt.Printf("Configuration saved to %s\n", *save)
+	} else {
+		fmt.Println()
+	}
+	return nil
+}
+
+// parseMultitimbralDestination parses a name=channel argument. A name on its own keeps the input
+// channel of its position.
+func parseMultitimbralDestination(arg string, position int) (multitimbralDestination, error) {
+	name, value, found := strings.Cut(arg, "=")
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return multitimbralDestination{}, fmt.Errorf("destination %d has no name", position)
+	}
+	if !found {
+		return multitimbralDestination{name: name, channel: uint8(position)}, nil
+	}
+	channel, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || channel < 1 || channel > 16 {
+		return multitimbralDestination{}, fmt.Errorf("destination %s has invalid channel: %q (must be 1-16)", name, value)
+	}
+	return multitimbralDestination{name: name, channel: uint8(channel)}, nil
+}
+
+// askMultitimbralDestinations asks for the outputs of the rig and their channels, until an empty
+// name is entered
+func askMultitimbralDestinations() ([]multitimbralDestination, error) {
+	reader := bufio.NewReader(os.Stdin)
+	var destinations []multitimbralDestination
+	for len(destinations) < 16 {
+		position := len(destinations) + 1
+		fmt.Printf("Name of the output for input channel %d (empty to finish): ", position)
+		line, err := reader.ReadString('\n')
+		name := strings.TrimSpace(line)
+		if name == "" {
+			if err != nil && len(destinations) == 0 {
+				return nil, fmt.Errorf("failed to read input: %w", err)
+			}
+			break
+		}
+		fmt.Printf("Channel %s listens on (default: %d): ", name, position)
+		line, err = reader.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("failed to read input: %w", err)
+		}
+		arg := name
+		if value := strings.TrimSpace(line); value != "" {
+			arg += "=" + value
+		}
+		destination, err := parseMultitimbralDestination(arg, position)
+		if err != nil {
+			return nil, err
+		}
+		destinations = append(destinations, destination)
+	}
+	return destinations, nil
+}
+
+// multitimbralConfig maps input channels 1 to N to the destinations in order: each output passes
+// one input channel and overrides it with its destination's channel when they differ
+func multitimbralConfig(base string, destinations []multitimbralDestination) (*Config, error) {
+	if len(destinations) == 0 {
+		return nil, fmt.Errorf("no destinations given")
+	}
+	if len(destinations) > 16 {
+		return nil, fmt.Errorf("too many destinations: %d (at most one per channel, 16)", len(destinations))
+	}
+	config := &Config{OutputBase: base}
+	for i, destination := range destinations {
+		output := OutputConfig{
+			Name:          destination.name,
+			ChannelFilter: &ChannelFilter{Channel: uint8(i + 1)},
+		}
+		if destination.channel != uint8(i+1) {
+			channel := destination.channel
+			output.OverrideChannel = &channel
+		}
+		config.Outputs = append(config.Outputs, output)
+	}
+	return config, nil
+}
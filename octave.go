@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// defaultOctaveRange is how many octaves the shift controls can move either way when range is
+// not set
+const defaultOctaveRange = 4
+
+// OctaveShiftConfig shifts an output's notes by octaves, with controls that change the shift
+// while playing, like the octave buttons of a keyboard
+type OctaveShiftConfig struct {
+	Octaves int            `json:"octaves,omitempty"` // shift at startup, within the range
+	Up      *TriggerConfig `json:"up,omitempty"`      // control that raises the shift by an octave
+	Down    *TriggerConfig `json:"down,omitempty"`    // control that lowers the shift by an octave
+	Range   int            `json:"range,omitempty"`   // 1-10, largest shift either way, default 4
+}
+
+// Validate checks the octave shift settings
+func (osc *OctaveShiftConfig) Validate() error {
+	if osc.Range < 0 || osc.Range > 10 {
+		return fmt.Errorf("invalid range: %d (must be 1-10)", osc.Range)
+	}
+	if limit := osc.limit(); osc.Octaves < -limit || osc.Octaves > limit {
+		return fmt.Errorf("invalid octaves: %d (must be -%d to %d)", osc.Octaves, limit, limit)
+	}
+	for _, trigger := range []*TriggerConfig{osc.Up, osc.Down} {
+		if trigger == nil {
+			continue
+		}
+		if err := trigger.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (osc *OctaveShiftConfig) limit() int {
+	if osc.Range == 0 {
+		return defaultOctaveRange
+	}
+	return osc.Range
+}
+
+// octaveShiftState holds the current shift of an output. Note ends use the shift their note
+// started with, so changing the shift while notes are held leaves no notes hanging.
+type octaveShiftState struct {
+	config  *OctaveShiftConfig
+	octaves int
+	sent    map[noteKey]uint8 // note sent for each held key
+}
+
+func newOctaveShiftState(config *OctaveShiftConfig) *octaveShiftState {
+	return &octaveShiftState{
+		config:  config,
+		octaves: config.Octaves,
+		sent:    make(map[noteKey]uint8),
+	}
+}
+
+// Shift moves the shift by a number of octaves within the range. Returns whether it changed.
+func (oss *octaveShiftState) Shift(octaves int) bool {
+	limit := oss.config.limit()
+	shifted := max(-limit, min(limit, oss.octaves+octaves))
+	if shifted == oss.octaves {
+		return false
+	}
+	oss.octaves = shifted
+	return true
+}
+
+// applyOctaveShift moves notes by the output's current octave shift if configured. Notes that
+// would leave the MIDI note range are sent unshifted.
+func applyOctaveShift(msg midi.Message, shift *octaveShiftState, transform *MessageTransformation) midi.Message {
+	if shift == nil {
+		return msg
+	}
+
+	var channel, key, velocity uint8
+	switch {
+	case msg.GetNoteStart(&channel, &key, &velocity):
+		note := int(key) + shift.octaves*12
+		if note < 0 || note > 127 {
+			note = int(key)
+		}
+		shift.sent[noteKey{channel, key}] = uint8(note)
+		if note == int(key) {
+			return msg
+		}
+		recordNoteChange(key, uint8(note), transform)
+		return midi.NoteOn(channel, uint8(note), velocity)
+	case msg.GetNoteEnd(&channel, &key):
+		k := noteKey{channel, key}
+		note, held := shift.sent[k]
+		delete(shift.sent, k)
+		if !held || note == key {
+			return msg
+		}
+		recordNoteChange(key, note, transform)
+		return midi.NoteOffVelocity(channel, note, msg[2])
+	}
+	return msg
+}
+
+// recordNoteChange records a changed note for logging, keeping the note from before earlier
+// changes
+func recordNoteChange(from, to uint8, transform *MessageTransformation) {
+	if transform.OriginalNote == nil {
+		transform.OriginalNote = &from
+	}
+	transform.TransformedNote = &to
+}
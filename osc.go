@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/drivers"
+)
+
+// defaultOSCAddresses is the OSC address of each message type, before the prefix
+var defaultOSCAddresses = map[string]string{
+	"note":       "/note",
+	"cc":         "/cc/{number}",
+	"pitchbend":  "/pitchbend",
+	"aftertouch": "/aftertouch",
+	"polytouch":  "/polytouch",
+	"program":    "/program",
+}
+
+// OSCConfig sends an output's messages as OSC messages over UDP, for visuals and other software
+// without MIDI input
+type OSCConfig struct {
+	Address   string            `json:"address"`             // host and port of the OSC receiver, e.g. "127.0.0.1:9000"
+	Prefix    string            `json:"prefix,omitempty"`    // added before every OSC address, e.g. "/bass"
+	Addresses map[string]string `json:"addresses,omitempty"` // OSC address templates by message type, replacing the defaults
+}
+
+// Validate checks the OSC settings
+func (oc *OSCConfig) Validate() error {
+	if _, _, err := net.SplitHostPort(oc.Address); err != nil {
+		return fmt.Errorf("invalid address: %w", err)
+	}
+	if oc.Prefix != "" && !strings.HasPrefix(oc.Prefix, "/") {
+		return fmt.Errorf("invalid prefix: %q (must start with /)", oc.Prefix)
+	}
+	for messageType, template := range oc.Addresses {
+		if _, ok := defaultOSCAddresses[messageType]; !ok {
+			return fmt.Errorf("unknown message type in addresses: %q", messageType)
+		}
+		if !strings.HasPrefix(template, "/") {
+			return fmt.Errorf("invalid address for %s: %q (must start with /)", messageType, template)
+		}
+	}
+	return nil
+}
+
+// address fills in the OSC address template of a message type. {channel} is replaced by the
+// 1-based channel and {number} by the note or controller number.
+func (oc *OSCConfig) address(messageType string, channel uint8, number uint8) string {
+	template, ok := oc.Addresses[messageType]
+	if !ok {
+		template = defaultOSCAddresses[messageType]
+	}
+	address := strings.NewReplacer(
+		"{channel}", strconv.Itoa(int(channel)+1),
+		"{number}", strconv.Itoa(int(number)),
+	).Replace(template)
+	return oc.Prefix + address
+}
+
+// oscMessage converts a MIDI message to an OSC address and integer arguments. The first argument
+// is the 1-based channel. Returns false for messages OSC outputs don't send.
+func (oc *OSCConfig) oscMessage(msg midi.Message) (string, []int32, bool) {
+	var channel, key, velocity, controller, value uint8
+	var relative int16
+	var absolute uint16
+	switch {
+	case msg.GetNoteStart(&channel, &key, &velocity):
+		return oc.address("note", channel, key), []int32{int32(channel) + 1, int32(key), int32(velocity)}, true
+	case msg.GetNoteEnd(&channel, &key):
+		return oc.address("note", channel, key), []int32{int32(channel) + 1, int32(key), 0}, true
+	case msg.GetControlChange(&channel, &controller, &value):
+		return oc.address("cc", channel, controller), []int32{int32(channel) + 1, int32(value)}, true
+	case msg.GetPitchBend(&channel, &relative, &absolute):
+		return oc.address("pitchbend", channel, 0), []int32{int32(channel) + 1, int32(relative)}, true
+	case msg.GetAfterTouch(&channel, &value):
+		return oc.address("aftertouch", channel, 0), []int32{int32(channel) + 1, int32(value)}, true
+	case msg.GetPolyAfterTouch(&channel, &key, &value):
+		return oc.address("polytouch", channel, key), []int32{int32(channel) + 1, int32(key), int32(value)}, true
+	case msg.GetProgramChange(&channel, &value):
+		return oc.address("program", channel, 0), []int32{int32(channel) + 1, int32(value)}, true
+	}
+	return "", nil, false
+}
+
+// encodeOSC encodes an OSC message with integer arguments
+func encodeOSC(address string, args []int32) []byte {
+	padded := func(b []byte, s string) []byte {
+		b = append(b, s...)
+		// Null terminated and padded to a multiple of 4 bytes
+		return append(b, make([]byte, 4-len(s)%4)...)
+	}
+	b := padded(nil, address)
+	b = padded(b, ","+strings.Repeat("i", len(args)))
+	for _, arg := range args {
+		b = binary.BigEndian.AppendUint32(b, uint32(arg))
+	}
+	return b
+}
+
+// oscOut is an output port that sends OSC messages over UDP
+type oscOut struct {
+	mu     sync.Mutex
+	config *OSCConfig
+	name   string
+	conn   net.Conn
+}
+
+func newOSCOut(name string, config *OSCConfig) *oscOut {
+	return &oscOut{config: config, name: name}
+}
+
+func (o *oscOut) Number() int             { return -1 }
+func (o *oscOut) String() string          { return o.name }
+func (o *oscOut) Underlying() interface{} { return o.conn }
+
+// IsOpen returns whether the socket is open
+func (o *oscOut) IsOpen() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.conn != nil
+}
+
+// Open opens the UDP socket to the receiver
+func (o *oscOut) Open() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.conn != nil {
+		return nil
+	}
+	conn, err := net.Dial("udp", o.config.Address)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", o.config.Address, err)
+	}
+	o.conn = conn
+	return nil
+}
+
+// Close closes the socket
+func (o *oscOut) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.conn == nil {
+		return nil
+	}
+	err := o.conn.Close()
+	o.conn = nil
+	return err
+}
+
+// Send converts a message to OSC and sends it. Messages without an OSC form, such as clock and
+// SysEx, are skipped.
+func (o *oscOut) Send(data []byte) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.conn == nil {
+		return drivers.ErrPortClosed
+	}
+
+	address, args, ok := o.config.oscMessage(midi.Message(data))
+	if !ok {
+		return nil
+	}
+	if _, err := o.conn.Write(encodeOSC(address, args)); err != nil {
+		return fmt.Errorf("failed to send to %s: %w", o.config.Address, err)
+	}
+	return nil
+}
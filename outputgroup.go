@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// OutputGroupConfig holds output settings shared by several outputs. Outputs join a group with
+// their group option, and their own settings override the group's. A group is also a layer that
+// is switched on and off as a unit, and its name can be used wherever outputs are listed by name.
+type OutputGroupConfig struct {
+	Name     string          `json:"name"`
+	Settings json.RawMessage `json:"settings,omitempty"` // any output options except name and group
+	Toggle   *TriggerConfig  `json:"toggle,omitempty"`   // input note or controller that switches the group's outputs off and on
+}
+
+// Validate checks the group settings that aren't applied when the configuration is loaded
+func (gc *OutputGroupConfig) Validate(outputs []OutputConfig) error {
+	if findOutputIndex(outputs, gc.Name) >= 0 {
+		return fmt.Errorf("name %q is also an output's", gc.Name)
+	}
+	if gc.Toggle != nil {
+		if err := gc.Toggle.Validate(); err != nil {
+			return fmt.Errorf("invalid toggle: %w", err)
+		}
+	}
+	return nil
+}
+
+// groupOutputs returns the outputs that belong to a group
+func (c *Config) groupOutputs(name string) []int {
+	var members []int
+	for i := range c.Outputs {
+		if c.Outputs[i].Group == name {
+			members = append(members, i)
+		}
+	}
+	return members
+}
+
+// sendsTo reports whether a list of output and group names includes an output, all outputs when
+// the list is empty
+func sendsTo(names []string, output *OutputConfig) bool {
+	return len(names) == 0 || slices.Contains(names, output.Name) || (output.Group != "" && slices.Contains(names, output.Group))
+}
+
+// hasOutputOrGroup reports whether a name is an output's, or a group's that has outputs
+func hasOutputOrGroup(outputs []OutputConfig, name string) bool {
+	for i := range outputs {
+		if outputs[i].Name == name || outputs[i].Group == name {
+			return true
+		}
+	}
+	return false
+}
+
+// handleGroupToggles switches the outputs of the groups whose toggle is pressed off, or back on
+// when they are all off. Returns false if msg is not a group toggle.
+func (r *router) handleGroupToggles(msg midi.Message) bool {
+	matchedAny := false
+	for _, group := range r.config.OutputGroups {
+		if group.Toggle == nil {
+			continue
+		}
+		matched, fired := group.Toggle.Match(msg)
+		if !matched {
+			continue
+		}
+		matchedAny = true
+		if !fired {
+			continue
+		}
+		members := r.config.groupOutputs(group.Name)
+		enabled := !slices.ContainsFunc(members, func(i int) bool { return r.enabled[i].Load() })
+		for _, i := range members {
+			r.setOutputEnabledLocked(i, enabled)
+		}
+		state := "off"
+		if enabled {
+			state = "on"
+		}
+		fmt.Printf("Group %s: %s\n", group.Name, state)
+	}
+	return matchedAny
+}
+
+// inheritedSettings returns the settings an output gets from its group and pipeline, before its
+// own options are applied
+func inheritedSettings(config *Config, output *OutputConfig) (*OutputConfig, error) {
+	var inherited OutputConfig
+	if output.Group != "" {
+		i := slices.IndexFunc(config.OutputGroups, func(group OutputGroupConfig) bool { return group.Name == output.Group })
+		if i >= 0 && len(config.OutputGroups[i].Settings) > 0 {
+			if err := layerOutput(&inherited, config.OutputGroups[i].Settings); err != nil {
+				return nil, fmt.Errorf("output group %s has invalid settings: %w", output.Group, err)
+			}
+		}
+	}
+	pipelineName := output.Pipeline
+	if pipelineName == "" {
+		pipelineName = inherited.Pipeline
+	}
+	if pipelineName != "" {
+		i := slices.IndexFunc(config.Pipelines, func(pipeline PipelineConfig) bool { return pipeline.Name == pipelineName })
+		if i >= 0 {
+			if err := layerOutput(&inherited, config.Pipelines[i].Settings); err != nil {
+				return nil, fmt.Errorf("pipeline %s has invalid settings: %w", pipelineName, err)
+			}
+		}
+	}
+	return &inherited, nil
+}
+
+// collapseOutputGroups removes the options outputs got from their group or pipeline from a
+// configuration about to be saved, so the saved outputs only set their own options and later
+// changes to the group or pipeline still reach them. Options changed since loading are kept.
+func collapseOutputGroups(config *Config) error {
+	outputs := slices.Clone(config.Outputs)
+	for i := range outputs {
+		output := &outputs[i]
+		if output.Group == "" && output.Pipeline == "" {
+			continue
+		}
+		inherited, err := inheritedSettings(config, output)
+		if err != nil {
+			return err
+		}
+		own, err := outputOptions(output)
+		if err != nil {
+			return err
+		}
+		shared, err := outputOptions(inherited)
+		if err != nil {
+			return err
+		}
+		for key, value := range own {
+			if key != "name" && key != "group" && bytes.Equal(value, shared[key]) {
+				delete(own, key)
+			}
+		}
+		data, err := json.Marshal(own)
+		if err != nil {
+			return err
+		}
+		var collapsed OutputConfig
+		if err := json.Unmarshal(data, &collapsed); err != nil {
+			return err
+		}
+		*output = collapsed
+	}
+	config.Outputs = outputs
+	return nil
+}
+
+// outputOptions returns the options an output sets, by name
+func outputOptions(output *OutputConfig) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(output)
+	if err != nil {
+		return nil, err
+	}
+	var options map[string]json.RawMessage
+	if err := json.Unmarshal(data, &options); err != nil {
+		return nil, err
+	}
+	return options, nil
+}
+
+// layerOutput applies a layer of output settings over the settings so far. An option the layer
+// sets replaces the earlier one as a whole, so an option that holds an object, such as
+// channel_filter, doesn't keep the fields the layer leaves out.
+func layerOutput(output *OutputConfig, settings []byte) error {
+	var layer map[string]json.RawMessage
+	if err := json.Unmarshal(settings, &layer); err != nil {
+		return err
+	}
+	options, err := outputOptions(output)
+	if err != nil {
+		return err
+	}
+	for key, value := range layer {
+		options[key] = value
+	}
+	data, err := json.Marshal(options)
+	if err != nil {
+		return err
+	}
+	var layered OutputConfig
+	if err := json.Unmarshal(data, &layered); err != nil {
+		return err
+	}
+	*output = layered
+	return nil
+}
+
+// applyOutputGroups fills in the settings of outputs that belong to a group or use a pipeline.
+// Pipeline settings override the group's, and the output's own settings override both. data is
+// the configuration the outputs were decoded from, so the options an output sets itself can be
+// told apart from options it leaves unset.
+func applyOutputGroups(config *Config, data []byte) error {
+	pipelines, err := pipelinesByName(config.Pipelines)
+	if err != nil {
+		return err
+	}
+
+	groups := make(map[string]*OutputGroupConfig)
+	groupPipelines := make(map[string]string)
+	for i := range config.OutputGroups {
+		group := &config.OutputGroups[i]
+		if group.Name == "" {
+			return fmt.Errorf("output group %d has no name", i+1)
+		}
+		if groups[group.Name] != nil {
+			return fmt.Errorf("output group %s is listed more than once", group.Name)
+		}
+
+		if len(group.Settings) == 0 {
+			// A group that only switches its outputs as a unit
+			groups[group.Name] = group
+			continue
+		}
+		var settings map[string]json.RawMessage
+		if err := json.Unmarshal(group.Settings, &settings); err != nil {
+			return fmt.Errorf("output group %s has invalid settings: %w", group.Name, err)
+		}
+		for _, key := range []string{"name", "group"} {
+			if _, ok := settings[key]; ok {
+				return fmt.Errorf("output group %s can't set %s", group.Name, key)
+			}
+		}
+		if value, ok := settings["pipeline"]; ok {
+			var pipeline string
+			if err := json.Unmarshal(value, &pipeline); err != nil {
+				return fmt.Errorf("output group %s has invalid pipeline: %w", group.Name, err)
+			}
+			groupPipelines[group.Name] = pipeline
+		}
+		groups[group.Name] = group
+	}
+
+	var raw struct {
+		Outputs []map[string]json.RawMessage `json:"outputs"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	for i := range config.Outputs {
+		output := &config.Outputs[i]
+		pipelineName := output.Pipeline
+		if pipelineName == "" {
+			pipelineName = groupPipelines[output.Group]
+		}
+		if output.Group == "" && pipelineName == "" {
+			continue
+		}
+
+		var group *OutputGroupConfig
+		if output.Group != "" {
+			group = groups[output.Group]
+			if group == nil {
+				return fmt.Errorf("output %d has unknown group: %q", i+1, output.Group)
+			}
+		}
+		var pipeline *PipelineConfig
+		if pipelineName != "" {
+			pipeline = pipelines[pipelineName]
+			if pipeline == nil {
+				return fmt.Errorf("output %d has unknown pipeline: %q", i+1, pipelineName)
+			}
+		}
+
+		// Options set to null count as unset, as saved configurations write some unset options
+		own := raw.Outputs[i]
+		for key, value := range own {
+			if bytes.Equal(bytes.TrimSpace(value), []byte("null")) {
+				delete(own, key)
+			}
+		}
+		ownData, err := json.Marshal(own)
+		if err != nil {
+			return err
+		}
+
+		// Each layer replaces the options it sets as a whole
+		var merged OutputConfig
+		if group != nil && len(group.Settings) > 0 {
+			if err := layerOutput(&merged, group.Settings); err != nil {
+				return fmt.Errorf("output group %s has invalid settings: %w", group.Name, err)
+			}
+		}
+		if pipeline != nil {
+			if err := layerOutput(&merged, pipeline.Settings); err != nil {
+				return fmt.Errorf("pipeline %s has invalid settings: %w", pipeline.Name, err)
+			}
+		}
+		if err := layerOutput(&merged, ownData); err != nil {
+			return fmt.Errorf("output %d is invalid: %w", i+1, err)
+		}
+		*output = merged
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestOutputGroupLayers(t *testing.T) {
+	config, err := parseConfig([]byte(`{
+		"output_groups": [
+			{"name": "Synths", "settings": {"channel_filter": {"channel": 1}, "min_velocity": 10}}
+		],
+		"outputs": [
+			{"name": "Juno", "group": "Synths"},
+			{"name": "Prophet", "group": "Synths", "channel_filter": {"channel_min": 1, "channel_max": 4}}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := validateConfigStructure(config); err != nil {
+		t.Fatalf("merged configuration is invalid: %v", err)
+	}
+
+	juno, prophet := config.Outputs[0], config.Outputs[1]
+	if juno.ChannelFilter == nil || juno.ChannelFilter.Channel != 1 {
+		t.Errorf("Juno: channel_filter = %+v, want the group's", juno.ChannelFilter)
+	}
+	if cf := prophet.ChannelFilter; cf == nil || *cf != (ChannelFilter{ChannelMin: 1, ChannelMax: 4}) {
+		t.Errorf("Prophet: channel_filter = %+v, want only its own channel range", cf)
+	}
+	if prophet.MinVelocity == nil || *prophet.MinVelocity != 10 {
+		t.Errorf("Prophet: min_velocity = %v, want the group's 10", prophet.MinVelocity)
+	}
+}
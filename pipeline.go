@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// pipelineExcludedOptions are the output options a pipeline can't set, as they say which output
+// it is and where its messages go rather than how they are processed
+var pipelineExcludedOptions = []string{
+	"name", "group", "pipeline", "description", "color", "input_device", "thru",
+	"device", "raw_file", "network", "socket", "osc", "mqtt",
+}
+
+// PipelineConfig is a named chain of processing settings several outputs can use, each
+// overriding the parameters it needs to change
+type PipelineConfig struct {
+	Name     string          `json:"name"`
+	Settings json.RawMessage `json:"settings"` // output filters and processing options
+}
+
+// pipelinesByName checks the pipelines and indexes them by name
+func pipelinesByName(pipelines []PipelineConfig) (map[string]*PipelineConfig, error) {
+	byName := make(map[string]*PipelineConfig)
+	for i := range pipelines {
+		pipeline := &pipelines[i]
+		if pipeline.Name == "" {
+			return nil, fmt.Errorf("pipeline %d has no name", i+1)
+		}
+		if byName[pipeline.Name] != nil {
+			return nil, fmt.Errorf("pipeline %s is listed more than once", pipeline.Name)
+		}
+
+		var settings map[string]json.RawMessage
+		if err := json.Unmarshal(pipeline.Settings, &settings); err != nil {
+			return nil, fmt.Errorf("pipeline %s has invalid settings: %w", pipeline.Name, err)
+		}
+		for _, key := range pipelineExcludedOptions {
+			if _, ok := settings[key]; ok {
+				return nil, fmt.Errorf("pipeline %s can't set %s", pipeline.Name, key)
+			}
+		}
+		byName[pipeline.Name] = pipeline
+	}
+	return byName, nil
+}
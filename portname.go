@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// defaultPortName is the port_name template used when none is set, the output base and the
+// output name separated by a space
+const defaultPortName = "{base} {name}"
+
+// portNamePlaceholder matches a placeholder in a port_name template
+var portNamePlaceholder = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// validatePortName checks that a port_name template only uses the known placeholders
+func validatePortName(template string) error {
+	if strings.TrimSpace(template) == "" {
+		return fmt.Errorf("empty template")
+	}
+	for _, match := range portNamePlaceholder.FindAllStringSubmatch(template, -1) {
+		switch match[1] {
+		case "base", "name", "number", "channel":
+		default:
+			return fmt.Errorf("unknown placeholder %s (must be {base}, {name}, {number} or {channel})", match[0])
+		}
+	}
+	return nil
+}
+
+// expandPortName fills in the placeholders of a port_name template
+func expandPortName(template, base, name, number, channel string) string {
+	return strings.NewReplacer("{base}", base, "{name}", name, "{number}", number, "{channel}", channel).Replace(template)
+}
+
+// outputPortName returns the full port name of an output, from its port_name template or the
+// configuration's
+func (c *Config) outputPortName(i int) string {
+	output := &c.Outputs[i]
+	template := c.PortName
+	if output.PortName != "" {
+		template = output.PortName
+	}
+	if template == "" {
+		template = defaultPortName
+	}
+	channel := ""
+	if output.OverrideChannel != nil {
+		channel = strconv.Itoa(int(*output.OverrideChannel))
+	} else if output.ChannelFilter != nil {
+		channel = channelSpans(output.ChannelFilter.channels())
+	}
+	return expandPortName(template, c.OutputBase, output.Name, strconv.Itoa(i+1), channel)
+}
+
+// checkPortNames refuses port_name templates that give several outputs, or an output and the
+// debug output, the same port name
+func checkPortNames(config *Config) error {
+	seen := make(map[string]int)
+	for i := range config.Outputs {
+		name := config.outputPortName(i)
+		if other, ok := seen[name]; ok {
+			return fmt.Errorf("output %d has the same port name as output %d: %q, add {name} or {number} to port_name", i+1, other+1, name)
+		}
+		seen[name] = i
+	}
+	if config.DebugOutput != "" {
+		name := config.debugPortName()
+		if other, ok := seen[name]; ok {
+			return fmt.Errorf("the debug output has the same port name as output %d: %q", other+1, name)
+		}
+	}
+	return nil
+}
+
+// debugPortName returns the full port name of the debug output, which has no number or channel
+func (c *Config) debugPortName() string {
+	template := c.PortName
+	if template == "" {
+		template = defaultPortName
+	}
+	return expandPortName(template, c.OutputBase, c.DebugOutput, "", "")
+}
+
+// channelSpans formats sorted channels as comma separated runs, e.g. "1-4,9-16"
+func channelSpans(channels []uint8) string {
+	var spans []string
+	for i := 0; i < len(channels); {
+		j := i
+		for j+1 < len(channels) && channels[j+1] == channels[j]+1 {
+			j++
+		}
+		span := strconv.Itoa(int(channels[i]))
+		if j > i {
+			span += "-" + strconv.Itoa(int(channels[j]))
+		}
+		spans = append(spans, span)
+		i = j + 1
+	}
+	return strings.Join(spans, ",")
+}
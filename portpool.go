@@ -0,0 +1,96 @@
+package main
+
+import (
+	"sync"
+
+	"gitlab.com/gomidi/midi/v2/drivers"
+)
+
+// portPool keeps the virtual ports of the router open across the restarts that apply a changed
+// configuration, so DAWs and patchbays stay connected to them. A restart takes the ports of the
+// previous run that it still needs by name, and the ones it no longer needs are closed.
+type portPool struct {
+	mu   sync.Mutex
+	outs map[string]drivers.Out
+	ins  map[string]drivers.In
+	used map[string]bool // ports taken by the current run
+}
+
+func newPortPool() *portPool {
+	return &portPool{
+		outs: make(map[string]drivers.Out),
+		ins:  make(map[string]drivers.In),
+		used: make(map[string]bool),
+	}
+}
+
+// portKey identifies a port by the client it was created under and its name
+func portKey(clientName, name string) string {
+	return clientName + "\x00" + name
+}
+
+// Begin starts a run, which takes its ports from the pool
+func (p *portPool) Begin() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.used = make(map[string]bool)
+}
+
+// Out returns the virtual output port of a previous run with the same name, or creates one with
+// open. Reports whether the port was kept from a previous run.
+func (p *portPool) Out(clientName, name string, open func(name string) (drivers.Out, error)) (drivers.Out, bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := portKey(clientName, name)
+	p.used[key] = true
+	if out, ok := p.outs[key]; ok {
+		return out, true, nil
+	}
+	out, err := open(name)
+	if err != nil {
+		return nil, false, err
+	}
+	p.outs[key] = out
+	return out, false, nil
+}
+
+// In returns the virtual input port of a previous run with the same name, or creates one with open
+func (p *portPool) In(clientName, name string, open func(name string) (drivers.In, error)) (drivers.In, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := portKey(clientName, name)
+	p.used[key] = true
+	if in, ok := p.ins[key]; ok {
+		return in, nil
+	}
+	in, err := open(name)
+	if err != nil {
+		return nil, err
+	}
+	p.ins[key] = in
+	return in, nil
+}
+
+// CloseUnused closes the ports the current run didn't take, once it has taken all of its ports
+func (p *portPool) CloseUnused() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, out := range p.outs {
+		if !p.used[key] {
+			out.Close()
+			delete(p.outs, key)
+		}
+	}
+	for key, in := range p.ins {
+		if !p.used[key] {
+			in.Close()
+			delete(p.ins, key)
+		}
+	}
+}
+
+// Close closes every port in the pool
+func (p *portPool) Close() {
+	p.Begin()
+	p.CloseUnused()
+}
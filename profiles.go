@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bufio"
+	"embed"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// profileFiles are the built-in device profiles, output snippets for common controllers and kits
+//
+//go:embed profiles/*.json
+var profileFiles embed.FS
+
+// builtinProfiles returns the built-in device profiles sorted by name
+func builtinProfiles() ([]*OutputSnippet, error) {
+	entries, err := profileFiles.ReadDir("profiles")
+	if err != nil {
+		return nil, err
+	}
+	var profiles []*OutputSnippet
+	for _, entry := range entries {
+		data, err := profileFiles.ReadFile("profiles/" + entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		profile, err := parseOutputSnippet(data)
+		if err != nil {
+			return nil, fmt.Errorf("profile %s: %w", entry.Name(), err)
+		}
+		profiles = append(profiles, profile)
+	}
+	sort.Slice(profiles, func(a, b int) bool {
+		return profiles[a].Name < profiles[b].Name
+	})
+	return profiles, nil
+}
+
+// selectProfile lists the device profiles and asks for one
+func selectProfile(reader *bufio.Reader, profiles []*OutputSnippet) (*OutputSnippet, error) {
+	fmt.Println("Device profiles:")
+	for i, profile := range profiles {
+		fmt.Printf("  %d: %s\n", i+1, profile.Name)
+		if profile.Description != "" {
+			fmt.Printf("     %s\n", profile.Description)
+		}
+	}
+
+	fmt.Printf("Select profile (1-%d): ", len(profiles))
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input: %w", err)
+	}
+
+	choice, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil || choice < 1 || choice > len(profiles) {
+		return nil, fmt.Errorf("invalid selection")
+	}
+	return profiles[choice-1], nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// defaultRandomCCStep is the largest change of a random walk step when step is not set
+const defaultRandomCCStep = 8
+
+// RandomCCConfig sends a controller that wanders randomly between two bounds, for slowly
+// evolving generative patches
+type RandomCCConfig struct {
+	Controller uint8    `json:"controller"`            // 0-127
+	Channel    uint8    `json:"channel"`               // 1-16
+	RateHz     float64  `json:"rate_hz"`               // steps per second, 0.01-50
+	Min        uint8    `json:"min,omitempty"`         // lowest value sent
+	Max        *uint8   `json:"max,omitempty"`         // highest value sent, default 127
+	Step       int      `json:"step,omitempty"`        // largest change per step, 1-127, default 8
+	WhileNotes bool     `json:"while_notes,omitempty"` // only move while a note is held on an input
+	Outputs    []string `json:"outputs,omitempty"`     // names of outputs or output groups that receive the controller, all outputs when empty
+}
+
+// Validate checks the random controller settings against the configured outputs
+func (rc *RandomCCConfig) Validate(outputs []OutputConfig) error {
+	if rc.Controller > 127 {
+		return fmt.Errorf("invalid controller: %d (must be 0-127)", rc.Controller)
+	}
+	if rc.Channel < 1 || rc.Channel > 16 {
+		return fmt.Errorf("invalid channel: %d (must be 1-16)", rc.Channel)
+	}
+	if rc.RateHz < 0.01 || rc.RateHz > 50 {
+		return fmt.Errorf("invalid rate_hz: %g (must be 0.01-50)", rc.RateHz)
+	}
+	if rc.Min > 127 {
+		return fmt.Errorf("invalid min: %d (must be 0-127)", rc.Min)
+	}
+	if rc.Max != nil && (*rc.Max > 127 || *rc.Max < rc.Min) {
+		return fmt.Errorf("invalid max: %d (must be %d-127)", *rc.Max, rc.Min)
+	}
+	if rc.Step < 0 || rc.Step > 127 {
+		return fmt.Errorf("invalid step: %d (must be 1-127)", rc.Step)
+	}
+	for _, name := range rc.Outputs {
+		if !hasOutputOrGroup(outputs, name) {
+			return fmt.Errorf("unknown output: %q", name)
+		}
+	}
+	return nil
+}
+
+// bounds returns the lowest and highest value of the walk
+func (rc *RandomCCConfig) bounds() (int, int) {
+	high := 127
+	if rc.Max != nil {
+		high = int(*rc.Max)
+	}
+	return int(rc.Min), high
+}
+
+// next returns the value one random step away from value, kept within the bounds
+func (rc *RandomCCConfig) next(value int) int {
+	step := rc.Step
+	if step == 0 {
+		step = defaultRandomCCStep
+	}
+	low, high := rc.bounds()
+	return max(low, min(high, value+rand.Intn(2*step+1)-step))
+}
+
+// runRandomCCs sends the random walks of the configured random controllers to their outputs until
+// stop is closed. playing reports whether a note is held, for walks that only move while playing.
+func runRandomCCs(config *Config, playing func() bool, send func(i int, msg midi.Message) error, stop <-chan struct{}) {
+	if len(config.RandomCCs) == 0 {
+		return
+	}
+
+	type walkState struct {
+		value  int
+		nextAt time.Time
+	}
+	states := make([]walkState, len(config.RandomCCs))
+	now := time.Now()
+	for i := range states {
+		low, high := config.RandomCCs[i].bounds()
+		states[i] = walkState{value: low + rand.Intn(high-low+1), nextAt: now}
+	}
+
+	// Steps are checked at the LFO interval, so walks share one ticker whatever their rates
+	ticker := time.NewTicker(lfoInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			for w := range config.RandomCCs {
+				walk := &config.RandomCCs[w]
+				state := &states[w]
+				if now.Before(state.nextAt) {
+					continue
+				}
+				state.nextAt = now.Add(time.Duration(float64(time.Second) / walk.RateHz))
+				if walk.WhileNotes && !playing() {
+					continue
+				}
+
+				value := walk.next(state.value)
+				if value == state.value {
+					continue
+				}
+				state.value = value
+
+				msg := midi.ControlChange(walk.Channel-1, walk.Controller, uint8(value))
+				for i, output := range config.Outputs {
+					if !sendsTo(walk.Outputs, &output) {
+						continue
+					}
+					if err := send(i, msg); err != nil {
+						name := config.outputPortName(i)
+						sendErrors.Printf(name, "Error sending random cc %d to %s: %v", w+1, name, err)
+					}
+				}
+			}
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/gomidi/midi/v2/drivers"
+)
+
+// pipeStdout is the process's stdout, which raw outputs with a path of "-" write to after
+// everything else printed has been moved to stderr
+var pipeStdout = os.Stdout
+
+// RawFileConfig reads or writes raw MIDI through a file or named pipe instead of a MIDI port
+type RawFileConfig struct {
+	Path   string `json:"path"`             // file or FIFO, outputs create it if needed, "-" reads stdin or writes stdout
+	Format string `json:"format,omitempty"` // "raw" (default) for plain MIDI bytes, "text" for timestamped hex lines
+}
+
+// Validate checks the raw file settings
+func (rc *RawFileConfig) Validate() error {
+	if rc.Path == "" {
+		return fmt.Errorf("raw file needs a path")
+	}
+	switch rc.Format {
+	case "", "raw", "text":
+	default:
+		return fmt.Errorf("invalid raw file format: %q (must be raw or text)", rc.Format)
+	}
+	return nil
+}
+
+// formatRawLine formats a message for the text format: milliseconds since the port was opened
+// followed by the message bytes in hex, for example "1520 90 3C 64"
+func formatRawLine(milliseconds int64, data []byte) string {
+	return fmt.Sprintf("%d % X\n", milliseconds, data)
+}
+
+// parseRawLine parses a line of the text format into its timestamp and message bytes
+func parseRawLine(line string) (int64, []byte, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return 0, nil, fmt.Errorf("expected a timestamp and message bytes")
+	}
+	milliseconds, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil || milliseconds < 0 {
+		return 0, nil, fmt.Errorf("bad timestamp %q", fields[0])
+	}
+	data := make([]byte, len(fields)-1)
+	for i, field := range fields[1:] {
+		b, err := strconv.ParseUint(field, 16, 8)
+		if err != nil {
+			return 0, nil, fmt.Errorf("bad byte %q", field)
+		}
+		data[i] = byte(b)
+	}
+	return milliseconds, data, nil
+}
+
+// writesStdout reports whether any output writes to stdout
+func (c *Config) writesStdout() bool {
+	for _, output := range c.Outputs {
+		if output.RawFile != nil && output.RawFile.Path == "-" {
+			return true
+		}
+	}
+	return false
+}
+
+// usePipe makes the router a filter between stdin and stdout: raw MIDI is read from stdin instead
+// of the inputs, and the outputs without a destination of their own write to stdout instead of
+// creating virtual ports
+func (c *Config) usePipe() {
+	c.InputDevice = ""
+	c.InputDevicePattern = ""
+	c.InputDevices = nil
+	c.RawInput = &RawFileConfig{Path: "-"}
+	for i := range c.Outputs {
+		output := &c.Outputs[i]
+		output.InputDevice = ""
+		if output.isVirtual() {
+			output.RawFile = &RawFileConfig{Path: "-"}
+		}
+	}
+}
+
+// pipeConfig is the configuration of --pipe without --config, copying stdin to stdout
+func pipeConfig() *Config {
+	config := &Config{OutputBase: "MIDI Router", Outputs: []OutputConfig{{Name: "Out"}}}
+	config.usePipe()
+	return config
+}
+
+// rawFileOut is an output port that writes MIDI bytes to a file or named pipe, or stdout
+type rawFileOut struct {
+	mu      sync.Mutex
+	config  *RawFileConfig
+	name    string
+	file    *os.File
+	started time.Time
+}
+
+func newRawFileOut(name string, config *RawFileConfig) *rawFileOut {
+	return &rawFileOut{config: config, name: name}
+}
+
+func (o *rawFileOut) Number() int             { return -1 }
+func (o *rawFileOut) String() string          { return o.name }
+func (o *rawFileOut) Underlying() interface{} { return o.file }
+
+// IsOpen returns whether the file is open
+func (o *rawFileOut) IsOpen() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.file != nil
+}
+
+// Open opens the file for appending. Opening a named pipe blocks until a reader connects.
+func (o *rawFileOut) Open() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.file != nil {
+		return nil
+	}
+	if o.config.Path == "-" {
+		o.file = pipeStdout
+		o.started = time.Now()
+		return nil
+	}
+	file, err := os.OpenFile(o.config.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open raw output file: %w", err)
+	}
+	o.file = file
+	o.started = time.Now()
+	return nil
+}
+
+// Close closes the file
+func (o *rawFileOut) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.file == nil {
+		return nil
+	}
+	// Stdout stays open for the next router start
+	var err error
+	if o.file != pipeStdout {
+		err = o.file.Close()
+	}
+	o.file = nil
+	return err
+}
+
+// Send writes a message in the configured format
+func (o *rawFileOut) Send(data []byte) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.file == nil {
+		return drivers.ErrPortClosed
+	}
+
+	var err error
+	if o.config.Format == "text" {
+		_, err = o.file.WriteString(formatRawLine(time.Since(o.started).Milliseconds(), data))
+	} else {
+		_, err = o.file.Write(data)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write to %s: %w", o.config.Path, err)
+	}
+	return nil
+}
+
+// rawFileIn is an input port that reads MIDI from stdin, a file or a named pipe. Named pipes are
+// reopened when the writer closes them, so several scripts can write one after another. Stdin
+// and regular files end the input at end of file.
+type rawFileIn struct {
+	config *RawFileConfig
+	done   chan struct{}
+}
+
+func newRawFileIn(config *RawFileConfig) *rawFileIn {
+	return &rawFileIn{config: config, done: make(chan struct{})}
+}
+
+func (i *rawFileIn) Open() error             { return nil }
+func (i *rawFileIn) Close() error            { return nil }
+func (i *rawFileIn) IsOpen() bool            { return true }
+func (i *rawFileIn) Number() int             { return -1 }
+func (i *rawFileIn) Underlying() interface{} { return nil }
+
+func (i *rawFileIn) String() string {
+	if i.config.Path == "-" {
+		return "stdin"
+	}
+	return i.config.Path
+}
+
+// Done is closed when the input has ended
+func (i *rawFileIn) Done() <-chan struct{} {
+	return i.done
+}
+
+// open opens the input, reporting whether it should be reopened at end of file
+func (i *rawFileIn) open() (io.ReadCloser, bool, error) {
+	if i.config.Path == "-" {
+		return io.NopCloser(os.Stdin), false, nil
+	}
+	file, err := os.Open(i.config.Path)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to open raw input: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, false, fmt.Errorf("failed to open raw input: %w", err)
+	}
+	return file, info.Mode()&os.ModeNamedPipe != 0, nil
+}
+
+// Listen reads the input in the background and passes each message to onMsg
+func (i *rawFileIn) Listen(onMsg func(msg []byte, milliseconds int32), config drivers.ListenConfig) (func(), error) {
+	file, reopen, err := i.open()
+	if err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	stopped := false
+	stop := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		stopped = true
+		file.Close()
+	}
+
+	go func() {
+		defer close(i.done)
+		reader := newSysExReader(config, onMsg)
+		for {
+			var err error
+			if i.config.Format == "text" {
+				err = i.readText(file, reader, time.Now())
+			} else {
+				err = i.readRaw(file, reader)
+			}
+
+			mu.Lock()
+			if stopped {
+				mu.Unlock()
+				return
+			}
+			file.Close()
+			mu.Unlock()
+
+			if err != nil {
+				log.Printf("Error reading %s: %v", i.String(), err)
+				return
+			}
+			if !reopen {
+				return
+			}
+
+			// Wait for the next writer
+			next, _, err := i.open()
+			if err != nil {
+				log.Printf("Error reopening %s: %v", i.String(), err)
+				return
+			}
+			mu.Lock()
+			if stopped {
+				mu.Unlock()
+				next.Close()
+				return
+			}
+			file = next
+			mu.Unlock()
+		}
+	}()
+
+	return stop, nil
+}
+
+// readRaw passes plain MIDI bytes through the reader as they arrive
+func (i *rawFileIn) readRaw(file io.Reader, reader *sysExReader) error {
+	buf := make([]byte, 1024)
+	last := time.Now()
+	for {
+		n, err := file.Read(buf)
+		if n > 0 {
+			now := time.Now()
+			reader.EachMessage(buf[:n], int32(now.Sub(last).Milliseconds()))
+			last = now
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readText sends each line of the text format at its timestamp, relative to when the input was opened
+func (i *rawFileIn) readText(file io.Reader, reader *sysExReader, started time.Time) error {
+	scanner := bufio.NewScanner(file)
+	// Lines of large SysEx dumps are longer than the default limit
+	scanner.Buffer(make([]byte, 64*1024), 3*16*1024*1024)
+	var last int64
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		milliseconds, data, err := parseRawLine(line)
+		if err != nil {
+			log.Printf("Skipping line %d of %s: %v", lineNumber, i.String(), err)
+			continue
+		}
+		if wait := time.Until(started.Add(time.Duration(milliseconds) * time.Millisecond)); wait > 0 {
+			time.Sleep(wait)
+		}
+		delta := milliseconds - last
+		if delta < 0 {
+			delta = 0
+		}
+		last = milliseconds
+		reader.EachMessage(data, int32(delta))
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// recorderTempoBPM is the tempo written to recorded MIDI files, which sets how ticks map to time
+const recorderTempoBPM = 120.0
+
+// recordedMessage is a single line of a JSONL recording
+type recordedMessage struct {
+	TimeMS   int64  `json:"time_ms"`             // milliseconds since the recording started
+	TimeUS   int64  `json:"time_us,omitempty"`   // the same time in microseconds, for timing analysis
+	DriverMS *int32 `json:"driver_ms,omitempty"` // timestamp the driver attached to a received message, in milliseconds since the input was opened
+	Port     string `json:"port,omitempty"`      // output the message was sent to, if any
+	Data     string `json:"data"`                // message bytes in hex, e.g. "90 3C 64"
+	Message  string `json:"message,omitempty"`   // decoded form, for reading the file
+}
+
+// messageRecorder records messages with their time to a JSONL file, or to a Standard MIDI File
+// when the file name ends in .mid or .midi. MIDI files are written when the recorder is closed.
+type messageRecorder struct {
+	mu       sync.Mutex
+	filename string
+	started  time.Time
+
+	// JSONL recording
+	file    *os.File
+	encoder *json.Encoder
+
+	// MIDI file recording
+	smf      bool
+	track    smf.Track
+	lastTime time.Time
+}
+
+func newMessageRecorder(filename string) (*messageRecorder, error) {
+	now := time.Now()
+	recorder := &messageRecorder{filename: filename, started: now, lastTime: now}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".mid", ".midi":
+		recorder.smf = true
+		recorder.track.Add(0, smf.MetaTempo(recorderTempoBPM))
+	default:
+		file, err := os.Create(filename)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create recording: %w", err)
+		}
+		recorder.file = file
+		recorder.encoder = json.NewEncoder(file)
+	}
+	return recorder, nil
+}
+
+// Record adds a message to the recording. port names the output it was sent to, if any.
+// MIDI clock is left out, it would fill the recording.
+func (mr *messageRecorder) Record(port string, msg midi.Message) error {
+	return mr.record(port, msg, nil)
+}
+
+// RecordReceived adds an incoming message to the recording, with the timestamp the driver
+// attached to it if any
+func (mr *messageRecorder) RecordReceived(msg midi.Message, driverMS *int32) error {
+	return mr.record("", msg, driverMS)
+}
+
+func (mr *messageRecorder) record(port string, msg midi.Message, driverMS *int32) error {
+	if msg.Is(midi.TimingClockMsg) {
+		return nil
+	}
+
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	now := time.Now()
+	if mr.smf {
+		// Real-time messages can't be stored in MIDI files
+		if len(msg) == 0 || msg[0] >= 0xF8 {
+			return nil
+		}
+		delta := smf.MetricTicks(960).Ticks(recorderTempoBPM, now.Sub(mr.lastTime))
+		mr.track.Add(delta, append([]byte(nil), msg...))
+		mr.lastTime = now
+		return nil
+	}
+
+	if mr.encoder == nil {
+		return fmt.Errorf("recording %s is closed", mr.filename)
+	}
+	return mr.encoder.Encode(recordedMessage{
+		TimeMS:   now.Sub(mr.started).Milliseconds(),
+		TimeUS:   now.Sub(mr.started).Microseconds(),
+		DriverMS: driverMS,
+		Port:     port,
+		Data:     fmt.Sprintf("% X", []byte(msg)),
+		Message:  msg.String(),
+	})
+}
+
+// Close finishes the recording
+func (mr *messageRecorder) Close() error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	if mr.smf {
+		mr.track.Close(0)
+		file := smf.New()
+		file.TimeFormat = smf.MetricTicks(960)
+		if err := file.Add(mr.track); err != nil {
+			return fmt.Errorf("failed to write recording: %w", err)
+		}
+		if err := file.WriteFile(mr.filename); err != nil {
+			return fmt.Errorf("failed to write recording: %w", err)
+		}
+		return nil
+	}
+
+	if mr.file == nil {
+		return nil
+	}
+	err := mr.file.Close()
+	mr.file = nil
+	mr.encoder = nil
+	return err
+}
+
+// recordingSender records every message an output sends
+func recordingSender(recorder *messageRecorder, port string, send func(midi.Message) error) func(midi.Message) error {
+	return func(msg midi.Message) error {
+		if err := send(msg); err != nil {
+			return err
+		}
+		if err := recorder.Record(port, msg); err != nil {
+			log.Printf("Error recording message: %v", err)
+		}
+		return nil
+	}
+}
+
+// readRecording reads the messages of a JSONL recording
+func readRecording(filename string) ([]recordedMessage, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read recording: %w", err)
+	}
+	defer file.Close()
+
+	var messages []recordedMessage
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var message recordedMessage
+		if err := json.Unmarshal([]byte(line), &message); err != nil {
+			return nil, fmt.Errorf("invalid recording %s, line %d: %w", filename, lineNumber, err)
+		}
+		if _, err := message.bytes(); err != nil {
+			return nil, fmt.Errorf("invalid recording %s, line %d: %w", filename, lineNumber, err)
+		}
+		messages = append(messages, message)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read recording: %w", err)
+	}
+	return messages, nil
+}
+
+// bytes decodes the hex message data
+func (rm *recordedMessage) bytes() (midi.Message, error) {
+	var data midi.Message
+	for _, field := range strings.Fields(rm.Data) {
+		b, err := strconv.ParseUint(field, 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("bad byte %q", field)
+		}
+		data = append(data, byte(b))
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty message")
+	}
+	return data, nil
+}
+
+// offset returns the time of the message since the recording started, in microseconds when the
+// recording has them
+func (rm *recordedMessage) offset() time.Duration {
+	if rm.TimeUS > 0 {
+		return time.Duration(rm.TimeUS) * time.Microsecond
+	}
+	return time.Duration(rm.TimeMS) * time.Millisecond
+}
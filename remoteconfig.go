@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maxRemoteConfigSize limits how much of a config URL response is read
+const maxRemoteConfigSize = 1 << 20
+
+// isConfigURL reports whether a --config value is an http(s) URL rather than a file
+func isConfigURL(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
+
+// remoteConfig fetches a configuration from a URL, using the ETag of the last response to skip
+// downloads when nothing changed
+type remoteConfig struct {
+	url       string
+	client    *http.Client
+	etag      string
+	last      []byte
+	cachePath string // last good config, used when the URL can't be reached
+	cached    bool   // whether the running config came from the cache
+}
+
+func newRemoteConfig(url string) *remoteConfig {
+	return &remoteConfig{
+		url:       url,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		cachePath: remoteConfigCachePath(url),
+	}
+}
+
+// remoteConfigCachePath returns the cache file for a config URL in the user's cache directory,
+// or an empty string if there is no cache directory
+func remoteConfigCachePath(url string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(dir, "midirouter", hex.EncodeToString(sum[:8])+".json")
+}
+
+// Load fetches the configuration, falling back to the cached copy of the last good
+// configuration when the URL can't be reached or returns an invalid config
+func (rc *remoteConfig) Load() (*Config, error) {
+	config, _, err := rc.Fetch()
+	if err == nil {
+		err = validateConfigStructure(config)
+	}
+	if err == nil {
+		rc.saveCache()
+		fmt.Printf("Using configuration from %s\n", rc.url)
+		return config, nil
+	}
+
+	if rc.cachePath == "" {
+		return nil, err
+	}
+	data, cacheErr := os.ReadFile(rc.cachePath)
+	if cacheErr != nil {
+		return nil, err
+	}
+	cachedConfig, cacheErr := parseConfig(data)
+	if cacheErr != nil {
+		return nil, err
+	}
+
+	log.Printf("Warning: %v", err)
+	modified := "unknown time"
+	if info, statErr := os.Stat(rc.cachePath); statErr == nil {
+		modified = info.ModTime().Format(time.DateTime)
+	}
+	fmt.Printf("Using cached configuration from %s (saved %s)\n", rc.cachePath, modified)
+	rc.cached = true
+	return cachedConfig, nil
+}
+
+// saveCache stores the last fetched configuration as the last good one
+func (rc *remoteConfig) saveCache() {
+	if rc.cachePath == "" || rc.last == nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(rc.cachePath), 0755); err != nil {
+		log.Printf("Warning: failed to cache config: %v", err)
+		return
+	}
+	if err := os.WriteFile(rc.cachePath, rc.last, 0644); err != nil {
+		log.Printf("Warning: failed to cache config: %v", err)
+	}
+}
+
+// Fetch downloads the configuration. changed is false when the server reports it is unchanged
+// or returns the same content as the last fetch.
+func (rc *remoteConfig) Fetch() (config *Config, changed bool, err error) {
+	req, err := http.NewRequest(http.MethodGet, rc.url, nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid config URL: %w", err)
+	}
+	if rc.etag != "" {
+		req.Header.Set("If-None-Match", rc.etag)
+	}
+
+	resp, err := rc.client.Do(req)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to fetch config: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, false, nil
+	default:
+		return nil, false, fmt.Errorf("failed to fetch config: %s returned %s", rc.url, resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxRemoteConfigSize))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to fetch config: %w", err)
+	}
+	if rc.last != nil && bytes.Equal(data, rc.last) {
+		rc.etag = resp.Header.Get("ETag")
+		return nil, false, nil
+	}
+
+	config, err = parseConfig(data)
+	if err != nil {
+		return nil, false, err
+	}
+	rc.etag = resp.Header.Get("ETag")
+	rc.last = data
+	return config, true, nil
+}
+
+// runWithRemoteConfig runs the router and checks the config URL every interval. A changed
+// configuration that passes validation replaces the running one by restarting the router;
+// fetch and validation errors are logged and the current configuration keeps running.
+func runWithRemoteConfig(drv midiDriver, config *Config, remote *remoteConfig, interval time.Duration, prepare func(*Config), logging logOptions) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// Keep the virtual ports open across the restarts that apply a changed configuration
+	logging.Ports = newPortPool()
+	defer logging.Ports.Close()
+
+	for {
+		done := make(chan struct{})
+		result := make(chan error, 1)
+		go func(config *Config) {
+			result <- runMIDIRouter(drv, config, logging, done)
+		}(config)
+
+		restart := false
+		for !restart {
+			select {
+			case err := <-result:
+				return err
+			case <-ticker.C:
+				updated, changed, err := remote.Fetch()
+				if err != nil {
+					log.Printf("Warning: %v", err)
+					continue
+				}
+				if !changed {
+					continue
+				}
+				if err := validateConfigStructure(updated); err != nil {
+					log.Printf("Warning: ignoring invalid config from %s: %v", remote.url, err)
+					continue
+				}
+				if updated.RawInput == nil {
+					if err := validateInputDevices(updated, drv); err != nil {
+						log.Printf("Warning: ignoring config from %s: %v", remote.url, err)
+						continue
+					}
+				}
+				remote.saveCache()
+				if remote.cached {
+					fmt.Printf("Using configuration from %s again\n", remote.url)
+					remote.cached = false
+				}
+
+				prepare(updated)
+				if sameConfig(updated, config) {
+					continue
+				}
+
+				fmt.Printf("Configuration changed at %s, restarting router...\n", remote.url)
+				close(done)
+				if err := <-result; err != nil {
+					return err
+				}
+				logging.Audit.Record(remote.url, "refresh", config, updated)
+				config = updated
+				restart = true
+			}
+		}
+	}
+}
+
+// sameConfig reports whether two configurations are identical
+func sameConfig(a, b *Config) bool {
+	dataA, errA := json.Marshal(a)
+	dataB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(dataA, dataB)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// NoteRepeatConfig retriggers held notes at a rate synced to MIDI clock, like the note repeat of
+// a drum machine
+type NoteRepeatConfig struct {
+	Rate         string         `json:"rate"`                    // note value between repeats, e.g. "1/16" or "1/8T"
+	Toggle       *TriggerConfig `json:"toggle,omitempty"`        // control that switches repeating on and off, always on when omitted
+	VelocityRamp int            `json:"velocity_ramp,omitempty"` // added to the velocity on each repeat, -126 to 126
+}
+
+// Validate checks the note repeat settings
+func (nrc *NoteRepeatConfig) Validate() error {
+	if _, err := parseNoteValue(nrc.Rate); err != nil {
+		return err
+	}
+	if nrc.Toggle != nil {
+		if err := nrc.Toggle.Validate(); err != nil {
+			return fmt.Errorf("invalid toggle: %w", err)
+		}
+	}
+	if nrc.VelocityRamp < -126 || nrc.VelocityRamp > 126 {
+		return fmt.Errorf("invalid velocity_ramp: %d (must be -126 to 126)", nrc.VelocityRamp)
+	}
+	return nil
+}
+
+// repeatingNote is a held note on an output. A new press of the same key replaces it, which stops
+// the old note's repeats.
+type repeatingNote struct {
+	velocity uint8
+}
+
+// noteRepeatState retriggers the notes held on an output while repeating is switched on
+type noteRepeatState struct {
+	config   *NoteRepeatConfig
+	name     string
+	clock    *clockTracker
+	schedule func(time.Duration, func()) // runs a function later, serialized with routing
+	send     func(midi.Message) error
+
+	enabled bool
+	toggles uint64 // counts toggles, so repeats started before the last toggle stop
+	held    map[noteKey]*repeatingNote
+}
+
+func newNoteRepeatState(config *NoteRepeatConfig, name string, clock *clockTracker, schedule func(time.Duration, func()), send func(midi.Message) error) *noteRepeatState {
+	return &noteRepeatState{
+		config:   config,
+		name:     name,
+		clock:    clock,
+		schedule: schedule,
+		send:     send,
+		enabled:  config.Toggle == nil,
+		held:     make(map[noteKey]*repeatingNote),
+	}
+}
+
+// Toggle switches repeating on or off. Notes that are already held start repeating when it is
+// switched on.
+func (rs *noteRepeatState) Toggle() bool {
+	rs.enabled = !rs.enabled
+	rs.toggles++
+	if rs.enabled {
+		for k, note := range rs.held {
+			rs.next(k, note)
+		}
+	}
+	return rs.enabled
+}
+
+// Track follows the notes sent to the output, starting repeats for new notes
+func (rs *noteRepeatState) Track(msgs []midi.Message) {
+	for _, msg := range msgs {
+		var channel, key, velocity uint8
+		switch {
+		case msg.GetNoteStart(&channel, &key, &velocity):
+			k := noteKey{channel, key}
+			note := &repeatingNote{velocity: velocity}
+			rs.held[k] = note
+			if rs.enabled {
+				rs.next(k, note)
+			}
+		case msg.GetNoteEnd(&channel, &key):
+			delete(rs.held, noteKey{channel, key})
+		}
+	}
+}
+
+// next schedules the next repeat of a held note, at the current tempo
+func (rs *noteRepeatState) next(k noteKey, note *repeatingNote) {
+	clocks, _ := parseNoteValue(rs.config.Rate)
+	interval := time.Duration(clocks * float64(rs.clock.TickInterval()))
+	toggles := rs.toggles
+	rs.schedule(interval, func() {
+		// Stop when the note was released, pressed again or repeating was switched off
+		if !rs.enabled || rs.toggles != toggles || rs.held[k] != note {
+			return
+		}
+		note.velocity = uint8(max(1, min(127, int(note.velocity)+rs.config.VelocityRamp)))
+		if err := rs.send(midi.NoteOff(k.Channel, k.Note)); err != nil {
+			sendErrors.Printf(rs.name, "Error sending note repeat to %s: %v", rs.name, err)
+			return
+		}
+		if err := rs.send(midi.NoteOn(k.Channel, k.Note, note.velocity)); err != nil {
+			sendErrors.Printf(rs.name, "Error sending note repeat to %s: %v", rs.name, err)
+			return
+		}
+		rs.next(k, note)
+	})
+}
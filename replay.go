@@ -0,0 +1,186 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// runReplayCommand routes a recorded input through a config, at the recorded timing, and
+// compares the messages sent to each output with an expected output recording.
+func runReplayCommand(args []string) error {
+	// The recording may come before the flags
+	var recording string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		recording, args = args[0], args[1:]
+	}
+
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	configFile := flags.String("config", "config.json", "Config file to route the recording through")
+	expectFile := flags.String("expect", "", "Output recording to compare the produced messages with")
+	outputFile := flags.String("output", "", "Record the produced messages to a .jsonl or .mid file")
+	tail := flags.Duration("tail", time.Second, "How long to wait for delayed messages after the last input")
+	flags.Parse(args)
+
+	if recording == "" && flags.NArg() == 1 {
+		recording = flags.Arg(0)
+	}
+	if recording == "" || flags.NArg() > 1 {
+		return fmt.Errorf("usage: midirouter %s", subcommands["replay"].usage)
+	}
+
+	inputs, err := readRecording(recording)
+	if err != nil {
+		return err
+	}
+	var expected []recordedMessage
+	if *expectFile != "" {
+		if expected, err = readRecording(*expectFile); err != nil {
+			return err
+		}
+	}
+
+	config, err := loadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+	if err := validateConfigStructure(config); err != nil {
+		return err
+	}
+
+	var recorder *messageRecorder
+	if *outputFile != "" {
+		if recorder, err = newMessageRecorder(*outputFile); err != nil {
+			return err
+		}
+		defer recorder.Close()
+	}
+
+	// Collect what each output would have sent
+	var mu sync.Mutex
+	var produced []recordedMessage
+	senders := make([]func(midi.Message) error, len(config.Outputs))
+	for i, outputConfig := range config.Outputs {
+		port := outputConfig.Name
+		senders[i] = func(msg midi.Message) error {
+			if msg.Is(midi.TimingClockMsg) {
+				return nil
+			}
+			mu.Lock()
+			produced = append(produced, recordedMessage{Port: port, Data: fmt.Sprintf("% X", []byte(msg))})
+			mu.Unlock()
+			if recorder != nil {
+				return recorder.Record(port, msg)
+			}
+			return nil
+		}
+	}
+
+	r, err := newRouter(config, senders, logOptions{Quiet: true})
+	if err != nil {
+		return err
+	}
+	if err := r.Start(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Replaying %d messages from %s through %s\n", len(inputs), recording, *configFile)
+	started := time.Now()
+	for _, input := range inputs {
+		// Recorded output lines are not input
+		if input.Port != "" {
+			continue
+		}
+		msg, _ := input.bytes()
+		time.Sleep(time.Until(started.Add(input.offset())))
+		r.HandleMessage(config.sourceNames()[0], msg)
+	}
+	time.Sleep(*tail)
+	r.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	fmt.Printf("Produced %d messages\n", len(produced))
+
+	if *expectFile == "" {
+		return nil
+	}
+	differences := compareRecordings(expected, produced)
+	if len(differences) == 0 {
+		fmt.Printf("Output matches %s\n", *expectFile)
+		return nil
+	}
+	for _, difference := range differences {
+		fmt.Println(difference)
+	}
+	return fmt.Errorf("output differs from %s", *expectFile)
+}
+
+// compareRecordings compares the message order of each output, ignoring timing, and describes
+// the first difference found for each output
+func compareRecordings(expected, produced []recordedMessage) []string {
+	byPort := func(messages []recordedMessage) map[string][]string {
+		ports := make(map[string][]string)
+		for _, message := range messages {
+			if message.Port == "" {
+				continue
+			}
+			data, _ := message.bytes()
+			if data.Is(midi.TimingClockMsg) {
+				continue
+			}
+			ports[message.Port] = append(ports[message.Port], fmt.Sprintf("% X", []byte(data)))
+		}
+		return ports
+	}
+	want := byPort(expected)
+	got := byPort(produced)
+
+	var ports []string
+	for port := range want {
+		ports = append(ports, port)
+	}
+	for port := range got {
+		if _, ok := want[port]; !ok {
+			ports = append(ports, port)
+		}
+	}
+	sort.Strings(ports)
+
+	var differences []string
+	for _, port := range ports {
+		w, g := want[port], got[port]
+		for i := 0; i < len(w) || i < len(g); i++ {
+			switch {
+			case i >= len(g):
+				differences = append(differences, fmt.Sprintf("%s: missing %d messages from #%d, first: %s",
+					port, len(w)-i, i+1, describeData(w[i])))
+			case i >= len(w):
+				differences = append(differences, fmt.Sprintf("%s: %d extra messages from #%d, first: %s",
+					port, len(g)-i, i+1, describeData(g[i])))
+			case w[i] != g[i]:
+				differences = append(differences, fmt.Sprintf("%s: message #%d is %s, expected %s",
+					port, i+1, describeData(g[i]), describeData(w[i])))
+			default:
+				continue
+			}
+			break
+		}
+	}
+	return differences
+}
+
+// describeData formats hex message data with its decoded form
+func describeData(data string) string {
+	message := recordedMessage{Data: data}
+	msg, err := message.bytes()
+	if err != nil {
+		return data
+	}
+	return fmt.Sprintf("[%s] %s", data, msg)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// ChannelRotationConfig spreads notes across a pool of channels, one note per channel,
+// so several mono synths or multitimbral parts can be played as one polyphonic instrument
+type ChannelRotationConfig struct {
+	Channels []uint8 `json:"channels"` // 1-16, in the order they are assigned
+}
+
+// Validate checks the channel rotation settings
+func (crc *ChannelRotationConfig) Validate() error {
+	if len(crc.Channels) < 2 {
+		return fmt.Errorf("channel rotation needs at least 2 channels")
+	}
+	seen := make(map[uint8]bool)
+	for _, channel := range crc.Channels {
+		if channel < 1 || channel > 16 {
+			return fmt.Errorf("invalid channel: %d (must be 1-16)", channel)
+		}
+		if seen[channel] {
+			return fmt.Errorf("channel %d is listed more than once", channel)
+		}
+		seen[channel] = true
+	}
+	return nil
+}
+
+// channelRotationState tracks which pool channel each sounding note was assigned to
+type channelRotationState struct {
+	pool     []uint8 // 0-based channels
+	next     int     // pool index to try first for the next note
+	assigned map[noteKey]uint8
+	holders  map[uint8]noteKey // 0-based channel -> note currently sounding on it
+}
+
+func newChannelRotationState(config *ChannelRotationConfig) *channelRotationState {
+	pool := make([]uint8, len(config.Channels))
+	for i, channel := range config.Channels {
+		pool[i] = channel - 1
+	}
+	return &channelRotationState{
+		pool:     pool,
+		assigned: make(map[noteKey]uint8),
+		holders:  make(map[uint8]noteKey),
+	}
+}
+
+// allocate picks a channel for a new note, preferring free channels in round-robin order.
+// When every channel is busy the next one in turn is stolen and its note is returned so it can be released.
+func (state *channelRotationState) allocate(k noteKey) (channel uint8, stolen *noteKey) {
+	index := -1
+	for offset := range state.pool {
+		candidate := (state.next + offset) % len(state.pool)
+		if _, busy := state.holders[state.pool[candidate]]; !busy {
+			index = candidate
+			break
+		}
+	}
+
+	if index < 0 {
+		index = state.next
+		previous := state.holders[state.pool[index]]
+		delete(state.assigned, previous)
+		stolen = &previous
+	}
+
+	channel = state.pool[index]
+	state.next = (index + 1) % len(state.pool)
+	state.assigned[k] = channel
+	state.holders[channel] = k
+	return channel, stolen
+}
+
+// release frees the channel a note was assigned to
+func (state *channelRotationState) release(k noteKey) {
+	delete(state.holders, state.assigned[k])
+	delete(state.assigned, k)
+}
+
+// rotateMessage rewrites a single message onto its rotation channel(s)
+func (state *channelRotationState) rotateMessage(msg midi.Message) []midi.Message {
+	var channel, key, velocity uint8
+
+	if msg.GetNoteStart(&channel, &key, &velocity) {
+		k := noteKey{channel, key}
+		var messages []midi.Message
+		// A re-triggered note gives up the channel it is sounding on before taking the next one,
+		// so the channel isn't held forever and the old voice doesn't hang
+		if previous, ok := state.assigned[k]; ok {
+			state.release(k)
+			messages = append(messages, midi.NoteOff(previous, key))
+		}
+		assigned, stolen := state.allocate(k)
+		if stolen != nil {
+			messages = append(messages, midi.NoteOff(assigned, stolen.Note))
+		}
+		return append(messages, midi.NoteOn(assigned, key, velocity))
+	}
+
+	if msg.GetNoteEnd(&channel, &key) {
+		k := noteKey{channel, key}
+		assigned, ok := state.assigned[k]
+		if !ok {
+			// The note was stolen by a newer note and has already been released
+			return nil
+		}
+		state.release(k)
+		return []midi.Message{withChannel(msg, assigned)}
+	}
+
+	var pressure uint8
+	if msg.GetPolyAfterTouch(&channel, &key, &pressure) {
+		if assigned, ok := state.assigned[noteKey{channel, key}]; ok {
+			return []midi.Message{withChannel(msg, assigned)}
+		}
+		return nil
+	}
+
+	// Other channel messages (pitch bend, controllers...) are sent to every channel in the pool
+	if hasChannelInfo(msg) {
+		messages := make([]midi.Message, len(state.pool))
+		for i, poolChannel := range state.pool {
+			messages[i] = withChannel(msg, poolChannel)
+		}
+		return messages
+	}
+
+	return []midi.Message{msg}
+}
+
+// applyChannelRotation moves each note, including harmony notes, onto its own channel from the pool
+func applyChannelRotation(msgs []midi.Message, state *channelRotationState, transform *MessageTransformation) []midi.Message {
+	if state == nil || len(msgs) == 0 {
+		return msgs
+	}
+
+	var rotated []midi.Message
+	for i, msg := range msgs {
+		out := state.rotateMessage(msg)
+		// Record the channel change of the played note for logging
+		if i == 0 && len(out) > 0 && hasChannelInfo(msg) {
+			originalChannel := extractChannelFromMessage(msg)
+			transformedChannel := extractChannelFromMessage(out[len(out)-1])
+			transform.OriginalChannel = &originalChannel
+			transform.TransformedChannel = &transformedChannel
+		}
+		rotated = append(rotated, out...)
+	}
+	return rotated
+}
+
+// withChannel returns a copy of a channel message moved to the given 0-based channel
+func withChannel(msg midi.Message, channel uint8) midi.Message {
+	newMsg := make(midi.Message, len(msg))
+	copy(newMsg, msg)
+	newMsg[0] = (newMsg[0] & 0xF0) | (channel & 0x0F)
+	return newMsg
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+func TestChannelRotation(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []midi.Message
+		want  []string // messages sent for the last step
+	}{
+		{
+			"first note takes the first channel",
+			[]midi.Message{midi.NoteOn(0, 60, 100)},
+			[]string{"90 3C 64"},
+		},
+		{
+			"second note takes the next channel",
+			[]midi.Message{midi.NoteOn(0, 60, 100), midi.NoteOn(0, 62, 100)},
+			[]string{"91 3E 64"},
+		},
+		{
+			"note off goes to the note's channel",
+			[]midi.Message{midi.NoteOn(0, 60, 100), midi.NoteOn(0, 62, 100), midi.NoteOff(0, 62)},
+			[]string{"81 3E 00"},
+		},
+		{
+			"re-triggered note releases its channel first",
+			[]midi.Message{midi.NoteOn(0, 60, 100), midi.NoteOn(0, 60, 90)},
+			[]string{"80 3C 00", "91 3C 5A"},
+		},
+		{
+			"note off after a re-trigger ends the new voice",
+			[]midi.Message{midi.NoteOn(0, 60, 100), midi.NoteOn(0, 60, 90), midi.NoteOff(0, 60)},
+			[]string{"81 3C 00"},
+		},
+		{
+			"re-triggered notes don't keep channels busy",
+			[]midi.Message{midi.NoteOn(0, 60, 100), midi.NoteOn(0, 60, 100), midi.NoteOn(0, 60, 100), midi.NoteOff(0, 60), midi.NoteOn(0, 62, 100), midi.NoteOn(0, 64, 100)},
+			[]string{"90 40 64"},
+		},
+		{
+			"exhausted pool steals the oldest channel",
+			[]midi.Message{midi.NoteOn(0, 60, 100), midi.NoteOn(0, 62, 100), midi.NoteOn(0, 64, 100)},
+			[]string{"80 3C 00", "90 40 64"},
+		},
+		{
+			"stolen note's note off is dropped",
+			[]midi.Message{midi.NoteOn(0, 60, 100), midi.NoteOn(0, 62, 100), midi.NoteOn(0, 64, 100), midi.NoteOff(0, 60)},
+			nil,
+		},
+		{
+			"controllers go to every channel in the pool",
+			[]midi.Message{midi.ControlChange(0, 1, 64)},
+			[]string{"B0 01 40", "B1 01 40"},
+		},
+	}
+	for _, test := range tests {
+		state := newChannelRotationState(&ChannelRotationConfig{Channels: []uint8{1, 2}})
+		var sent []midi.Message
+		for _, step := range test.steps {
+			sent = state.rotateMessage(step)
+		}
+		var got []string
+		for _, msg := range sent {
+			got = append(got, fmt.Sprintf("% X", []byte(msg)))
+		}
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%s: sent %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,766 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// router applies a configuration's filters and processing to incoming messages and sends the
+// results to one sender per output. It knows nothing about MIDI ports, so it can also route
+// recorded messages.
+type router struct {
+	// Serializes incoming messages and timed gesture events
+	mu sync.Mutex
+
+	config  *Config
+	logging logOptions
+	senders []func(midi.Message) error
+	enabled []atomic.Bool            // outputs switched on, changed while running by SetOutputEnabled
+	loops   *loopDetector            // nil when loop detection is off
+	mirror  func(midi.Message) error // the debug output, nil without one
+
+	// Inputs whose messages reach the thru outputs and the debug output before they are parsed
+	rawInputs map[string]bool
+
+	// Log lines name the input of each message when several inputs are merged
+	showInputs bool
+
+	harmonizers      []*harmonizerState
+	probabilityGates []*probabilityGate
+	velocityGates    []*velocityGate
+	chordGates       []*chordGate
+	gestures         []*gestureState
+	takeovers        []*softTakeover
+	rotations        []*channelRotationState
+	octaveShifts     []*octaveShiftState
+	scaleNotes       []*scaleNoteState
+	chokes           []*chokeState
+	strums           []*strumState
+	repeats          []*noteRepeatState
+	envelopes        []*envelopeState
+	tunings          []*tuningState
+
+	// Global key, changed by the key control
+	key *keyState
+
+	// Driver timestamp of the incoming message being handled, nil for timed events
+	driverMS *int32
+
+	// Notes held on the inputs, for random controllers that only move while playing
+	heldNotes map[noteKey]bool
+
+	// Scheduler for delayed messages such as echoes
+	sched *scheduler
+	clock *clockTracker
+	stats *routeStats
+
+	// Internal clock generator with tap tempo, or regenerated input clock
+	generator *clockGenerator
+	taps      *tapTempo
+
+	// Stops background senders such as Active Sensing, gain reassertion and LFOs
+	stopBackground chan struct{}
+}
+
+// newRouter sets up the routing state for a configuration. senders holds one sender per output.
+func newRouter(config *Config, senders []func(midi.Message) error, logging logOptions) (*router, error) {
+	r := &router{
+		config:           config,
+		logging:          logging,
+		senders:          slices.Clone(senders),
+		enabled:          make([]atomic.Bool, len(config.Outputs)),
+		harmonizers:      make([]*harmonizerState, len(config.Outputs)),
+		probabilityGates: make([]*probabilityGate, len(config.Outputs)),
+		velocityGates:    make([]*velocityGate, len(config.Outputs)),
+		chordGates:       make([]*chordGate, len(config.Outputs)),
+		takeovers:        make([]*softTakeover, len(config.Outputs)),
+		rotations:        make([]*channelRotationState, len(config.Outputs)),
+		octaveShifts:     make([]*octaveShiftState, len(config.Outputs)),
+		scaleNotes:       make([]*scaleNoteState, len(config.Outputs)),
+		chokes:           make([]*chokeState, len(config.Outputs)),
+		strums:           make([]*strumState, len(config.Outputs)),
+		repeats:          make([]*noteRepeatState, len(config.Outputs)),
+		envelopes:        make([]*envelopeState, len(config.Outputs)),
+		tunings:          make([]*tuningState, len(config.Outputs)),
+		heldNotes:        make(map[noteKey]bool),
+		rawInputs:        make(map[string]bool),
+		clock:            &clockTracker{},
+		showInputs:       len(config.sourceNames()) > 1,
+		taps:             &tapTempo{},
+	}
+
+	if config.detectsLoops() {
+		r.loops = newLoopDetector()
+		for i, send := range r.senders {
+			r.senders[i] = r.loops.sender(send)
+		}
+	}
+
+	outputNames := make([]string, len(config.Outputs))
+	for i, outputConfig := range config.Outputs {
+		outputNames[i] = outputConfig.Name
+
+		if outputConfig.ClockWhileRunning {
+			r.senders[i] = gateClock(r.senders[i])
+		}
+		r.enabled[i].Store(outputConfig.isEnabled())
+		r.senders[i] = enabledSender(&r.enabled[i], r.senders[i])
+
+		if outputConfig.Harmonizer != nil {
+			r.harmonizers[i] = newHarmonizerState()
+		}
+		if outputConfig.Probability != nil {
+			r.probabilityGates[i] = newProbabilityGate(*outputConfig.Probability)
+		}
+		if outputConfig.MinVelocity != nil {
+			r.velocityGates[i] = newVelocityGate(*outputConfig.MinVelocity)
+		}
+		if outputConfig.ChordFilter != nil {
+			r.chordGates[i] = newChordGate(outputConfig.ChordFilter)
+		}
+		if outputConfig.SoftTakeover {
+			// Controls pick up the values the outputs had before the configuration changed
+			r.takeovers[i] = newSoftTakeover(sentControllers.Values(outputConfig.Name))
+		}
+		if outputConfig.ChannelRotation != nil {
+			r.rotations[i] = newChannelRotationState(outputConfig.ChannelRotation)
+		}
+		if outputConfig.OctaveShift != nil {
+			r.octaveShifts[i] = newOctaveShiftState(outputConfig.OctaveShift)
+		}
+		if outputConfig.ScaleQuantize || outputConfig.ScaleTranspose != 0 {
+			r.scaleNotes[i] = newScaleNoteState()
+		}
+		if len(outputConfig.ChokeGroups) > 0 {
+			r.chokes[i] = newChokeState(outputConfig.ChokeGroups)
+		}
+		if outputConfig.Strum != nil {
+			r.strums[i] = newStrumState(outputConfig.Strum, r.outputName(i), r.scheduleLocked, func(m midi.Message) error {
+				return r.sendTo(i, m)
+			})
+		}
+		if outputConfig.NoteRepeat != nil {
+			r.repeats[i] = newNoteRepeatState(outputConfig.NoteRepeat, r.outputName(i), r.clock, r.scheduleLocked, func(m midi.Message) error {
+				return r.sendTo(i, m)
+			})
+		}
+		if outputConfig.Envelope != nil {
+			r.envelopes[i] = newEnvelopeState(outputConfig.Envelope, r.outputName(i), r.scheduleLocked, func(m midi.Message) error {
+				return r.sendTo(i, m)
+			})
+		}
+		if outputConfig.Tuning != nil {
+			tuning, err := newTuningState(outputConfig.Tuning)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load tuning for output %d: %w", i+1, err)
+			}
+			r.tunings[i] = tuning
+		}
+	}
+	r.stats = newRouteStats(outputNames)
+
+	if config.Key != nil {
+		key, err := newKeyState(config.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key: %w", err)
+		}
+		r.key = key
+	}
+
+	for i := range config.Gestures {
+		r.gestures = append(r.gestures, newGestureState(&config.Gestures[i]))
+	}
+
+	return r, nil
+}
+
+// Start sends startup messages such as MTS tunings and starts the scheduler and clock
+func (r *router) Start() error {
+	for i, outputConfig := range r.config.Outputs {
+		if outputConfig.MTS != nil {
+			if err := sendMTS(outputConfig.MTS, r.senders[i]); err != nil {
+				return fmt.Errorf("failed to send MTS tuning to output %d: %w", i+1, err)
+			}
+		}
+		if outputConfig.Gain != nil {
+			if err := sendGain(&outputConfig, r.senders[i]); err != nil {
+				return fmt.Errorf("failed to send gain to output %d: %w", i+1, err)
+			}
+		}
+	}
+
+	r.sched = newScheduler()
+
+	r.stopBackground = make(chan struct{})
+	stopBackground := r.stopBackground
+	goFailsafe(func() { generateActiveSensing(r.config, r.senders, stopBackground) })
+	goFailsafe(func() { runLFOs(r.config, r.clock, r.sendTo, stopBackground) })
+	goFailsafe(func() { runRandomCCs(r.config, r.playing, r.sendTo, stopBackground) })
+	for i := range r.config.Outputs {
+		if gain := r.config.Outputs[i].Gain; gain != nil && gain.ReassertSeconds > 0 {
+			goFailsafe(func() { reassertGain(&r.config.Outputs[i], r.outputName(i), r.senders[i], stopBackground) })
+		}
+	}
+
+	if r.config.Clock != nil {
+		var clockOutputs []int
+		for i, outputConfig := range r.config.Outputs {
+			if outputConfig.Thru {
+				continue
+			}
+			if sendsTo(r.config.Clock.Outputs, &outputConfig) {
+				clockOutputs = append(clockOutputs, i)
+			}
+		}
+
+		sendClock := func(msg midi.Message) {
+			for _, i := range clockOutputs {
+				outputMsg, ok := applySongPosition(msg, r.config.Outputs[i].SongPosition)
+				if !ok {
+					continue
+				}
+				outputMsgs := []midi.Message{outputMsg}
+				if mapped, handled := applyTransportMap(outputMsg, r.config.Outputs[i].TransportMap); handled {
+					outputMsgs = mapped
+				}
+				for _, m := range outputMsgs {
+					if err := r.senders[i](m); err != nil {
+						sendErrors.Printf(r.outputName(i), "Error sending clock to %s: %v", r.outputName(i), err)
+					}
+				}
+			}
+		}
+		if r.config.Clock.regenerates() {
+			r.generator = newClockRegenerator(r.clock, sendClock)
+		} else {
+			r.generator = newClockGenerator(r.config.Clock.BPM, sendClock, r.clock)
+		}
+		r.generator.Start(r.config.Clock.StartPosition)
+	}
+
+	return nil
+}
+
+// Stop stops the clock and drops pending delayed messages
+func (r *router) Stop() {
+	if r.generator != nil {
+		r.generator.Stop()
+	}
+	if r.sched != nil {
+		r.sched.Stop()
+	}
+	if r.stopBackground != nil {
+		close(r.stopBackground)
+		r.stopBackground = nil
+	}
+}
+
+// handleRepeatToggles switches the note repeat of outputs whose toggle control is pressed.
+// Returns whether the message was a toggle control.
+func (r *router) handleRepeatToggles(msg midi.Message) bool {
+	handled := false
+	for i, repeat := range r.repeats {
+		if repeat == nil {
+			continue
+		}
+		matched, fired := repeat.config.Toggle.Match(msg)
+		if !matched {
+			continue
+		}
+		handled = true
+		if fired {
+			if repeat.Toggle() {
+				fmt.Printf("Note repeat on for %s\n", r.outputName(i))
+			} else {
+				fmt.Printf("Note repeat off for %s\n", r.outputName(i))
+			}
+		}
+	}
+	return handled
+}
+
+// playing reports whether a note is held on any input
+func (r *router) playing() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.heldNotes) > 0
+}
+
+// handleOctaveShifts raises or lowers the octave shift of outputs whose up or down control is
+// pressed, and shows the new shift. Returns whether the message was a shift control.
+func (r *router) handleOctaveShifts(msg midi.Message) bool {
+	handled := false
+	for i, shift := range r.octaveShifts {
+		if shift == nil {
+			continue
+		}
+		for _, control := range []struct {
+			trigger *TriggerConfig
+			octaves int
+		}{{shift.config.Up, 1}, {shift.config.Down, -1}} {
+			matched, fired := control.trigger.Match(msg)
+			if !matched {
+				continue
+			}
+			handled = true
+			if fired && shift.Shift(control.octaves) {
+				fmt.Printf("Octave shift for %s: %+d\n", r.outputName(i), shift.octaves)
+			}
+		}
+	}
+	return handled
+}
+
+// inputLabel returns the input name to show in log lines, empty with a single input
+func (r *router) inputLabel(input string) string {
+	if !r.showInputs {
+		return ""
+	}
+	return input
+}
+
+// sendTo sends a processed message to an output and keeps track of its controller state
+func (r *router) sendTo(i int, msg midi.Message) error {
+	if err := r.senders[i](msg); err != nil {
+		return err
+	}
+	sentControllers.Update(r.config.Outputs[i].Name, msg)
+	return nil
+}
+
+// scheduleLocked runs fn after the given delay, serialized with incoming messages
+func (r *router) scheduleLocked(delay time.Duration, fn func()) {
+	r.sched.Schedule(delay, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		fn()
+	})
+}
+
+// outputName returns the full port name of an output
+func (r *router) outputName(i int) string {
+	return r.config.outputPortName(i)
+}
+
+// TapTempo registers a tap of the tap tempo control
+func (r *router) TapTempo() {
+	if bpm, ok := r.taps.Tap(time.Now()); ok {
+		r.generator.SetBPM(bpm)
+		fmt.Printf("Tempo: %.1f BPM\n", r.generator.BPM())
+	}
+}
+
+// RestoreControllers sends every output the programs, controller values and pitch bends it was
+// last sent, for a synth that was switched on after the router started
+func (r *router) RestoreControllers() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, output := range r.config.Outputs {
+		msgs := sentControllers.Messages(output.Name)
+		for _, msg := range msgs {
+			if err := r.senders[i](msg); err != nil {
+				log.Printf("Error restoring controllers of %s: %v", output.Name, err)
+				break
+			}
+		}
+		if len(msgs) > 0 {
+			fmt.Printf("Restored %d controller values to %s\n", len(msgs), output.Name)
+		}
+	}
+}
+
+// HandleMessage routes a single incoming message to the outputs. input is the name of the input
+// device the message arrived on.
+func (r *router) HandleMessage(input string, msg midi.Message) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handleMessage(input, msg)
+}
+
+// HandleMessageAt routes an incoming message like HandleMessage, with the timestamp the driver
+// attached to it in milliseconds since the input was opened
+func (r *router) HandleMessageAt(input string, msg midi.Message, driverMS int32) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.driverMS = &driverMS
+	defer func() { r.driverMS = nil }()
+	r.handleMessage(input, msg)
+}
+
+func (r *router) handleMessage(input string, msg midi.Message) {
+	config := r.config
+
+	if r.logging.RecordInput != nil {
+		if err := r.logging.RecordInput.RecordReceived(msg, r.driverMS); err != nil {
+			log.Printf("Error recording message: %v", err)
+		}
+	}
+
+	// Raw inputs have already been checked for feedback and sent to the thru outputs by HandleRawAt
+	if !r.rawInputs[input] {
+		// Messages the outputs sent that came back in a feedback loop would be sent again forever
+		if r.loops.Looping(input, msg, time.Now()) {
+			r.stats.Dropped(msg)
+			return
+		}
+
+		// Thru outputs and the debug output get every message as it arrived, before any control handling
+		r.sendMirror(msg)
+		r.sendThru(input, msg)
+	}
+	if r.thruOnly(msg) {
+		return
+	}
+
+	if msg.Is(midi.TimingClockMsg) {
+		r.clock.Tick(time.Now())
+	}
+
+	var channel, key, velocity uint8
+	switch {
+	case msg.GetNoteStart(&channel, &key, &velocity):
+		r.heldNotes[noteKey{channel, key}] = true
+	case msg.GetNoteEnd(&channel, &key):
+		delete(r.heldNotes, noteKey{channel, key})
+	}
+
+	// A regenerated clock replaces the input's clock, and passes its transport through
+	if config.Clock != nil && config.Clock.regenerates() {
+		switch {
+		case msg.Is(midi.TimingClockMsg):
+			r.generator.InputTick()
+			return
+		case msg.Is(midi.StartMsg), msg.Is(midi.StopMsg), msg.Is(midi.ContinueMsg):
+			r.generator.Transport(msg)
+			return
+		}
+	}
+
+	if config.Clock != nil && !config.Clock.regenerates() {
+		// Follow song position changes sent by the input
+		var position uint16
+		if msg.GetSPP(&position) {
+			r.generator.SetSongPosition(position)
+		}
+
+		if matched, fired := config.Clock.TransportTrigger.Match(msg); matched {
+			if fired {
+				if r.generator.ToggleTransport() {
+					fmt.Println("Clock continued")
+				} else {
+					fmt.Println("Clock stopped")
+				}
+			}
+			return
+		}
+	}
+
+	// Tap tempo button presses control the router and are not routed
+	if config.Clock != nil && config.Clock.TapTempo != nil {
+		if matched, fired := config.Clock.TapTempo.Trigger.Match(msg); matched {
+			if fired {
+				r.TapTempo()
+			}
+			return
+		}
+	}
+
+	// Note repeat toggles switch repeating on and off and are not routed
+	if r.handleRepeatToggles(msg) {
+		return
+	}
+
+	// Octave shift controls change the shift of their outputs and are not routed
+	if r.handleOctaveShifts(msg) {
+		return
+	}
+
+	// The key control changes the global key and is not routed
+	if r.key != nil {
+		if matched, changed := r.key.Control(msg); matched {
+			if changed {
+				fmt.Printf("Key: %s\n", r.key)
+			}
+			return
+		}
+	}
+
+	// Macro triggers fire their macros and are not routed
+	if r.handleMacroTriggers(msg) {
+		return
+	}
+
+	// Group toggles switch layers of outputs and are not routed
+	if r.handleGroupToggles(msg) {
+		return
+	}
+
+	// Double taps and long presses of gesture triggers send other events
+	if r.handleGestures(input, msg) {
+		return
+	}
+
+	r.route(input, msg)
+}
+
+// handleGestures passes presses and releases of gesture triggers to their detectors, and routes
+// the original message and gesture events as they decide. The original is only routed if every
+// gesture on the control agrees. Returns false if msg is not a trigger.
+func (r *router) handleGestures(input string, msg midi.Message) bool {
+	matchedAny := false
+	routeOriginal := true
+	var events []midi.Message
+
+	for _, gesture := range r.gestures {
+		matched, pressed := gesture.config.Trigger.Match(msg)
+		if !matched {
+			continue
+		}
+		matchedAny = true
+
+		var msgs []midi.Message
+		var route bool
+		if pressed {
+			msgs, route = gesture.Press(msg[0]&0x0F, time.Now())
+			if gesture.config.Gesture == "long_press" {
+				press := gesture.press
+				r.sched.Schedule(gesture.config.duration(), func() {
+					r.mu.Lock()
+					defer r.mu.Unlock()
+					for _, m := range gesture.Held(press) {
+						r.route(input, m)
+					}
+				})
+			}
+		} else {
+			msgs, route = gesture.Release()
+		}
+		routeOriginal = routeOriginal && route
+		events = append(events, msgs...)
+	}
+
+	if !matchedAny {
+		return false
+	}
+	if routeOriginal {
+		r.route(input, msg)
+	}
+	for _, m := range events {
+		r.route(input, m)
+	}
+	return true
+}
+
+// route sends a message from an input to every output whose filters accept it
+func (r *router) route(input string, msg midi.Message) {
+	config := r.config
+	anyRouted := false
+	// Route groups that already delivered this message to an output
+	var claimedGroups map[string]bool
+	// An output that stops on match took the message, so no later output receives it
+	stopped := false
+
+	for i, outputConfig := range config.Outputs {
+		if !r.enabled[i].Load() {
+			continue
+		}
+		// Thru outputs already got the message from handleMessage
+		if outputConfig.Thru {
+			anyRouted = anyRouted || outputConfig.acceptsInput(config, input)
+			continue
+		}
+		// Catch dropped outputs only get the messages no other output took
+		if outputConfig.CatchDropped {
+			continue
+		}
+		if stopped || (outputConfig.RouteGroup != "" && claimedGroups[outputConfig.RouteGroup]) {
+			continue
+		}
+
+		// An output whose filters took the message counts even if its processing sent nothing,
+		// such as a control held back by soft takeover
+		matched, _ := r.routeTo(i, input, msg)
+		anyRouted = anyRouted || matched
+		if !matched {
+			continue
+		}
+		if outputConfig.RouteGroup != "" && !outputConfig.Continue {
+			if claimedGroups == nil {
+				claimedGroups = make(map[string]bool)
+			}
+			claimedGroups[outputConfig.RouteGroup] = true
+		}
+		if outputConfig.StopOnMatch || (config.ExclusiveRouting && !outputConfig.Continue) {
+			stopped = true
+		}
+	}
+
+	if !anyRouted {
+		for i, outputConfig := range config.Outputs {
+			if outputConfig.CatchDropped && r.enabled[i].Load() {
+				matched, _ := r.routeTo(i, input, msg)
+				anyRouted = anyRouted || matched
+			}
+		}
+	}
+
+	// Log dropped message if no outputs were successful
+	if !anyRouted {
+		logDroppedMessage(msg, r.inputLabel(input), r.driverMS, r.logging)
+		r.stats.Dropped(msg)
+		if r.logging.CaptureDropped != nil {
+			if err := r.logging.CaptureDropped.Record("", msg); err != nil {
+				log.Printf("Error capturing dropped message: %v", err)
+			}
+		}
+	}
+}
+
+// routeTo sends a message from an input to an output if its filters accept it. Returns whether
+// the filters matched, and whether anything was sent.
+func (r *router) routeTo(i int, input string, msg midi.Message) (matched, routed bool) {
+	outputConfig := &r.config.Outputs[i]
+	if !outputConfig.acceptsInput(r.config, input) ||
+		!shouldRouteMessage(msg, outputConfig) ||
+		(r.velocityGates[i] != nil && !r.velocityGates[i].ShouldPass(msg)) ||
+		(r.chordGates[i] != nil && !r.chordGates[i].ShouldPass(msg)) ||
+		(r.probabilityGates[i] != nil && !r.probabilityGates[i].ShouldPass(msg)) {
+		return false, false
+	}
+	fullName := r.outputName(i)
+
+	// Send the untouched message alongside the processed ones
+	if outputConfig.Dry != nil {
+		r.sendDry(i, input, msg)
+	}
+
+	// Forward, rewrite or suppress song position pointers
+	msgToSend, ok := applySongPosition(msg, outputConfig.SongPosition)
+	if !ok {
+		return false, false
+	}
+
+	// Replace or block transport messages, skipping the other processing
+	if mapped, handled := applyTransportMap(msgToSend, outputConfig.TransportMap); handled {
+		for _, m := range mapped {
+			if err := r.senders[i](m); err != nil {
+				sendErrors.Printf(fullName, "Error sending to %s: %v", fullName, err)
+				r.stats.Error(i)
+			} else {
+				logSuccessfulRoute(fullName, outputColor(outputConfig, i), m, &MessageTransformation{Input: r.inputLabel(input), DriverMS: r.driverMS}, r.logging)
+				r.stats.Routed(i, m)
+				routed = true
+			}
+		}
+		return true, routed
+	}
+
+	routed = r.processAndSend(i, input, msg, msgToSend)
+
+	// Start the held notes of a chord that just became big enough
+	if r.chordGates[i] != nil && isNoteStart(msg) {
+		for _, held := range r.chordGates[i].CatchUp() {
+			r.processAndSend(i, input, held, held)
+		}
+	}
+	return true, routed
+}
+
+// processAndSend applies an output's processing to a message that passed its filters and sends
+// the result. msg is the incoming message from input, for logging, and msgToSend the message to
+// process. Returns whether the message was sent.
+func (r *router) processAndSend(i int, input string, msg, msgToSend midi.Message) bool {
+	output := &r.config.Outputs[i]
+	fullName := r.outputName(i)
+
+	// Initialize transformation tracking for this output
+	outputTransform := &MessageTransformation{Input: r.inputLabel(input), DriverMS: r.driverMS}
+
+	// Apply drum kit processing in drums mode
+	if output.Mode == "drums" {
+		var ok bool
+		if msgToSend, ok = applyDrums(msgToSend, output.drums(), outputTransform); !ok {
+			return false
+		}
+	}
+	// Apply channel override if configured
+	msgToSend = applyChannelOverride(msgToSend, output.OverrideChannel, outputTransform)
+	// Rescale controller ranges if configured
+	msgToSend = applyCCRanges(msgToSend, output.CCRanges, outputTransform)
+	// Apply note transposition if configured
+	msgToSend = applyNoteTransposition(msgToSend, output.TransposeSemitones, outputTransform)
+	// Apply the current octave shift if configured
+	msgToSend = applyOctaveShift(msgToSend, r.octaveShifts[i], outputTransform)
+	// Snap notes to the global key and move them by scale steps if configured
+	msgToSend = applyScaleNotes(msgToSend, output, r.key, r.scaleNotes[i], outputTransform)
+	// Compress note velocities if configured
+	msgToSend = applyVelocityCompressor(msgToSend, output.VelocityCompressor, outputTransform)
+	// Add harmony notes if configured
+	msgsToSend := applyHarmonizer(msgToSend, output.Harmonizer, r.key, r.harmonizers[i], outputTransform)
+	// Keep notes within the output's note limits if configured
+	msgsToSend = applyNoteLimits(msgsToSend, output, outputTransform)
+	// Spread notes across the channel rotation pool if configured
+	msgsToSend = applyChannelRotation(msgsToSend, r.rotations[i], outputTransform)
+	// Copy messages to additional channels if configured
+	msgsToSend = applyChannelDuplication(msgsToSend, output.DuplicateChannels, outputTransform)
+	// Retune notes with per-note pitch bend if configured
+	msgsToSend = applyTuning(msgsToSend, r.tunings[i], outputTransform)
+	// End the notes choked by a note start if choke groups are configured
+	msgsToSend = applyChokeGroups(msgsToSend, r.chokes[i])
+	if len(msgsToSend) == 0 {
+		return false
+	}
+
+	// Hold back controls that haven't picked up the output's value yet
+	if r.takeovers[i] != nil {
+		msgsToSend = slices.DeleteFunc(msgsToSend, func(m midi.Message) bool { return !r.takeovers[i].Pass(m) })
+		if len(msgsToSend) == 0 {
+			return false
+		}
+	}
+
+	// Start or release the controller envelope, before the note so it sounds from the start value
+	if r.envelopes[i] != nil {
+		r.envelopes[i].Track(msgsToSend)
+	}
+
+	var err error
+	if r.strums[i] != nil {
+		// Hold back chord notes to send them one after another
+		err = r.strums[i].Send(msgsToSend)
+	} else {
+		for _, m := range msgsToSend {
+			if err = r.sendTo(i, m); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		sendErrors.Printf(fullName, "Error sending to %s: %v", fullName, err)
+		r.stats.Error(i)
+		return false
+	}
+
+	// Log successful route immediately with per-output transformations
+	outputTransform.Sent = msgsToSend
+	logSuccessfulRoute(fullName, outputColor(output, i), msg, outputTransform, r.logging)
+	r.stats.Routed(i, msg)
+
+	// Schedule echo repeats if configured
+	for _, m := range msgsToSend {
+		applyEcho(m, output.Echo, r.sched, r.clock, fullName, r.senders[i])
+	}
+
+	// Retrigger held notes if note repeat is configured
+	if r.repeats[i] != nil {
+		r.repeats[i].Track(msgsToSend)
+	}
+	return true
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// testRouter sets up a router whose outputs record what they are sent. An output named "Broken"
+// fails every send.
+func testRouter(t *testing.T, outputs []OutputConfig) (*router, map[string][]string) {
+	t.Helper()
+	sent := make(map[string][]string)
+	senders := make([]func(midi.Message) error, len(outputs))
+	for i, output := range outputs {
+		name := output.Name
+		senders[i] = func(msg midi.Message) error {
+			if name == "Broken" {
+				return errors.New("broken output")
+			}
+			sent[name] = append(sent[name], fmt.Sprintf("% X", []byte(msg)))
+			return nil
+		}
+	}
+	r, err := newRouter(&Config{OutputBase: "Test", Outputs: outputs}, senders, logOptions{Quiet: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, sent
+}
+
+func TestCatchDropped(t *testing.T) {
+	channel1 := &ChannelFilter{Channel: 1}
+
+	tests := []struct {
+		name    string
+		outputs []OutputConfig
+		msg     midi.Message
+		caught  bool
+	}{
+		{
+			"no filter matched",
+			[]OutputConfig{{Name: "Keys", ChannelFilter: channel1}, {Name: "Catch", CatchDropped: true}},
+			midi.NoteOn(1, 60, 100),
+			true,
+		},
+		{
+			"a filter matched",
+			[]OutputConfig{{Name: "Keys", ChannelFilter: channel1}, {Name: "Catch", CatchDropped: true}},
+			midi.NoteOn(0, 60, 100),
+			false,
+		},
+		{
+			"matched by the loser of a route group",
+			[]OutputConfig{
+				{Name: "Lower", RouteGroup: "split", ChannelFilter: channel1},
+				{Name: "Upper", RouteGroup: "split", ChannelFilter: channel1},
+				{Name: "Catch", CatchDropped: true},
+			},
+			midi.NoteOn(0, 60, 100),
+			false,
+		},
+		{
+			"matched by an output that failed to send",
+			[]OutputConfig{{Name: "Broken", ChannelFilter: channel1}, {Name: "Catch", CatchDropped: true}},
+			midi.NoteOn(0, 60, 100),
+			false,
+		},
+		{
+			"disabled output doesn't take the message",
+			[]OutputConfig{{Name: "Keys", ChannelFilter: channel1, Enabled: new(bool)}, {Name: "Catch", CatchDropped: true}},
+			midi.NoteOn(0, 60, 100),
+			true,
+		},
+	}
+	for _, test := range tests {
+		r, sent := testRouter(t, test.outputs)
+		r.HandleMessageAt("Input", test.msg, 0)
+		if caught := len(sent["Catch"]) > 0; caught != test.caught {
+			t.Errorf("%s: caught = %v, want %v (sent %v)", test.name, caught, test.caught, sent)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// routersConfig is a configuration file that runs several independent routers in one process,
+// such as one for each controller. Each router is a complete configuration with its own inputs,
+// outputs and filters.
+type routersConfig struct {
+	Routers []json.RawMessage `json:"routers"`
+}
+
+// loadRouters loads the routers of a configuration file that lists several, and checks that they
+// can run side by side. Returns nil without an error when the file holds a single router.
+func loadRouters(filename string) ([]*Config, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		// Reported when the configuration is loaded as a single router
+		return nil, nil
+	}
+	var file routersConfig
+	if err := json.Unmarshal(data, &file); err != nil || file.Routers == nil {
+		return nil, nil
+	}
+	if len(file.Routers) == 0 {
+		return nil, fmt.Errorf("no routers configured")
+	}
+
+	var configs []*Config
+	for i, data := range file.Routers {
+		config, err := parseConfig(data)
+		if err != nil {
+			return nil, fmt.Errorf("router %d: %w", i+1, err)
+		}
+		if err := validateConfigStructure(config); err != nil {
+			return nil, fmt.Errorf("router %d is invalid: %w", i+1, err)
+		}
+		configs = append(configs, config)
+	}
+	if err := validateRouters(configs); err != nil {
+		return nil, err
+	}
+	return configs, nil
+}
+
+// validateRouters checks that routers running in one process don't create the same ports or
+// share the terminal
+func validateRouters(configs []*Config) error {
+	ports := make(map[string]int)
+	claim := func(i int, port string) error {
+		if other, ok := ports[port]; ok && other != i {
+			return fmt.Errorf("routers %d and %d both create the port %s", other+1, i+1, port)
+		}
+		ports[port] = i
+		return nil
+	}
+
+	webSocketInput := -1
+	for i, config := range configs {
+		if config.Driver != configs[0].Driver {
+			return fmt.Errorf("router %d uses another driver than router 1, the routers share one", i+1)
+		}
+		if (config.RawInput != nil && config.RawInput.Path == "-") || config.readsHotkeys() || config.writesStdout() {
+			return fmt.Errorf("router %d reads or writes the terminal, which several routers can't share", i+1)
+		}
+		if config.WebSocketInput != "" {
+			if webSocketInput >= 0 {
+				return fmt.Errorf("routers %d and %d both set websocket_input, only one router can take it", webSocketInput+1, i+1)
+			}
+			webSocketInput = i
+		}
+
+		for j, output := range config.Outputs {
+			if output.isVirtual() {
+				if err := claim(i, config.outputPortName(j)); err != nil {
+					return err
+				}
+			}
+		}
+		if config.DebugOutput != "" {
+			if err := claim(i, config.debugPortName()); err != nil {
+				return err
+			}
+		}
+		for _, name := range config.virtualInputNames() {
+			if err := claim(i, name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkRouterDevices checks that the devices of every router are connected, or waits for them
+// when wait is set
+func checkRouterDevices(configs []*Config, drv midiDriver, wait bool) error {
+	for i, config := range configs {
+		if wait {
+			waitForDevices(config, drv)
+			continue
+		}
+		if config.RawInput != nil {
+			continue
+		}
+		if err := validateInputDevices(config, drv); err != nil {
+			return fmt.Errorf("router %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// runRouters runs several routers at once until they are interrupted. If one of them fails, the
+// others are stopped.
+func runRouters(drv midiDriver, configs []*Config, logging logOptions) error {
+	// Control mode, the editor and WebSocket macros act on a single router
+	logging.Running = nil
+
+	done := make(chan struct{})
+	results := make(chan error, len(configs))
+	for i, config := range configs {
+		go func() {
+			defer recoverFailsafe()
+			err := runMIDIRouter(drv, config, logging, done)
+			if err != nil {
+				err = fmt.Errorf("router %d: %w", i+1, err)
+			}
+			results <- err
+		}()
+	}
+
+	var first error
+	for range configs {
+		if err := <-results; err != nil && first == nil {
+			first = err
+			close(done)
+		}
+	}
+	return first
+}
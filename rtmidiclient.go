@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"gitlab.com/gomidi/midi/v2/drivers"
+	"gitlab.com/gomidi/midi/v2/drivers/rtmididrv/imported/rtmidi"
+)
+
+// rtmidiClient opens rtmidi ports under a custom client name, with a chosen rtmidi API.
+// rtmididrv always uses rtmidi's default client names and API, which makes several router
+// instances indistinguishable in aconnect and Audio MIDI Setup. It is also a MIDI driver, for
+// the backends that select an rtmidi API.
+type rtmidiClient struct {
+	name string     // rtmidi's default client names when empty
+	api  rtmidi.API // the first compiled API that works when unspecified
+}
+
+// newAPIClient returns a driver for an rtmidi API, failing when rtmidi was built without it
+func newAPIClient(api rtmidi.API) (midiDriver, error) {
+	if !slices.Contains(rtmidi.CompiledAPI(), api) {
+		return nil, fmt.Errorf("this build of rtmidi has no %s support", api)
+	}
+	return &rtmidiClient{api: api}, nil
+}
+
+// inName and outName return the client names of input and output ports
+func (c *rtmidiClient) inName() string {
+	if c.name == "" {
+		return "RtMidi Input Client"
+	}
+	return c.name
+}
+
+func (c *rtmidiClient) outName() string {
+	if c.name == "" {
+		return "RtMidi Output Client"
+	}
+	return c.name
+}
+
+func (c *rtmidiClient) String() string { return "rtmidi" }
+func (c *rtmidiClient) Close() error   { return nil }
+
+// Ins lists the input ports of the client's API. Ports are opened when listened to.
+func (c *rtmidiClient) Ins() ([]drivers.In, error) {
+	midiIn, err := rtmidi.NewMIDIIn(c.api, c.inName(), 1024)
+	if err != nil {
+		return nil, fmt.Errorf("can't open MIDI in client %q: %v", c.inName(), err)
+	}
+	defer midiIn.Destroy()
+
+	count, err := midiIn.PortCount()
+	if err != nil {
+		return nil, err
+	}
+	ins := make([]drivers.In, count)
+	for i := range count {
+		name, err := midiIn.PortName(i)
+		if err != nil {
+			return nil, err
+		}
+		ins[i] = &clientIn{client: c.inName(), api: c.api, number: i, name: name}
+	}
+	return ins, nil
+}
+
+// Outs lists the output ports of the client's API. Ports are opened when first sent to.
+func (c *rtmidiClient) Outs() ([]drivers.Out, error) {
+	midiOut, err := rtmidi.NewMIDIOut(c.api, c.outName())
+	if err != nil {
+		return nil, fmt.Errorf("can't open MIDI out client %q: %v", c.outName(), err)
+	}
+	defer midiOut.Destroy()
+
+	count, err := midiOut.PortCount()
+	if err != nil {
+		return nil, err
+	}
+	outs := make([]drivers.Out, count)
+	for i := range count {
+		name, err := midiOut.PortName(i)
+		if err != nil {
+			return nil, err
+		}
+		outs[i] = &clientOut{client: c.outName(), api: c.api, number: i, name: name}
+	}
+	return outs, nil
+}
+
+// OpenVirtualOut creates a virtual output port owned by the named client
+func (c *rtmidiClient) OpenVirtualOut(name string) (drivers.Out, error) {
+	midiOut, err := rtmidi.NewMIDIOut(c.api, c.outName())
+	if err != nil {
+		return nil, fmt.Errorf("can't open MIDI out client %q: %v", c.outName(), err)
+	}
+	if err := midiOut.OpenVirtualPort(name); err != nil {
+		midiOut.Close()
+		return nil, fmt.Errorf("can't open virtual out port: %v", err)
+	}
+	return &clientOut{client: c.outName(), api: c.api, number: -1, name: name, midiOut: midiOut}, nil
+}
+
+// OpenVirtualIn creates a virtual input port owned by the named client
+func (c *rtmidiClient) OpenVirtualIn(name string) (drivers.In, error) {
+	midiIn, err := rtmidi.NewMIDIIn(c.api, c.inName(), 1024)
+	if err != nil {
+		return nil, fmt.Errorf("can't open MIDI in client %q: %v", c.inName(), err)
+	}
+	if err := midiIn.OpenVirtualPort(name); err != nil {
+		midiIn.Close()
+		return nil, fmt.Errorf("can't open virtual in port: %v", err)
+	}
+	return &clientIn{client: c.inName(), api: c.api, number: -1, name: name, midiIn: midiIn}, nil
+}
+
+// WrapIn returns an input port that connects to the same device through the named client
+func (c *rtmidiClient) WrapIn(in drivers.In) drivers.In {
+	return &clientIn{client: c.inName(), api: c.api, number: in.Number(), name: in.String()}
+}
+
+// clientOut is an rtmidi output port opened by rtmidiClient
+type clientOut struct {
+	client  string
+	api     rtmidi.API
+	number  int
+	name    string
+	midiOut rtmidi.MIDIOut
+}
+
+// Open connects to the output port
+func (o *clientOut) Open() error {
+	if o.midiOut != nil {
+		return nil
+	}
+	midiOut, err := rtmidi.NewMIDIOut(o.api, o.client)
+	if err != nil {
+		return fmt.Errorf("can't open MIDI out client %q: %v", o.client, err)
+	}
+	if err := midiOut.OpenPort(o.number, o.name); err != nil {
+		midiOut.Close()
+		return fmt.Errorf("can't open MIDI out port %v (%s): %v", o.number, o.name, err)
+	}
+	o.midiOut = midiOut
+	return nil
+}
+
+func (o *clientOut) IsOpen() bool            { return o.midiOut != nil }
+func (o *clientOut) Number() int             { return o.number }
+func (o *clientOut) String() string          { return o.name }
+func (o *clientOut) Underlying() interface{} { return o.midiOut }
+
+// Close closes the output port
+func (o *clientOut) Close() error {
+	if o.midiOut == nil {
+		return nil
+	}
+	err := o.midiOut.Close()
+	o.midiOut = nil
+	return err
+}
+
+// Send writes raw MIDI bytes to the port
+func (o *clientOut) Send(b []byte) error {
+	if o.midiOut == nil {
+		return drivers.ErrPortClosed
+	}
+	if err := o.midiOut.SendMessage(b); err != nil {
+		return fmt.Errorf("could not send message to MIDI out %s: %v", o.name, err)
+	}
+	return nil
+}
+
+// clientIn is an rtmidi input port opened by rtmidiClient
+type clientIn struct {
+	client string
+	api    rtmidi.API
+	number int
+	name   string
+	midiIn rtmidi.MIDIIn
+}
+
+func (i *clientIn) IsOpen() bool            { return i.midiIn != nil }
+func (i *clientIn) Number() int             { return i.number }
+func (i *clientIn) String() string          { return i.name }
+func (i *clientIn) Underlying() interface{} { return i.midiIn }
+
+// Open connects to the input port
+func (i *clientIn) Open() error {
+	if i.midiIn != nil {
+		return nil
+	}
+	midiIn, err := rtmidi.NewMIDIIn(i.api, i.client, 1024)
+	if err != nil {
+		return fmt.Errorf("can't open MIDI in client %q: %v", i.client, err)
+	}
+	if err := midiIn.OpenPort(i.number, i.name); err != nil {
+		midiIn.Close()
+		return fmt.Errorf("can't open MIDI in port %v (%s): %v", i.number, i.name, err)
+	}
+	i.midiIn = midiIn
+	return nil
+}
+
+// Close closes the input port
+func (i *clientIn) Close() error {
+	if i.midiIn == nil {
+		return nil
+	}
+	err := i.midiIn.Close()
+	i.midiIn = nil
+	return err
+}
+
+// Listen passes incoming messages to onMsg, in the same way as rtmididrv
+func (i *clientIn) Listen(onMsg func(msg []byte, milliseconds int32), config drivers.ListenConfig) (func(), error) {
+	if i.midiIn == nil {
+		return nil, drivers.ErrPortClosed
+	}
+
+	return listenRTMidi(i.midiIn, onMsg, config, nil)
+}
@@ -0,0 +1,649 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"gitlab.com/gomidi/midi/v2/drivers"
+)
+
+// defaultRTPMIDIPort is the usual control port of AppleMIDI sessions. The data port is the one
+// after it.
+const defaultRTPMIDIPort = 5004
+
+// rtpMIDISyncInterval is how often a session's clocks are synchronized
+const rtpMIDISyncInterval = 10 * time.Second
+
+// rtpMIDIInviteTimeout is how long to wait for an answer to an invitation
+const rtpMIDIInviteTimeout = 5 * time.Second
+
+// NetworkConfig sends an output to an RTP-MIDI (AppleMIDI) session on another machine, such as
+// an iPad or a Mac's network MIDI session
+type NetworkConfig struct {
+	Host        string `json:"host"`                   // address of the machine running the session
+	Port        int    `json:"port,omitempty"`         // control port of the session, default 5004
+	SessionName string `json:"session_name,omitempty"` // name the router joins the session with, the output's port name when empty
+}
+
+// Validate checks the network settings
+func (nc *NetworkConfig) Validate() error {
+	if nc.Host == "" {
+		return fmt.Errorf("network output needs a host")
+	}
+	if nc.Port < 0 || nc.Port > 65534 {
+		return fmt.Errorf("invalid port: %d (must be 1-65534)", nc.Port)
+	}
+	return nil
+}
+
+func (nc *NetworkConfig) port() int {
+	if nc.Port == 0 {
+		return defaultRTPMIDIPort
+	}
+	return nc.Port
+}
+
+// AppleMIDI session commands, sent on both the control and the data port
+const (
+	appleMIDIInvitation = "IN"
+	appleMIDIAccept     = "OK"
+	appleMIDIReject     = "NO"
+	appleMIDIEnd        = "BY"
+	appleMIDISync       = "CK"
+)
+
+// appleMIDIProtocolVersion is the version sent with session commands
+const appleMIDIProtocolVersion = 2
+
+// sessionPacket is an AppleMIDI invitation, acceptance, rejection or end of session
+type sessionPacket struct {
+	Command string
+	Token   uint32 // chosen by the initiator, repeated in the answer
+	SSRC    uint32 // identifies the sender
+	Name    string
+}
+
+// bytes encodes the packet
+func (sp sessionPacket) bytes() []byte {
+	b := []byte{0xFF, 0xFF, sp.Command[0], sp.Command[1]}
+	b = binary.BigEndian.AppendUint32(b, appleMIDIProtocolVersion)
+	b = binary.BigEndian.AppendUint32(b, sp.Token)
+	b = binary.BigEndian.AppendUint32(b, sp.SSRC)
+	if sp.Command != appleMIDIEnd {
+		b = append(append(b, sp.Name...), 0)
+	}
+	return b
+}
+
+// parseSessionPacket decodes a session command, returning false for other packets
+func parseSessionPacket(b []byte) (sessionPacket, bool) {
+	if len(b) < 16 || b[0] != 0xFF || b[1] != 0xFF {
+		return sessionPacket{}, false
+	}
+	sp := sessionPacket{
+		Command: string(b[2:4]),
+		Token:   binary.BigEndian.Uint32(b[8:12]),
+		SSRC:    binary.BigEndian.Uint32(b[12:16]),
+	}
+	switch sp.Command {
+	case appleMIDIInvitation, appleMIDIAccept, appleMIDIReject, appleMIDIEnd:
+	default:
+		return sessionPacket{}, false
+	}
+	name := b[16:]
+	for i, c := range name {
+		if c == 0 {
+			name = name[:i]
+			break
+		}
+	}
+	sp.Name = string(name)
+	return sp, true
+}
+
+// syncPacket is an AppleMIDI clock synchronization. The initiator sends count 0 with its time,
+// the other side answers with count 1 adding its time, and the initiator ends with count 2.
+type syncPacket struct {
+	SSRC       uint32
+	Count      uint8
+	Timestamps [3]uint64 // in 100 microsecond units
+}
+
+func (sp syncPacket) bytes() []byte {
+	b := []byte{0xFF, 0xFF, 'C', 'K'}
+	b = binary.BigEndian.AppendUint32(b, sp.SSRC)
+	b = append(b, sp.Count, 0, 0, 0)
+	for _, ts := range sp.Timestamps {
+		b = binary.BigEndian.AppendUint64(b, ts)
+	}
+	return b
+}
+
+func parseSyncPacket(b []byte) (syncPacket, bool) {
+	if len(b) < 36 || b[0] != 0xFF || b[1] != 0xFF || string(b[2:4]) != appleMIDISync {
+		return syncPacket{}, false
+	}
+	sp := syncPacket{SSRC: binary.BigEndian.Uint32(b[4:8]), Count: b[8]}
+	for i := range sp.Timestamps {
+		sp.Timestamps[i] = binary.BigEndian.Uint64(b[12+8*i:])
+	}
+	return sp, true
+}
+
+// rtpMIDIPacket encodes a MIDI message as an RTP-MIDI packet without a recovery journal.
+// timestamp is in 100 microsecond units.
+func rtpMIDIPacket(sequence uint16, timestamp uint32, ssrc uint32, data []byte) []byte {
+	b := []byte{0x80, 0x61}
+	b = binary.BigEndian.AppendUint16(b, sequence)
+	b = binary.BigEndian.AppendUint32(b, timestamp)
+	b = binary.BigEndian.AppendUint32(b, ssrc)
+	if len(data) <= 15 {
+		// Short header: B, J, Z and P flags clear and a 4 bit length
+		b = append(b, byte(len(data)))
+	} else {
+		// Long header: B flag and a 12 bit length
+		b = append(b, 0x80|byte(len(data)>>8), byte(len(data)))
+	}
+	return append(b, data...)
+}
+
+// rtpMIDIOut is an output port that joins an RTP-MIDI session as its initiator and sends each
+// message as an RTP-MIDI packet
+type rtpMIDIOut struct {
+	mu      sync.Mutex
+	config  *NetworkConfig
+	name    string
+	control *net.UDPConn
+	data    *net.UDPConn
+	ssrc    uint32
+	token   uint32
+	seq     uint16
+	started time.Time
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+func newRTPMIDIOut(name string, config *NetworkConfig) *rtpMIDIOut {
+	return &rtpMIDIOut{config: config, name: name}
+}
+
+func (o *rtpMIDIOut) Number() int             { return -1 }
+func (o *rtpMIDIOut) String() string          { return o.name }
+func (o *rtpMIDIOut) Underlying() interface{} { return o.data }
+
+// IsOpen returns whether the session is joined
+func (o *rtpMIDIOut) IsOpen() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.data != nil
+}
+
+// sessionName returns the name the router joins the session with
+func (o *rtpMIDIOut) sessionName() string {
+	if o.config.SessionName != "" {
+		return o.config.SessionName
+	}
+	return o.name
+}
+
+// timestamp returns the session time in 100 microsecond units
+func (o *rtpMIDIOut) timestamp() uint64 {
+	return uint64(time.Since(o.started) / (100 * time.Microsecond))
+}
+
+// Open invites the remote session on its control and data ports, then keeps the clocks in sync
+func (o *rtpMIDIOut) Open() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.data != nil {
+		return nil
+	}
+
+	o.ssrc, o.token = rand.Uint32(), rand.Uint32()
+	o.started = time.Now()
+	host := o.config.Host
+	control, err := o.join(net.JoinHostPort(host, strconv.Itoa(o.config.port())))
+	if err != nil {
+		return err
+	}
+	data, err := o.join(net.JoinHostPort(host, strconv.Itoa(o.config.port()+1)))
+	if err != nil {
+		control.Close()
+		return err
+	}
+	o.control, o.data = control, data
+
+	o.stop = make(chan struct{})
+	o.wg.Add(2)
+	go o.receive(data)
+	go o.synchronize(data)
+	return nil
+}
+
+// join sends an invitation from a new socket and waits for the session to accept it
+func (o *rtpMIDIOut) join(address string) (*net.UDPConn, error) {
+	remote, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve RTP-MIDI session %s: %w", address, err)
+	}
+	conn, err := net.DialUDP("udp", nil, remote)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to RTP-MIDI session %s: %w", address, err)
+	}
+
+	invitation := sessionPacket{Command: appleMIDIInvitation, Token: o.token, SSRC: o.ssrc, Name: o.sessionName()}
+	if _, err := conn.Write(invitation.bytes()); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to invite RTP-MIDI session %s: %w", address, err)
+	}
+
+	buf := make([]byte, 1500)
+	conn.SetReadDeadline(time.Now().Add(rtpMIDIInviteTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("no answer from RTP-MIDI session %s: %w", address, err)
+		}
+		answer, ok := parseSessionPacket(buf[:n])
+		if !ok || answer.Token != o.token {
+			continue
+		}
+		if answer.Command == appleMIDIAccept {
+			return conn, nil
+		}
+		conn.Close()
+		return nil, fmt.Errorf("RTP-MIDI session %s declined the invitation", address)
+	}
+}
+
+// receive answers clock synchronizations on the data port until the port is closed
+func (o *rtpMIDIOut) receive(conn *net.UDPConn) {
+	defer o.wg.Done()
+	buf := make([]byte, 1500)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		if ck, ok := parseSyncPacket(buf[:n]); ok && ck.Count == 1 {
+			ck.SSRC, ck.Count = o.ssrc, 2
+			ck.Timestamps[2] = o.timestamp()
+			conn.Write(ck.bytes())
+		} else if end, ok := parseSessionPacket(buf[:n]); ok && end.Command == appleMIDIEnd {
+			log.Printf("RTP-MIDI session of %s ended by the remote side", o.name)
+		}
+	}
+}
+
+// synchronize starts a clock synchronization now and then at the sync interval
+func (o *rtpMIDIOut) synchronize(conn *net.UDPConn) {
+	defer o.wg.Done()
+	ticker := time.NewTicker(rtpMIDISyncInterval)
+	defer ticker.Stop()
+	for {
+		ck := syncPacket{SSRC: o.ssrc, Count: 0, Timestamps: [3]uint64{o.timestamp()}}
+		conn.Write(ck.bytes())
+		select {
+		case <-ticker.C:
+		case <-o.stop:
+			return
+		}
+	}
+}
+
+// Close leaves the session
+func (o *rtpMIDIOut) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.data == nil {
+		return nil
+	}
+
+	end := sessionPacket{Command: appleMIDIEnd, Token: o.token, SSRC: o.ssrc}
+	o.control.Write(end.bytes())
+	close(o.stop)
+	err := errors.Join(o.control.Close(), o.data.Close())
+	o.wg.Wait()
+	o.control, o.data = nil, nil
+	return err
+}
+
+// Send sends a message to the session
+func (o *rtpMIDIOut) Send(data []byte) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.data == nil {
+		return drivers.ErrPortClosed
+	}
+	if len(data) > 0x0FFF {
+		return fmt.Errorf("message too large for RTP-MIDI: %d bytes", len(data))
+	}
+
+	o.seq++
+	packet := rtpMIDIPacket(o.seq, uint32(o.timestamp()), o.ssrc, data)
+	if _, err := o.data.Write(packet); err != nil {
+		return fmt.Errorf("failed to send to RTP-MIDI session %s: %w", o.config.Host, err)
+	}
+	return nil
+}
+
+// defaultRTPMIDISessionName is the name of the router's network input session when
+// session_name is not set
+const defaultRTPMIDISessionName = "MIDI Router"
+
+// NetworkInputConfig publishes an RTP-MIDI (AppleMIDI) session that other machines join to send
+// into the router
+type NetworkInputConfig struct {
+	Port        int    `json:"port,omitempty"`         // control port to listen on, default 5004
+	SessionName string `json:"session_name,omitempty"` // name of the session, also the input's name for input_filter, default "MIDI Router"
+}
+
+// Validate checks the network input settings
+func (nc *NetworkInputConfig) Validate() error {
+	if nc.Port < 0 || nc.Port > 65534 {
+		return fmt.Errorf("invalid port: %d (must be 1-65534)", nc.Port)
+	}
+	return nil
+}
+
+func (nc *NetworkInputConfig) port() int {
+	if nc.Port == 0 {
+		return defaultRTPMIDIPort
+	}
+	return nc.Port
+}
+
+// name returns the session name, which is also the input's name
+func (nc *NetworkInputConfig) name() string {
+	if nc.SessionName != "" {
+		return nc.SessionName
+	}
+	return defaultRTPMIDISessionName
+}
+
+// parseRTPMIDIPacket returns the MIDI command list of an RTP-MIDI packet, ignoring the recovery
+// journal. Returns false for packets that are not RTP-MIDI.
+func parseRTPMIDIPacket(b []byte) ([]byte, bool) {
+	if len(b) < 13 || b[0]&0xC0 != 0x80 || b[1]&0x7F != 0x61 {
+		return nil, false
+	}
+	header := b[12]
+	length, start := int(header&0x0F), 13
+	if header&0x80 != 0 {
+		// Long header with a 12 bit length
+		if len(b) < 14 {
+			return nil, false
+		}
+		length, start = int(header&0x0F)<<8|int(b[13]), 14
+	}
+	if start+length > len(b) {
+		return nil, false
+	}
+	commands := b[start : start+length]
+	if header&0x20 == 0 {
+		// The first command has no delta time, give it an empty one so every command has one
+		commands = append([]byte{0}, commands...)
+	}
+	return commands, true
+}
+
+// splitRTPMIDICommands splits a command list, where each command follows its delta time, into
+// complete MIDI messages. Running status is expanded, and the segments of a SysEx message split
+// across commands are joined back into one message.
+func splitRTPMIDICommands(commands []byte, sysEx []byte) (msgs [][]byte, pending []byte) {
+	var status byte
+	pending = sysEx
+	for i := 0; i < len(commands); {
+		// Delta time, 1 to 4 bytes
+		for n := 0; n < 4 && i < len(commands); n++ {
+			i++
+			if commands[i-1]&0x80 == 0 {
+				break
+			}
+		}
+		if i >= len(commands) {
+			break
+		}
+
+		first := commands[i]
+		if first == 0xF0 || first == 0xF7 {
+			// SysEx segment, up to an F7 ending the message or an F0 continued in a later command
+			end := i + 1
+			for end < len(commands) && commands[end] != 0xF0 && commands[end] != 0xF7 {
+				end++
+			}
+			if end >= len(commands) {
+				break
+			}
+			if first == 0xF0 {
+				pending = []byte{0xF0}
+			}
+			if pending != nil {
+				pending = append(pending, commands[i+1:end]...)
+				if commands[end] == 0xF7 {
+					msgs = append(msgs, append(pending, 0xF7))
+					pending = nil
+				}
+			}
+			i = end + 1
+			continue
+		}
+
+		if first >= 0x80 {
+			if first < 0xF0 {
+				status = first
+			}
+			i++
+		} else if status == 0 {
+			// Data without a status, the rest of the list can't be read
+			break
+		} else {
+			first = status
+		}
+
+		size := midiDataSize(first)
+		if i+size > len(commands) {
+			break
+		}
+		msgs = append(msgs, append([]byte{first}, commands[i:i+size]...))
+		i += size
+	}
+	return msgs, pending
+}
+
+// midiDataSize returns the number of data bytes following a status byte, other than SysEx
+func midiDataSize(status byte) int {
+	switch {
+	case status >= 0xF0:
+		switch status {
+		case 0xF1, 0xF3:
+			return 1
+		case 0xF2:
+			return 2
+		}
+		return 0
+	case status&0xF0 == 0xC0, status&0xF0 == 0xD0:
+		return 1
+	}
+	return 2
+}
+
+// rtpMIDIIn is an input port that publishes an RTP-MIDI session. Any number of remote
+// initiators can join it, and their messages are merged.
+type rtpMIDIIn struct {
+	mu      sync.Mutex
+	config  *NetworkInputConfig
+	control *net.UDPConn
+	data    *net.UDPConn
+	ssrc    uint32
+	started time.Time
+	peers   map[uint32]string // names of the joined initiators by SSRC
+	wg      sync.WaitGroup
+}
+
+func newRTPMIDIIn(config *NetworkInputConfig) *rtpMIDIIn {
+	return &rtpMIDIIn{config: config, peers: make(map[uint32]string)}
+}
+
+func (i *rtpMIDIIn) Number() int             { return -1 }
+func (i *rtpMIDIIn) String() string          { return i.config.name() }
+func (i *rtpMIDIIn) Underlying() interface{} { return i.data }
+
+// IsOpen returns whether the session's ports are open
+func (i *rtpMIDIIn) IsOpen() bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.data != nil
+}
+
+// timestamp returns the session time in 100 microsecond units
+func (i *rtpMIDIIn) timestamp() uint64 {
+	return uint64(time.Since(i.started) / (100 * time.Microsecond))
+}
+
+// Open listens on the session's control and data ports
+func (i *rtpMIDIIn) Open() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.data != nil {
+		return nil
+	}
+
+	control, err := net.ListenUDP("udp", &net.UDPAddr{Port: i.config.port()})
+	if err != nil {
+		return fmt.Errorf("failed to listen on port %d: %w", i.config.port(), err)
+	}
+	data, err := net.ListenUDP("udp", &net.UDPAddr{Port: i.config.port() + 1})
+	if err != nil {
+		control.Close()
+		return fmt.Errorf("failed to listen on port %d: %w", i.config.port()+1, err)
+	}
+	i.control, i.data = control, data
+	i.ssrc = rand.Uint32()
+	i.started = time.Now()
+	return nil
+}
+
+// Close ends the session for every joined initiator and closes its ports
+func (i *rtpMIDIIn) Close() error {
+	i.mu.Lock()
+	if i.data == nil {
+		i.mu.Unlock()
+		return nil
+	}
+	control, data := i.control, i.data
+	i.control, i.data = nil, nil
+	i.mu.Unlock()
+
+	err := errors.Join(control.Close(), data.Close())
+	i.wg.Wait()
+	return err
+}
+
+// Listen answers the session's invitations and clock synchronizations in the background, and
+// passes the MIDI messages of joined initiators to onMsg
+func (i *rtpMIDIIn) Listen(onMsg func(msg []byte, milliseconds int32), config drivers.ListenConfig) (func(), error) {
+	if err := i.Open(); err != nil {
+		return nil, err
+	}
+	i.mu.Lock()
+	control, data := i.control, i.data
+	i.mu.Unlock()
+
+	var readerMu sync.Mutex
+	reader := newSysExReader(config, onMsg)
+	receive := func(msg []byte) {
+		readerMu.Lock()
+		defer readerMu.Unlock()
+		reader.EachMessage(msg, 0)
+	}
+
+	i.wg.Add(2)
+	go i.serve(control, nil)
+	go i.serve(data, receive)
+	return func() { i.Close() }, nil
+}
+
+// serve answers the session commands arriving on a port until it is closed. receive is nil for
+// the control port, which carries no MIDI.
+func (i *rtpMIDIIn) serve(conn *net.UDPConn, receive func(msg []byte)) {
+	defer i.wg.Done()
+	sysEx := make(map[uint32][]byte) // unfinished SysEx of each initiator
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		packet := buf[:n]
+
+		if sp, ok := parseSessionPacket(packet); ok {
+			i.handleSession(conn, addr, sp, receive == nil)
+			continue
+		}
+		if ck, ok := parseSyncPacket(packet); ok {
+			if ck.Count == 0 {
+				ck.SSRC, ck.Count = i.ssrc, 1
+				ck.Timestamps[1] = i.timestamp()
+				conn.WriteToUDP(ck.bytes(), addr)
+			}
+			continue
+		}
+		if receive == nil {
+			continue
+		}
+
+		commands, ok := parseRTPMIDIPacket(packet)
+		if !ok {
+			continue
+		}
+		ssrc := binary.BigEndian.Uint32(packet[8:12])
+		i.mu.Lock()
+		_, joined := i.peers[ssrc]
+		i.mu.Unlock()
+		if !joined {
+			continue
+		}
+		var msgs [][]byte
+		msgs, sysEx[ssrc] = splitRTPMIDICommands(commands, sysEx[ssrc])
+		for _, msg := range msgs {
+			receive(msg)
+		}
+	}
+}
+
+// handleSession accepts invitations and forgets initiators that end their session
+func (i *rtpMIDIIn) handleSession(conn *net.UDPConn, addr *net.UDPAddr, sp sessionPacket, control bool) {
+	switch sp.Command {
+	case appleMIDIInvitation:
+		answer := sessionPacket{Command: appleMIDIAccept, Token: sp.Token, SSRC: i.ssrc, Name: i.config.name()}
+		conn.WriteToUDP(answer.bytes(), addr)
+		if control {
+			return
+		}
+		i.mu.Lock()
+		_, joined := i.peers[sp.SSRC]
+		i.peers[sp.SSRC] = sp.Name
+		i.mu.Unlock()
+		if !joined {
+			log.Printf("%s joined the RTP-MIDI session %s from %s", sp.Name, i.config.name(), addr.IP)
+		}
+	case appleMIDIEnd:
+		i.mu.Lock()
+		name, joined := i.peers[sp.SSRC]
+		delete(i.peers, sp.SSRC)
+		i.mu.Unlock()
+		if joined {
+			log.Printf("%s left the RTP-MIDI session %s", name, i.config.name())
+		}
+	}
+}
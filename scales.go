@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// noteNames lists the pitch class names used when displaying notes
+var noteNames = []string{"C", "C#", "D", "D#", "E", "F", "F#", "G", "G#", "A", "A#", "B"}
+
+// scaleIntervals maps scale names to the semitone offset of each degree from the root
+var scaleIntervals = map[string][]int{
+	"major":            {0, 2, 4, 5, 7, 9, 11},
+	"minor":            {0, 2, 3, 5, 7, 8, 10},
+	"harmonic_minor":   {0, 2, 3, 5, 7, 8, 11},
+	"melodic_minor":    {0, 2, 3, 5, 7, 9, 11},
+	"dorian":           {0, 2, 3, 5, 7, 9, 10},
+	"phrygian":         {0, 1, 3, 5, 7, 8, 10},
+	"lydian":           {0, 2, 4, 6, 7, 9, 11},
+	"mixolydian":       {0, 2, 4, 5, 7, 9, 10},
+	"locrian":          {0, 1, 3, 5, 6, 8, 10},
+	"major_pentatonic": {0, 2, 4, 7, 9},
+	"minor_pentatonic": {0, 3, 5, 7, 10},
+	"blues":            {0, 3, 5, 6, 7, 10},
+	"chromatic":        {0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+}
+
+// scaleNames returns the sorted list of known scale names for help and error messages
+func scaleNames() []string {
+	names := make([]string, 0, len(scaleIntervals))
+	for name := range scaleIntervals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupScale returns the degree offsets for a scale name
+func lookupScale(name string) ([]int, error) {
+	scale, ok := scaleIntervals[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return nil, fmt.Errorf("unknown scale: %q (available: %s)", name, strings.Join(scaleNames(), ", "))
+	}
+	return scale, nil
+}
+
+// parseKey converts a key name such as "C", "F#" or "Bb" into a pitch class (0-11)
+func parseKey(name string) (uint8, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return 0, fmt.Errorf("empty key name")
+	}
+
+	base := strings.ToUpper(name[:1])
+	pitchClass := -1
+	for i, noteName := range noteNames {
+		if noteName == base {
+			pitchClass = i
+			break
+		}
+	}
+	if pitchClass < 0 {
+		return 0, fmt.Errorf("invalid key name: %q", name)
+	}
+
+	switch name[1:] {
+	case "":
+	case "#":
+		pitchClass++
+	case "b":
+		pitchClass--
+	default:
+		return 0, fmt.Errorf("invalid key name: %q", name)
+	}
+
+	return uint8((pitchClass + 12) % 12), nil
+}
+
+// floorDiv divides rounding towards negative infinity, so negative scale steps wrap correctly
+func floorDiv(a, b int) int {
+	q := a / b
+	if (a%b != 0) && ((a < 0) != (b < 0)) {
+		q--
+	}
+	return q
+}
+
+// scaleStepNote returns the note that is the given number of scale steps away from note.
+// Notes outside the scale keep their chromatic offset from the scale degree below them.
+// The result may fall outside the MIDI note range and must be checked by the caller.
+func scaleStepNote(note uint8, root uint8, scale []int, steps int) int {
+	relative := int(note) - int(root)
+	octave := floorDiv(relative, 12)
+	pitchClass := relative - octave*12
+
+	degree := 0
+	for i, offset := range scale {
+		if offset <= pitchClass {
+			degree = i
+		}
+	}
+	chromatic := pitchClass - scale[degree]
+
+	target := degree + steps
+	targetOctave := octave + floorDiv(target, len(scale))
+	targetDegree := target - floorDiv(target, len(scale))*len(scale)
+
+	return int(root) + targetOctave*12 + scale[targetDegree] + chromatic
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// scheduledEvent is a function to run at a specific time
+type scheduledEvent struct {
+	at  time.Time
+	seq uint64 // keeps events scheduled for the same time in insertion order
+	fn  func()
+}
+
+// eventQueue is a min-heap of scheduled events ordered by time
+type eventQueue []*scheduledEvent
+
+func (q eventQueue) Len() int { return len(q) }
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].at.Equal(q[j].at) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].at.Before(q[j].at)
+}
+func (q eventQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *eventQueue) Push(x any)   { *q = append(*q, x.(*scheduledEvent)) }
+func (q *eventQueue) Pop() any {
+	old := *q
+	event := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return event
+}
+
+// scheduler runs delayed events in time order from a single goroutine
+type scheduler struct {
+	mu     sync.Mutex
+	queue  eventQueue
+	seq    uint64
+	wake   chan struct{}
+	done   chan struct{}
+	closed bool
+}
+
+// newScheduler creates a scheduler and starts its goroutine
+func newScheduler() *scheduler {
+	s := &scheduler{
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Schedule runs fn after the given delay
+func (s *scheduler) Schedule(delay time.Duration, fn func()) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.seq++
+	heap.Push(&s.queue, &scheduledEvent{at: time.Now().Add(delay), seq: s.seq, fn: fn})
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Stop discards pending events and stops the scheduler goroutine
+func (s *scheduler) Stop() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	s.queue = nil
+	s.mu.Unlock()
+	close(s.done)
+}
+
+func (s *scheduler) run() {
+	defer recoverFailsafe()
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		s.mu.Lock()
+		var due []func()
+		now := time.Now()
+		for len(s.queue) > 0 && !s.queue[0].at.After(now) {
+			due = append(due, heap.Pop(&s.queue).(*scheduledEvent).fn)
+		}
+		wait := time.Hour
+		if len(s.queue) > 0 {
+			wait = s.queue[0].at.Sub(now)
+		}
+		s.mu.Unlock()
+
+		for _, fn := range due {
+			fn()
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-s.done:
+			return
+		case <-s.wake:
+		case <-timer.C:
+		}
+	}
+}
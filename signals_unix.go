@@ -0,0 +1,14 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// Signals that make a running router re-send or save its controller state
+var (
+	restoreSignal  os.Signal = syscall.SIGUSR1
+	snapshotSignal os.Signal = syscall.SIGUSR2
+)
@@ -0,0 +1,11 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// Windows has no user signals, controller state is only saved on exit
+var (
+	restoreSignal  os.Signal
+	snapshotSignal os.Signal
+)
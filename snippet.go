@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// OutputSnippet is a shareable output preset: one output in the configuration file format, with
+// a description of what it is for, such as the drum map of a particular kit
+type OutputSnippet struct {
+	Name        string          `json:"name"`                  // what the preset is, e.g. "Roland TD-17 drum map"
+	Description string          `json:"description,omitempty"` // what it does and how to use it
+	Author      string          `json:"author,omitempty"`
+	Output      json.RawMessage `json:"output"` // the output's settings, like an entry of outputs
+}
+
+// loadOutputSnippet reads and checks a snippet file
+func loadOutputSnippet(filename string) (*OutputSnippet, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snippet: %w", err)
+	}
+	return parseOutputSnippet(data)
+}
+
+// parseOutputSnippet parses and checks a snippet
+func parseOutputSnippet(data []byte) (*OutputSnippet, error) {
+	var snippet OutputSnippet
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&snippet); err != nil {
+		return nil, fmt.Errorf("invalid snippet: %w", err)
+	}
+	if snippet.Name == "" {
+		return nil, fmt.Errorf("invalid snippet: missing name")
+	}
+	if len(snippet.Output) == 0 {
+		return nil, fmt.Errorf("invalid snippet: missing output")
+	}
+	// Catch options misspelled or from a newer version, which the configuration would ignore
+	decoder = json.NewDecoder(bytes.NewReader(snippet.Output))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&OutputConfig{}); err != nil {
+		return nil, fmt.Errorf("invalid snippet output: %w", err)
+	}
+	return &snippet, nil
+}
+
+// runImportOutputCommand appends the output of a snippet to a configuration file:
+//
+//	midirouter import-output [--config file] [--name name] <snippet>
+func runImportOutputCommand(args []string) error {
+	flags := flag.NewFlagSet("import-output", flag.ExitOnError)
+	configFile := flags.String("config", "config.json", "Configuration file the output is added to")
+	name := flags.String("name", "", "Name of the added output, the snippet's output name when empty")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: midirouter import-output [--config file] [--name name] <snippet>")
+	}
+
+	snippet, err := loadOutputSnippet(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(*configFile)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	// The output is added to the configuration as written, so a group or pipeline it names
+	// applies to it like to the other outputs, and the file is saved as written too instead of
+	// with its groups, pipelines and auto outputs expanded
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return fmt.Errorf("failed to unmarshal config: %w", err)
+	}
+	var outputs []json.RawMessage
+	if raw, ok := fields["outputs"]; ok {
+		if err := json.Unmarshal(raw, &outputs); err != nil {
+			return fmt.Errorf("failed to unmarshal config: %w", err)
+		}
+	}
+	var output map[string]json.RawMessage
+	if err := json.Unmarshal(snippet.Output, &output); err != nil {
+		return fmt.Errorf("invalid snippet output: %w", err)
+	}
+	if *name != "" {
+		output["name"], _ = json.Marshal(*name)
+	}
+	// A missing name is reported by the validation below
+	var addedName string
+	json.Unmarshal(output["name"], &addedName)
+	added, err := json.Marshal(output)
+	if err != nil {
+		return err
+	}
+	outputs = append(outputs, added)
+	if fields["outputs"], err = json.Marshal(outputs); err != nil {
+		return err
+	}
+	if data, err = json.MarshalIndent(fields, "", "  "); err != nil {
+		return err
+	}
+
+	config, err := parseConfig(data)
+	if err != nil {
+		return err
+	}
+	named := 0
+	for _, output := range config.Outputs {
+		if output.Name == addedName {
+			named++
+		}
+	}
+	if named > 1 {
+		return fmt.Errorf("an output named %q already exists, pick another name with --name", addedName)
+	}
+	if err := validateConfigStructure(config); err != nil {
+		return fmt.Errorf("the snippet's output doesn't fit the configuration: %w", err)
+	}
+	if err := os.WriteFile(*configFile, data, 0644); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+
+	fmt.Printf("Added output %d %q from %s to %s\n", len(outputs), addedName, snippet.Name, *configFile)
+	if snippet.Description != "" {
+		fmt.Println(snippet.Description)
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"gitlab.com/gomidi/midi/v2/drivers"
+)
+
+// socketReconnectInterval is how long a TCP output waits between connection attempts
+const socketReconnectInterval = time.Second
+
+// socketDialTimeout is how long a connection attempt may take
+const socketDialTimeout = 5 * time.Second
+
+// SocketConfig streams an output's raw MIDI bytes to a TCP or UDP address, for custom software
+// on another machine
+type SocketConfig struct {
+	Protocol string `json:"protocol,omitempty"` // "tcp" (default) or "udp"
+	Address  string `json:"address"`            // host and port, e.g. "192.168.1.20:9000"
+	Format   string `json:"format,omitempty"`   // "raw" (default) for plain MIDI bytes, "text" for timestamped hex lines
+}
+
+// Validate checks the socket settings
+func (sc *SocketConfig) Validate() error {
+	switch sc.Protocol {
+	case "", "tcp", "udp":
+	default:
+		return fmt.Errorf("invalid protocol: %q (must be tcp or udp)", sc.Protocol)
+	}
+	if _, _, err := net.SplitHostPort(sc.Address); err != nil {
+		return fmt.Errorf("invalid address: %w", err)
+	}
+	switch sc.Format {
+	case "", "raw", "text":
+	default:
+		return fmt.Errorf("invalid format: %q (must be raw or text)", sc.Format)
+	}
+	return nil
+}
+
+func (sc *SocketConfig) protocol() string {
+	if sc.Protocol == "" {
+		return "tcp"
+	}
+	return sc.Protocol
+}
+
+// socketOut is an output port that writes MIDI bytes to a TCP or UDP socket. A TCP output that
+// can't connect, or loses its connection, keeps trying to connect in the background, and its
+// messages fail until it's connected again.
+type socketOut struct {
+	mu           sync.Mutex
+	config       *SocketConfig
+	name         string
+	conn         net.Conn
+	open         bool
+	reconnecting bool
+	started      time.Time
+	stop         chan struct{}
+	wg           sync.WaitGroup
+}
+
+func newSocketOut(name string, config *SocketConfig) *socketOut {
+	return &socketOut{config: config, name: name}
+}
+
+func (o *socketOut) Number() int             { return -1 }
+func (o *socketOut) String() string          { return o.name }
+func (o *socketOut) Underlying() interface{} { return o.conn }
+
+// IsOpen returns whether the port is open, connected or not
+func (o *socketOut) IsOpen() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.open
+}
+
+// Open connects to the address. A TCP connection that fails is retried in the background
+// instead of failing, so the receiving software can start after the router.
+func (o *socketOut) Open() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.open {
+		return nil
+	}
+
+	conn, err := net.DialTimeout(o.config.protocol(), o.config.Address, socketDialTimeout)
+	if err != nil && o.config.protocol() == "udp" {
+		return fmt.Errorf("failed to open %s: %w", o.config.Address, err)
+	}
+	o.open = true
+	o.started = time.Now()
+	o.stop = make(chan struct{})
+	if err != nil {
+		log.Printf("Failed to connect to %s, retrying: %v", o.config.Address, err)
+		o.reconnectLocked()
+		return nil
+	}
+	o.conn = conn
+	return nil
+}
+
+// reconnectLocked starts connecting again in the background unless it already is
+func (o *socketOut) reconnectLocked() {
+	if o.reconnecting {
+		return
+	}
+	o.reconnecting = true
+	o.wg.Add(1)
+	go func() {
+		defer o.wg.Done()
+		ticker := time.NewTicker(socketReconnectInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-o.stop:
+				return
+			}
+			conn, err := net.DialTimeout(o.config.protocol(), o.config.Address, socketDialTimeout)
+			if err != nil {
+				continue
+			}
+
+			o.mu.Lock()
+			o.reconnecting = false
+			if !o.open {
+				o.mu.Unlock()
+				conn.Close()
+				return
+			}
+			o.conn = conn
+			o.mu.Unlock()
+			log.Printf("Connected to %s for %s", o.config.Address, o.name)
+			return
+		}
+	}()
+}
+
+// Close closes the connection and stops reconnecting
+func (o *socketOut) Close() error {
+	o.mu.Lock()
+	if !o.open {
+		o.mu.Unlock()
+		return nil
+	}
+	o.open = false
+	close(o.stop)
+	var err error
+	if o.conn != nil {
+		err = o.conn.Close()
+		o.conn = nil
+	}
+	o.mu.Unlock()
+
+	o.wg.Wait()
+	return err
+}
+
+// Send writes a message in the configured format. A failed TCP write drops the connection and
+// starts reconnecting.
+func (o *socketOut) Send(data []byte) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !o.open {
+		return drivers.ErrPortClosed
+	}
+	if o.conn == nil {
+		return fmt.Errorf("not connected to %s", o.config.Address)
+	}
+
+	if o.config.Format == "text" {
+		data = []byte(formatRawLine(time.Since(o.started).Milliseconds(), data))
+	}
+	if _, err := o.conn.Write(data); err != nil {
+		if o.config.protocol() == "tcp" {
+			log.Printf("Lost connection to %s, reconnecting: %v", o.config.Address, err)
+			o.conn.Close()
+			o.conn = nil
+			o.reconnectLocked()
+		}
+		return fmt.Errorf("failed to send to %s: %w", o.config.Address, err)
+	}
+	return nil
+}
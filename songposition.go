@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// SongPositionConfig controls how song position pointer messages are sent to an output
+type SongPositionConfig struct {
+	Mode   string `json:"mode"`             // "forward" (default), "suppress" or "rewrite"
+	Offset int    `json:"offset,omitempty"` // MIDI beats (16th notes) added to the position when rewriting
+}
+
+// Validate checks the song position settings
+func (spc *SongPositionConfig) Validate() error {
+	switch spc.Mode {
+	case "", "forward", "suppress":
+		if spc.Offset != 0 {
+			return fmt.Errorf("offset is only used in rewrite mode")
+		}
+	case "rewrite":
+	default:
+		return fmt.Errorf("invalid song position mode: %q (must be forward, suppress or rewrite)", spc.Mode)
+	}
+	return nil
+}
+
+// applySongPosition forwards, rewrites or suppresses a song position pointer message.
+// Returns false if the message should not be sent.
+func applySongPosition(msg midi.Message, config *SongPositionConfig) (midi.Message, bool) {
+	var position uint16
+	if config == nil || !msg.GetSPP(&position) {
+		return msg, true
+	}
+
+	switch config.Mode {
+	case "suppress":
+		return nil, false
+	case "rewrite":
+		rewritten := max(0, min(maxSongPosition, int(position)+config.Offset))
+		return midi.SPP(uint16(rewritten)), true
+	default:
+		return msg, true
+	}
+}
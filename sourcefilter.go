@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// SourceFilter passes or blocks messages by the input they came from, when several inputs are
+// merged. Allow and deny work like input_filter and input_exclude, but can be inverted and
+// combined with input_device. Sources are named like in input_filter: input devices, the
+// virtual, network, WebSocket or MQTT input, or a logical input for all of its ports.
+type SourceFilter struct {
+	Allow  []string `json:"allow,omitempty"`  // only pass messages from these sources, every source when empty
+	Deny   []string `json:"deny,omitempty"`   // never pass messages from these sources
+	Invert bool     `json:"invert,omitempty"` // pass the messages the lists block instead
+}
+
+// Validate checks that the filter names known sources
+func (sf *SourceFilter) Validate(config *Config) error {
+	if len(sf.Allow) == 0 && len(sf.Deny) == 0 {
+		return fmt.Errorf("needs allow or deny")
+	}
+	sources := config.sourceNames()
+	for _, name := range slices.Concat(sf.Allow, sf.Deny) {
+		if !slices.Contains(sources, name) && config.findLogicalInput(name) == nil {
+			return fmt.Errorf("unknown source: %s", name)
+		}
+	}
+	return nil
+}
+
+// ShouldPass tests if messages from an input pass the filter
+func (sf *SourceFilter) ShouldPass(config *Config, input string) bool {
+	pass := !config.inputListed(sf.Deny, input) && (len(sf.Allow) == 0 || config.inputListed(sf.Allow, input))
+	return pass != sf.Invert
+}
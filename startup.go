@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// defaultStartupTimeoutMS is how long an output waits for its device to acknowledge the startup
+// messages by default
+const defaultStartupTimeoutMS = 5000
+
+// StartupConfig holds back routing until an output's device is connected and ready, so the first
+// messages aren't lost while a synth boots or loads its setup
+type StartupConfig struct {
+	Order     int      `json:"order,omitempty"`      // position in the startup sequence, lower first, then configuration order
+	Init      []string `json:"init,omitempty"`       // messages in hex sent to the output first, e.g. a SysEx that sets up the device
+	AckInput  string   `json:"ack_input,omitempty"`  // input port the device answers on, usually its MIDI out
+	Ack       string   `json:"ack,omitempty"`        // hex start of the answer that means the device is ready, e.g. "F0 41 10"
+	TimeoutMS int      `json:"timeout_ms,omitempty"` // how long to wait for the answer before starting anyway, default 5000
+	DelayMS   int      `json:"delay_ms,omitempty"`   // wait after the init messages, or after the answer
+}
+
+// Validate checks the startup settings
+func (sc *StartupConfig) Validate() error {
+	for i, data := range sc.Init {
+		if _, err := parseHexMessage(data); err != nil {
+			return fmt.Errorf("invalid init message %d: %w", i+1, err)
+		}
+	}
+	if (sc.Ack != "") != (sc.AckInput != "") {
+		return fmt.Errorf("ack and ack_input must be set together")
+	}
+	if sc.Ack != "" {
+		if _, err := parseHexMessage(sc.Ack); err != nil {
+			return fmt.Errorf("invalid ack: %w", err)
+		}
+	}
+	if sc.TimeoutMS < 0 {
+		return fmt.Errorf("invalid timeout_ms: %d", sc.TimeoutMS)
+	}
+	if sc.DelayMS < 0 {
+		return fmt.Errorf("invalid delay_ms: %d", sc.DelayMS)
+	}
+	return nil
+}
+
+func (sc *StartupConfig) timeout() time.Duration {
+	if sc.TimeoutMS > 0 {
+		return time.Duration(sc.TimeoutMS) * time.Millisecond
+	}
+	return defaultStartupTimeoutMS * time.Millisecond
+}
+
+// parseHexMessage parses message bytes written in hex, e.g. "F0 41 10", which must start with a
+// status byte
+func parseHexMessage(data string) ([]byte, error) {
+	msg, err := (&recordedMessage{Data: data}).bytes()
+	if err != nil {
+		return nil, err
+	}
+	if len(msg) == 0 || msg[0] < 0x80 {
+		return nil, fmt.Errorf("%q is not a MIDI message", data)
+	}
+	return msg, nil
+}
+
+// startupOrder returns the outputs with startup settings in the order they are started
+func startupOrder(config *Config) []int {
+	var order []int
+	for i, output := range config.Outputs {
+		if output.Startup != nil {
+			order = append(order, i)
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return config.Outputs[order[a]].Startup.Order < config.Outputs[order[b]].Startup.Order
+	})
+	return order
+}
+
+// waitForStartupDevices waits until the devices of the outputs with startup settings and the
+// inputs they answer on are connected. Returns false if done was closed first.
+func waitForStartupDevices(drv midiDriver, config *Config, done <-chan struct{}) (bool, error) {
+	waiting := ""
+	for {
+		missing, err := missingStartupDevice(drv, config)
+		if err != nil {
+			return false, err
+		}
+		if missing == "" {
+			if waiting != "" {
+				fmt.Println("Devices connected")
+			}
+			return true, nil
+		}
+		if missing != waiting {
+			fmt.Printf("Waiting for device: %s\n", missing)
+			waiting = missing
+		}
+		select {
+		case <-time.After(devicePollInterval):
+		case <-done:
+			return false, nil
+		}
+	}
+}
+
+// missingStartupDevice returns the first device an output's startup waits for that isn't
+// connected, or an empty string
+func missingStartupDevice(drv midiDriver, config *Config) (string, error) {
+	outs, err := drv.Outs()
+	if err != nil {
+		return "", fmt.Errorf("failed to get MIDI outputs: %w", err)
+	}
+	ins, err := drv.Ins()
+	if err != nil {
+		return "", fmt.Errorf("failed to get MIDI inputs: %w", err)
+	}
+	inNames := getDeviceNames(ins)
+	for _, i := range startupOrder(config) {
+		output := &config.Outputs[i]
+		if output.Device != "" {
+			if _, err := findOutputDevice(outs, output.Device); err != nil {
+				return output.Device, nil
+			}
+		}
+		if output.Startup.AckInput != "" && !slices.Contains(inNames, output.Startup.AckInput) {
+			return output.Startup.AckInput, nil
+		}
+	}
+	return "", nil
+}
+
+// startOutputs sends the startup messages of the outputs that have them, one output after the
+// other, and waits for each device to be ready. Returns false if done was closed first.
+func startOutputs(drv midiDriver, config *Config, senders []func(midi.Message) error, done <-chan struct{}) (bool, error) {
+	for _, i := range startupOrder(config) {
+		if started, err := startOutput(drv, config, i, senders[i], done); !started || err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// startOutput sends an output's startup messages and waits for its device to be ready
+func startOutput(drv midiDriver, config *Config, i int, send func(midi.Message) error, done <-chan struct{}) (bool, error) {
+	startup := config.Outputs[i].Startup
+	name := config.Outputs[i].Name
+
+	// Listen for the answer before sending, so a fast device's answer isn't missed
+	var ready <-chan struct{}
+	if startup.Ack != "" {
+		acked, stopListening, err := listenForAck(drv, startup, config.sysExMaxSize())
+		if err != nil {
+			return false, fmt.Errorf("output %d: %w", i+1, err)
+		}
+		defer stopListening()
+		ready = acked
+	}
+
+	for _, data := range startup.Init {
+		// Checked by Validate
+		msg, _ := parseHexMessage(data)
+		if err := send(msg); err != nil {
+			return false, fmt.Errorf("failed to send startup message to output %d: %w", i+1, err)
+		}
+	}
+
+	if ready != nil {
+		fmt.Printf("Waiting for %s to be ready...\n", name)
+		select {
+		case <-ready:
+			fmt.Printf("%s is ready\n", name)
+		case <-time.After(startup.timeout()):
+			log.Printf("Warning: %s didn't answer within %s, starting anyway", name, startup.timeout())
+		case <-done:
+			return false, nil
+		}
+	}
+
+	if startup.DelayMS > 0 {
+		select {
+		case <-time.After(time.Duration(startup.DelayMS) * time.Millisecond):
+		case <-done:
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// listenForAck listens on an output's ack input until the answer arrives, closing the returned
+// channel. SysEx answers larger than maxSize are dropped.
+func listenForAck(drv midiDriver, startup *StartupConfig, maxSize int) (<-chan struct{}, func(), error) {
+	ins, err := drv.Ins()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get MIDI inputs: %w", err)
+	}
+	prefix, _ := parseHexMessage(startup.Ack)
+	for _, in := range ins {
+		if in.String() != startup.AckInput {
+			continue
+		}
+		acked := make(chan struct{})
+		var once sync.Once
+		stop, err := midi.ListenTo(sysExIn{in}, func(msg midi.Message, timestampms int32) {
+			if bytes.HasPrefix(msg, prefix) {
+				once.Do(func() { close(acked) })
+			}
+		}, midi.UseSysEx(), midi.SysExBufferSize(uint32(maxSize)))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to listen to %s: %w", startup.AckInput, err)
+		}
+		return acked, stop, nil
+	}
+	return nil, nil, fmt.Errorf("ack input not found: %s", startup.AckInput)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// routeStats counts routed messages and send errors per output, and messages no output took.
+// Clock ticks are left out, as in the message log.
+type routeStats struct {
+	names   []string
+	routed  []atomic.Uint64
+	errors  []atomic.Uint64
+	dropped atomic.Uint64
+
+	// last messages for the dashboard preview
+	last        []atomic.Pointer[midi.Message]
+	lastDropped atomic.Pointer[midi.Message]
+}
+
+func newRouteStats(names []string) *routeStats {
+	return &routeStats{
+		names:  names,
+		routed: make([]atomic.Uint64, len(names)),
+		errors: make([]atomic.Uint64, len(names)),
+		last:   make([]atomic.Pointer[midi.Message], len(names)),
+	}
+}
+
+// Routed records a message sent to an output
+func (rs *routeStats) Routed(output int, msg midi.Message) {
+	if !msg.Is(midi.TimingClockMsg) {
+		rs.routed[output].Add(1)
+		last := slices.Clone(msg)
+		rs.last[output].Store(&last)
+	}
+}
+
+// Error records a failed send to an output
+func (rs *routeStats) Error(output int) {
+	rs.errors[output].Add(1)
+}
+
+// Dropped records a message that no output received
+func (rs *routeStats) Dropped(msg midi.Message) {
+	if !msg.Is(midi.TimingClockMsg) {
+		rs.dropped.Add(1)
+		last := slices.Clone(msg)
+		rs.lastDropped.Store(&last)
+	}
+}
+
+// Summary formats the totals on a single line
+func (rs *routeStats) Summary() string {
+	parts := make([]string, 0, len(rs.names)+1)
+	for i, name := range rs.names {
+		part := fmt.Sprintf("%s: %d routed", name, rs.routed[i].Load())
+		if errors := rs.errors[i].Load(); errors > 0 {
+			part = fmt.Sprintf("%s, %d errors", part, errors)
+		}
+		parts = append(parts, part)
+	}
+	parts = append(parts, fmt.Sprintf("dropped: %d", rs.dropped.Load()))
+	return strings.Join(parts, " | ")
+}
+
+// Report prints the summary every interval until stop is closed
+func (rs *routeStats) Report(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			fmt.Printf("[STATS] %s\n", rs.Summary())
+		case <-stop:
+			return
+		}
+	}
+}
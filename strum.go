@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"time"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// defaultStrumGatherMS is how long notes are collected into one strum when gather_ms is not set
+const defaultStrumGatherMS = 30
+
+// StrumConfig spreads the notes of a chord over time like a strummed guitar or harp
+type StrumConfig struct {
+	TimeMS    int    `json:"time_ms"`             // time from the first to the last note of a chord
+	Direction string `json:"direction,omitempty"` // "up" (low to high, default), "down" or "random"
+	GatherMS  int    `json:"gather_ms,omitempty"` // notes starting within this time form a chord, default 30
+}
+
+// Validate checks the strum settings
+func (sc *StrumConfig) Validate() error {
+	if sc.TimeMS < 1 || sc.TimeMS > 2000 {
+		return fmt.Errorf("invalid time_ms: %d (must be 1-2000)", sc.TimeMS)
+	}
+	switch sc.Direction {
+	case "", "up", "down", "random":
+	default:
+		return fmt.Errorf("invalid direction: %q (must be up, down or random)", sc.Direction)
+	}
+	if sc.GatherMS < 0 || sc.GatherMS > 500 {
+		return fmt.Errorf("invalid gather_ms: %d (must be 0-500)", sc.GatherMS)
+	}
+	return nil
+}
+
+func (sc *StrumConfig) gather() time.Duration {
+	if sc.GatherMS == 0 {
+		return defaultStrumGatherMS * time.Millisecond
+	}
+	return time.Duration(sc.GatherMS) * time.Millisecond
+}
+
+// strumNote is a note start with the messages that belong to it, such as a tuning pitch bend
+type strumNote struct {
+	key     noteKey
+	msgs    []midi.Message
+	release []midi.Message // the note's end, when it was released before the strum
+}
+
+// strumState collects the notes of a chord sent to an output and sends them one after another.
+// Note ends are delayed until their note has started.
+type strumState struct {
+	config   *StrumConfig
+	name     string
+	schedule func(time.Duration, func()) // runs a function later, serialized with routing
+	send     func(midi.Message) error
+
+	pending []strumNote           // notes gathered for the next strum
+	startAt map[noteKey]time.Time // when each held strummed note starts, at the latest
+}
+
+func newStrumState(config *StrumConfig, name string, schedule func(time.Duration, func()), send func(midi.Message) error) *strumState {
+	return &strumState{
+		config:   config,
+		name:     name,
+		schedule: schedule,
+		send:     send,
+		startAt:  make(map[noteKey]time.Time),
+	}
+}
+
+// Send sends the messages produced for one incoming message. Note starts and the messages
+// before them are held back for the strum, other messages are sent in order.
+func (ss *strumState) Send(msgs []midi.Message) error {
+	start := 0
+	for i, msg := range msgs {
+		var channel, key, velocity uint8
+		switch {
+		case msg.GetNoteStart(&channel, &key, &velocity):
+			k := noteKey{channel, key}
+			if len(ss.pending) == 0 {
+				ss.schedule(ss.config.gather(), ss.strum)
+			}
+			ss.pending = append(ss.pending, strumNote{key: k, msgs: msgs[start : i+1]})
+			// Until the strum is timed, the note starts no later than the end of the strum
+			ss.startAt[k] = time.Now().Add(ss.config.gather() + time.Duration(ss.config.TimeMS)*time.Millisecond)
+		case msg.GetNoteEnd(&channel, &key):
+			k := noteKey{channel, key}
+			at, held := ss.startAt[k]
+			delete(ss.startAt, k)
+			if note := ss.findPending(k); note != nil {
+				// Released before the strum, the note ends right after it starts
+				note.release = msgs[start : i+1]
+				break
+			}
+			if wait := time.Until(at); held && wait > 0 {
+				// A millisecond later, so it can't overtake its note start
+				ss.schedule(wait+time.Millisecond, ss.sender(msgs[start:i+1]))
+				break
+			}
+			if err := ss.sendAll(msgs[start : i+1]); err != nil {
+				return err
+			}
+		default:
+			continue
+		}
+		start = i + 1
+	}
+	return ss.sendAll(msgs[start:])
+}
+
+// strum sends the gathered notes spread over the strum time
+func (ss *strumState) strum() {
+	notes := ss.pending
+	ss.pending = nil
+
+	switch ss.config.Direction {
+	case "down":
+		slices.SortStableFunc(notes, func(a, b strumNote) int { return int(b.key.Note) - int(a.key.Note) })
+	case "random":
+		rand.Shuffle(len(notes), func(i, j int) { notes[i], notes[j] = notes[j], notes[i] })
+	default:
+		slices.SortStableFunc(notes, func(a, b strumNote) int { return int(a.key.Note) - int(b.key.Note) })
+	}
+
+	var step time.Duration
+	if len(notes) > 1 {
+		step = time.Duration(ss.config.TimeMS) * time.Millisecond / time.Duration(len(notes)-1)
+	}
+	now := time.Now()
+	for i, note := range notes {
+		delay := time.Duration(i) * step
+		if _, held := ss.startAt[note.key]; held {
+			ss.startAt[note.key] = now.Add(delay)
+		}
+		msgs := append(slices.Clip(note.msgs), note.release...)
+		if delay == 0 {
+			ss.sender(msgs)()
+		} else {
+			ss.schedule(delay, ss.sender(msgs))
+		}
+	}
+}
+
+// findPending returns the gathered note for a key that has not been released yet
+func (ss *strumState) findPending(k noteKey) *strumNote {
+	for i := len(ss.pending) - 1; i >= 0; i-- {
+		if ss.pending[i].key == k && ss.pending[i].release == nil {
+			return &ss.pending[i]
+		}
+	}
+	return nil
+}
+
+// sender returns a function that sends messages later, logging errors
+func (ss *strumState) sender(msgs []midi.Message) func() {
+	return func() {
+		if err := ss.sendAll(msgs); err != nil {
+			sendErrors.Printf(ss.name, "Error sending strummed note to %s: %v", ss.name, err)
+		}
+	}
+}
+
+func (ss *strumState) sendAll(msgs []midi.Message) error {
+	for _, msg := range msgs {
+		if err := ss.send(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/drivers"
+	"gitlab.com/gomidi/midi/v2/drivers/rtmididrv/imported/rtmidi"
+)
+
+// defaultSysExMaxSize is the largest SysEx message received when max_size is not set, large
+// enough for most patch and sample dumps
+const defaultSysExMaxSize = 65536
+
+// sysExPacingQueue is how many messages can wait for a paced output before sends block
+const sysExPacingQueue = 4096
+
+// SysExConfig enables receiving System Exclusive messages from the input
+type SysExConfig struct {
+	MaxSize int `json:"max_size,omitempty"` // largest message in bytes including F0 and F7, default 65536
+}
+
+// Validate checks the size limit
+func (sc *SysExConfig) Validate() error {
+	if sc.MaxSize != 0 && (sc.MaxSize < 16 || sc.MaxSize > 16*1024*1024) {
+		return fmt.Errorf("invalid max_size: %d (must be 16 bytes to 16 MB)", sc.MaxSize)
+	}
+	return nil
+}
+
+func (sc *SysExConfig) maxSize() int {
+	if sc.MaxSize == 0 {
+		return defaultSysExMaxSize
+	}
+	return sc.MaxSize
+}
+
+// SysExPacingConfig slows down SysEx sent to an output, for devices that lose data when a dump
+// arrives faster than they can store it
+type SysExPacingConfig struct {
+	BytesPerSecond int `json:"bytes_per_second,omitempty"` // SysEx data rate, unlimited when 0. MIDI DIN runs at 3125.
+	GapMS          int `json:"gap_ms,omitempty"`           // pause after each SysEx message
+}
+
+// Validate checks the pacing settings
+func (spc *SysExPacingConfig) Validate() error {
+	if spc.BytesPerSecond < 0 {
+		return fmt.Errorf("invalid bytes_per_second: %d", spc.BytesPerSecond)
+	}
+	if spc.GapMS < 0 {
+		return fmt.Errorf("invalid gap_ms: %d", spc.GapMS)
+	}
+	if spc.BytesPerSecond == 0 && spc.GapMS == 0 {
+		return fmt.Errorf("set bytes_per_second or gap_ms")
+	}
+	return nil
+}
+
+// duration returns how long a SysEx message of the given size occupies the output
+func (spc *SysExPacingConfig) duration(size int) time.Duration {
+	d := time.Duration(spc.GapMS) * time.Millisecond
+	if spc.BytesPerSecond > 0 {
+		d += time.Duration(size) * time.Second / time.Duration(spc.BytesPerSecond)
+	}
+	return d
+}
+
+// sysExReader feeds incoming bytes to a drivers.Reader, dropping SysEx messages that don't fit
+// its buffer. The reader would otherwise write past the end of its buffer.
+type sysExReader struct {
+	reader   *drivers.Reader
+	maxSize  int
+	size     int  // bytes of the current SysEx message, 0 outside SysEx
+	dropping bool // the current SysEx message is too large
+}
+
+func newSysExReader(config drivers.ListenConfig, onMsg func(msg []byte, milliseconds int32)) *sysExReader {
+	maxSize := int(config.SysExBufferSize)
+	if maxSize == 0 {
+		maxSize = 1024 // the reader's default
+	}
+	return &sysExReader{
+		reader:  drivers.NewReader(config, onMsg),
+		maxSize: maxSize,
+	}
+}
+
+// EachMessage passes data on to the reader, leaving out the rest of an oversized SysEx message.
+// Without its F7 the reader discards the partial message at the next status byte.
+func (r *sysExReader) EachMessage(data []byte, deltaMilliseconds int32) {
+	filtered := make([]byte, 0, len(data))
+	for _, b := range data {
+		switch {
+		case b >= 0xF8:
+			// Realtime bytes can come in the middle of a SysEx message without ending it
+		case b == 0xF0:
+			r.size, r.dropping = 1, false
+		case r.size == 0:
+			// Outside SysEx
+		case b == 0xF7 && r.dropping:
+			// End of a dropped message
+			r.size, r.dropping = 0, false
+			continue
+		case b >= 0x80:
+			// F7, or a non-realtime status byte interrupting the message
+			r.size, r.dropping = 0, false
+		case r.dropping:
+			continue
+		case r.size >= r.maxSize-1:
+			// Leave room for the F7
+			log.Printf("Dropping a SysEx message larger than %d bytes, raise sysex max_size to receive it", r.maxSize)
+			r.dropping = true
+			continue
+		default:
+			r.size++
+		}
+		filtered = append(filtered, b)
+	}
+	if len(filtered) > 0 {
+		r.reader.EachMessage(filtered, deltaMilliseconds)
+	}
+}
+
+// listenRTMidi passes incoming messages of an rtmidi input to onMsg, in the same way as rtmididrv.
+// When raw is set it first gets each message as rtmidi delivered it, and a message it returns
+// false for isn't parsed.
+func listenRTMidi(midiIn rtmidi.MIDIIn, onMsg func(msg []byte, milliseconds int32), config drivers.ListenConfig, raw func(msg []byte, milliseconds int32) bool) (func(), error) {
+	if err := midiIn.IgnoreTypes(!config.SysEx, !config.TimeCode, !config.ActiveSense); err != nil {
+		return nil, err
+	}
+
+	reader := newSysExReader(config, onMsg)
+	err := midiIn.SetCallback(func(_ rtmidi.MIDIIn, bt []byte, deltaSeconds float64) {
+		ms := int32(math.Round(deltaSeconds * 1000))
+		if raw != nil && len(bt) > 0 && !raw(append([]byte(nil), bt...), ms) {
+			return
+		}
+		reader.EachMessage(bt, ms)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		midiIn.CancelCallback()
+	}, nil
+}
+
+// sysExIn listens to an rtmididrv input through sysExReader, so oversized SysEx is dropped
+type sysExIn struct {
+	drivers.In
+}
+
+// Listen passes incoming messages to onMsg
+func (i sysExIn) Listen(onMsg func(msg []byte, milliseconds int32), config drivers.ListenConfig) (func(), error) {
+	midiIn, ok := i.In.Underlying().(rtmidi.MIDIIn)
+	if !ok {
+		return i.In.Listen(onMsg, config)
+	}
+	return listenRTMidi(midiIn, onMsg, config, nil)
+}
+
+// pacedSender queues the messages of an output and sends them in order from a goroutine, waiting
+// after each SysEx message as configured. The returned stop function drops unsent messages.
+func pacedSender(send func(midi.Message) error, pacing *SysExPacingConfig, name string) (func(midi.Message) error, func()) {
+	queue := make(chan midi.Message, sysExPacingQueue)
+	stopped := make(chan struct{})
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer recoverFailsafe()
+		var nextSysEx time.Time
+		for {
+			select {
+			case msg := <-queue:
+				if msg.Is(midi.SysExMsg) {
+					if wait := time.Until(nextSysEx); wait > 0 {
+						select {
+						case <-time.After(wait):
+						case <-stopped:
+							return
+						}
+					}
+					nextSysEx = time.Now().Add(pacing.duration(len(msg)))
+				}
+				if err := send(msg); err != nil {
+					sendErrors.Printf(name, "Error sending to %s: %v", name, err)
+				}
+			case <-stopped:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			close(stopped)
+			wg.Wait()
+		})
+	}
+
+	return func(msg midi.Message) error {
+		select {
+		case queue <- msg:
+			return nil
+		case <-stopped:
+			return drivers.ErrPortClosed
+		}
+	}, stop
+}
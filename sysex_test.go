@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"gitlab.com/gomidi/midi/v2/drivers"
+)
+
+func TestSysExReader(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks [][]byte
+		want   []string
+	}{
+		{
+			"split SysEx",
+			[][]byte{{0xF0, 0x01, 0x02}, {0x03, 0xF7}},
+			[]string{"F0 01 02 03 F7"},
+		},
+		{
+			"clock in the middle of a SysEx message",
+			[][]byte{{0xF0, 0x01, 0x02}, {0xF8}, {0x03, 0xF7}},
+			[]string{"F8", "F0 01 02 03 F7"},
+		},
+		{
+			"SysEx that just fits",
+			[][]byte{{0xF0, 1, 2, 3, 4, 5, 6, 0xF7}},
+			[]string{"F0 01 02 03 04 05 06 F7"},
+		},
+		{
+			"oversized SysEx is dropped",
+			[][]byte{{0xF0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 0xF7}, {0x90, 0x3C, 0x64}},
+			[]string{"90 3C 64"},
+		},
+		{
+			"oversized SysEx split by clock bytes is dropped",
+			[][]byte{{0xF0, 1, 2, 3, 0xF8, 4, 5}, {0xF8, 6, 7, 8, 9}, {0xF8, 10, 11, 0xF7}, {0x90, 0x3C, 0x64}},
+			[]string{"F8", "F8", "F8", "90 3C 64"},
+		},
+		{
+			"status byte ends an oversized SysEx message",
+			[][]byte{{0xF0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, {0x90, 0x3C, 0x64}, {0xF0, 1, 0xF7}},
+			[]string{"90 3C 64", "F0 01 F7"},
+		},
+	}
+	for _, test := range tests {
+		var got []string
+		config := drivers.ListenConfig{SysEx: true, TimeCode: true, SysExBufferSize: 8}
+		reader := newSysExReader(config, func(msg []byte, milliseconds int32) {
+			got = append(got, fmt.Sprintf("% X", msg))
+		})
+		for _, chunk := range test.chunks {
+			reader.EachMessage(chunk, 0)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
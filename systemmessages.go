@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// activeSenseInterval is how often generated Active Sensing messages are sent. Receivers assume
+// the connection is lost after 300ms without any message.
+const activeSenseInterval = 250 * time.Millisecond
+
+// SystemMessagesConfig controls Active Sensing, undefined and system common messages for an output
+type SystemMessagesConfig struct {
+	ActiveSensing    string `json:"active_sensing,omitempty"`     // "forward", "strip" or "generate", passes what the input delivers when empty
+	DropUndefined    bool   `json:"drop_undefined,omitempty"`     // drop the undefined status bytes F4, F5, F9 and FD
+	DropSystemCommon bool   `json:"drop_system_common,omitempty"` // drop MTC quarter frames, song select and tune request
+}
+
+// Validate checks the active sensing mode
+func (smc *SystemMessagesConfig) Validate() error {
+	switch smc.ActiveSensing {
+	case "", "forward", "strip", "generate":
+		return nil
+	default:
+		return fmt.Errorf("invalid active sensing mode: %q (must be forward, strip or generate)", smc.ActiveSensing)
+	}
+}
+
+// ShouldPass tests if a system message should be sent to the output. Generated Active Sensing
+// replaces the input's.
+func (smc *SystemMessagesConfig) ShouldPass(msg midi.Message) bool {
+	if len(msg) == 0 {
+		return true
+	}
+
+	switch msg[0] {
+	case 0xFE:
+		return smc.ActiveSensing != "strip" && smc.ActiveSensing != "generate"
+	case 0xF4, 0xF5, 0xF9, 0xFD:
+		return !smc.DropUndefined
+	case 0xF1, 0xF3, 0xF6:
+		return !smc.DropSystemCommon
+	}
+	return true
+}
+
+// configForwardsActiveSensing reports whether any output wants the input's Active Sensing,
+// which the MIDI driver filters out unless requested
+func configForwardsActiveSensing(config *Config) bool {
+	for _, output := range config.Outputs {
+		if output.SystemMessages != nil && output.SystemMessages.ActiveSensing == "forward" {
+			return true
+		}
+	}
+	return false
+}
+
+// generateActiveSensing sends Active Sensing to the outputs that generate it until stop is closed
+func generateActiveSensing(config *Config, senders []func(midi.Message) error, stop <-chan struct{}) {
+	var outputs []int
+	for i, output := range config.Outputs {
+		if output.SystemMessages != nil && output.SystemMessages.ActiveSensing == "generate" {
+			outputs = append(outputs, i)
+		}
+	}
+	if len(outputs) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(activeSenseInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, i := range outputs {
+				if err := senders[i](midi.Activesense()); err != nil {
+					name := config.outputPortName(i)
+					sendErrors.Printf(name, "Error sending active sensing to %s: %v", name, err)
+				}
+			}
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"time"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/drivers"
+	"gitlab.com/gomidi/midi/v2/drivers/rtmididrv/imported/rtmidi"
+)
+
+// configHasThru reports whether any output, or the debug output, copies its inputs verbatim
+func configHasThru(config *Config) bool {
+	if config.DebugOutput != "" {
+		return true
+	}
+	for _, output := range config.Outputs {
+		if output.Thru {
+			return true
+		}
+	}
+	return false
+}
+
+// receivesSysEx reports whether SysEx is received from the inputs, for the sysex setting or thru
+// outputs
+func (c *Config) receivesSysEx() bool {
+	return c.SysEx != nil || configHasThru(c)
+}
+
+// sysExMaxSize returns the largest SysEx message received from the inputs
+func (c *Config) sysExMaxSize() int {
+	if c.SysEx != nil {
+		return c.SysEx.maxSize()
+	}
+	return defaultSysExMaxSize
+}
+
+// thruIn listens to an rtmidi input and passes each message to raw exactly as the driver
+// delivered it, before it is parsed. Parsing drops undefined status bytes and SysEx over the size
+// limit, which thru outputs should still get.
+type thruIn struct {
+	drivers.In
+	raw func(msg []byte, milliseconds int32) bool
+}
+
+// receivesRaw reports whether an input delivers its messages unparsed through thruIn
+func receivesRaw(in drivers.In) bool {
+	_, ok := in.Underlying().(rtmidi.MIDIIn)
+	return ok
+}
+
+// Listen passes incoming messages to raw and then to onMsg
+func (i thruIn) Listen(onMsg func(msg []byte, milliseconds int32), config drivers.ListenConfig) (func(), error) {
+	midiIn, ok := i.In.Underlying().(rtmidi.MIDIIn)
+	if !ok {
+		return i.In.Listen(onMsg, config)
+	}
+	return listenRTMidi(midiIn, onMsg, config, i.raw)
+}
+
+// listenRaw has the router take the messages of an input as the driver delivered them for the
+// thru outputs and the debug output. Must be called before any input is listened to.
+func (r *router) listenRaw(input string) {
+	r.rawInputs[input] = true
+}
+
+// HandleRawAt sends a message exactly as the driver delivered it to the thru outputs and the debug
+// output. Returns false for a message dropped as feedback, which isn't handled any further.
+func (r *router) HandleRawAt(input string, data []byte, driverMS int32) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.driverMS = &driverMS
+	defer func() { r.driverMS = nil }()
+
+	msg := midi.Message(data)
+	if r.loops.Looping(input, msg, time.Now()) {
+		r.stats.Dropped(msg)
+		return false
+	}
+	r.sendMirror(msg)
+	r.sendThru(input, msg)
+	return true
+}
+
+// sendThru copies an incoming message unchanged to the thru outputs that take its input, before
+// any other handling. Returns whether any thru output sent it.
+func (r *router) sendThru(input string, msg midi.Message) bool {
+	sent := false
+	for i, output := range r.config.Outputs {
+		if !output.Thru || !output.acceptsInput(r.config, input) || !r.enabled[i].Load() {
+			continue
+		}
+		if err := r.sendTo(i, msg); err != nil {
+			sendErrors.Printf(r.outputName(i), "Error sending to %s: %v", r.outputName(i), err)
+			r.stats.Error(i)
+			continue
+		}
+		logSuccessfulRoute(r.outputName(i), outputColor(&output, i), msg, &MessageTransformation{Input: r.inputLabel(input), DriverMS: r.driverMS}, r.logging)
+		r.stats.Routed(i, msg)
+		sent = true
+	}
+	return sent
+}
+
+// mirrorTo sends a copy of every input message to the debug output. The copies are left out of
+// loop detection, since they would make every repeated input message look like an echo.
+func (r *router) mirrorTo(send func(midi.Message) error) {
+	r.mirror = send
+}
+
+// sendMirror copies an incoming message unchanged to the debug output, if there is one
+func (r *router) sendMirror(msg midi.Message) {
+	if r.mirror == nil {
+		return
+	}
+	if err := r.mirror(msg); err != nil {
+		name := r.config.debugPortName()
+		sendErrors.Printf(name, "Error sending to %s: %v", name, err)
+	}
+}
+
+// thruOnly reports whether a message is only received for thru outputs, so the other outputs
+// don't see messages they wouldn't receive without them
+func (r *router) thruOnly(msg midi.Message) bool {
+	switch {
+	case msg.Is(midi.TimingClockMsg), msg.Is(midi.MTCMsg):
+		return !configNeedsClock(r.config)
+	case msg.Is(midi.ActiveSenseMsg):
+		return !configForwardsActiveSensing(r.config)
+	case msg.Is(midi.SysExMsg):
+		return r.config.SysEx == nil
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// timestampSources lists the clocks log lines can be timestamped with
+var timestampSources = []string{"monotonic", "driver"}
+
+// processStarted is when the process started, the zero of monotonic timestamps
+var processStarted = time.Now()
+
+// validateTimestampSource checks a --timestamps value, empty for none
+func validateTimestampSource(source string) error {
+	if source == "" {
+		return nil
+	}
+	for _, known := range timestampSources {
+		if source == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid timestamps: %q (must be %s)", source, strings.Join(timestampSources, " or "))
+}
+
+// monotonicSeconds returns the seconds since the process started, from the monotonic clock
+func monotonicSeconds(t time.Time) float64 {
+	return t.Sub(processStarted).Seconds()
+}
+
+// timestamp returns the timestamp a log line starts with, empty when timestamps are off.
+// Monotonic timestamps are seconds since the process started, with microsecond resolution.
+// Driver timestamps are the milliseconds since the input was opened that the MIDI driver
+// attached to the incoming message, "-" for messages that didn't come from an input.
+func (lo logOptions) timestamp(driverMS *int32) string {
+	switch lo.Timestamps {
+	case "monotonic":
+		return fmt.Sprintf("%.6f ", monotonicSeconds(time.Now()))
+	case "driver":
+		if driverMS == nil {
+			return "- "
+		}
+		return fmt.Sprintf("%dms ", *driverMS)
+	}
+	return ""
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// TransportMapConfig replaces or blocks Start, Stop and Continue messages sent to an output
+type TransportMapConfig struct {
+	Start    *TransportAction `json:"start,omitempty"`
+	Stop     *TransportAction `json:"stop,omitempty"`
+	Continue *TransportAction `json:"continue,omitempty"`
+}
+
+// TransportAction is what an output receives instead of a transport message
+type TransportAction struct {
+	Block    bool          `json:"block,omitempty"`    // drop the transport message
+	Messages []MessageSpec `json:"messages,omitempty"` // messages sent in place of the transport message
+}
+
+// Validate checks the transport mapping
+func (tmc *TransportMapConfig) Validate() error {
+	for name, action := range map[string]*TransportAction{"start": tmc.Start, "stop": tmc.Stop, "continue": tmc.Continue} {
+		if action == nil {
+			continue
+		}
+		if action.Block == (len(action.Messages) > 0) {
+			return fmt.Errorf("%s needs either block or messages", name)
+		}
+		for _, spec := range action.Messages {
+			if err := spec.Validate(); err != nil {
+				return fmt.Errorf("invalid %s message: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// action returns the mapping for a transport message, or nil if the message is not mapped
+func (tmc *TransportMapConfig) action(msg midi.Message) *TransportAction {
+	switch {
+	case msg.Is(midi.StartMsg):
+		return tmc.Start
+	case msg.Is(midi.StopMsg):
+		return tmc.Stop
+	case msg.Is(midi.ContinueMsg):
+		return tmc.Continue
+	}
+	return nil
+}
+
+// applyTransportMap returns the messages to send in place of a mapped transport message.
+// handled is false when the message is not a mapped transport message and should be processed normally.
+func applyTransportMap(msg midi.Message, transportMap *TransportMapConfig) (messages []midi.Message, handled bool) {
+	if transportMap == nil {
+		return nil, false
+	}
+	action := transportMap.action(msg)
+	if action == nil {
+		return nil, false
+	}
+	for _, spec := range action.Messages {
+		messages = append(messages, spec.Message())
+	}
+	return messages, true
+}
+
+// gateClock wraps an output's sender to only send clock ticks while the output's transport is
+// running, for drum machines that misbehave on clock without a Start. The output counts as running
+// once it has been sent Start or Continue, until it is sent Stop.
+func gateClock(send func(midi.Message) error) func(midi.Message) error {
+	var running atomic.Bool
+	return func(msg midi.Message) error {
+		if msg.Is(midi.TimingClockMsg) && !running.Load() {
+			return nil
+		}
+		if err := send(msg); err != nil {
+			return err
+		}
+		switch {
+		case msg.Is(midi.StartMsg), msg.Is(midi.ContinueMsg):
+			running.Store(true)
+		case msg.Is(midi.StopMsg):
+			running.Store(false)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// TriggerConfig maps an input note or controller, such as a pad or button, to a router control
+type TriggerConfig struct {
+	Type    string `json:"type"`              // "note" or "cc"
+	Number  uint8  `json:"number"`            // note or controller number, 0-127
+	Channel uint8  `json:"channel,omitempty"` // 1-16, any channel when omitted
+}
+
+// Validate checks the trigger settings
+func (tc *TriggerConfig) Validate() error {
+	if tc.Type != "note" && tc.Type != "cc" {
+		return fmt.Errorf("invalid trigger type: %q (must be note or cc)", tc.Type)
+	}
+	if tc.Number > 127 {
+		return fmt.Errorf("invalid trigger number: %d (must be 0-127)", tc.Number)
+	}
+	if tc.Channel > 16 {
+		return fmt.Errorf("invalid trigger channel: %d (must be 1-16)", tc.Channel)
+	}
+	return nil
+}
+
+// Match tests a message against the trigger. matched is true for both the press and release of the
+// control, so the caller can keep them from being routed; fired is true only for the press.
+// Controllers count as pressed at values of 64 and above.
+func (tc *TriggerConfig) Match(msg midi.Message) (matched bool, fired bool) {
+	if tc == nil {
+		return false, false
+	}
+
+	var channel, number, value uint8
+	switch tc.Type {
+	case "note":
+		if msg.GetNoteStart(&channel, &number, &value) {
+			fired = true
+		} else if !msg.GetNoteEnd(&channel, &number) {
+			return false, false
+		}
+	case "cc":
+		if !msg.GetControlChange(&channel, &number, &value) {
+			return false, false
+		}
+		fired = value >= 64
+	default:
+		return false, false
+	}
+
+	if number != tc.Number || (tc.Channel != 0 && channel+1 != tc.Channel) {
+		return false, false
+	}
+	return true, fired
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// tuningBaseFrequency is the frequency of MIDI note 0 in standard tuning, used by .tun files
+const tuningBaseFrequency = 8.1757989156
+
+// TuningConfig retunes notes by sending each note on its own channel with a pitch bend offset
+type TuningConfig struct {
+	File           string  `json:"file"`                       // Scala .scl or AnaMark .tun file
+	ReferenceNote  *uint8  `json:"reference_note,omitempty"`   // note the .scl scale starts on, default 60
+	Channels       []uint8 `json:"channels"`                   // 1-16, channels used for per-note pitch bend
+	PitchBendRange uint8   `json:"pitch_bend_range,omitempty"` // semitones, must match the synth, default 2
+}
+
+// Validate checks the tuning settings and that the tuning file can be loaded
+func (tc *TuningConfig) Validate() error {
+	if len(tc.Channels) == 0 {
+		return fmt.Errorf("tuning needs at least one channel")
+	}
+	for _, channel := range tc.Channels {
+		if channel < 1 || channel > 16 {
+			return fmt.Errorf("invalid tuning channel: %d (must be 1-16)", channel)
+		}
+	}
+	if tc.ReferenceNote != nil && *tc.ReferenceNote > 127 {
+		return fmt.Errorf("invalid reference note: %d (must be 0-127)", *tc.ReferenceNote)
+	}
+	if tc.PitchBendRange > 48 {
+		return fmt.Errorf("invalid pitch bend range: %d (must be 1-48)", tc.PitchBendRange)
+	}
+	_, err := tc.load()
+	return err
+}
+
+// load reads the tuning file into a table of pitches for every MIDI note
+func (tc *TuningConfig) load() (*tuningTable, error) {
+	referenceNote := uint8(60)
+	if tc.ReferenceNote != nil {
+		referenceNote = *tc.ReferenceNote
+	}
+	return loadTuningFile(tc.File, referenceNote)
+}
+
+// bendRange returns the synth's pitch bend range in semitones
+func (tc *TuningConfig) bendRange() uint8 {
+	if tc.PitchBendRange == 0 {
+		return 2
+	}
+	return tc.PitchBendRange
+}
+
+// tuningTable holds the pitch of every MIDI note in cents above MIDI note 0 in standard tuning
+type tuningTable [128]float64
+
+// loadTuningFile loads a Scala .scl or AnaMark .tun file based on its extension
+func loadTuningFile(filename string, referenceNote uint8) (*tuningTable, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tuning file: %w", err)
+	}
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".scl":
+		return parseScala(file, referenceNote)
+	case ".tun":
+		return parseTun(file)
+	default:
+		return nil, fmt.Errorf("unsupported tuning file: %s (expected .scl or .tun)", filename)
+	}
+}
+
+// parseScala parses a Scala scale and maps it linearly onto the keyboard starting at the reference note,
+// which keeps its standard pitch
+func parseScala(file io.Reader, referenceNote uint8) (*tuningTable, error) {
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "!") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read tuning file: %w", err)
+	}
+
+	// First line is the description, second the number of pitches
+	if len(lines) < 2 {
+		return nil, fmt.Errorf("invalid scala file: missing header")
+	}
+	fields := strings.Fields(lines[1])
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("invalid scala file: missing note count")
+	}
+	count, err := strconv.Atoi(fields[0])
+	if err != nil || count < 1 {
+		return nil, fmt.Errorf("invalid scala file: bad note count %q", lines[1])
+	}
+	if len(lines)-2 < count {
+		return nil, fmt.Errorf("invalid scala file: expected %d pitches, found %d", count, len(lines)-2)
+	}
+
+	degrees := make([]float64, count+1)
+	for i := 0; i < count; i++ {
+		cents, err := parseScalaPitch(lines[2+i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid scala file: %w", err)
+		}
+		degrees[i+1] = cents
+	}
+	period := degrees[count]
+
+	var table tuningTable
+	for note := 0; note < 128; note++ {
+		steps := note - int(referenceNote)
+		octave := floorDiv(steps, count)
+		degree := steps - octave*count
+		table[note] = float64(referenceNote)*100 + float64(octave)*period + degrees[degree]
+	}
+	return &table, nil
+}
+
+// parseScalaPitch converts a scala pitch line (cents if it contains a period, otherwise a ratio) to cents
+func parseScalaPitch(line string) (float64, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("empty pitch line")
+	}
+	value := fields[0]
+
+	if strings.Contains(value, ".") {
+		cents, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, fmt.Errorf("bad cents value %q", value)
+		}
+		return cents, nil
+	}
+
+	numerator, denominator := value, "1"
+	if parts := strings.SplitN(value, "/", 2); len(parts) == 2 {
+		numerator, denominator = parts[0], parts[1]
+	}
+	n, err1 := strconv.ParseFloat(numerator, 64)
+	d, err2 := strconv.ParseFloat(denominator, 64)
+	if err1 != nil || err2 != nil || n <= 0 || d <= 0 {
+		return 0, fmt.Errorf("bad ratio %q", value)
+	}
+	return 1200 * math.Log2(n/d), nil
+}
+
+// parseTun parses the [Tuning] and [Exact Tuning] sections of an AnaMark .tun file.
+// Notes that are not listed keep standard tuning.
+func parseTun(file io.Reader) (*tuningTable, error) {
+	var table tuningTable
+	for note := range table {
+		table[note] = float64(note) * 100
+	}
+
+	baseOffset := 0.0
+	section := ""
+	exact := make(map[int]float64)
+	approximate := make(map[int]float64)
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			section = strings.ToLower(strings.Trim(line, "[]"))
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || (section != "tuning" && section != "exact tuning") {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tun file: bad value in %q", line)
+		}
+
+		if section == "exact tuning" && key == "basefreq" {
+			if number <= 0 {
+				return nil, fmt.Errorf("invalid tun file: bad base frequency %g", number)
+			}
+			baseOffset = 1200 * math.Log2(number/tuningBaseFrequency)
+			continue
+		}
+
+		var note int
+		if _, err := fmt.Sscanf(key, "note %d", &note); err != nil || note < 0 || note > 127 {
+			continue
+		}
+		switch section {
+		case "tuning":
+			approximate[note] = number
+		case "exact tuning":
+			exact[note] = number
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read tuning file: %w", err)
+	}
+
+	for note, cents := range approximate {
+		table[note] = cents
+	}
+	// Exact values take precedence and are relative to the base frequency
+	for note, cents := range exact {
+		table[note] = cents + baseOffset
+	}
+	return &table, nil
+}
+
+// nearestNote returns the closest standard MIDI note to a note's tuned pitch and the remaining offset in cents
+func (table *tuningTable) nearestNote(note uint8) (uint8, float64, bool) {
+	cents := table[note]
+	nearest := math.Round(cents / 100)
+	if nearest < 0 || nearest > 127 {
+		return 0, 0, false
+	}
+	return uint8(nearest), cents - nearest*100, true
+}
+
+// tuningState assigns every sounding note its own channel so it can carry its own pitch bend
+type tuningState struct {
+	config   *TuningConfig
+	table    *tuningTable
+	channels *channelRotationState
+}
+
+func newTuningState(config *TuningConfig) (*tuningState, error) {
+	table, err := config.load()
+	if err != nil {
+		return nil, err
+	}
+	return &tuningState{
+		config:   config,
+		table:    table,
+		channels: newChannelRotationState(&ChannelRotationConfig{Channels: config.Channels}),
+	}, nil
+}
+
+// pitchBendFor converts a cent offset into a pitch bend value for the configured bend range
+func (state *tuningState) pitchBendFor(cents float64) int16 {
+	bend := math.Round(cents / (float64(state.config.bendRange()) * 100) * 8192)
+	return int16(math.Max(-8192, math.Min(8191, bend)))
+}
+
+// retuneMessage rewrites a single message for per-note pitch bend tuning
+func (state *tuningState) retuneMessage(msg midi.Message) []midi.Message {
+	var channel, key, velocity uint8
+
+	if msg.GetNoteStart(&channel, &key, &velocity) {
+		tunedNote, cents, ok := state.table.nearestNote(key)
+		if !ok {
+			return nil
+		}
+		assigned, stolen := state.channels.allocate(noteKey{channel, key})
+		var messages []midi.Message
+		if stolen != nil {
+			if stolenNote, _, ok := state.table.nearestNote(stolen.Note); ok {
+				messages = append(messages, midi.NoteOff(assigned, stolenNote))
+			}
+		}
+		return append(messages,
+			midi.Pitchbend(assigned, state.pitchBendFor(cents)),
+			midi.NoteOn(assigned, tunedNote, velocity))
+	}
+
+	if msg.GetNoteEnd(&channel, &key) {
+		k := noteKey{channel, key}
+		assigned, ok := state.channels.assigned[k]
+		if !ok {
+			return nil
+		}
+		delete(state.channels.assigned, k)
+		delete(state.channels.holders, assigned)
+		tunedNote, _, ok := state.table.nearestNote(key)
+		if !ok {
+			return nil
+		}
+		return []midi.Message{midi.NoteOff(assigned, tunedNote)}
+	}
+
+	// Incoming pitch bend would overwrite the tuning offsets
+	if msg.Is(midi.PitchBendMsg) {
+		return nil
+	}
+
+	// Poly aftertouch and the remaining channel messages follow the channel rotation rules
+	return state.channels.rotateMessage(msg)
+}
+
+// applyTuning retunes every note in the messages, including harmony notes
+func applyTuning(msgs []midi.Message, state *tuningState, transform *MessageTransformation) []midi.Message {
+	if state == nil {
+		return msgs
+	}
+
+	var tuned []midi.Message
+	for i, msg := range msgs {
+		out := state.retuneMessage(msg)
+		// Record the note and channel change of the played note for logging
+		if i == 0 && len(out) > 0 && isNoteMessage(msg) {
+			var channel, key, velocity uint8
+			var tunedChannel, tunedKey uint8
+			last := out[len(out)-1]
+			if (msg.GetNoteOn(&channel, &key, &velocity) || msg.GetNoteOff(&channel, &key, &velocity)) &&
+				(last.GetNoteOn(&tunedChannel, &tunedKey, &velocity) || last.GetNoteOff(&tunedChannel, &tunedKey, &velocity)) {
+				originalChannel, transformedChannel := channel+1, tunedChannel+1
+				transform.OriginalChannel = &originalChannel
+				transform.TransformedChannel = &transformedChannel
+				if tunedKey != key {
+					// Keep the played note if transposition already recorded one
+					if transform.OriginalNote == nil {
+						transform.OriginalNote = &key
+					}
+					transform.TransformedNote = &tunedKey
+				}
+			}
+		}
+		tuned = append(tuned, out...)
+	}
+	return tuned
+}
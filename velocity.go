@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// VelocityCompressorConfig compresses note on velocities above a threshold like an audio
+// compressor, taming hard hits while leaving softer playing untouched
+type VelocityCompressorConfig struct {
+	Threshold uint8   `json:"threshold"`        // velocity where compression starts, 1-127
+	Ratio     float64 `json:"ratio"`            // 4 turns 4 steps above the threshold into 1, values below 1 expand
+	Makeup    int     `json:"makeup,omitempty"` // added to every velocity after compression, -126 to 126
+}
+
+// Validate checks the compressor settings
+func (vc *VelocityCompressorConfig) Validate() error {
+	if vc.Threshold < 1 || vc.Threshold > 127 {
+		return fmt.Errorf("invalid threshold: %d (must be 1-127)", vc.Threshold)
+	}
+	if vc.Ratio <= 0 || vc.Ratio > 100 {
+		return fmt.Errorf("invalid ratio: %g (must be greater than 0 and at most 100)", vc.Ratio)
+	}
+	if vc.Makeup < -126 || vc.Makeup > 126 {
+		return fmt.Errorf("invalid makeup: %d (must be -126 to 126)", vc.Makeup)
+	}
+	return nil
+}
+
+// Compress returns the velocity after compression and makeup, kept within 1-127 so note ons
+// never become note offs
+func (vc *VelocityCompressorConfig) Compress(velocity uint8) uint8 {
+	compressed := float64(velocity)
+	if velocity > vc.Threshold {
+		compressed = float64(vc.Threshold) + float64(velocity-vc.Threshold)/vc.Ratio
+	}
+	compressed = math.Round(compressed) + float64(vc.Makeup)
+	return uint8(max(1, min(127, compressed)))
+}
+
+// applyVelocityCompressor compresses the velocity of note on messages
+func applyVelocityCompressor(msg midi.Message, compressor *VelocityCompressorConfig, transform *MessageTransformation) midi.Message {
+	var channel, key, velocity uint8
+	if compressor == nil || !msg.GetNoteStart(&channel, &key, &velocity) {
+		return msg
+	}
+
+	compressed := compressor.Compress(velocity)
+	if compressed == velocity {
+		return msg
+	}
+	transform.OriginalVelocity = &velocity
+	transform.TransformedVelocity = &compressed
+	return midi.NoteOn(channel, key, compressed)
+}
+
+// velocityGate drops note ons softer than a minimum velocity, such as a pad brushed by accident,
+// and remembers them so their note offs are dropped too
+type velocityGate struct {
+	minVelocity uint8
+	dropped     map[noteKey]bool
+}
+
+func newVelocityGate(minVelocity uint8) *velocityGate {
+	return &velocityGate{minVelocity: minVelocity, dropped: make(map[noteKey]bool)}
+}
+
+// ShouldPass tests if a MIDI message should pass through this velocity gate
+func (vg *velocityGate) ShouldPass(msg midi.Message) bool {
+	var channel, key, velocity uint8
+	if msg.GetNoteStart(&channel, &key, &velocity) {
+		pass := velocity >= vg.minVelocity
+		vg.dropped[noteKey{channel, key}] = !pass
+		return pass
+	}
+	if msg.GetNoteEnd(&channel, &key) {
+		k := noteKey{channel, key}
+		pass := !vg.dropped[k]
+		delete(vg.dropped, k)
+		return pass
+	}
+	// Non-note messages pass through
+	return true
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+)
+
+// webLogSize is how many of the latest messages the log viewer can scroll back to when it opens
+const webLogSize = 2000
+
+// webLogPage is the log viewer, a page that follows the messages sent to the outputs with filters
+//
+//go:embed web/log.html
+var webLogPage []byte
+
+// webLogBuffer keeps the latest messages sent to the outputs, oldest first once full
+type webLogBuffer struct {
+	messages []webSocketMessage
+	next     int // where the next message goes once the buffer is full
+}
+
+// Add keeps a message, replacing the oldest when the buffer is full
+func (lb *webLogBuffer) Add(message webSocketMessage) {
+	if len(lb.messages) < webLogSize {
+		lb.messages = append(lb.messages, message)
+		return
+	}
+	lb.messages[lb.next] = message
+	lb.next = (lb.next + 1) % webLogSize
+}
+
+// Messages returns the kept messages, oldest first
+func (lb *webLogBuffer) Messages() []webSocketMessage {
+	messages := make([]webSocketMessage, 0, len(lb.messages))
+	messages = append(messages, lb.messages[lb.next:]...)
+	return append(messages, lb.messages[:lb.next]...)
+}
+
+// serveLogViewer serves the log viewer page. The page carries no messages, it fetches them with
+// the token in its own URL.
+func (b *webSocketBridge) serveLogViewer(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(webLogPage)
+}
+
+// serveRecent serves the latest messages as a JSON array, for the log viewer's scrollback
+func (b *webSocketBridge) serveRecent(w http.ResponseWriter, req *http.Request) {
+	if _, authorized := b.authorize(req); !authorized {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	b.mu.Lock()
+	messages := b.recent.Messages()
+	b.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(messages)
+}
@@ -0,0 +1,502 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/drivers"
+)
+
+// webSocketGUID is appended to a client's key to compute the handshake's accept key
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// webSocketQueue is how many messages a slow client can fall behind before messages are dropped
+const webSocketQueue = 256
+
+// webSocketMaxFrame is the largest frame, or message assembled from continuation frames, accepted
+// from a client
+const webSocketMaxFrame = 64 * 1024
+
+// WebSocket opcodes
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+// webSocketMessage is the JSON form of a message sent to or received from a client. data holds
+// the message bytes as numbers, like the data of a Web MIDI message event.
+type webSocketMessage struct {
+	Output string `json:"output,omitempty"`  // output the message was sent to
+	Data   []int  `json:"data"`              // message bytes, e.g. [144, 60, 100]
+	TimeMS int64  `json:"time_ms,omitempty"` // milliseconds since the bridge started
+	Macro  string `json:"macro,omitempty"`   // name of a macro to fire, sent by clients instead of data
+}
+
+// webSocketRoles lists the roles a WebSocket token can have. Watch clients only receive messages,
+// control clients can also send them.
+var webSocketRoles = []string{"watch", "control"}
+
+// WebSocketTokenConfig lets the clients that connect with a token in, with a role
+type WebSocketTokenConfig struct {
+	Token string `json:"token"`
+	Role  string `json:"role"`           // "watch" or "control"
+	Name  string `json:"name,omitempty"` // who the token is for, shown in the log
+}
+
+// Validate checks the token settings
+func (tc *WebSocketTokenConfig) Validate() error {
+	if tc.Token == "" {
+		return fmt.Errorf("token is required")
+	}
+	if !slices.Contains(webSocketRoles, tc.Role) {
+		return fmt.Errorf("invalid role: %q (must be %s)", tc.Role, strings.Join(webSocketRoles, " or "))
+	}
+	return nil
+}
+
+// webSocketBridge serves the messages sent to the outputs to WebSocket clients, and passes the
+// messages clients send to the router's WebSocket input. It lasts across router restarts.
+type webSocketBridge struct {
+	mu      sync.Mutex
+	started time.Time
+	clients map[*webSocketClient]bool
+	onMsg   func(msg []byte)       // the WebSocket input's listener, nil when not listening
+	tokens  []WebSocketTokenConfig // when set, clients need one of the tokens to connect
+	running *runningRouter         // the router currently running, for firing macros
+	recent  webLogBuffer           // the latest messages, for the log viewer
+	origins []string               // web pages on other origins that may connect
+}
+
+// webSocketClient is a connected client, subscribed to one output or all of them
+type webSocketClient struct {
+	conn   net.Conn
+	output string // empty for every output
+	binary bool   // send raw message bytes in binary frames instead of JSON
+	token  *WebSocketTokenConfig
+	warned bool // logged that the client can't send
+	queue  chan []byte
+	done   chan struct{}
+}
+
+// SetTokens replaces the tokens clients connect with, disconnecting the clients whose token was
+// removed or changed role. Without tokens every client can connect and send.
+func (b *webSocketBridge) SetTokens(tokens []WebSocketTokenConfig) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = tokens
+	for client := range b.clients {
+		if client.token == nil && len(tokens) == 0 {
+			continue
+		}
+		if client.token != nil {
+			if token := b.findTokenLocked(client.token.Token); token != nil && token.Role == client.token.Role {
+				continue
+			}
+		}
+		client.conn.Close()
+	}
+}
+
+// findTokenLocked returns the token settings of a token, or nil when it isn't known
+func (b *webSocketBridge) findTokenLocked(token string) *WebSocketTokenConfig {
+	for i := range b.tokens {
+		if subtle.ConstantTimeCompare([]byte(b.tokens[i].Token), []byte(token)) == 1 {
+			return &b.tokens[i]
+		}
+	}
+	return nil
+}
+
+// canSend reports whether a client may send messages to the WebSocket input
+func (c *webSocketClient) canSend() bool {
+	return c.token == nil || c.token.Role == "control"
+}
+
+// name describes the client in the log
+func (c *webSocketClient) name() string {
+	if c.token != nil && c.token.Name != "" {
+		return c.token.Name
+	}
+	return c.conn.RemoteAddr().String()
+}
+
+// startWebSocketBridge serves the bridge on the given host and port in the background. Pages from
+// origins other than the bridge's own can only connect when listed in origins.
+func startWebSocketBridge(host string, port int, origins []string, running *runningRouter) (*webSocketBridge, error) {
+	bridge := &webSocketBridge{started: time.Now(), clients: make(map[*webSocketClient]bool), running: running, origins: origins}
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on port %d: %w", port, err)
+	}
+	go func() {
+		if err := http.Serve(listener, bridge); err != nil {
+			log.Printf("WebSocket bridge stopped: %v", err)
+		}
+	}()
+	return bridge, nil
+}
+
+// allowedOrigin reports whether a request may connect from the page it came from. Browsers don't
+// keep other sites from opening WebSocket connections, so a page on any site could otherwise reach
+// the router. Clients that aren't browsers send no Origin and are let in.
+func (b *webSocketBridge) allowedOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" || slices.Contains(b.origins, origin) {
+		return true
+	}
+	parsed, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(parsed.Host, req.Host)
+}
+
+// authorize returns the token settings of a request's token, and whether the request may connect.
+// Browsers can't set headers on WebSocket connections, so the token can also be in the URL.
+func (b *webSocketBridge) authorize(req *http.Request) (*WebSocketTokenConfig, bool) {
+	token := req.URL.Query().Get("token")
+	if bearer, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer "); ok {
+		token = bearer
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.tokens) == 0 {
+		return nil, true
+	}
+	found := b.findTokenLocked(token)
+	if found == nil {
+		return nil, false
+	}
+	copied := *found
+	return &copied, true
+}
+
+// ServeHTTP upgrades a request to a WebSocket connection. "/" subscribes to every output and
+// "/outputs/<name>" to one, "?format=binary" selects binary frames. "/log" serves the log viewer.
+func (b *webSocketBridge) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var output string
+	switch {
+	case req.URL.Path == "/":
+	case strings.HasPrefix(req.URL.Path, "/outputs/"):
+		output = strings.TrimPrefix(req.URL.Path, "/outputs/")
+	case req.URL.Path == "/log":
+		b.serveLogViewer(w, req)
+		return
+	case req.URL.Path == "/log/recent":
+		b.serveRecent(w, req)
+		return
+	default:
+		http.NotFound(w, req)
+		return
+	}
+
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if !strings.EqualFold(req.Header.Get("Upgrade"), "websocket") || key == "" {
+		http.Error(w, "expected a WebSocket upgrade", http.StatusBadRequest)
+		return
+	}
+
+	if !b.allowedOrigin(req) {
+		log.Printf("Refused WebSocket client %s: origin %s is not allowed", req.RemoteAddr, req.Header.Get("Origin"))
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	tokenConfig, authorized := b.authorize(req)
+	if !authorized {
+		log.Printf("Refused WebSocket client %s: invalid token", req.RemoteAddr)
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket upgrade not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+
+	accept := sha1.Sum([]byte(key + webSocketGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(accept[:]))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return
+	}
+
+	client := &webSocketClient{
+		conn:   conn,
+		output: output,
+		binary: req.URL.Query().Get("format") == "binary",
+		token:  tokenConfig,
+		queue:  make(chan []byte, webSocketQueue),
+		done:   make(chan struct{}),
+	}
+	b.mu.Lock()
+	b.clients[client] = true
+	b.mu.Unlock()
+
+	go client.write()
+	b.read(client, rw.Reader)
+
+	b.mu.Lock()
+	delete(b.clients, client)
+	b.mu.Unlock()
+	close(client.done)
+	conn.Close()
+}
+
+// read passes the messages a client sends to the WebSocket input until the client disconnects.
+// Binary frames hold raw message bytes, text frames the JSON form.
+func (b *webSocketBridge) read(client *webSocketClient, reader *bufio.Reader) {
+	var message []byte
+	var messageOpcode byte
+	for {
+		fin, opcode, payload, err := readWebSocketFrame(reader)
+		if err != nil {
+			return
+		}
+
+		switch opcode {
+		case wsClose:
+			client.send(wsClose, nil)
+			return
+		case wsPing:
+			client.send(wsPong, payload)
+			continue
+		case wsPong:
+			continue
+		case wsText, wsBinary:
+			message, messageOpcode = payload, opcode
+		case wsContinuation:
+			if len(message)+len(payload) > webSocketMaxFrame {
+				log.Printf("Disconnecting WebSocket client %s: message larger than %d bytes", client.name(), webSocketMaxFrame)
+				return
+			}
+			message = append(message, payload...)
+		}
+		if !fin {
+			continue
+		}
+
+		if !client.canSend() {
+			if !client.warned {
+				log.Printf("Ignoring messages from WebSocket client %s, its token can only watch", client.name())
+				client.warned = true
+			}
+			continue
+		}
+
+		data := message
+		if messageOpcode == wsText {
+			var decoded webSocketMessage
+			if err := json.Unmarshal(message, &decoded); err != nil {
+				log.Printf("Ignoring invalid WebSocket message: %v", err)
+				continue
+			}
+			if decoded.Macro != "" {
+				if err := b.running.FireMacro(decoded.Macro); err != nil {
+					log.Printf("Error firing macro from WebSocket client %s: %v", client.name(), err)
+				}
+				continue
+			}
+			data = make([]byte, 0, len(decoded.Data))
+			for _, value := range decoded.Data {
+				if value < 0 || value > 255 {
+					data = nil
+					break
+				}
+				data = append(data, byte(value))
+			}
+			if data == nil {
+				log.Printf("Ignoring invalid WebSocket message: bytes must be 0-255")
+				continue
+			}
+		}
+
+		b.mu.Lock()
+		onMsg := b.onMsg
+		b.mu.Unlock()
+		if onMsg != nil && len(data) > 0 {
+			onMsg(data)
+		}
+	}
+}
+
+// Publish sends a message sent to an output to the clients subscribed to it, and keeps it for the
+// log viewer
+func (b *webSocketBridge) Publish(output string, msg midi.Message) {
+	data := make([]int, len(msg))
+	for i, value := range msg {
+		data[i] = int(value)
+	}
+	message := webSocketMessage{Output: output, Data: data, TimeMS: time.Since(b.started).Milliseconds()}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !msg.Is(midi.TimingClockMsg) {
+		b.recent.Add(message)
+	}
+	var text []byte
+	for client := range b.clients {
+		if client.output != "" && client.output != output {
+			continue
+		}
+		if client.binary {
+			client.send(wsBinary, msg)
+			continue
+		}
+		if text == nil {
+			text, _ = json.Marshal(message)
+		}
+		client.send(wsText, text)
+	}
+}
+
+// sender wraps an output's sender to publish the messages it sends
+func (b *webSocketBridge) sender(output string, send func(midi.Message) error) func(midi.Message) error {
+	return func(msg midi.Message) error {
+		if err := send(msg); err != nil {
+			return err
+		}
+		b.Publish(output, msg)
+		return nil
+	}
+}
+
+// send queues a frame for the client, dropping it if the client has fallen too far behind
+func (c *webSocketClient) send(opcode byte, payload []byte) {
+	select {
+	case c.queue <- webSocketFrame(opcode, payload):
+	default:
+	}
+}
+
+// write sends the client's queued frames until it disconnects
+func (c *webSocketClient) write() {
+	for {
+		select {
+		case frame := <-c.queue:
+			if _, err := c.conn.Write(frame); err != nil {
+				c.conn.Close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// webSocketFrame encodes an unmasked frame, as sent by servers
+func webSocketFrame(opcode byte, payload []byte) []byte {
+	frame := []byte{0x80 | opcode}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	return append(frame, payload...)
+}
+
+// readWebSocketFrame reads a frame from a client, removing its mask
+func readWebSocketFrame(reader *bufio.Reader) (fin bool, opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, opcode = header[0]&0x80 != 0, header[0]&0x0F
+	masked := header[1]&0x80 != 0
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if length > webSocketMaxFrame {
+		return false, 0, nil, errors.New("WebSocket frame too large")
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(reader, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, opcode, payload, nil
+}
+
+// webSocketIn is the input port for the messages WebSocket clients send
+type webSocketIn struct {
+	bridge *webSocketBridge
+	name   string
+}
+
+func (i *webSocketIn) Open() error             { return nil }
+func (i *webSocketIn) Close() error            { return nil }
+func (i *webSocketIn) IsOpen() bool            { return true }
+func (i *webSocketIn) Number() int             { return -1 }
+func (i *webSocketIn) String() string          { return i.name }
+func (i *webSocketIn) Underlying() interface{} { return i.bridge }
+
+// Listen passes the messages clients send to onMsg until stopped
+func (i *webSocketIn) Listen(onMsg func(msg []byte, milliseconds int32), config drivers.ListenConfig) (func(), error) {
+	reader := newSysExReader(config, onMsg)
+	var mu sync.Mutex
+	listener := func(msg []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		reader.EachMessage(msg, 0)
+	}
+
+	i.bridge.mu.Lock()
+	i.bridge.onMsg = listener
+	i.bridge.mu.Unlock()
+	return func() {
+		i.bridge.mu.Lock()
+		defer i.bridge.mu.Unlock()
+		i.bridge.onMsg = nil
+	}, nil
+}